@@ -3,10 +3,16 @@ package ast
 import (
 	"lang/token"
 	"lang/types"
+	"reflect"
 )
 
+// Node is a node of the syntax tree. Pos and End are the positions of its
+// first character and of the character just after it, or token.NoPos for
+// nodes that are not in the source.
 type Node interface {
 	isNode()
+	Pos() token.Pos
+	End() token.Pos
 }
 
 type Statement interface {
@@ -21,44 +27,283 @@ type Expression interface {
 	Location() string
 }
 
+// Program is a package, made of the statements of all its files. Package is
+// empty for programs without a package declaration, which are the main
+// package.
 type Program struct {
+	Package    string
+	Imports    []*Import
 	Statements []Statement
+
+	// PackagePos is the position of the package keyword and Comments the
+	// comments of the file in source order, which are only kept if the
+	// lexer returns them. Both are unset for the programs of the loader,
+	// which are made of several files.
+	PackagePos token.Pos
+	Comments   []*Comment
+
+	// set by loader for packages translated from C headers, whose names are
+	// all exported
+	Foreign bool
 }
 
 func (p *Program) isNode() {}
 
+// Pos and End of a program are those of its first and last declarations,
+// which can be in different files.
+func (p *Program) Pos() token.Pos {
+	if p.PackagePos.IsValid() {
+		return p.PackagePos
+	}
+	if len(p.Imports) > 0 {
+		return p.Imports[0].Pos()
+	}
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.NoPos
+}
+
+func (p *Program) End() token.Pos {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	if len(p.Imports) > 0 {
+		return p.Imports[len(p.Imports)-1].End()
+	}
+	return token.NoPos
+}
+
+// Comment is a line comment, whose text includes the // but not the
+// newline, or a block comment.
+type Comment struct {
+	Token token.Token
+}
+
+func (c *Comment) isNode()        {}
+func (c *Comment) Pos() token.Pos { return c.Token.Pos }
+func (c *Comment) End() token.Pos { return c.Token.End() }
+
+// Import is an import of the package at Path, which is referred to by Name.
+type Import struct {
+	Token token.Token
+	Name  string
+	Path  string
+
+	// ImportPos is the position of the import keyword.
+	ImportPos token.Pos
+
+	// set by loader
+	Program *Program
+}
+
+func (i *Import) isNode()        {}
+func (i *Import) Pos() token.Pos { return pos(i.ImportPos, i.Token.Pos) }
+func (i *Import) End() token.Pos { return i.Token.End() }
+
+type Defer struct {
+	Token token.Token
+	Call  Expression
+}
+
+func (d *Defer) isNode()        {}
+func (d *Defer) isStatement()   {}
+func (d *Defer) Pos() token.Pos { return d.Token.Pos }
+func (d *Defer) End() token.Pos { return end(d.Call, d.Token.End()) }
+
 type FuncArg struct {
 	Name string
 	Type *Type
 }
 
-func (fa *FuncArg) isNode()      {}
-func (fa *FuncArg) isStatement() {}
+func (fa *FuncArg) isNode()        {}
+func (fa *FuncArg) isStatement()   {}
+func (fa *FuncArg) Pos() token.Pos { return fa.Type.Pos() }
+func (fa *FuncArg) End() token.Pos { return fa.Type.End() }
 
 type FuncCall struct {
 	Token    token.Token
+	Package  string
+	TypeArgs []*Type
 	Args     []Expression
+
+	// PackagePos is the position of the package of a qualified call and
+	// Rparen the position of the closing parenthesis.
+	PackagePos token.Pos
+	Rparen     token.Pos
+
 	FuncDecl *FuncDecl
+
+	// set by checker for calls through a variable of function type
+	VarDecl *VarDecl
+
+	// set by checker for calls of generic functions
+	Instance []types.Type
+
+	// set by checker for calls of the builtin len
+	Builtin bool
+
 	Register string
 }
 
 func (fc *FuncCall) isNode()          {}
 func (fc *FuncCall) isStatement()     {}
 func (fc *FuncCall) isExpression()    {}
-func (fc *FuncCall) Type() types.Type { return fc.FuncDecl.ReturnType.Type }
 func (fc *FuncCall) Location() string { return fc.Register }
+func (fc *FuncCall) Pos() token.Pos   { return pos(fc.PackagePos, fc.Token.Pos) }
+func (fc *FuncCall) End() token.Pos   { return after(fc.Rparen, fc.Token.End()) }
+
+func (fc *FuncCall) Type() types.Type {
+	if fc.Builtin {
+		return types.TypeInt32
+	}
+	if fc.VarDecl != nil {
+		if f, ok := fc.VarDecl.Type.Type.(*types.Func); ok {
+			return f.Return
+		}
+		return types.TypeNil
+	}
+	// Calls of generic functions whose type arguments are unknown have no
+	// type, like calls of undeclared functions.
+	if fc.FuncDecl == nil || !fc.FuncDecl.HasReturn || len(fc.Instance) != len(fc.FuncDecl.TypeParams) {
+		return types.TypeNil
+	}
+	return types.Subst(fc.FuncDecl.ReturnType.Type, fc.Bindings())
+}
+
+// Bindings maps the type parameters of the called function to the type
+// arguments of the call.
+func (fc *FuncCall) Bindings() map[*types.TypeParam]types.Type {
+	if fc.FuncDecl == nil || len(fc.Instance) != len(fc.FuncDecl.TypeParams) {
+		return nil
+	}
+
+	bindings := make(map[*types.TypeParam]types.Type)
+	for i, tp := range fc.FuncDecl.TypeParams {
+		bindings[tp.Type] = fc.Instance[i]
+	}
+	return bindings
+}
+
+// Signature returns the type of the called function, with the type
+// arguments of the call in place of its type parameters.
+func (fc *FuncCall) Signature() *types.Func {
+	if fc.VarDecl != nil {
+		return fc.VarDecl.Type.Type.(*types.Func)
+	}
+	return types.Subst(fc.FuncDecl.Signature(), fc.Bindings()).(*types.Func)
+}
 
 type FuncDecl struct {
+	Receiver   *VarDecl
+	TypeParams []*TypeParam
 	Params     []*VarDecl
 	Body       []Statement
 	Extern     bool
+	Package    string
 	Token      token.Token
 	HasReturn  bool
 	ReturnType *Type
+
+	// FuncPos is the position of the func or export keyword, which methods
+	// of interfaces have none of, Rparen the position of the parenthesis
+	// that closes the parameters and Rbrace the position of the brace that
+	// closes the body.
+	FuncPos token.Pos
+	Rparen  token.Pos
+	Rbrace  token.Pos
+
+	// Variadic is set if the last parameter is declared as ...T and
+	// receives the remaining arguments as a []T. VarArgs is set if the
+	// parameters end with ..., which passes the remaining arguments to a C
+	// function as varargs.
+	Variadic bool
+	VarArgs  bool
+
+	// Export is set for functions declared with export, which are called
+	// from C under their unqualified name.
+	Export bool
+}
+
+func (fd *FuncDecl) isNode()        {}
+func (fd *FuncDecl) isStatement()   {}
+func (fd *FuncDecl) Pos() token.Pos { return pos(fd.FuncPos, fd.Token.Pos) }
+
+func (fd *FuncDecl) End() token.Pos {
+	switch {
+	case fd.Rbrace.IsValid():
+		return fd.Rbrace + 1
+	case fd.HasReturn:
+		return fd.ReturnType.End()
+	default:
+		return after(fd.Rparen, fd.Token.End())
+	}
+}
+
+// Signature returns the type of the function.
+func (fd *FuncDecl) Signature() *types.Func {
+	sig := &types.Func{Params: make([]types.Type, 0), Return: fd.Result(), Variadic: fd.Variadic}
+	for _, vd := range fd.Params {
+		sig.Params = append(sig.Params, vd.Type.Type)
+	}
+	return sig
+}
+
+// Result returns the return type of the function, or TypeNil if it returns
+// nothing.
+func (fd *FuncDecl) Result() types.Type {
+	if !fd.HasReturn {
+		return types.TypeNil
+	}
+	return fd.ReturnType.Type
 }
 
-func (fd *FuncDecl) isNode()      {}
-func (fd *FuncDecl) isStatement() {}
+type MethodCall struct {
+	Token    token.Token
+	Receiver Expression
+	Args     []Expression
+	Rparen   token.Pos
+
+	// set by checker
+	Method   *types.Method
+	FuncDecl *FuncDecl
+	Variant  *types.Variant
+
+	Register string
+}
+
+func (mc *MethodCall) isNode()          {}
+func (mc *MethodCall) isStatement()     {}
+func (mc *MethodCall) isExpression()    {}
+func (mc *MethodCall) Location() string { return mc.Register }
+func (mc *MethodCall) Pos() token.Pos   { return mc.Receiver.Pos() }
+func (mc *MethodCall) End() token.Pos   { return after(mc.Rparen, mc.Token.End()) }
+
+func (mc *MethodCall) Type() types.Type {
+	if mc.Variant != nil {
+		return mc.Variant.Union
+	}
+	if mc.Method == nil {
+		return types.TypeNil
+	}
+	return mc.Method.Sig.Return
+}
+
+type TypeParam struct {
+	Token      token.Token
+	Constraint *Type
+	Type       *types.TypeParam
+}
+
+func (tp *TypeParam) isNode()        {}
+func (tp *TypeParam) Pos() token.Pos { return tp.Token.Pos }
+
+func (tp *TypeParam) End() token.Pos {
+	if tp.Constraint != nil {
+		return tp.Constraint.End()
+	}
+	return tp.Token.End()
+}
 
 type IntLiteral struct {
 	Token token.Token
@@ -69,6 +314,44 @@ func (il *IntLiteral) isNode()          {}
 func (il *IntLiteral) isExpression()    {}
 func (il *IntLiteral) Type() types.Type { return types.TypeInt32 }
 func (il *IntLiteral) Location() string { return il.Token.Value }
+func (il *IntLiteral) Pos() token.Pos   { return il.Token.Pos }
+func (il *IntLiteral) End() token.Pos   { return il.Token.End() }
+
+// StringLiteral is a NUL terminated string constant, which is a pointer to
+// its first byte.
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) isNode()          {}
+func (sl *StringLiteral) isExpression()    {}
+func (sl *StringLiteral) Type() types.Type { return &types.Pointer{To: types.TypeUint8} }
+func (sl *StringLiteral) Location() string { return "" }
+func (sl *StringLiteral) Pos() token.Pos   { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Pos   { return sl.Token.End() }
+
+// Index is an element X[Index] of a slice. Token is the opening bracket and
+// Rbrack the position of the closing one.
+type Index struct {
+	Token  token.Token
+	X      Expression
+	Index  Expression
+	Rbrack token.Pos
+}
+
+func (ix *Index) isNode()          {}
+func (ix *Index) isExpression()    {}
+func (ix *Index) Location() string { return "" }
+func (ix *Index) Pos() token.Pos   { return ix.X.Pos() }
+func (ix *Index) End() token.Pos   { return after(ix.Rbrack, ix.Token.End()) }
+
+func (ix *Index) Type() types.Type {
+	if s, ok := ix.X.Type().(*types.Slice); ok {
+		return s.Elem
+	}
+	return types.TypeNil
+}
 
 type Return struct {
 	Token    token.Token
@@ -76,8 +359,82 @@ type Return struct {
 	Value    Expression
 }
 
-func (r *Return) isNode()      {}
-func (r *Return) isStatement() {}
+func (r *Return) isNode()        {}
+func (r *Return) isStatement()   {}
+func (r *Return) Pos() token.Pos { return r.Token.Pos }
+
+func (r *Return) End() token.Pos {
+	if r.HasValue {
+		return end(r.Value, r.Token.End())
+	}
+	return r.Token.End()
+}
+
+// FuncLit is an anonymous function. It shares the variables of enclosing
+// functions that it refers to, its captures, with them.
+type FuncLit struct {
+	Token token.Token
+	Decl  *FuncDecl
+
+	// set by checker
+	Captures []*VarDecl
+}
+
+func (fl *FuncLit) isNode()          {}
+func (fl *FuncLit) isExpression()    {}
+func (fl *FuncLit) Type() types.Type { return fl.Decl.Signature() }
+func (fl *FuncLit) Location() string { return "" }
+func (fl *FuncLit) Pos() token.Pos   { return fl.Token.Pos }
+func (fl *FuncLit) End() token.Pos   { return fl.Decl.End() }
+
+// Try evaluates an optional or a result and returns none or the error from
+// the enclosing function if it does not hold a value.
+type Try struct {
+	Token token.Token
+	X     Expression
+}
+
+func (t *Try) isNode()          {}
+func (t *Try) isStatement()     {}
+func (t *Try) isExpression()    {}
+func (t *Try) Location() string { return "" }
+func (t *Try) Pos() token.Pos   { return t.Token.Pos }
+func (t *Try) End() token.Pos   { return end(t.X, t.Token.End()) }
+
+func (t *Try) Type() types.Type {
+	switch v := t.X.Type().(type) {
+	case *types.Optional:
+		return v.Elem
+	case *types.Result:
+		return v.Value
+	default:
+		return types.TypeNil
+	}
+}
+
+type None struct {
+	Token token.Token
+}
+
+func (n *None) isNode()          {}
+func (n *None) isExpression()    {}
+func (n *None) Type() types.Type { return types.TypeNone }
+func (n *None) Location() string { return "" }
+func (n *None) Pos() token.Pos   { return n.Token.Pos }
+func (n *None) End() token.Pos   { return n.Token.End() }
+
+type Err struct {
+	Token  token.Token
+	X      Expression
+	Rparen token.Pos
+}
+
+func (e *Err) isNode()          {}
+func (e *Err) isExpression()    {}
+func (e *Err) Type() types.Type { return &types.Error{Err: e.X.Type()} }
+func (e *Err) Location() string { return "" }
+func (e *Err) Pos() token.Pos   { return e.Token.Pos }
+func (e *Err) End() token.Pos   { return after(e.Rparen, e.Token.End()) }
 
 type InfixExpression struct {
 	Token    token.Token
@@ -88,14 +445,179 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) isNode()          {}
 func (ie *InfixExpression) isExpression()    {}
-func (ie *InfixExpression) Type() types.Type { return ie.Left.Type() }
 func (ie *InfixExpression) Location() string { return ie.Register }
+func (ie *InfixExpression) Pos() token.Pos   { return ie.Left.Pos() }
+func (ie *InfixExpression) End() token.Pos   { return end(ie.Right, ie.Token.End()) }
 
-type Var struct {
+// Type returns the type that both operands are converted to, which is the
+// wider of their types. Integer literals take the type of the other operand,
+// so that the order of the operands does not matter.
+func (ie *InfixExpression) Type() types.Type {
+	l, r := ie.Left.Type(), ie.Right.Type()
+	if _, ok := ie.Left.(*IntLiteral); ok {
+		return r
+	}
+	if _, ok := ie.Right.(*IntLiteral); ok {
+		return l
+	}
+	if types.IntBits(r) > types.IntBits(l) {
+		return r
+	}
+	return l
+}
+
+type TypeDecl struct {
+	Token    token.Token
+	Type     *Type
+	Methods  []*FuncDecl
+	Members  []*EnumMember
+	Variants []*Variant
+
+	// TypePos is the position of the type, enum or union keyword and
+	// Rbrace the position of the brace that closes an interface, an enum or
+	// a union.
+	TypePos token.Pos
+	Rbrace  token.Pos
+}
+
+func (td *TypeDecl) isNode()        {}
+func (td *TypeDecl) isStatement()   {}
+func (td *TypeDecl) Pos() token.Pos { return pos(td.TypePos, td.Token.Pos) }
+
+func (td *TypeDecl) End() token.Pos {
+	switch {
+	case td.Rbrace.IsValid():
+		return td.Rbrace + 1
+	case td.Type != nil:
+		return td.Type.End()
+	default:
+		return td.Token.End()
+	}
+}
+
+type EnumMember struct {
 	Token token.Token
+	Value *IntLiteral
+}
+
+func (em *EnumMember) isNode()        {}
+func (em *EnumMember) Pos() token.Pos { return em.Token.Pos }
+
+func (em *EnumMember) End() token.Pos {
+	if em.Value != nil {
+		return em.Value.End()
+	}
+	return em.Token.End()
+}
+
+type Variant struct {
+	Token  token.Token
+	Fields []*Type
+	Rparen token.Pos
+}
+
+func (v *Variant) isNode()        {}
+func (v *Variant) Pos() token.Pos { return v.Token.Pos }
+func (v *Variant) End() token.Pos { return after(v.Rparen, v.Token.End()) }
+
+type Selector struct {
+	Token token.Token
+	X     Expression
 
 	// set by checker
-	VarDecl *VarDecl
+	Member  *types.EnumMember
+	Variant *types.Variant
+}
+
+func (s *Selector) isNode()          {}
+func (s *Selector) isExpression()    {}
+func (s *Selector) Location() string { return "" }
+func (s *Selector) Pos() token.Pos   { return start(s.X, s.Token.Pos) }
+func (s *Selector) End() token.Pos   { return s.Token.End() }
+
+func (s *Selector) Type() types.Type {
+	switch {
+	case s.Member != nil:
+		return s.Member.Enum
+	case s.Variant != nil:
+		return s.Variant.Union
+	default:
+		return types.TypeNil
+	}
+}
+
+// Pattern is a case of a switch on a union, which binds the fields of the
+// matched variant to new variables. X is nil if the variant is not qualified
+// by the name of the union, like some(x) in a switch on an optional.
+type Pattern struct {
+	Token    token.Token
+	X        *Var
+	Bindings []*VarDecl
+	Rparen   token.Pos
+
+	// set by checker
+	Variant *types.Variant
+}
+
+func (p *Pattern) isNode()          {}
+func (p *Pattern) isExpression()    {}
+func (p *Pattern) Location() string { return "" }
+func (p *Pattern) End() token.Pos   { return after(p.Rparen, p.Token.End()) }
+
+func (p *Pattern) Pos() token.Pos {
+	if p.X != nil {
+		return p.X.Pos()
+	}
+	return p.Token.Pos
+}
+
+func (p *Pattern) Type() types.Type {
+	if p.Variant == nil {
+		return types.TypeNil
+	}
+	return p.Variant.Union
+}
+
+type Switch struct {
+	Token  token.Token
+	Value  Expression
+	Cases  []*Case
+	Rbrace token.Pos
+}
+
+func (s *Switch) isNode()        {}
+func (s *Switch) isStatement()   {}
+func (s *Switch) Pos() token.Pos { return s.Token.Pos }
+func (s *Switch) End() token.Pos { return after(s.Rbrace, s.Token.End()) }
+
+type Case struct {
+	Token   token.Token
+	Default bool
+	Values  []Expression
+	Body    []Statement
+	Colon   token.Pos
+}
+
+func (c *Case) isNode()        {}
+func (c *Case) Pos() token.Pos { return c.Token.Pos }
+
+func (c *Case) End() token.Pos {
+	if len(c.Body) > 0 {
+		return c.Body[len(c.Body)-1].End()
+	}
+	return after(c.Colon, c.Token.End())
+}
+
+type Var struct {
+	Token   token.Token
+	Package string
+
+	// PackagePos is the position of the package of a qualified name.
+	PackagePos token.Pos
+
+	// set by checker, FuncDecl if the variable names a function
+	VarDecl  *VarDecl
+	FuncDecl *FuncDecl
 
 	// set by assembler
 	Register string
@@ -103,25 +625,98 @@ type Var struct {
 
 func (v *Var) isNode()          {}
 func (v *Var) isExpression()    {}
-func (v *Var) Type() types.Type { return v.VarDecl.Type.Type }
 func (v *Var) Location() string { return v.Register }
+func (v *Var) Pos() token.Pos   { return pos(v.PackagePos, v.Token.Pos) }
+func (v *Var) End() token.Pos   { return v.Token.End() }
+
+func (v *Var) Type() types.Type {
+	switch {
+	case v.VarDecl != nil:
+		return v.VarDecl.Type.Type
+	case v.FuncDecl != nil:
+		return v.FuncDecl.Signature()
+	default:
+		return types.TypeNil
+	}
+}
+
+type Assign struct {
+	Token token.Token
+	X     *Var
+	Value Expression
+}
+
+func (a *Assign) isNode()        {}
+func (a *Assign) isStatement()   {}
+func (a *Assign) Pos() token.Pos { return start(a.X, a.Token.Pos) }
+func (a *Assign) End() token.Pos { return end(a.Value, a.Token.End()) }
 
 type VarDecl struct {
 	Token    token.Token
 	Type     *Type
 	Value    Expression
 	Register string
+
+	// VarPos is the position of the var keyword, which parameters and
+	// bindings have none of.
+	VarPos token.Pos
+
+	// set by checker if a function literal refers to the variable
+	Captured bool
 }
 
-func (vd *VarDecl) isNode()      {}
-func (vd *VarDecl) isStatement() {}
+func (vd *VarDecl) isNode()        {}
+func (vd *VarDecl) isStatement()   {}
+func (vd *VarDecl) Pos() token.Pos { return pos(vd.VarPos, vd.Token.Pos) }
+
+func (vd *VarDecl) End() token.Pos {
+	if e := end(vd.Value, token.NoPos); e.IsValid() {
+		return e
+	}
+	if vd.Type != nil && vd.Type.End().IsValid() {
+		return vd.Type.End()
+	}
+	return vd.Token.End()
+}
 
+// Type is a type written in source from From up to To. Token is the token
+// that the type is named by, or the first token of types like []T.
 type Type struct {
 	Token token.Token
 	Type  types.Type
+	From  token.Pos
+	To    token.Pos
+}
+
+func (t *Type) isNode()        {}
+func (t *Type) Pos() token.Pos { return pos(t.From, t.Token.Pos) }
+func (t *Type) End() token.Pos { return pos(t.To, t.Token.End()) }
+
+// BadExpr is a placeholder for an expression with a syntax error, which spans
+// the tokens from From up to To.
+type BadExpr struct {
+	From token.Token
+	To   token.Token
+}
+
+func (be *BadExpr) isNode()          {}
+func (be *BadExpr) isExpression()    {}
+func (be *BadExpr) Type() types.Type { return types.TypeNil }
+func (be *BadExpr) Location() string { return "" }
+func (be *BadExpr) Pos() token.Pos   { return be.From.Pos }
+func (be *BadExpr) End() token.Pos   { return be.To.Pos }
+
+// BadStmt is a placeholder for a statement or a declaration with a syntax
+// error, which spans the tokens from From up to To.
+type BadStmt struct {
+	From token.Token
+	To   token.Token
 }
 
-func (t *Type) isNode() {}
+func (bs *BadStmt) isNode()        {}
+func (bs *BadStmt) isStatement()   {}
+func (bs *BadStmt) Pos() token.Pos { return bs.From.Pos }
+func (bs *BadStmt) End() token.Pos { return bs.To.Pos }
 
 type EmptyExpression struct{}
 
@@ -129,6 +724,8 @@ func (ee *EmptyExpression) isNode()          {}
 func (ee *EmptyExpression) isExpression()    {}
 func (ee *EmptyExpression) Type() types.Type { return types.TypeNil }
 func (ee *EmptyExpression) Location() string { return "" }
+func (ee *EmptyExpression) Pos() token.Pos   { return token.NoPos }
+func (ee *EmptyExpression) End() token.Pos   { return token.NoPos }
 
 type RegisterExpression struct {
 	RegisterType types.Type
@@ -139,3 +736,38 @@ func (re *RegisterExpression) isNode()          {}
 func (re *RegisterExpression) isExpression()    {}
 func (re *RegisterExpression) Type() types.Type { return re.RegisterType }
 func (re *RegisterExpression) Location() string { return re.Register }
+func (re *RegisterExpression) Pos() token.Pos   { return token.NoPos }
+func (re *RegisterExpression) End() token.Pos   { return token.NoPos }
+
+// pos returns p, or def if p is not valid.
+func pos(p, def token.Pos) token.Pos {
+	if p.IsValid() {
+		return p
+	}
+	return def
+}
+
+// after returns the position after the single character at p, or def if p is
+// not valid.
+func after(p, def token.Pos) token.Pos {
+	if p.IsValid() {
+		return p + 1
+	}
+	return def
+}
+
+// start returns the position of n, or def if n is nil or has no position.
+func start(n Node, def token.Pos) token.Pos {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		return def
+	}
+	return pos(n.Pos(), def)
+}
+
+// end returns the end of n, or def if n is nil or has no position.
+func end(n Node, def token.Pos) token.Pos {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		return def
+	}
+	return pos(n.End(), def)
+}
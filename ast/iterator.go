@@ -39,6 +39,10 @@ func (it *Iterator) Next() (Node, bool) {
 			it.push(v.Statements[i])
 		}
 	case *IntLiteral:
+	case *StringLiteral:
+	case *Index:
+		it.push(v.Index)
+		it.push(v.X)
 	case *InfixExpression:
 		it.push(v.Right)
 		it.push(v.Left)
@@ -50,13 +54,69 @@ func (it *Iterator) Next() (Node, bool) {
 		for i := len(v.Params) - 1; i >= 0; i-- {
 			it.push(v.Params[i])
 		}
+		if v.Receiver != nil {
+			it.push(v.Receiver)
+		}
+		for i := len(v.Body) - 1; i >= 0; i-- {
+			it.push(v.Body[i])
+		}
+	case *MethodCall:
+		for i := len(v.Args) - 1; i >= 0; i-- {
+			it.push(v.Args[i])
+		}
+		it.push(v.Receiver)
+	case *TypeDecl:
+		for i := len(v.Variants) - 1; i >= 0; i-- {
+			it.push(v.Variants[i])
+		}
+		for i := len(v.Members) - 1; i >= 0; i-- {
+			it.push(v.Members[i])
+		}
+		for i := len(v.Methods) - 1; i >= 0; i-- {
+			it.push(v.Methods[i])
+		}
+	case *Switch:
+		for i := len(v.Cases) - 1; i >= 0; i-- {
+			it.push(v.Cases[i])
+		}
+		it.push(v.Value)
+	case *Case:
 		for i := len(v.Body) - 1; i >= 0; i-- {
 			it.push(v.Body[i])
 		}
+		for i := len(v.Values) - 1; i >= 0; i-- {
+			it.push(v.Values[i])
+		}
+	case *Selector:
+		it.push(v.X)
+	case *Pattern:
+		for i := len(v.Bindings) - 1; i >= 0; i-- {
+			it.push(v.Bindings[i])
+		}
+		if v.X != nil {
+			it.push(v.X)
+		}
+	case *EnumMember:
+	case *Variant:
+	case *Defer:
+		it.push(v.Call)
 	case *Return:
 		if v.HasValue {
 			it.push(v.Value)
 		}
+	case *Assign:
+		it.push(v.Value)
+		it.push(v.X)
+	case *FuncLit:
+		// The body of a function literal is a separate function, which
+		// is iterated on its own.
+	case *Try:
+		it.push(v.X)
+	case *Err:
+		it.push(v.X)
+	case *None:
+	case *BadExpr:
+	case *BadStmt:
 	case *VarDecl:
 		it.push(v.Value)
 	case *Var:
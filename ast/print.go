@@ -0,0 +1,98 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"lang/token"
+	"lang/types"
+	"reflect"
+	"strings"
+)
+
+// Fprint writes the tree of n to w, one field per line. Fields with zero
+// values and positions are left out, tokens are written as their type and
+// value, and types as they are written in source.
+func Fprint(w io.Writer, n Node) error {
+	p := &printer{w: w, visiting: make(map[uintptr]bool)}
+	p.print(reflect.ValueOf(n), 0)
+	p.line("")
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+
+	// visiting holds the nodes that are being printed, since the checker
+	// links nodes to their declarations.
+	visiting map[uintptr]bool
+}
+
+var (
+	tokenType = reflect.TypeOf(token.Token{})
+	posType   = reflect.TypeOf(token.NoPos)
+	typeType  = reflect.TypeOf((*types.Type)(nil)).Elem()
+)
+
+func (p *printer) print(v reflect.Value, depth int) {
+	if v.Type().Implements(typeType) && v.Kind() != reflect.Interface {
+		p.write(types.String(v.Interface().(types.Type)))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		p.print(v.Elem(), depth)
+	case reflect.Ptr:
+		if p.visiting[v.Pointer()] {
+			p.write(fmt.Sprintf("%s (cycle)", v.Type()))
+			return
+		}
+		p.visiting[v.Pointer()] = true
+		p.write(v.Type().String() + " ")
+		p.print(v.Elem(), depth)
+		delete(p.visiting, v.Pointer())
+	case reflect.Struct:
+		if v.Type() == tokenType {
+			tok := v.Interface().(token.Token)
+			p.write(fmt.Sprintf("%s %q", tok.Type, tok.Value))
+			return
+		}
+		p.write("{")
+		empty := true
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if !v.Type().Field(i).IsExported() || f.Type() == posType || f.IsZero() || f.Kind() == reflect.Slice && f.Len() == 0 {
+				continue
+			}
+			p.line(strings.Repeat("  ", depth+1) + v.Type().Field(i).Name + ": ")
+			p.print(f, depth+1)
+			empty = false
+		}
+		if empty {
+			p.write("}")
+			return
+		}
+		p.line(strings.Repeat("  ", depth) + "}")
+	case reflect.Slice:
+		p.write("[")
+		for i := 0; i < v.Len(); i++ {
+			p.line(strings.Repeat("  ", depth+1))
+			p.print(v.Index(i), depth+1)
+		}
+		p.line(strings.Repeat("  ", depth) + "]")
+	default:
+		p.write(fmt.Sprintf("%#v", v.Interface()))
+	}
+}
+
+// line starts a new line with s.
+func (p *printer) line(s string) {
+	p.write("\n" + s)
+}
+
+func (p *printer) write(s string) {
+	if p.err == nil {
+		_, p.err = io.WriteString(p.w, s)
+	}
+}
@@ -0,0 +1,996 @@
+// Package cheader translates C headers into declarations of this language, so
+// that C libraries can be imported instead of declaring each of their
+// functions by hand.
+//
+// It understands a practical subset of C: function prototypes, enums,
+// typedefs, structs and #define of integer constants. Structs are opaque and
+// can only be used through pointers. Unsigned and plain chars become u8, so
+// strings are ^u8. Pointers to types that have no equivalent, like void,
+// become pointers to i32. Variadic functions keep their C varargs.
+// Declarations that cannot be translated are skipped.
+package cheader
+
+import (
+	"fmt"
+	"lang/token"
+	"strconv"
+	"strings"
+)
+
+type kind int
+
+const (
+	basic kind = iota
+	pointer
+	array
+	function
+	record
+	enumType
+	typedefName
+)
+
+// ctype is a C type. Basic types are named after the keyword they are
+// declared with, with all integers of the size of an int named int, and
+// spelled like the specifiers they are declared with.
+type ctype struct {
+	kind     kind
+	name     string
+	spelling string
+	elem     *ctype
+	params   []*ctype
+	names    []string
+	variadic bool
+	enum     *enumDecl
+}
+
+type enumDecl struct {
+	name    string
+	members []*enumMember
+}
+
+type enumMember struct {
+	name  string
+	value int64
+	ok    bool
+}
+
+type funcDecl struct {
+	name string
+	typ  *ctype
+}
+
+type parser struct {
+	toks []string
+	pos  int
+
+	macros   map[string]*macro
+	consts   map[string]int64
+	typedefs map[string]*ctype
+	order    []string
+	records  []string
+	enums    []*enumDecl
+	funcs    []*funcDecl
+	static   bool
+	skipped  []string
+}
+
+// Translate translates the declarations of a C header into the source of the
+// package pkg. It also returns a description of each declaration that was
+// skipped.
+func Translate(pkg, src string) (string, []string) {
+	code, macros := preprocess(src)
+
+	p := &parser{
+		macros:   make(map[string]*macro),
+		consts:   make(map[string]int64),
+		typedefs: make(map[string]*ctype),
+		skipped:  make([]string, 0),
+	}
+	for _, m := range macros {
+		p.macros[m.name] = m
+	}
+	for name, t := range stdTypes {
+		p.typedefs[name] = &ctype{kind: basic, name: t, spelling: t}
+	}
+	p.toks = p.expand(tokenize(strings.Join(code, "\n")), make(map[string]bool))
+	p.parse()
+
+	tr := &translator{parser: p, emitted: make(map[string]bool)}
+	return tr.translate(pkg, macros), p.skipped
+}
+
+// stdTypes maps the standard typedefs to basic types of the same size.
+var stdTypes = map[string]string{
+	"int8_t":    "signed char",
+	"uint8_t":   "char",
+	"int16_t":   "short",
+	"uint16_t":  "short",
+	"int32_t":   "int",
+	"uint32_t":  "int",
+	"int64_t":   "long",
+	"uint64_t":  "long",
+	"size_t":    "long",
+	"ssize_t":   "long",
+	"intptr_t":  "long",
+	"uintptr_t": "long",
+	"ptrdiff_t": "long",
+	"wchar_t":   "int",
+	"bool":      "bool",
+}
+
+// expand replaces macros in toks by their values.
+func (p *parser) expand(toks []string, active map[string]bool) []string {
+	out := make([]string, 0, len(toks))
+	for _, t := range toks {
+		m, ok := p.macros[t]
+		if !ok || active[t] {
+			out = append(out, t)
+			continue
+		}
+		active[t] = true
+		out = append(out, p.expand(m.value, active)...)
+		delete(active, t)
+	}
+	return out
+}
+
+func (p *parser) parse() {
+	for p.pos < len(p.toks) {
+		start := p.pos
+		if err := p.decl(); err != nil {
+			p.pos = start
+			p.skipped = append(p.skipped, fmt.Sprintf("%s: %v", p.describe(), err))
+			p.skipDecl()
+		}
+	}
+}
+
+// describe returns the first tokens of the declaration at the current
+// position.
+func (p *parser) describe() string {
+	end := p.pos
+	for end < len(p.toks) && end < p.pos+6 && p.toks[end] != ";" && p.toks[end] != "{" {
+		end++
+	}
+	return strings.Join(p.toks[p.pos:end], " ")
+}
+
+// skipDecl skips to the end of the declaration at the current position.
+func (p *parser) skipDecl() {
+	depth := 0
+	for p.pos < len(p.toks) {
+		t := p.next()
+		switch t {
+		case "{", "(", "[":
+			depth++
+		case "}", ")", "]":
+			depth--
+			if depth == 0 && t == "}" && !p.is(";") && !isName(p.cur()) {
+				return
+			}
+		case ";":
+			if depth <= 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *parser) decl() error {
+	switch {
+	case p.accept(";"), p.accept("}"):
+		return nil
+	case p.is("extern") && strings.HasPrefix(p.peek(1), "\""):
+		p.pos += 2
+		p.accept("{")
+		return nil
+	case p.accept("typedef"):
+		return p.typedef()
+	}
+
+	p.static = false
+	base, err := p.specifiers()
+	if err != nil {
+		return err
+	}
+	if p.accept(";") {
+		return nil
+	}
+
+	for {
+		name, t, err := p.declarator(base)
+		if err != nil {
+			return err
+		}
+		if p.is("{") {
+			// Function definitions are inline functions, which have no
+			// symbol to link against.
+			p.skipDecl()
+			return nil
+		}
+		if t.kind == function && name != "" && !p.static {
+			p.funcs = append(p.funcs, &funcDecl{name: name, typ: t})
+		}
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	return p.expect(";")
+}
+
+func (p *parser) typedef() error {
+	base, err := p.specifiers()
+	if err != nil {
+		return err
+	}
+
+	for {
+		name, t, err := p.declarator(base)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return fmt.Errorf("typedef without a name")
+		}
+
+		// Anonymous enums and structs are named after their typedef.
+		switch {
+		case t.kind == enumType && t.name == "":
+			t.name = name
+			t.enum.name = name
+		case t.kind == record && t.name == "":
+			t.name = name
+			p.records = append(p.records, name)
+		}
+
+		if _, ok := p.typedefs[name]; !ok {
+			p.order = append(p.order, name)
+		}
+		p.typedefs[name] = t
+
+		if !p.accept(",") {
+			break
+		}
+	}
+
+	return p.expect(";")
+}
+
+// specifiers parses the specifiers and qualifiers of a declaration and
+// returns the type they declare.
+func (p *parser) specifiers() (*ctype, error) {
+	var t *ctype
+	words := make(map[string]int)
+	spelling := make([]string, 0)
+
+	for p.pos < len(p.toks) {
+		tok := p.cur()
+		switch tok {
+		case "const", "volatile", "restrict", "__restrict", "__restrict__", "inline", "__inline",
+			"__inline__", "register", "auto", "extern", "__extension__", "_Noreturn", "__cdecl":
+			p.next()
+		case "static":
+			p.static = true
+			p.next()
+		case "__attribute__", "__attribute", "__declspec", "__asm__", "__asm", "asm":
+			p.next()
+			p.skipGroup()
+		case "void", "char", "short", "int", "long", "float", "double", "signed", "unsigned", "_Bool":
+			words[tok]++
+			spelling = append(spelling, tok)
+			p.next()
+		case "struct", "union":
+			p.next()
+			rt, err := p.record()
+			if err != nil {
+				return nil, err
+			}
+			t = rt
+		case "enum":
+			p.next()
+			et, err := p.enum()
+			if err != nil {
+				return nil, err
+			}
+			t = et
+		default:
+			if t != nil || len(words) > 0 || !isName(tok) {
+				return p.specified(t, words, spelling)
+			}
+			p.next()
+			t = &ctype{kind: typedefName, name: tok}
+		}
+	}
+	return p.specified(t, words, spelling)
+}
+
+func (p *parser) specified(t *ctype, words map[string]int, spelling []string) (*ctype, error) {
+	if t != nil {
+		return t, nil
+	}
+	name := "int"
+	switch {
+	case len(words) == 0:
+		return nil, fmt.Errorf("missing type")
+	case words["void"] > 0:
+		name = "void"
+	case words["char"] > 0 && words["signed"] > 0:
+		name = "signed char"
+	case words["char"] > 0:
+		name = "char"
+	case words["float"] > 0:
+		name = "float"
+	case words["double"] > 0:
+		name = "double"
+	case words["_Bool"] > 0:
+		name = "bool"
+	case words["short"] > 0:
+		name = "short"
+	case words["long"] > 0:
+		name = "long"
+	}
+	return &ctype{kind: basic, name: name, spelling: strings.Join(spelling, " ")}, nil
+}
+
+// record parses the tag and fields of a struct or union. Fields are skipped,
+// since records are opaque.
+func (p *parser) record() (*ctype, error) {
+	t := &ctype{kind: record}
+	if isName(p.cur()) {
+		t.name = p.next()
+	}
+	if p.is("{") {
+		p.skipGroup()
+	} else if t.name == "" {
+		return nil, fmt.Errorf("struct without a name or fields")
+	}
+	if t.name != "" && !contains(p.records, t.name) {
+		p.records = append(p.records, t.name)
+	}
+	return t, nil
+}
+
+func (p *parser) enum() (*ctype, error) {
+	e := &enumDecl{}
+	if isName(p.cur()) {
+		e.name = p.next()
+	}
+	t := &ctype{kind: enumType, name: e.name, enum: e}
+	if !p.accept("{") {
+		if e.name == "" {
+			return nil, fmt.Errorf("enum without a name or members")
+		}
+		return t, nil
+	}
+
+	var value int64
+	ok := true
+	for !p.accept("}") {
+		if p.pos >= len(p.toks) || !isName(p.cur()) {
+			return nil, fmt.Errorf("invalid enum member %s", p.cur())
+		}
+		m := &enumMember{name: p.next()}
+
+		if p.accept("=") {
+			start := p.pos
+			for p.pos < len(p.toks) && !p.is(",") && !p.is("}") {
+				if p.is("(") {
+					p.skipGroup()
+					continue
+				}
+				p.next()
+			}
+			value, ok = p.eval(p.toks[start:p.pos])
+		}
+		m.value, m.ok = value, ok
+		if ok {
+			p.consts[m.name] = value
+		}
+		e.members = append(e.members, m)
+		value++
+
+		p.accept(",")
+	}
+
+	p.enums = append(p.enums, e)
+	return t, nil
+}
+
+// declarator parses the declarator of a declaration, which applies pointers,
+// arrays and parameters to base, and returns the declared name and type. The
+// name is empty for abstract declarators.
+func (p *parser) declarator(base *ctype) (string, *ctype, error) {
+	t := base
+	for p.accept("*") {
+		t = &ctype{kind: pointer, elem: t}
+		p.qualifiers()
+	}
+
+	var name string
+	var inner *ctype
+	hole := &ctype{}
+	switch {
+	case p.is("(") && (p.peek(1) == "*" || p.peek(1) == "("):
+		p.next()
+		n, it, err := p.declarator(hole)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return "", nil, err
+		}
+		name, inner = n, it
+	case isName(p.cur()):
+		name = p.next()
+	}
+
+	for {
+		if p.accept("(") {
+			f, err := p.params()
+			if err != nil {
+				return "", nil, err
+			}
+			f.elem = t
+			t = f
+			continue
+		}
+		if p.is("[") {
+			p.skipGroup()
+			t = &ctype{kind: array, elem: t}
+			continue
+		}
+		break
+	}
+	p.qualifiers()
+
+	if inner != nil {
+		t = fill(inner, hole, t)
+	}
+	return name, t, nil
+}
+
+// fill replaces hole in t, the type of a nested declarator, by outer.
+func fill(t, hole, outer *ctype) *ctype {
+	if t == hole {
+		return outer
+	}
+	if t.elem == nil {
+		return t
+	}
+	c := *t
+	c.elem = fill(t.elem, hole, outer)
+	return &c
+}
+
+// params parses the parameters of a function type, after the opening
+// parenthesis.
+func (p *parser) params() (*ctype, error) {
+	f := &ctype{kind: function, params: make([]*ctype, 0), names: make([]string, 0)}
+	if p.accept(")") {
+		return f, nil
+	}
+	if p.is("void") && p.peek(1) == ")" {
+		p.pos += 2
+		return f, nil
+	}
+
+	for {
+		if p.accept("...") {
+			f.variadic = true
+			return f, p.expect(")")
+		}
+		base, err := p.specifiers()
+		if err != nil {
+			return nil, err
+		}
+		name, t, err := p.declarator(base)
+		if err != nil {
+			return nil, err
+		}
+		// Arrays and functions are passed as pointers.
+		switch t.kind {
+		case array:
+			t = &ctype{kind: pointer, elem: t.elem}
+		case function:
+			t = &ctype{kind: pointer, elem: t}
+		}
+		f.params = append(f.params, t)
+		f.names = append(f.names, name)
+
+		if !p.accept(",") {
+			return f, p.expect(")")
+		}
+	}
+}
+
+// qualifiers skips the qualifiers and attributes after a pointer or a
+// declarator.
+func (p *parser) qualifiers() {
+	for p.pos < len(p.toks) {
+		switch p.cur() {
+		case "const", "volatile", "restrict", "__restrict", "__restrict__":
+			p.next()
+		case "__attribute__", "__attribute", "__asm__", "__asm", "asm":
+			p.next()
+			p.skipGroup()
+		default:
+			return
+		}
+	}
+}
+
+// skipGroup skips a parenthesized, bracketed or braced group.
+func (p *parser) skipGroup() {
+	depth := 0
+	for p.pos < len(p.toks) {
+		switch p.next() {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		}
+		if depth <= 0 {
+			return
+		}
+	}
+}
+
+// eval evaluates an integer constant expression.
+func (p *parser) eval(toks []string) (int64, bool) {
+	e := &evaluator{toks: toks, parser: p}
+	v, ok := e.expr(0)
+	return v, ok && e.pos == len(toks)
+}
+
+func (p *parser) cur() string {
+	return p.peek(0)
+}
+
+func (p *parser) peek(n int) string {
+	if p.pos+n >= len(p.toks) {
+		return ""
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) next() string {
+	t := p.cur()
+	p.pos++
+	return t
+}
+
+func (p *parser) is(t string) bool {
+	return p.cur() == t
+}
+
+func (p *parser) accept(t string) bool {
+	if p.is(t) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(t string) error {
+	if !p.accept(t) {
+		return fmt.Errorf("expected %s, got %q", t, p.cur())
+	}
+	return nil
+}
+
+func isName(t string) bool {
+	if t == "" || !isIdent(t[0]) {
+		return false
+	}
+	switch t {
+	case "const", "volatile", "struct", "union", "enum", "typedef", "static", "extern",
+		"void", "char", "short", "int", "long", "float", "double", "signed", "unsigned":
+		return false
+	}
+	return true
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluator evaluates constant expressions with the usual C operators on
+// integers. Names refer to enum members and macros.
+type evaluator struct {
+	toks   []string
+	pos    int
+	parser *parser
+	depth  int
+}
+
+var precedences = map[string]int{
+	"|":  1,
+	"^":  2,
+	"&":  3,
+	"<<": 4,
+	">>": 4,
+	"+":  5,
+	"-":  5,
+	"*":  6,
+	"/":  6,
+	"%":  6,
+}
+
+func (e *evaluator) expr(min int) (int64, bool) {
+	left, ok := e.unary()
+	if !ok {
+		return 0, false
+	}
+	for e.pos < len(e.toks) {
+		op := e.toks[e.pos]
+		prec, isOp := precedences[op]
+		if !isOp || prec <= min {
+			break
+		}
+		e.pos++
+		right, ok := e.expr(prec)
+		if !ok {
+			return 0, false
+		}
+		switch op {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/", "%":
+			if right == 0 {
+				return 0, false
+			}
+			if op == "/" {
+				left /= right
+			} else {
+				left %= right
+			}
+		}
+	}
+	return left, true
+}
+
+func (e *evaluator) unary() (int64, bool) {
+	if e.pos >= len(e.toks) {
+		return 0, false
+	}
+	tok := e.toks[e.pos]
+	e.pos++
+
+	switch {
+	case tok == "-":
+		v, ok := e.unary()
+		return -v, ok
+	case tok == "+":
+		return e.unary()
+	case tok == "~":
+		v, ok := e.unary()
+		return ^v, ok
+	case tok == "(":
+		// Casts to integer types are ignored.
+		end := e.pos
+		for end < len(e.toks) && e.isTypeName(e.toks[end]) {
+			end++
+		}
+		if end > e.pos && end < len(e.toks) && e.toks[end] == ")" {
+			e.pos = end + 1
+			return e.unary()
+		}
+
+		v, ok := e.expr(0)
+		if !ok || e.pos >= len(e.toks) || e.toks[e.pos] != ")" {
+			return 0, false
+		}
+		e.pos++
+		return v, true
+	case isDigit(tok[0]):
+		return parseInt(tok)
+	case strings.HasPrefix(tok, "'"):
+		s, err := strconv.Unquote(tok)
+		if err != nil || len(s) != 1 {
+			return 0, false
+		}
+		return int64(s[0]), true
+	case isIdent(tok[0]):
+		if v, ok := e.parser.consts[tok]; ok {
+			return v, true
+		}
+		m, ok := e.parser.macros[tok]
+		if !ok || e.depth > 16 {
+			return 0, false
+		}
+		sub := &evaluator{toks: m.value, parser: e.parser, depth: e.depth + 1}
+		v, ok := sub.expr(0)
+		return v, ok && sub.pos == len(m.value)
+	}
+	return 0, false
+}
+
+func (e *evaluator) isTypeName(tok string) bool {
+	switch tok {
+	case "char", "short", "int", "long", "signed", "unsigned":
+		return true
+	}
+	_, ok := e.parser.typedefs[tok]
+	return ok
+}
+
+// parseInt parses a decimal, octal or hexadecimal integer literal with an
+// optional suffix.
+func parseInt(tok string) (int64, bool) {
+	tok = strings.TrimRight(tok, "uUlL")
+	v, err := strconv.ParseInt(tok, 0, 64)
+	if err != nil {
+		u, err := strconv.ParseUint(tok, 0, 64)
+		return int64(u), err == nil
+	}
+	return v, true
+}
+
+// translator writes the parsed declarations in this language.
+type translator struct {
+	*parser
+	b       strings.Builder
+	emitted map[string]bool
+}
+
+func (tr *translator) translate(pkg string, macros []*macro) string {
+	fmt.Fprintf(&tr.b, "package %s\n\n", pkg)
+
+	// Later definitions of a macro replace earlier ones.
+	last := make(map[string]int)
+	for i, m := range macros {
+		last[m.name] = i
+	}
+	for i, m := range macros {
+		if last[m.name] != i || len(m.value) == 0 {
+			continue
+		}
+		v, ok := tr.eval(m.value)
+		if !ok {
+			continue
+		}
+		tr.global(m.name, v)
+	}
+
+	for _, e := range tr.enums {
+		tr.enum(e)
+	}
+
+	for _, name := range tr.records {
+		if !tr.emitted[name] && token.KeywordsMap[name] == "" {
+			tr.emitted[name] = true
+			fmt.Fprintf(&tr.b, "type %s i32\n", name)
+		}
+	}
+
+	for _, name := range tr.order {
+		if tr.emitted[name] || !tr.isInt(tr.typedefs[name]) || token.KeywordsMap[name] != "" {
+			continue
+		}
+		tr.emitted[name] = true
+		fmt.Fprintf(&tr.b, "type %s i32\n", name)
+	}
+
+	declared := make(map[string]bool)
+	for _, fd := range tr.funcs {
+		if declared[fd.name] {
+			continue
+		}
+		declared[fd.name] = true
+		if err := tr.function(fd); err != nil {
+			tr.skipped = append(tr.skipped, fmt.Sprintf("%s: %v", fd.name, err))
+		}
+	}
+
+	return tr.b.String()
+}
+
+// global declares an integer constant as a global variable. Negative values
+// cannot be written as literals, so they are skipped.
+func (tr *translator) global(name string, v int64) {
+	switch {
+	case tr.emitted[name] || token.KeywordsMap[name] != "":
+		return
+	case v < 0 || v > 1<<31-1:
+		tr.skipped = append(tr.skipped, fmt.Sprintf("%s: value %d does not fit in a non-negative i32", name, v))
+		return
+	}
+	tr.emitted[name] = true
+	fmt.Fprintf(&tr.b, "var %s i32 = %d\n", name, v)
+}
+
+func (tr *translator) enum(e *enumDecl) {
+	if e.name == "" || tr.emitted[e.name] {
+		for _, m := range e.members {
+			if m.ok {
+				tr.global(m.name, m.value)
+			}
+		}
+		return
+	}
+	tr.emitted[e.name] = true
+
+	fmt.Fprintf(&tr.b, "enum %s {\n", safe(e.name))
+	for _, m := range e.members {
+		switch {
+		case !m.ok:
+			tr.skipped = append(tr.skipped, fmt.Sprintf("%s.%s: value is not constant", e.name, m.name))
+		case m.value < 0 || m.value > 1<<31-1:
+			tr.skipped = append(tr.skipped, fmt.Sprintf("%s.%s: value %d does not fit in a non-negative i32", e.name, m.name, m.value))
+		default:
+			fmt.Fprintf(&tr.b, "\t%s = %d\n", safe(m.name), m.value)
+		}
+	}
+	fmt.Fprintf(&tr.b, "}\n")
+}
+
+func (tr *translator) function(fd *funcDecl) error {
+	if token.KeywordsMap[fd.name] != "" {
+		return fmt.Errorf("name is a keyword")
+	}
+	params := make([]string, 0)
+	for i, p := range fd.typ.params {
+		t, err := tr.typ(p, true)
+		if err != nil {
+			return fmt.Errorf("parameter %d: %v", i+1, err)
+		}
+		name := fd.typ.names[i]
+		if name == "" {
+			name = fmt.Sprintf("p%d", i)
+		}
+		params = append(params, safe(name)+" "+t)
+	}
+	if fd.typ.variadic {
+		params = append(params, "...")
+	}
+
+	s := fmt.Sprintf("func %s(%s)", fd.name, strings.Join(params, ", "))
+	if !tr.isVoid(fd.typ.elem) {
+		t, err := tr.typ(fd.typ.elem, false)
+		if err != nil {
+			return fmt.Errorf("result: %v", err)
+		}
+		s += " " + t
+	}
+	fmt.Fprintf(&tr.b, "%s\n", s)
+
+	return nil
+}
+
+// typ returns the type in this language that t translates to. Function
+// pointers are only supported as parameters, where they are passed as plain C
+// function pointers.
+func (tr *translator) typ(t *ctype, param bool) (string, error) {
+	switch t.kind {
+	case basic:
+		switch t.name {
+		case "int":
+			return "i32", nil
+		case "char":
+			return "u8", nil
+		}
+		return "", fmt.Errorf("type %s is not supported", t.spelling)
+	case enumType:
+		if t.name != "" && tr.emitted[t.name] {
+			return safe(t.name), nil
+		}
+		return "i32", nil
+	case record:
+		return "", fmt.Errorf("struct %s can only be passed by pointer", t.name)
+	case typedefName:
+		u, ok := tr.typedefs[t.name]
+		if !ok {
+			return "", fmt.Errorf("unknown type %s", t.name)
+		}
+		if tr.isInt(u) && tr.emitted[t.name] {
+			return safe(t.name), nil
+		}
+		return tr.typ(u, param)
+	case pointer, array:
+		if f, ok := tr.funcPointer(t); ok {
+			if !param {
+				return "", fmt.Errorf("function pointers are only supported as parameters")
+			}
+			return tr.funcType(f)
+		}
+		if name, ok := tr.recordName(t.elem); ok {
+			return "^" + safe(name), nil
+		}
+		elem, err := tr.typ(t.elem, false)
+		if err != nil {
+			return "^i32", nil
+		}
+		return "^" + elem, nil
+	default:
+		return "", fmt.Errorf("unsupported type")
+	}
+}
+
+func (tr *translator) funcType(f *ctype) (string, error) {
+	if f.variadic {
+		return "", fmt.Errorf("variadic function pointers are not supported")
+	}
+	params := make([]string, 0)
+	for _, p := range f.params {
+		t, err := tr.typ(p, false)
+		if err != nil {
+			return "", err
+		}
+		params = append(params, t)
+	}
+	s := "func(" + strings.Join(params, ", ") + ")"
+	if !tr.isVoid(f.elem) {
+		t, err := tr.typ(f.elem, false)
+		if err != nil {
+			return "", err
+		}
+		s += " " + t
+	}
+	return s, nil
+}
+
+// funcPointer returns the function type that t points to, if it is a pointer
+// to a function.
+func (tr *translator) funcPointer(t *ctype) (*ctype, bool) {
+	elem := tr.underlying(t.elem)
+	if elem.kind == function {
+		return elem, true
+	}
+	return nil, false
+}
+
+// recordName returns the name of the opaque type of t if t is a struct.
+func (tr *translator) recordName(t *ctype) (string, bool) {
+	t = tr.underlying(t)
+	return t.name, t.kind == record && t.name != ""
+}
+
+// underlying resolves typedef names.
+func (tr *translator) underlying(t *ctype) *ctype {
+	for i := 0; t.kind == typedefName && i < 16; i++ {
+		u, ok := tr.typedefs[t.name]
+		if !ok {
+			break
+		}
+		t = u
+	}
+	return t
+}
+
+func (tr *translator) isInt(t *ctype) bool {
+	t = tr.underlying(t)
+	return t.kind == basic && t.name == "int"
+}
+
+func (tr *translator) isVoid(t *ctype) bool {
+	t = tr.underlying(t)
+	return t.kind == basic && t.name == "void"
+}
+
+// safe renames names that are keywords of this language.
+func safe(name string) string {
+	if token.KeywordsMap[name] != "" {
+		return name + "_"
+	}
+	return name
+}
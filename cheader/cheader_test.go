@@ -0,0 +1,82 @@
+package cheader
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	input := `
+#ifndef DEMO_H
+#define DEMO_H
+#ifdef __cplusplus
+extern "C" {
+#endif
+#if defined(_WIN32)
+ #define API __declspec(dllexport)
+#else
+ #define API
+#endif
+#define VERSION 3
+#define FLAG (1 << 4) /* a flag */
+#define NEGATIVE -1
+#define MAX(a, b) ((a) > (b) ? (a) : (b))
+typedef int flag;
+typedef struct window window;
+typedef struct { int x, y; } point;
+typedef enum { RED, GREEN = 5, BLUE } color;
+enum { ANON = VERSION * 2 };
+typedef void (*callback)(window* w, int key);
+API int init(void);
+API window* open(int width, int height, const char* title);
+API void on_key(window *w, callback cb);
+API flag is(color c, unsigned int type);
+API double now(void);
+API unsigned long long ticks(void);
+API int logf(const char* format, ...);
+API point where(window* w);
+static inline int twice(int x) { return x * 2; }
+#ifdef __cplusplus
+}
+#endif
+#endif
+`
+	want := `package demo
+
+var VERSION i32 = 3
+var FLAG i32 = 16
+enum color {
+	RED = 0
+	GREEN = 5
+	BLUE = 6
+}
+var ANON i32 = 6
+type window i32
+type point i32
+type flag i32
+func init() i32
+func open(width i32, height i32, title ^u8) ^window
+func on_key(w ^window, cb func(^window, i32))
+func is(c color, type_ i32) flag
+func logf(format ^u8, ...) i32
+`
+	got, skipped := Translate("demo", input)
+	if got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	wantSkipped := []string{
+		"NEGATIVE: value -1 does not fit",
+		"now: result: type double is not supported",
+		"ticks: result: type unsigned long long is not supported",
+		"where: result: struct point can only be passed by pointer",
+	}
+	if len(skipped) != len(wantSkipped) {
+		t.Fatalf("expected %d skipped declarations, got %v", len(wantSkipped), skipped)
+	}
+	for i := range wantSkipped {
+		if !strings.Contains(skipped[i], wantSkipped[i]) {
+			t.Fatalf("[%d] expected %q, got %q", i, wantSkipped[i], skipped[i])
+		}
+	}
+}
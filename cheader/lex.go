@@ -0,0 +1,183 @@
+package cheader
+
+import (
+	"strings"
+)
+
+// macro is an object-like macro defined with #define.
+type macro struct {
+	name  string
+	value []string
+}
+
+// preprocess removes comments and runs the directives of a header. Macros
+// are collected instead of being expanded. #ifdef and #ifndef are evaluated,
+// but all branches of #if are kept, since their conditions usually depend on
+// the platform. Later definitions of a macro replace earlier ones.
+func preprocess(src string) ([]string, []*macro) {
+	src = stripComments(strings.ReplaceAll(src, "\\\n", " "))
+
+	code := make([]string, 0)
+	macros := make([]*macro, 0)
+	defined := make(map[string]bool)
+
+	// stack holds for each open conditional whether its current branch is
+	// kept and whether the branch is decided by #ifdef or #ifndef.
+	type cond struct {
+		keep    bool
+		decided bool
+	}
+	stack := make([]cond, 0)
+	keep := func() bool {
+		for _, c := range stack {
+			if !c.keep {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "#") {
+			if keep() {
+				code = append(code, line)
+			}
+			continue
+		}
+
+		toks := tokenize(strings.TrimSpace(trimmed[1:]))
+		if len(toks) == 0 {
+			continue
+		}
+		switch toks[0] {
+		case "ifdef", "ifndef":
+			want := toks[0] == "ifdef"
+			stack = append(stack, cond{keep: len(toks) > 1 && defined[toks[1]] == want, decided: true})
+		case "if":
+			stack = append(stack, cond{keep: true})
+		case "elif":
+			if len(stack) > 0 && stack[len(stack)-1].decided {
+				stack[len(stack)-1].keep = false
+			}
+		case "else":
+			if len(stack) > 0 && stack[len(stack)-1].decided {
+				stack[len(stack)-1].keep = !stack[len(stack)-1].keep
+			}
+		case "endif":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case "define":
+			if !keep() || len(toks) < 2 {
+				continue
+			}
+			defined[toks[1]] = true
+			// Function-like macros have a parenthesis right after the name.
+			rest := strings.TrimSpace(trimmed[1:])
+			rest = strings.TrimSpace(strings.TrimPrefix(rest, "define"))
+			if strings.HasPrefix(rest[len(toks[1]):], "(") {
+				continue
+			}
+			macros = append(macros, &macro{name: toks[1], value: toks[2:]})
+		case "undef":
+			if keep() && len(toks) > 1 {
+				delete(defined, toks[1])
+			}
+		}
+	}
+
+	return code, macros
+}
+
+func stripComments(src string) string {
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch {
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			// Keep the line breaks, so that directives stay on their own
+			// lines.
+			b.WriteString(strings.Repeat("\n", strings.Count(src[i:i+2+end], "\n")))
+			b.WriteByte(' ')
+			i += end + 3
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				return b.String()
+			}
+			i += end - 1
+		case src[i] == '"' || src[i] == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != src[i] {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				j = len(src) - 1
+			}
+			b.WriteString(src[i : j+1])
+			i = j
+		default:
+			b.WriteByte(src[i])
+		}
+	}
+	return b.String()
+}
+
+// tokenize splits C source into identifiers, numbers, string and character
+// literals and punctuation. Punctuation is split into single characters,
+// except for the ellipsis.
+func tokenize(src string) []string {
+	toks := make([]string, 0)
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f' || ch == '\v':
+			i++
+		case isIdent(ch) || isDigit(ch):
+			j := i
+			for j < len(src) && (isIdent(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		case ch == '"' || ch == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != ch {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				j = len(src) - 1
+			}
+			toks = append(toks, src[i:j+1])
+			i = j + 1
+		case strings.HasPrefix(src[i:], "..."):
+			toks = append(toks, "...")
+			i += 3
+		case strings.HasPrefix(src[i:], "<<"), strings.HasPrefix(src[i:], ">>"):
+			toks = append(toks, src[i:i+2])
+			i += 2
+		default:
+			toks = append(toks, string(ch))
+			i++
+		}
+	}
+	return toks
+}
+
+func isIdent(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '_'
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
@@ -3,32 +3,151 @@ package checker
 import (
 	"fmt"
 	"lang/ast"
+	"lang/diag"
 	"lang/token"
+	"lang/types"
+	"math"
+	"strings"
+	"unicode"
 )
 
 type Checker struct {
-	program *ast.Program
-	context *Context
-	Errors  []string
+	program  *ast.Program
+	context  *Context
+	funcDecl *ast.FuncDecl
+	methods  map[*types.Named]map[string]*ast.FuncDecl
+	Errors   []*diag.Diagnostic
+
+	// Scopes maps functions, function literals and cases to the context of
+	// their body, for tools that look up the names in scope at a position.
+	Scopes map[ast.Node]*Context
+
+	// scope is the outermost context, which holds the declarations of the
+	// package, and imports the checkers of the packages it imports by name
+	scope   *Context
+	imports map[string]*Checker
 }
 
 func New(p *ast.Program) *Checker {
 	c := &Checker{
 		program: p,
 		context: newContext(nil),
-		Errors:  make([]string, 0),
+		methods: make(map[*types.Named]map[string]*ast.FuncDecl),
+		Errors:  make([]*diag.Diagnostic, 0),
+		Scopes:  make(map[ast.Node]*Context),
+		imports: make(map[string]*Checker),
 	}
+	c.scope = c.context
 	return c
 }
 
+// CheckPackages checks the packages of a program, which must be ordered so
+// that each package comes after the packages it imports. It returns the errors
+// of all packages.
+func CheckPackages(progs []*ast.Program) []*diag.Diagnostic {
+	errors := make([]*diag.Diagnostic, 0)
+	for _, c := range Checkers(progs) {
+		errors = append(errors, c.Errors...)
+	}
+	return errors
+}
+
+// Checkers checks the packages of a program like CheckPackages and returns
+// their checkers in the same order, which keep the errors and the scopes of
+// each package.
+func Checkers(progs []*ast.Program) []*Checker {
+	checkers := make([]*Checker, 0, len(progs))
+	byProg := make(map[*ast.Program]*Checker)
+	methods := make(map[*types.Named]map[string]*ast.FuncDecl)
+
+	symbols := make(map[string]*ast.FuncDecl)
+
+	for _, prog := range progs {
+		c := New(prog)
+		c.methods = methods
+		for _, imp := range prog.Imports {
+			if dep, ok := byProg[imp.Program]; ok {
+				c.imports[imp.Name] = dep
+			}
+		}
+		c.Check()
+		c.checkSymbols(symbols)
+
+		byProg[prog] = c
+		checkers = append(checkers, c)
+	}
+
+	return checkers
+}
+
+// Scope returns the context of the declarations of the package.
+func (c *Checker) Scope() *Context {
+	return c.scope
+}
+
+// checkSymbols checks that the functions of the package whose symbols are
+// not qualified by the package name, which are the functions of the main
+// package and exported functions, do not collide with those of the packages
+// in symbols. The functions are added to symbols.
+func (c *Checker) checkSymbols(symbols map[string]*ast.FuncDecl) {
+	main := c.program.Package == "" || c.program.Package == "main"
+	local := make(map[string]*ast.FuncDecl)
+	for _, stmt := range c.program.Statements {
+		fd, ok := stmt.(*ast.FuncDecl)
+		if !ok || fd.Extern || fd.Receiver != nil || !(fd.Export || main) {
+			continue
+		}
+		if dup, ok := symbols[fd.Token.Value]; ok {
+			c.report(diag.Errorf(diag.At(fd.Token), diag.Duplicate, "symbol %s is already defined", fd.Token.Value)).
+				Note(diag.At(dup.Token), "%s is defined here", dup.Token.Value)
+			continue
+		}
+		local[fd.Token.Value] = fd
+	}
+	for name, fd := range local {
+		symbols[name] = fd
+	}
+}
+
 func (c *Checker) Check() {
+	for _, stmt := range c.program.Statements {
+		if td, ok := stmt.(*ast.TypeDecl); ok {
+			c.checkTypeDeclDup(td)
+			c.context.types[td.Token.Value] = td
+		}
+	}
+
+	for _, stmt := range c.program.Statements {
+		if td, ok := stmt.(*ast.TypeDecl); ok {
+			c.checkTypeDecl(td)
+		}
+	}
+
 	for _, stmt := range c.program.Statements {
 		switch v := stmt.(type) {
+		case *ast.TypeDecl:
 		case *ast.FuncDecl:
+			c.checkExport(v)
+			if v.Receiver != nil {
+				c.checkMethodDecl(v)
+				continue
+			}
 			c.checkFuncDeclDup(v)
+			c.checkVariadic(v)
+			c.checkSignature(v)
+			c.checkMain(v)
 			c.context.funcs[v.Token.Value] = v
 		case *ast.VarDecl:
 			c.checkVarDecl(v)
+			if t := v.Type.Type; !isInteger(t) && !unresolved(t) {
+				c.error(v.Token, "global %s must have an integer type, not %s", v.Token.Value, types.String(t))
+			}
+			switch v.Value.(type) {
+			case *ast.IntLiteral, *ast.BadExpr:
+			default:
+				c.error(v.Token, "global %s must be initialized with an integer literal", v.Token.Value)
+			}
+		case *ast.BadStmt:
 		default:
 			panic("unsupported type")
 		}
@@ -38,15 +157,180 @@ func (c *Checker) Check() {
 }
 
 func (c *Checker) checkReturn(r *ast.Return) {
-	c.checkExpression(r.Value)
+	switch {
+	case c.funcDecl == nil:
+		if r.HasValue {
+			c.checkExpression(r.Value)
+		}
+		c.error(r.Token, "return outside of a function")
+	case r.HasValue && !c.funcDecl.HasReturn:
+		c.checkExpression(r.Value)
+		c.error(r.Token, "too many return values, %s returns nothing", c.funcDecl.Token.Value)
+	case r.HasValue:
+		c.checkExpression(r.Value)
+		c.checkAssignable(r.Token, c.funcDecl.ReturnType.Type, r.Value)
+	case c.funcDecl.HasReturn:
+		c.error(r.Token, "missing return value, %s returns %s", c.funcDecl.Token.Value, types.String(c.funcDecl.ReturnType.Type))
+	}
+}
+
+// checkTry checks that the operand of try is an optional or a result, and
+// that the enclosing function can return its none or error.
+func (c *Checker) checkTry(t *ast.Try) {
+	c.checkExpression(t.X)
+
+	if c.funcDecl == nil {
+		c.error(t.Token, "try outside of a function")
+		return
+	}
+	ret := c.funcDecl.Result()
+
+	switch x := t.X.Type().(type) {
+	case *types.Optional:
+		if _, ok := ret.(*types.Optional); !ok {
+			c.errorPropagate(t.Token, "none", ret)
+		}
+	case *types.Result:
+		r, ok := ret.(*types.Result)
+		if !ok || !types.Identical(r.Err, x.Err) {
+			c.errorPropagate(t.Token, fmt.Sprintf("err(%s)", types.String(x.Err)), ret)
+		}
+	default:
+		c.error(t.Token, "try requires an optional or a result, got %s", types.String(x))
+	}
+}
+
+func (c *Checker) errorPropagate(t token.Token, what string, ret types.Type) {
+	if _, ok := ret.(*types.Nil); ok {
+		c.error(t, "cannot propagate %s from %s, which returns nothing", what, c.funcDecl.Token.Value)
+		return
+	}
+	c.error(t, "cannot propagate %s from %s, which returns %s", what, c.funcDecl.Token.Value, types.String(ret))
+}
+
+// checkDefer checks that a defer statement defers a function call. Method
+// calls cannot be deferred, but a function literal that makes one can.
+func (c *Checker) checkDefer(d *ast.Defer) {
+	switch v := d.Call.(type) {
+	case *ast.FuncCall:
+		c.checkFuncCall(v)
+	case *ast.MethodCall:
+		c.checkMethodCall(v)
+		c.error(d.Token, "cannot defer method call %s, defer a function literal that calls it", v.Token.Value)
+		return
+	default:
+		c.error(d.Token, "expression in defer must be a function call")
+		return
+	}
+	if c.funcDecl == nil {
+		c.error(d.Token, "defer outside of a function")
+	}
 }
 
 func (c *Checker) checkVar(v *ast.Var) {
-	vd, ok := c.context.getVar(v.Token.Value)
-	if ok {
+	if v.Package != "" {
+		c.checkQualifiedVar(v)
+		return
+	}
+
+	if vd, lits, ok := c.context.lookupVar(v.Token.Value); ok {
+		c.capture(vd, lits)
 		v.VarDecl = vd
-	} else {
+		return
+	}
+
+	fd, ok := c.context.getFuncDecl(v.Token.Value)
+	if !ok {
 		c.errorNotFound(v.Token, v.Token.Value)
+		return
+	}
+	c.checkFuncValue(v, fd)
+}
+
+// checkQualifiedVar checks a reference to a variable or function of an
+// imported package.
+func (c *Checker) checkQualifiedVar(v *ast.Var) {
+	scope, ok := c.importScope(v.Token, v.Package, v.Token.Value)
+	if !ok {
+		return
+	}
+	if vd, ok := scope.vars[v.Token.Value]; ok {
+		v.VarDecl = vd
+		return
+	}
+	fd, ok := scope.funcs[v.Token.Value]
+	if !ok {
+		c.errorNotFound(v.Token, v.Package+"."+v.Token.Value)
+		return
+	}
+	c.checkFuncValue(v, fd)
+}
+
+// checkFuncValue checks the use of the function fd as a value.
+func (c *Checker) checkFuncValue(v *ast.Var, fd *ast.FuncDecl) {
+	if len(fd.TypeParams) > 0 {
+		c.error(v.Token, "cannot use generic function %s without instantiation", v.Token.Value)
+		return
+	}
+	if fd.VarArgs {
+		c.error(v.Token, "cannot use variadic function %s as a value", v.Token.Value)
+		return
+	}
+	if fd.Extern && hasFuncParams(fd) {
+		c.error(v.Token, "cannot use extern function %s with function parameters as a value", v.Token.Value)
+		return
+	}
+	v.FuncDecl = fd
+}
+
+// capture records that the function literals capture vd.
+func (c *Checker) capture(vd *ast.VarDecl, lits []*ast.FuncLit) {
+outer:
+	for _, lit := range lits {
+		vd.Captured = true
+
+		for _, captured := range lit.Captures {
+			if captured == vd {
+				continue outer
+			}
+		}
+		lit.Captures = append(lit.Captures, vd)
+	}
+}
+
+func (c *Checker) checkFuncLit(lit *ast.FuncLit) {
+	fd := lit.Decl
+	if fd.HasReturn {
+		c.resolveType(fd.ReturnType)
+	}
+
+	outer := c.funcDecl
+	c.funcDecl = fd
+	c.pushContext()
+	c.context.closure = lit
+	c.Scopes[lit] = c.context
+	c.checkVariadic(fd)
+
+	for _, vd := range fd.Params {
+		c.checkVarDecl(vd)
+	}
+	c.checkStatements(fd.Body)
+	c.checkTerminates(fd)
+
+	c.popContext()
+	c.funcDecl = outer
+}
+
+func (c *Checker) checkAssign(a *ast.Assign) {
+	c.checkVar(a.X)
+	c.checkExpression(a.Value)
+
+	if a.X.FuncDecl != nil {
+		c.error(a.Token, "cannot assign to function %s", a.X.Token.Value)
+		return
+	}
+	if a.X.VarDecl != nil {
+		c.checkAssignable(a.Token, a.X.VarDecl.Type.Type, a.Value)
 	}
 }
 
@@ -55,7 +339,9 @@ func (c *Checker) checkVarDecl(vd *ast.VarDecl) {
 		c.errorDuplicate(vd.Token, dup.Token)
 	}
 
+	c.resolveType(vd.Type)
 	c.checkExpression(vd.Value)
+	c.checkAssignable(vd.Token, vd.Type.Type, vd.Value)
 
 	c.context.vars[vd.Token.Value] = vd
 }
@@ -68,16 +354,51 @@ func (c *Checker) checkExpression(e ast.Expression) {
 		c.checkInfixExpression(v)
 	case *ast.FuncCall:
 		c.checkFuncCall(v)
+	case *ast.MethodCall:
+		c.checkMethodCall(v)
+	case *ast.Selector:
+		c.checkSelector(v)
+		if v.Variant != nil && len(v.Variant.Fields) > 0 {
+			c.error(v.Token, "wrong number of arguments to %s.%s: got 0, want %d", v.Variant.Union.Name(), v.Variant.Name, len(v.Variant.Fields))
+		}
+	case *ast.FuncLit:
+		c.checkFuncLit(v)
+	case *ast.Try:
+		c.checkTry(v)
+	case *ast.Err:
+		c.checkExpression(v.X)
+	case *ast.Index:
+		c.checkIndex(v)
+	case *ast.None:
 	case *ast.IntLiteral:
+	case *ast.StringLiteral:
 	case *ast.EmptyExpression:
+	case *ast.BadExpr:
 	default:
 		panic(fmt.Sprintf("checking unsupported expression: %T", v))
 	}
 }
 
+// checkInfixExpression checks that the operands of an arithmetic operator are
+// integers. The right operand is converted to the type of the left one.
 func (c *Checker) checkInfixExpression(ie *ast.InfixExpression) {
 	c.checkExpression(ie.Left)
 	c.checkExpression(ie.Right)
+
+	for _, x := range []ast.Expression{ie.Left, ie.Right} {
+		if !c.checkValue(x) {
+			return
+		}
+		if t := x.Type(); !isInteger(t) && !unresolved(t) {
+			c.error(ie.Token, "operator %s not defined on %s", ie.Token.Value, types.String(t))
+			return
+		}
+	}
+	for _, x := range []ast.Expression{ie.Left, ie.Right} {
+		if il, ok := x.(*ast.IntLiteral); ok {
+			c.checkOverflow(ie.Type(), il)
+		}
+	}
 }
 
 func (c *Checker) checkFuncArg(fc *ast.FuncArg) {
@@ -85,62 +406,1464 @@ func (c *Checker) checkFuncArg(fc *ast.FuncArg) {
 }
 
 func (c *Checker) checkFuncCall(fc *ast.FuncCall) {
-	fd, ok := c.context.getFuncDecl(fc.Token.Value)
-	if !ok {
-		c.errorNotFound(fc.Token, fc.Token.Value)
+	var fd *ast.FuncDecl
+	if fc.Package != "" {
+		fd = c.qualifiedFunc(fc)
+	} else {
+		if vd, lits, ok := c.context.lookupVar(fc.Token.Value); ok {
+			c.capture(vd, lits)
+			c.checkIndirectCall(fc, vd)
+			return
+		}
+
+		var ok bool
+		fd, ok = c.context.getFuncDecl(fc.Token.Value)
+		if !ok && fc.Token.Value == "len" {
+			c.checkLen(fc)
+			return
+		}
+		if !ok {
+			c.errorNotFound(fc.Token, fc.Token.Value)
+		}
 	}
 
 	fc.FuncDecl = fd
+
+	for _, arg := range fc.Args {
+		c.checkExpression(arg)
+	}
+
+	if fd == nil {
+		return
+	}
+
+	if len(fd.TypeParams) > 0 {
+		if !c.checkInstance(fc) {
+			return
+		}
+	} else if len(fc.TypeArgs) > 0 {
+		c.error(fc.Token, "%s is not a generic function", fc.Token.Value)
+		return
+	}
+
+	sig := fc.Signature()
+	if fd.VarArgs {
+		c.checkVarArgs(fc, sig)
+	} else {
+		c.checkArgs(fc.Token, fc.Args, sig)
+	}
+
+	// C functions take plain function pointers, which closures cannot be
+	// converted to.
+	if fd.Extern && len(fc.Args) >= len(sig.Params) {
+		for i, p := range sig.Params {
+			if _, ok := p.(*types.Func); !ok {
+				continue
+			}
+			if v, ok := fc.Args[i].(*ast.Var); !ok || v.FuncDecl == nil {
+				c.error(fc.Token, "only named functions can be passed as callbacks to extern function %s", fc.Token.Value)
+			}
+		}
+	}
 }
 
-func (c *Checker) checkFuncDecl(fd *ast.FuncDecl) {
-	c.pushContext()
-	defer c.popContext()
+// checkVarArgs checks a call of a C function with varargs. The arguments
+// after the fixed parameters are passed with the default argument promotions
+// of C, so they must have a type that can be promoted.
+func (c *Checker) checkVarArgs(fc *ast.FuncCall, sig *types.Func) {
+	n := len(sig.Params)
+	if len(fc.Args) < n {
+		c.error(fc.Token, "wrong number of arguments to %s: got %d, want at least %d", fc.Token.Value, len(fc.Args), n)
+		return
+	}
+	c.checkArgs(fc.Token, fc.Args[:n], sig)
+
+	for _, arg := range fc.Args[n:] {
+		t := arg.Type()
+		if types.Promote(t) == nil {
+			c.error(fc.Token, "cannot pass %s as a variadic argument to C function %s", types.String(t), fc.Token.Value)
+		}
+	}
+}
+
+// checkLen checks a call of the builtin len, which returns the length of a
+// slice.
+func (c *Checker) checkLen(fc *ast.FuncCall) {
+	fc.Builtin = true
+	for _, arg := range fc.Args {
+		c.checkExpression(arg)
+	}
+
+	if len(fc.Args) != 1 {
+		c.error(fc.Token, "wrong number of arguments to len: got %d, want 1", len(fc.Args))
+		return
+	}
+	t := fc.Args[0].Type()
+	if _, ok := t.(*types.Slice); !ok {
+		c.error(fc.Token, "invalid argument of type %s for len", types.String(t))
+	}
+}
+
+func (c *Checker) checkIndex(ix *ast.Index) {
+	c.checkExpression(ix.X)
+	c.checkExpression(ix.Index)
+
+	t := ix.X.Type()
+	if _, ok := t.(*types.Nil); ok {
+		return
+	}
+	if _, ok := t.(*types.Slice); !ok {
+		c.error(ix.Token, "cannot index value of type %s", types.String(t))
+		return
+	}
+	if !ix.Index.Type().IsNumeric() {
+		c.error(ix.Token, "invalid index of type %s", types.String(ix.Index.Type()))
+	}
+}
+
+// checkVariadic checks where variadic parameters are declared. Only extern
+// functions take C varargs, and they cannot receive a slice.
+func (c *Checker) checkVariadic(fd *ast.FuncDecl) {
+	switch {
+	case fd.Receiver != nil && (fd.Variadic || fd.VarArgs):
+		c.error(fd.Token, "methods cannot be variadic")
+	case fd.Extern && fd.Variadic:
+		c.error(fd.Token, "extern function %s must declare its varargs as ...", fd.Token.Value)
+	case !fd.Extern && fd.VarArgs:
+		c.error(fd.Token, "only extern functions can take C varargs, declare the last parameter of %s as ...T", fd.Token.Value)
+	}
+}
+
+// checkExport checks that an exported function can be called from C.
+func (c *Checker) checkExport(fd *ast.FuncDecl) {
+	if !fd.Export {
+		return
+	}
+
+	_, returnsFunc := fd.Result().(*types.Func)
+	switch {
+	case fd.Receiver != nil:
+		c.error(fd.Token, "methods cannot be exported")
+	case fd.Extern:
+		c.error(fd.Token, "exported function %s must have a body", fd.Token.Value)
+	case len(fd.TypeParams) > 0:
+		c.error(fd.Token, "generic function %s cannot be exported", fd.Token.Value)
+	case hasFuncParams(fd) || returnsFunc:
+		c.error(fd.Token, "exported function %s cannot take or return functions", fd.Token.Value)
+	}
+}
+
+// checkMain checks that the main function of the main package can be called
+// like main in C, which takes no parameters or argc and argv and returns the
+// exit status.
+func (c *Checker) checkMain(fd *ast.FuncDecl) {
+	if fd.Token.Value != "main" || !(c.program.Package == "" || c.program.Package == "main") {
+		return
+	}
+
+	argv := &types.Pointer{To: &types.Pointer{To: types.TypeUint8}}
+	params := true
+	switch len(fd.Params) {
+	case 0:
+	case 2:
+		for i, want := range []types.Type{types.TypeInt32, argv} {
+			t := fd.Params[i].Type.Type
+			params = params && (unresolved(t) || types.Identical(t, want))
+		}
+	default:
+		params = false
+	}
+	if !params {
+		c.error(fd.Token, "main must take no parameters or (i32, ^^u8), not %s", types.String(fd.Signature()))
+	}
+
+	if t := fd.Result(); fd.HasReturn && !isInteger(t) && !unresolved(t) {
+		c.error(fd.Token, "main must return an integer or nothing, not %s", types.String(t))
+	}
+}
+
+// declares reports whether the named type t, or the type t points to, is
+// declared in the checked package.
+func (c *Checker) declares(t types.Type) bool {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.To
+	}
+	for _, td := range c.scope.types {
+		if td.Type.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// qualifiedFunc returns the function of an imported package that fc calls, or
+// nil if there is none.
+func (c *Checker) qualifiedFunc(fc *ast.FuncCall) *ast.FuncDecl {
+	scope, ok := c.importScope(fc.Token, fc.Package, fc.Token.Value)
+	if !ok {
+		return nil
+	}
+	fd, ok := scope.funcs[fc.Token.Value]
+	if !ok {
+		c.errorNotFound(fc.Token, fc.Package+"."+fc.Token.Value)
+		return nil
+	}
+	return fd
+}
+
+// importScope returns the declarations of the imported package pkg, if name
+// is exported from it. Names that start with an upper case letter are
+// exported.
+func (c *Checker) importScope(t token.Token, pkg, name string) (*Context, bool) {
+	imp, ok := c.imports[pkg]
+	if !ok {
+		c.error(t, "package %s not imported", pkg)
+		return nil, false
+	}
+	if !imp.exports(name) {
+		c.error(t, "cannot refer to unexported name %s.%s", pkg, name)
+		return nil, false
+	}
+	return imp.scope, true
+}
+
+// exports reports whether name can be referred to from other packages.
+func (c *Checker) exports(name string) bool {
+	return c.program.Foreign || exported(name)
+}
 
+func exported(name string) bool {
+	return name != "" && unicode.IsUpper(rune(name[0]))
+}
+
+func hasFuncParams(fd *ast.FuncDecl) bool {
 	for _, vd := range fd.Params {
-		c.checkVarDecl(vd)
+		if _, ok := vd.Type.Type.(*types.Func); ok {
+			return true
+		}
 	}
+	return false
+}
 
-	for _, s := range fd.Body {
-		switch v := s.(type) {
-		case *ast.VarDecl:
-			c.checkVarDecl(v)
-		case *ast.Return:
-			c.checkReturn(v)
+// checkIndirectCall checks a call through a variable of function type.
+func (c *Checker) checkIndirectCall(fc *ast.FuncCall, vd *ast.VarDecl) {
+	for _, arg := range fc.Args {
+		c.checkExpression(arg)
+	}
+
+	sig, ok := vd.Type.Type.(*types.Func)
+	if !ok {
+		c.error(fc.Token, "cannot call non-function %s of type %s", fc.Token.Value, types.String(vd.Type.Type))
+		return
+	}
+	if len(fc.TypeArgs) > 0 {
+		c.error(fc.Token, "%s is not a generic function", fc.Token.Value)
+		return
+	}
+	fc.VarDecl = vd
+
+	c.checkArgs(fc.Token, fc.Args, sig)
+}
+
+// checkInstance sets the type arguments of a call of a generic function,
+// inferring them from the arguments if they are not given, and checks them
+// against the constraints of the type parameters.
+func (c *Checker) checkInstance(fc *ast.FuncCall) bool {
+	fd := fc.FuncDecl
+	bindings := make(map[*types.TypeParam]types.Type)
+
+	if len(fc.TypeArgs) > 0 {
+		if len(fc.TypeArgs) != len(fd.TypeParams) {
+			c.error(fc.Token, "wrong number of type arguments to %s: got %d, want %d", fc.Token.Value, len(fc.TypeArgs), len(fd.TypeParams))
+			return false
+		}
+		for i, t := range fc.TypeArgs {
+			c.resolveType(t)
+			bindings[fd.TypeParams[i].Type] = t.Type
+		}
+	} else {
+		sig := fd.Signature()
+		if !c.checkArgCount(fc.Token, fc.Args, sig) {
+			return false
+		}
+		for i, arg := range fc.Args {
+			if !c.infer(fc.Token, paramType(sig, i), arg.Type(), bindings) {
+				return false
+			}
+		}
+	}
+
+	ok := true
+	for _, tp := range fd.TypeParams {
+		t, found := bindings[tp.Type]
+		if !found {
+			c.error(fc.Token, "cannot infer %s in call to %s", tp.Type.Name(), fc.Token.Value)
+			return false
+		}
+		fc.Instance = append(fc.Instance, t)
+
+		if tp.Type.Constraint == nil {
+			continue
+		}
+		if missing := c.missingMethods(t, tp.Type.Constraint); len(missing) > 0 {
+			c.error(fc.Token, "%s does not satisfy %s, missing methods: %s", types.String(t), tp.Type.Constraint.Name(), strings.Join(missing, ", "))
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// infer binds the type parameters in param to the corresponding parts of arg.
+func (c *Checker) infer(t token.Token, param, arg types.Type, bindings map[*types.TypeParam]types.Type) bool {
+	switch p := param.(type) {
+	case *types.TypeParam:
+		// none and err(e) only convert to other types, so they say nothing
+		// about the type of the parameter.
+		switch arg.(type) {
+		case *types.Nil, *types.None, *types.Error:
+			return true
+		}
+		if b, ok := bindings[p]; ok && !types.Identical(b, arg) {
+			c.error(t, "conflicting types for %s: %s and %s", p.Name(), types.String(b), types.String(arg))
+			return false
+		}
+		bindings[p] = arg
+	case *types.Pointer:
+		if a, ok := arg.(*types.Pointer); ok {
+			return c.infer(t, p.To, a.To, bindings)
+		}
+	case *types.Slice:
+		if a, ok := arg.(*types.Slice); ok {
+			return c.infer(t, p.Elem, a.Elem, bindings)
+		}
+	case *types.Func:
+		a, ok := arg.(*types.Func)
+		if !ok || len(a.Params) != len(p.Params) {
+			return true
+		}
+		for i := range p.Params {
+			if !c.infer(t, p.Params[i], a.Params[i], bindings) {
+				return false
+			}
+		}
+		return c.infer(t, p.Return, a.Return, bindings)
+	case *types.Optional:
+		switch a := arg.(type) {
+		case *types.Optional:
+			return c.infer(t, p.Elem, a.Elem, bindings)
+		case *types.None:
 		default:
-			panic(fmt.Sprintf("cannot check body %T", v))
+			return c.infer(t, p.Elem, arg, bindings)
+		}
+	case *types.Result:
+		switch a := arg.(type) {
+		case *types.Result:
+			return c.infer(t, p.Err, a.Err, bindings) && c.infer(t, p.Value, a.Value, bindings)
+		case *types.Error:
+			return c.infer(t, p.Err, a.Err, bindings)
+		default:
+			return c.infer(t, p.Value, arg, bindings)
 		}
 	}
+	return true
 }
 
-func (c *Checker) checkFuncDecls() {
-	it := ast.NewIterator(c.program)
-	for n, ok := it.Next(); ok; n, ok = it.Next() {
-		switch v := n.(type) {
-		case *ast.FuncDecl:
-			c.checkFuncDecl(v)
+func (c *Checker) checkMethodCall(mc *ast.MethodCall) {
+	if u, ok := c.typeNamedBy(mc.Receiver).(*types.Union); ok {
+		c.checkVariantCall(mc, u)
+		return
+	}
+
+	c.checkExpression(mc.Receiver)
+	for _, arg := range mc.Args {
+		c.checkExpression(arg)
+	}
+
+	t := mc.Receiver.Type()
+	if _, ok := t.(*types.Nil); ok {
+		return
+	}
+
+	if iface, ok := t.(*types.Interface); ok {
+		if _, m, ok := iface.Method(mc.Token.Value); ok {
+			mc.Method = m
 		}
+	} else if tp, ok := t.(*types.TypeParam); ok {
+		if m, ok := c.lookupMethod(tp, mc.Token.Value); ok {
+			mc.Method = m
+		}
+	} else if fd, ok := c.methodDecl(t, mc.Token.Value); ok {
+		if !c.checkReceiver(mc, fd) {
+			return
+		}
+		if !exported(mc.Token.Value) && !c.declares(fd.Receiver.Type.Type) {
+			c.error(mc.Token, "cannot refer to unexported method %s of %s", mc.Token.Value, types.String(t))
+			return
+		}
+		mc.FuncDecl = fd
+		mc.Method = &types.Method{Name: mc.Token.Value, Sig: fd.Signature()}
+	}
+
+	if mc.Method == nil {
+		c.error(mc.Token, "%s has no method %s", types.String(t), mc.Token.Value)
+		return
 	}
+
+	c.checkArgs(mc.Token, mc.Args, mc.Method.Sig)
 }
 
-func (c *Checker) checkFuncDeclDup(fd *ast.FuncDecl) {
-	if dup, ok := c.context.getFuncDecl(fd.Token.Value); ok {
-		c.errorDuplicate(fd.Token, dup.Token)
+// checkReceiver checks that the receiver of a call to a method with a pointer
+// receiver is either a pointer or a variable, whose address is taken.
+func (c *Checker) checkReceiver(mc *ast.MethodCall, fd *ast.FuncDecl) bool {
+	if _, ok := fd.Receiver.Type.Type.(*types.Pointer); !ok {
+		return true
+	}
+	if _, ok := mc.Receiver.Type().(*types.Pointer); ok {
+		return true
+	}
+	if _, ok := mc.Receiver.(*ast.Var); ok {
+		return true
+	}
+
+	c.error(mc.Token, "cannot take the address of the receiver of %s", mc.Token.Value)
+	return false
+}
+
+func (c *Checker) checkArgs(t token.Token, args []ast.Expression, sig *types.Func) {
+	if !c.checkArgCount(t, args, sig) {
+		return
+	}
+
+	for i, arg := range args {
+		c.checkAssignable(t, paramType(sig, i), arg)
+	}
+}
+
+// checkArgCount reports an error if a call passes too few or too many
+// arguments. Variadic functions take any number of arguments after their
+// fixed parameters.
+func (c *Checker) checkArgCount(t token.Token, args []ast.Expression, sig *types.Func) bool {
+	if sig.Rest() == nil {
+		if len(args) != len(sig.Params) {
+			c.error(t, "wrong number of arguments to %s: got %d, want %d", t.Value, len(args), len(sig.Params))
+			return false
+		}
+		return true
+	}
+
+	if fixed := len(sig.Params) - 1; len(args) < fixed {
+		c.error(t, "wrong number of arguments to %s: got %d, want at least %d", t.Value, len(args), fixed)
+		return false
+	}
+	return true
+}
+
+// paramType returns the type of the parameter that receives the i-th
+// argument of a call, which is the element type of the variadic parameter
+// for the arguments after the fixed parameters.
+func paramType(sig *types.Func, i int) types.Type {
+	if rest := sig.Rest(); rest != nil && i >= len(sig.Params)-1 {
+		return rest
+	}
+	return sig.Params[i]
+}
+
+// checkAssignable reports an error if the value of e cannot be used where a
+// value of type dst is expected. The value must have type dst, except that
+// integers convert to other integer types, values are wrapped in optionals
+// and results, and types convert to the interfaces they implement.
+func (c *Checker) checkAssignable(t token.Token, dst types.Type, e ast.Expression) {
+	// Syntax errors are already reported, and so are operators on values
+	// that are not integers.
+	switch v := e.(type) {
+	case *ast.BadExpr:
+		return
+	case *ast.InfixExpression:
+		if !isInteger(v.Type()) {
+			return
+		}
 	}
+	if !c.checkValue(e) || unresolved(dst) {
+		return
+	}
+	src := e.Type()
+
+	// Values are wrapped in optionals and results as some(x) and ok(x).
+	switch d := dst.(type) {
+	case *types.Optional:
+		if _, ok := src.(*types.None); ok || types.Identical(src, d) {
+			return
+		}
+		if _, ok := src.(*types.Error); !ok {
+			c.checkAssignable(t, d.Elem, e)
+			return
+		}
+	case *types.Result:
+		if err, ok := src.(*types.Error); ok {
+			if !types.Identical(err.Err, d.Err) {
+				c.error(t, "cannot use %s as %s", types.String(src), types.String(dst))
+			}
+			return
+		}
+		if !types.Identical(src, d) {
+			c.checkAssignable(t, d.Value, e)
+		}
+		return
+	}
+
+	switch src.(type) {
+	case *types.None, *types.Error:
+		c.error(t, "cannot use %s as %s", types.String(src), types.String(dst))
+		return
+	}
+
+	if il, ok := e.(*ast.IntLiteral); ok && isInteger(dst) {
+		c.checkOverflow(dst, il)
+		return
+	}
+
+	// The errors of unresolved names and types are already reported.
+	if unresolved(src) || unresolved(dst) || types.Identical(src, dst) {
+		return
+	}
+
+	if iface, ok := dst.(*types.Interface); ok {
+		c.checkImplements(t, src, iface)
+		return
+	}
+
+	if !isInteger(src) || !isInteger(dst) {
+		c.error(t, "cannot use %s as %s", types.String(src), types.String(dst))
+	}
+}
+
+// checkValue reports an error if e is a call of a function that returns
+// nothing, which has no value to use.
+func (c *Checker) checkValue(e ast.Expression) bool {
+	if _, ok := e.Type().(*types.Nil); !ok {
+		return true
+	}
+
+	switch v := e.(type) {
+	case *ast.FuncCall:
+		// Calls of undeclared functions and of generic functions that could
+		// not be instantiated have no type either, which is reported already.
+		if v.FuncDecl == nil && v.VarDecl == nil || v.FuncDecl != nil && v.FuncDecl.HasReturn {
+			return true
+		}
+		name := v.Token.Value
+		if v.Package != "" {
+			name = v.Package + "." + name
+		}
+		c.error(v.Token, "%s() used as value", name)
+		return false
+	case *ast.MethodCall:
+		if v.Method == nil {
+			return true
+		}
+		c.error(v.Token, "%s() used as value", v.Token.Value)
+		return false
+	default:
+		return true
+	}
+}
+
+// checkImplements reports an error if values of src cannot be converted to
+// iface.
+func (c *Checker) checkImplements(t token.Token, src types.Type, iface *types.Interface) {
+	if _, ok := src.(*types.Interface); ok {
+		c.error(t, "cannot use %s as %s", types.String(src), iface.Name())
+		return
+	}
+
+	if missing := c.missingMethods(src, iface); len(missing) > 0 {
+		c.error(t, "%s does not implement %s, missing methods: %s", types.String(src), iface.Name(), strings.Join(missing, ", "))
+	}
+}
+
+// isInteger reports whether t is an integer type. Integers convert to each
+// other implicitly, by extending or truncating them.
+func isInteger(t types.Type) bool {
+	switch v := t.(type) {
+	case *types.Int32, *types.Uint8:
+		return true
+	case *types.Named:
+		return isInteger(v.Underlying)
+	default:
+		return false
+	}
+}
+
+// checkOverflow reports an error if the value of an integer literal does not
+// fit in the integer type t, and whether it fits.
+func (c *Checker) checkOverflow(t types.Type, il *ast.IntLiteral) bool {
+	if min, max := intRange(t); il.Value < min || il.Value > max {
+		c.error(il.Token, "constant %d overflows %s", il.Value, types.String(t))
+		return false
+	}
+	return true
+}
+
+// intRange returns the smallest and the largest value of the integer type t.
+func intRange(t types.Type) (int, int) {
+	switch v := t.(type) {
+	case *types.Uint8:
+		return 0, math.MaxUint8
+	case *types.Named:
+		return intRange(v.Underlying)
+	default:
+		return math.MinInt32, math.MaxInt32
+	}
+}
+
+// unresolved reports whether t is the type of an expression that could not be
+// checked, or refers to a type name that could not be resolved. Named types
+// whose underlying type is invalid have the underlying type nil.
+func unresolved(t types.Type) bool {
+	switch v := t.(type) {
+	case *types.Nil, *types.Custom:
+		return true
+	case *types.Named:
+		_, ok := v.Underlying.(*types.Nil)
+		return ok
+	case *types.Pointer:
+		return unresolved(v.To)
+	case *types.Slice:
+		return unresolved(v.Elem)
+	case *types.Optional:
+		return unresolved(v.Elem)
+	case *types.Result:
+		return unresolved(v.Err) || unresolved(v.Value)
+	default:
+		return false
+	}
+}
+
+func (c *Checker) missingMethods(t types.Type, iface *types.Interface) []string {
+	missing := make([]string, 0)
+	for _, want := range iface.Methods {
+		got, ok := c.lookupMethod(t, want.Name)
+		if !ok {
+			missing = append(missing, want.Name)
+		} else if !types.Identical(got.Sig, want.Sig) {
+			missing = append(missing, fmt.Sprintf("%s (have %s, want %s)", want.Name, types.String(got.Sig), types.String(want.Sig)))
+		}
+	}
+	return missing
+}
+
+// lookupMethod returns the named method in the method set of t. The method
+// set of a named type contains the methods with a value receiver, the method
+// set of a pointer to it also those with a pointer receiver.
+func (c *Checker) lookupMethod(t types.Type, name string) (*types.Method, bool) {
+	if iface, ok := t.(*types.Interface); ok {
+		_, m, ok := iface.Method(name)
+		return m, ok
+	}
+
+	if tp, ok := t.(*types.TypeParam); ok {
+		if tp.Constraint == nil {
+			return nil, false
+		}
+		_, m, ok := tp.Constraint.Method(name)
+		return m, ok
+	}
+
+	fd, ok := c.methodDecl(t, name)
+	if !ok {
+		return nil, false
+	}
+
+	_, ptr := t.(*types.Pointer)
+	if _, ok := fd.Receiver.Type.Type.(*types.Pointer); ok && !ptr {
+		return nil, false
+	}
+
+	return &types.Method{Name: name, Sig: fd.Signature()}, true
+}
+
+// methodDecl returns the declaration of the named method of t, which is a
+// named type or a pointer to one.
+func (c *Checker) methodDecl(t types.Type, name string) (*ast.FuncDecl, bool) {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.To
+	}
+
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil, false
+	}
+
+	fd, ok := c.methods[named][name]
+	return fd, ok
+}
+
+func (c *Checker) checkFuncDecl(fd *ast.FuncDecl) {
+	c.pushContext()
+	defer c.popContext()
+	c.Scopes[fd] = c.context
+
+	c.funcDecl = fd
+	c.declareTypeParams(fd)
+
+	if fd.Receiver != nil {
+		c.checkVarDecl(fd.Receiver)
+	}
+
+	for _, vd := range fd.Params {
+		c.checkVarDecl(vd)
+	}
+
+	c.checkStatements(fd.Body)
+	c.checkTerminates(fd)
+}
+
+// checkTerminates reports an error if the body of a function that returns a
+// value can end without a return.
+func (c *Checker) checkTerminates(fd *ast.FuncDecl) {
+	if !fd.HasReturn || fd.Extern || terminates(fd.Body) {
+		return
+	}
+	if fd.Token.Type == token.FUNC {
+		c.error(fd.Token, "missing return at the end of function literal")
+		return
+	}
+	c.error(fd.Token, "missing return at the end of %s", fd.Token.Value)
+}
+
+// terminates reports whether the statements end in a return, or in a switch
+// that covers every value of its operand and whose cases all terminate.
+func terminates(stmts []ast.Statement) bool {
+	if len(stmts) == 0 {
+		return false
+	}
+
+	switch v := stmts[len(stmts)-1].(type) {
+	case *ast.Return, *ast.BadStmt:
+		return true
+	case *ast.Switch:
+		exhaustive := false
+		switch t := v.Value.Type().(type) {
+		case *types.Enum, *types.Nil:
+			// Missing cases of enums are reported by checkSwitch, and
+			// operands that could not be checked by checkExpression.
+			exhaustive = true
+		default:
+			_, exhaustive = types.AsUnion(t)
+		}
+		for _, cs := range v.Cases {
+			exhaustive = exhaustive || cs.Default
+			if !terminates(cs.Body) {
+				return false
+			}
+		}
+		return exhaustive
+	default:
+		return false
+	}
+}
+
+func (c *Checker) checkStatements(stmts []ast.Statement) {
+	for _, s := range stmts {
+		switch v := s.(type) {
+		case *ast.VarDecl:
+			c.checkVarDecl(v)
+		case *ast.Assign:
+			c.checkAssign(v)
+		case *ast.Return:
+			c.checkReturn(v)
+		case *ast.FuncCall:
+			c.checkFuncCall(v)
+		case *ast.MethodCall:
+			c.checkMethodCall(v)
+		case *ast.Defer:
+			c.checkDefer(v)
+		case *ast.Try:
+			c.checkTry(v)
+		case *ast.Switch:
+			c.checkSwitch(v)
+		case *ast.BadStmt:
+		default:
+			panic(fmt.Sprintf("cannot check body %T", v))
+		}
+	}
+}
+
+func (c *Checker) checkSwitch(s *ast.Switch) {
+	c.checkExpression(s.Value)
+	c.checkValue(s.Value)
+	t := s.Value.Type()
+
+	if u, ok := types.AsUnion(t); ok {
+		c.checkUnionSwitch(s, u)
+		return
+	}
+	if _, ok := t.(*types.Enum); !ok && !isInteger(t) && !unresolved(t) {
+		c.error(s.Token, "cannot switch on %s, which is not an integer, an enum or a union", types.String(t))
+		t = types.TypeNil
+	}
+
+	var def *ast.Case
+	seen := make(map[int]*ast.Case)
+
+	for _, cs := range s.Cases {
+		if cs.Default {
+			if def != nil {
+				c.errorDuplicate(cs.Token, def.Token)
+			}
+			def = cs
+		}
+
+		for _, e := range cs.Values {
+			v, ok := c.checkCaseValue(cs.Token, t, e)
+			if !ok {
+				continue
+			}
+			if prev, ok := seen[v]; ok {
+				c.report(diag.Errorf(diag.At(cs.Token), diag.Duplicate, "duplicate case %d in switch", v)).
+					Note(diag.At(prev.Token), "previous case")
+				continue
+			}
+			seen[v] = cs
+		}
+
+		c.pushContext()
+		c.Scopes[cs] = c.context
+		c.checkStatements(cs.Body)
+		c.popContext()
+	}
+
+	enum, ok := t.(*types.Enum)
+	if !ok || def != nil {
+		return
+	}
+
+	// Members are covered by value, so that members with a duplicate value,
+	// which checkEnum reports, do not also make the switch incomplete.
+	missing := make([]string, 0)
+	for _, m := range enum.Members {
+		if _, ok := seen[m.Value]; !ok {
+			missing = append(missing, m.Name)
+		}
+	}
+	if len(missing) > 0 {
+		c.error(s.Token, "switch on %s is not exhaustive, missing cases: %s", enum.Name(), strings.Join(missing, ", "))
+	}
+}
+
+// checkCaseValue checks that e is a constant of type t and returns its value.
+func (c *Checker) checkCaseValue(tok token.Token, t types.Type, e ast.Expression) (int, bool) {
+	if _, ok := t.(*types.Nil); ok {
+		return 0, false
+	}
+
+	switch v := e.(type) {
+	case *ast.IntLiteral:
+		if t.IsNumeric() {
+			return v.Value, c.checkOverflow(t, v)
+		}
+	case *ast.Selector:
+		c.checkSelector(v)
+		if v.Member == nil && v.Variant == nil {
+			return 0, false
+		}
+		if v.Member != nil && v.Member.Enum == t {
+			return v.Member.Value, true
+		}
+	case *ast.Pattern:
+		name := v.Token.Value
+		if v.X != nil {
+			name = v.X.Token.Value + "." + name
+		}
+		c.error(tok, "cannot use pattern %s in switch on %s", name, types.String(t))
+		return 0, false
+	default:
+		c.checkExpression(e)
+		c.error(tok, "case value must be a constant")
+		return 0, false
+	}
+
+	c.error(tok, "cannot use %s as a case of switch on %s", types.String(e.Type()), types.String(t))
+	return 0, false
+}
+
+func (c *Checker) checkSelector(s *ast.Selector) {
+	if s.Member != nil || s.Variant != nil {
+		return
+	}
+
+	switch t := c.typeNamedBy(s.X).(type) {
+	case *types.Enum:
+		m, ok := t.Member(s.Token.Value)
+		if !ok {
+			c.error(s.Token, "%s has no member %s", t.Name(), s.Token.Value)
+		}
+		s.Member = m
+	case *types.Union:
+		v, ok := t.Variant(s.Token.Value)
+		if !ok {
+			c.error(s.Token, "%s has no variant %s", t.Name(), s.Token.Value)
+		}
+		s.Variant = v
+	default:
+		c.checkExpression(s.X)
+		c.error(s.Token, "%s has no field %s", types.String(s.X.Type()), s.Token.Value)
+	}
+}
+
+// typeNamedBy returns the type that e refers to if e is the name of a
+// declared type and not of a variable, and nil otherwise.
+func (c *Checker) typeNamedBy(e ast.Expression) types.Type {
+	v, ok := e.(*ast.Var)
+	if !ok {
+		return nil
+	}
+	if v.Package != "" {
+		imp, ok := c.imports[v.Package]
+		if !ok || !imp.exports(v.Token.Value) {
+			return nil
+		}
+		td, ok := imp.scope.types[v.Token.Value]
+		if !ok {
+			return nil
+		}
+		return td.Type.Type
+	}
+	if _, ok := c.context.getVar(v.Token.Value); ok {
+		return nil
+	}
+	td, ok := c.context.getTypeDecl(v.Token.Value)
+	if !ok {
+		return nil
+	}
+	return td.Type.Type
+}
+
+// checkVariantCall checks the construction of a union variant with a payload,
+// like Shape.Circle(1).
+func (c *Checker) checkVariantCall(mc *ast.MethodCall, u *types.Union) {
+	for _, arg := range mc.Args {
+		c.checkExpression(arg)
+	}
+
+	v, ok := u.Variant(mc.Token.Value)
+	if !ok {
+		c.error(mc.Token, "%s has no variant %s", u.Name(), mc.Token.Value)
+		return
+	}
+	mc.Variant = v
+
+	if len(mc.Args) != len(v.Fields) {
+		c.error(mc.Token, "wrong number of arguments to %s.%s: got %d, want %d", u.Name(), v.Name, len(mc.Args), len(v.Fields))
+		return
+	}
+	for i, arg := range mc.Args {
+		c.checkAssignable(mc.Token, v.Fields[i], arg)
+	}
+}
+
+func (c *Checker) checkUnionSwitch(s *ast.Switch, u *types.Union) {
+	var def *ast.Case
+	covered := make(map[*types.Variant]bool)
+
+	for _, cs := range s.Cases {
+		if cs.Default {
+			if def != nil {
+				c.errorDuplicate(cs.Token, def.Token)
+			}
+			def = cs
+		}
+
+		c.pushContext()
+		c.Scopes[cs] = c.context
+
+		for _, e := range cs.Values {
+			if p, ok := e.(*ast.Pattern); ok && len(cs.Values) > 1 && binds(p) {
+				c.error(p.Token, "cannot bind fields in a case with multiple values")
+			}
+
+			v, ok := c.checkVariantCase(cs.Token, u, e)
+			if !ok {
+				continue
+			}
+			if covered[v] {
+				c.error(cs.Token, "unreachable case %s.%s, already covered", u.Name(), v.Name)
+			}
+			covered[v] = true
+		}
+
+		c.checkStatements(cs.Body)
+		c.popContext()
+	}
+
+	if def != nil {
+		if len(covered) == len(u.Variants) {
+			c.error(def.Token, "unreachable default case, all variants of %s are covered", u.Name())
+		}
+		return
+	}
+
+	missing := make([]string, 0)
+	for _, v := range u.Variants {
+		if !covered[v] {
+			missing = append(missing, v.Name)
+		}
+	}
+	if len(missing) > 0 {
+		c.error(s.Token, "switch on %s is not exhaustive, missing cases: %s", u.Name(), strings.Join(missing, ", "))
+	}
+}
+
+// checkVariantCase checks a case of a switch on a union and declares the
+// variables bound by patterns.
+func (c *Checker) checkVariantCase(tok token.Token, u *types.Union, e ast.Expression) (*types.Variant, bool) {
+	switch v := e.(type) {
+	case *ast.Selector:
+		c.checkSelector(v)
+		if v.Variant == nil && v.Member == nil {
+			return nil, false
+		}
+		if v.Variant != nil && v.Variant.Union == u {
+			return v.Variant, true
+		}
+	case *ast.None:
+		if variant, ok := u.Variant("none"); ok {
+			return variant, true
+		}
+	case *ast.Pattern:
+		// Variants that are not qualified by a union name belong to the
+		// union that is switched on.
+		t := u
+		if v.X != nil {
+			var ok bool
+			t, ok = c.typeNamedBy(v.X).(*types.Union)
+			if !ok {
+				c.error(v.X.Token, "%s is not a union", v.X.Token.Value)
+				return nil, false
+			}
+		}
+		variant, ok := t.Variant(v.Token.Value)
+		if !ok {
+			c.error(v.Token, "%s has no variant %s", t.Name(), v.Token.Value)
+			return nil, false
+		}
+		v.Variant = variant
+		if t != u {
+			break
+		}
+
+		if len(v.Bindings) != len(variant.Fields) {
+			c.error(v.Token, "wrong number of bindings for %s.%s: got %d, want %d", u.Name(), variant.Name, len(v.Bindings), len(variant.Fields))
+			return nil, false
+		}
+		for i, vd := range v.Bindings {
+			vd.Type = &ast.Type{Token: vd.Token, Type: variant.Fields[i]}
+			if vd.Token.Value != "_" {
+				c.checkVarDecl(vd)
+			}
+		}
+		return variant, true
+	default:
+		c.checkExpression(e)
+	}
+
+	c.error(tok, "cannot use %s as a case of switch on %s", types.String(e.Type()), u.Name())
+	return nil, false
+}
+
+func (c *Checker) checkFuncDecls() {
+	for _, stmt := range c.program.Statements {
+		switch v := stmt.(type) {
+		case *ast.FuncDecl:
+			c.checkFuncDecl(v)
+		}
+	}
+}
+
+// checkSignature resolves the type parameters, parameter and return types of
+// a function.
+func (c *Checker) checkSignature(fd *ast.FuncDecl) {
+	c.pushContext()
+	defer c.popContext()
+
+	seen := make(map[string]*ast.TypeParam)
+	for _, tp := range fd.TypeParams {
+		if dup, ok := seen[tp.Token.Value]; ok {
+			c.errorDuplicate(tp.Token, dup.Token)
+		}
+		seen[tp.Token.Value] = tp
+
+		if tp.Constraint == nil {
+			continue
+		}
+		c.resolveType(tp.Constraint)
+		iface, ok := tp.Constraint.Type.(*types.Interface)
+		if unresolved(tp.Constraint.Type) {
+			continue
+		}
+		if !ok {
+			c.error(tp.Constraint.Token, "constraint %s is not an interface", types.String(tp.Constraint.Type))
+			continue
+		}
+		tp.Type.Constraint = iface
+	}
+	c.declareTypeParams(fd)
+
+	for _, vd := range fd.Params {
+		c.resolveType(vd.Type)
+	}
+	if fd.HasReturn {
+		c.resolveType(fd.ReturnType)
+	}
+}
+
+func (c *Checker) checkTypeDecl(td *ast.TypeDecl) {
+	switch t := td.Type.Type.(type) {
+	case *types.Named:
+		c.checkNamed(td, t)
+		return
+	case *types.Enum:
+		c.checkEnum(td, t)
+		return
+	case *types.Union:
+		c.checkUnion(td, t)
+		return
+	}
+
+	iface := td.Type.Type.(*types.Interface)
+
+	seen := make(map[string]*ast.FuncDecl)
+	for _, fd := range td.Methods {
+		if dup, ok := seen[fd.Token.Value]; ok {
+			c.errorDuplicate(fd.Token, dup.Token)
+			continue
+		}
+		seen[fd.Token.Value] = fd
+
+		if fd.Variadic || fd.VarArgs {
+			c.error(fd.Token, "methods cannot be variadic")
+		}
+		c.checkSignature(fd)
+		iface.Methods = append(iface.Methods, &types.Method{Name: fd.Token.Value, Sig: fd.Signature()})
+	}
+}
+
+func (c *Checker) checkNamed(td *ast.TypeDecl, named *types.Named) {
+	named.Underlying = c.resolve(named.Underlying)
+	c.checkResolved(td.Type.Token, named.Underlying)
+	named.Underlying = dropUnresolved(named.Underlying)
+
+	// Calls, indexing and none do not look through named types, so the
+	// values of a named optional, result, slice or function could not be used.
+	switch u := named.Underlying.(type) {
+	case *types.Named, *types.Interface, *types.Optional, *types.Result, *types.Slice, *types.Func:
+		c.error(td.Type.Token, "invalid underlying type %s", types.String(u))
+		named.Underlying = types.TypeNil
+	default:
+		if refersTo(u, named) {
+			c.error(td.Token, "invalid recursive type %s", named.Name())
+			named.Underlying = types.TypeNil
+		}
+	}
+}
+
+func (c *Checker) declareTypeParams(fd *ast.FuncDecl) {
+	for _, tp := range fd.TypeParams {
+		c.context.typeParams[tp.Token.Value] = tp.Type
+	}
+}
+
+// checkEnum assigns the values of the members of an enum. Members without an
+// explicit value are numbered from the previous member. The values must be
+// distinct and fit in the backing type.
+func (c *Checker) checkEnum(td *ast.TypeDecl, enum *types.Enum) {
+	enum.Backing = c.resolve(enum.Backing)
+	if !enum.Backing.IsNumeric() {
+		c.error(td.Type.Token, "invalid backing type %s for enum %s", types.String(enum.Backing), enum.Name())
+		enum.Backing = types.TypeInt32
+	}
+
+	value := 0
+	seen := make(map[string]*ast.EnumMember)
+	values := make(map[int]*ast.EnumMember)
+	for _, em := range td.Members {
+		if dup, ok := seen[em.Token.Value]; ok {
+			c.errorDuplicate(em.Token, dup.Token)
+			continue
+		}
+		seen[em.Token.Value] = em
+
+		if em.Value != nil {
+			value = em.Value.Value
+		}
+		if min, max := intRange(enum.Backing); value < min || value > max {
+			c.error(em.Token, "value %d of %s.%s overflows %s", value, enum.Name(), em.Token.Value, types.String(enum.Backing))
+		}
+		if prev, ok := values[value]; ok {
+			c.report(diag.Errorf(diag.At(em.Token), diag.Duplicate, "duplicate value %d of %s.%s", value, enum.Name(), em.Token.Value)).
+				Note(diag.At(prev.Token), "%s.%s has value %d", enum.Name(), prev.Token.Value, value)
+		}
+		values[value] = em
+		enum.Members = append(enum.Members, &types.EnumMember{Name: em.Token.Value, Value: value, Enum: enum})
+		value++
+	}
+}
+
+// checkUnion resolves the fields of the variants of a union and numbers their
+// tags in declaration order.
+func (c *Checker) checkUnion(td *ast.TypeDecl, u *types.Union) {
+	seen := make(map[string]*ast.Variant)
+	for _, v := range td.Variants {
+		if dup, ok := seen[v.Token.Value]; ok {
+			c.errorDuplicate(v.Token, dup.Token)
+			continue
+		}
+		seen[v.Token.Value] = v
+
+		variant := &types.Variant{Name: v.Token.Value, Tag: len(u.Variants), Union: u}
+		for _, f := range v.Fields {
+			c.resolveType(f)
+			variant.Fields = append(variant.Fields, f.Type)
+		}
+		u.Variants = append(u.Variants, variant)
+	}
+
+	for i, v := range u.Variants {
+		for _, f := range v.Fields {
+			if contains(f, u) {
+				c.error(td.Variants[i].Token, "invalid recursive type %s", u.Name())
+				v.Fields = nil
+				break
+			}
+		}
+	}
+}
+
+// checkMethodDecl resolves the receiver of a method and adds the method to the
+// method table of the receiver's type.
+func (c *Checker) checkMethodDecl(fd *ast.FuncDecl) {
+	if len(fd.TypeParams) > 0 {
+		c.error(fd.Token, "methods cannot have type parameters")
+	}
+	c.checkVariadic(fd)
+
+	c.resolveType(fd.Receiver.Type)
+	c.checkSignature(fd)
+
+	t := fd.Receiver.Type.Type
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.To
+	}
+
+	named, ok := t.(*types.Named)
+	if unresolved(t) {
+		return
+	}
+	if !ok {
+		c.error(fd.Receiver.Type.Token, "invalid receiver type %s", types.String(fd.Receiver.Type.Type))
+		return
+	}
+	if !c.declares(named) {
+		c.error(fd.Receiver.Type.Token, "cannot define methods on %s of another package", named.Name())
+		return
+	}
+
+	if c.methods[named] == nil {
+		c.methods[named] = make(map[string]*ast.FuncDecl)
+	}
+	if dup, ok := c.methods[named][fd.Token.Value]; ok {
+		c.errorDuplicate(fd.Token, dup.Token)
+	}
+	c.methods[named][fd.Token.Value] = fd
+}
+
+func (c *Checker) checkTypeDeclDup(td *ast.TypeDecl) {
+	if dup, ok := c.context.getTypeDecl(td.Token.Value); ok {
+		c.errorDuplicate(td.Token, dup.Token)
+	}
+}
+
+// resolveType replaces the names of declared types with the types they
+// declare. Unknown names are reported and left as they are.
+func (c *Checker) resolveType(t *ast.Type) {
+	t.Type = c.resolve(t.Type)
+	c.checkResolved(t.Token, t.Type)
+	t.Type = dropUnresolved(t.Type)
+}
+
+// dropUnresolved replaces the names of types in t that could not be resolved,
+// which checkResolved reports, with nil so that they are reported only once.
+func dropUnresolved(t types.Type) types.Type {
+	switch v := t.(type) {
+	case *types.Custom:
+		return types.TypeNil
+	case *types.Pointer:
+		v.To = dropUnresolved(v.To)
+	case *types.Slice:
+		v.Elem = dropUnresolved(v.Elem)
+	case *types.Optional:
+		return types.NewOptional(dropUnresolved(v.Elem))
+	case *types.Result:
+		return types.NewResult(dropUnresolved(v.Err), dropUnresolved(v.Value))
+	case *types.Func:
+		for i, p := range v.Params {
+			v.Params[i] = dropUnresolved(p)
+		}
+		v.Return = dropUnresolved(v.Return)
+	}
+	return t
+}
+
+// checkResolved reports the names of types in t that could not be resolved.
+func (c *Checker) checkResolved(tok token.Token, t types.Type) {
+	switch v := t.(type) {
+	case *types.Pointer:
+		c.checkResolved(tok, v.To)
+	case *types.Slice:
+		c.checkResolved(tok, v.Elem)
+	case *types.Optional:
+		c.checkResolved(tok, v.Elem)
+	case *types.Result:
+		c.checkResolved(tok, v.Err)
+		c.checkResolved(tok, v.Value)
+	case *types.Func:
+		for _, p := range v.Params {
+			c.checkResolved(tok, p)
+		}
+		c.checkResolved(tok, v.Return)
+	case *types.Custom:
+		if v.Package == "" {
+			c.error(tok, "undefined type %s", v.Name())
+			return
+		}
+		name := strings.TrimPrefix(v.Name(), v.Package+".")
+		if _, ok := c.importScope(tok, v.Package, name); ok {
+			c.errorNotFound(tok, v.Name())
+		}
+	}
+}
+
+func (c *Checker) resolve(t types.Type) types.Type {
+	switch v := t.(type) {
+	case *types.Pointer:
+		v.To = c.resolve(v.To)
+	case *types.Slice:
+		v.Elem = c.resolve(v.Elem)
+	case *types.Optional:
+		return types.NewOptional(c.resolve(v.Elem))
+	case *types.Result:
+		return types.NewResult(c.resolve(v.Err), c.resolve(v.Value))
+	case *types.Func:
+		for i, p := range v.Params {
+			v.Params[i] = c.resolve(p)
+		}
+		v.Return = c.resolve(v.Return)
+	case *types.Custom:
+		if v.Package != "" {
+			return c.resolveQualified(v)
+		}
+		if tp, ok := c.context.getTypeParam(v.Name()); ok {
+			return tp
+		}
+		if td, ok := c.context.getTypeDecl(v.Name()); ok {
+			return td.Type.Type
+		}
+	}
+	return t
+}
+
+// resolveQualified returns the exported type of an imported package that t
+// names, or t if there is none.
+func (c *Checker) resolveQualified(t *types.Custom) types.Type {
+	imp, ok := c.imports[t.Package]
+	if !ok {
+		return t
+	}
+	name := strings.TrimPrefix(t.Name(), t.Package+".")
+	if !imp.exports(name) {
+		return t
+	}
+	if td, ok := imp.scope.types[name]; ok {
+		return td.Type.Type
+	}
+	return t
+}
+
+// refersTo reports whether t is named or contains named through pointers or
+// the underlying types of other named types.
+func refersTo(t types.Type, named *types.Named) bool {
+	switch v := t.(type) {
+	case *types.Pointer:
+		return refersTo(v.To, named)
+	case *types.Named:
+		return v == named || refersTo(v.Underlying, named)
+	case *types.Optional:
+		return refersTo(v.Elem, named)
+	case *types.Result:
+		return refersTo(v.Err, named) || refersTo(v.Value, named)
+	default:
+		return false
+	}
+}
+
+// binds reports whether a pattern declares any variables.
+func binds(p *ast.Pattern) bool {
+	for _, vd := range p.Bindings {
+		if vd.Token.Value != "_" {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether values of t contain a value of u, which makes u
+// infinitely large if t is a field of u.
+func contains(t types.Type, u *types.Union) bool {
+	switch v := t.(type) {
+	case *types.Union:
+		if v == u {
+			return true
+		}
+		for _, variant := range v.Variants {
+			for _, f := range variant.Fields {
+				if contains(f, u) {
+					return true
+				}
+			}
+		}
+	case *types.Named:
+		return contains(v.Underlying, u)
+	case *types.Optional:
+		return contains(v.Elem, u)
+	case *types.Result:
+		return contains(v.Err, u) || contains(v.Value, u)
+	}
+	return false
+}
+
+func (c *Checker) checkFuncDeclDup(fd *ast.FuncDecl) {
+	if dup, ok := c.context.getFuncDecl(fd.Token.Value); ok {
+		c.errorDuplicate(fd.Token, dup.Token)
+	}
+}
+
+func (c *Checker) error(t token.Token, msg string, args ...interface{}) *diag.Diagnostic {
+	return c.report(diag.Errorf(diag.At(t), diag.Type, msg, args...))
 }
 
-func (c *Checker) error(t token.Token, msg string, args ...interface{}) {
-	msg = fmt.Sprintf(msg, args...)
-	err := fmt.Sprintf("%s %s", t.Path(), msg)
-	c.Errors = append(c.Errors, err)
+func (c *Checker) report(d *diag.Diagnostic) *diag.Diagnostic {
+	c.Errors = append(c.Errors, d)
+	return d
 }
 
 func (c *Checker) errorDuplicate(t, dup token.Token) {
-	c.error(t, "duplicate declaration of '%s', previous declaration at %s", t.Value, dup.Path())
+	c.report(diag.Errorf(diag.At(t), diag.Duplicate, "duplicate declaration of '%s'", t.Value)).
+		Note(diag.At(dup), "previous declaration of '%s'", dup.Value)
 }
 
 func (c *Checker) errorNotFound(t token.Token, name string) {
-	c.error(t, "%s not declared", name)
+	c.report(diag.Errorf(diag.At(t), diag.Undeclared, "%s not declared", name))
 }
 
 func (c *Checker) pushContext() {
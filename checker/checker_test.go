@@ -2,8 +2,11 @@ package checker
 
 import (
 	"lang/ast"
+	"lang/diag"
 	"lang/lexer"
 	"lang/parser"
+	"lang/types"
+	"strings"
 	"testing"
 )
 
@@ -19,12 +22,19 @@ func x() {}
 	if len(checker.Errors) != 1 {
 		t.Fatalf("Expected 1 error, got %d", len(checker.Errors))
 	}
+	d := checker.Errors[0]
+	if d.Code != diag.Duplicate || d.Span.Start.Line != 3 {
+		t.Fatalf("expected a duplicate at line 3, got %v", d)
+	}
+	if len(d.Notes) != 1 || d.Notes[0].Span.Start.Line != 2 {
+		t.Fatalf("expected a note at line 2, got %v", d.Notes)
+	}
 }
 
 func TestDuplicateVarDecl(t *testing.T) {
 	input := `
-var x i21 = 1
-var x i21 = 2
+var x i32 = 1
+var x i32 = 2
 	`
 	p := parse(t, input)
 	checker := New(p)
@@ -57,15 +67,1010 @@ var y i32 = x
 	}
 }
 
+func TestGlobalInitializer(t *testing.T) {
+	input := `
+func one() i32 {
+	return 1
+}
+var y i32 = one()
+	`
+	checkErrors(t, input, "global y must be initialized with an integer literal")
+}
+
+func TestDeferRequiresCall(t *testing.T) {
+	input := `
+func close()
+func main() {
+	defer close()
+	defer 1
+}
+	`
+	checkErrors(t, input, "expression in defer must be a function call")
+}
+
+func TestInterfaceSatisfaction(t *testing.T) {
+	input := `
+type Any interface {}
+type Animal interface {
+	speak(times i32) i32
+}
+func keep(a Any)
+func bark(a Animal) i32 {
+	keep(1)
+	return a.speak(2)
+}
+	`
+	p := checkErrors(t, input)
+
+	call := p.Statements[3].(*ast.FuncDecl).Body[1].(*ast.Return).Value.(*ast.MethodCall)
+	if call.Method == nil || call.Method.Name != "speak" {
+		t.Fatalf("expected method speak, got %v", call.Method)
+	}
+}
+
+func TestInterfaceMissingMethods(t *testing.T) {
+	input := `
+type Animal interface {
+	speak() i32
+	walk(steps i32)
+}
+func bark(a Animal)
+func main() {
+	bark(1)
+}
+	`
+	checkErrors(t, input, "i32 does not implement Animal, missing methods: speak, walk")
+}
+
+func TestMethodResolution(t *testing.T) {
+	input := `
+type Meters i32
+func (m Meters) Len() i32 {
+	return m
+}
+func Len() i32 {
+	return 0
+}
+func main() i32 {
+	var m Meters = 1
+	return m.Len()
+}
+	`
+	p := checkErrors(t, input)
+
+	method := p.Statements[1].(*ast.FuncDecl)
+	call := p.Statements[3].(*ast.FuncDecl).Body[1].(*ast.Return).Value.(*ast.MethodCall)
+	if call.FuncDecl != method {
+		t.Fatalf("expected %v, got %v", method, call.FuncDecl)
+	}
+}
+
+func TestMethodSets(t *testing.T) {
+	input := `
+type Walker interface {
+	walk()
+}
+type Dog i32
+func (d ^Dog) walk() {}
+func move(w Walker)
+func main() {
+	var d Dog = 1
+	d.walk()
+	move(d)
+}
+	`
+	checkErrors(t, input, "Dog does not implement Walker, missing methods: walk")
+}
+
+func TestInvalidReceiver(t *testing.T) {
+	input := `
+type Dog i32
+func (d Dog) walk() {}
+func (d Dog) walk() {}
+func (x i32) walk() {}
+	`
+	checkErrors(t, input,
+		"duplicate declaration of 'walk'",
+		"invalid receiver type i32",
+	)
+}
+
+func TestGenericInference(t *testing.T) {
+	input := `
+func first[T](a T, b ^T) T {
+	return a
+}
+func get() ^i32
+func main() i32 {
+	return first(1, get())
+}
+	`
+	p := checkErrors(t, input)
+
+	call := p.Statements[2].(*ast.FuncDecl).Body[0].(*ast.Return).Value.(*ast.FuncCall)
+	if len(call.Instance) != 1 || call.Instance[0] != types.TypeInt32 {
+		t.Fatalf("expected instance [i32], got %v", call.Instance)
+	}
+	if call.Type() != types.TypeInt32 {
+		t.Fatalf("expected i32, got %v", call.Type())
+	}
+}
+
+func TestGenericConstraints(t *testing.T) {
+	input := `
+type Speaker interface {
+	speak() i32
+}
+type Dog i32
+func (d Dog) speak() i32 {
+	return d
+}
+func talk[T Speaker](x T) i32 {
+	return x.speak()
+}
+func empty[T]() {}
+func main() {
+	var d Dog = 1
+	talk(d)
+	talk[i32](1)
+	empty()
+	talk[Dog, Dog](d)
+}
+	`
+	checkErrors(t, input,
+		"i32 does not satisfy Speaker, missing methods: speak",
+		"cannot infer T in call to empty",
+		"wrong number of type arguments to talk: got 2, want 1",
+	)
+}
+
+func TestEnumValues(t *testing.T) {
+	input := `
+enum Color {
+	Red, Green = 5, Blue
+}
+	`
+	p := checkErrors(t, input)
+
+	enum := p.Statements[0].(*ast.TypeDecl).Type.Type.(*types.Enum)
+	want := []int{0, 5, 6}
+	for i, m := range enum.Members {
+		if m.Value != want[i] {
+			t.Fatalf("[%d] expected %d, got %d", i, want[i], m.Value)
+		}
+	}
+}
+
+func TestSwitchExhaustive(t *testing.T) {
+	input := `
+enum Color {
+	Red, Green, Blue
+}
+func test(c Color, x i32) {
+	switch c {
+	case Color.Red:
+	case Color.Green, Color.Red:
+	}
+	switch c {
+	case Color.Red:
+	default:
+	}
+	switch x {
+	case 1, Color.Blue:
+	}
+}
+	`
+	checkErrors(t, input,
+		"duplicate case 0 in switch",
+		"switch on Color is not exhaustive, missing cases: Blue",
+		"cannot use Color as a case of switch on i32",
+	)
+}
+
+func TestUnionSwitch(t *testing.T) {
+	input := `
+union Shape {
+	Circle(i32),
+	Rect(i32, i32),
+	Empty
+}
+func test(s Shape) i32 {
+	var e Shape = Shape.Empty
+	var c Shape = Shape.Circle(1, 2)
+	switch s {
+	case Shape.Circle(r):
+		return r
+	case Shape.Rect(w, _):
+		return w
+	case Shape.Circle(_):
+	}
+	switch s {
+	case Shape.Rect(w):
+	case Shape.Empty:
+	}
+	switch s {
+	case Shape.Circle(_), Shape.Rect(_, _), Shape.Empty:
+	default:
+	}
+	return 0
+}
+	`
+	p := checkErrors(t, input,
+		"wrong number of arguments to Shape.Circle: got 2, want 1",
+		"unreachable case Shape.Circle, already covered",
+		"switch on Shape is not exhaustive, missing cases: Empty",
+		"wrong number of bindings for Shape.Rect: got 1, want 2",
+		"switch on Shape is not exhaustive, missing cases: Circle, Rect",
+		"unreachable default case, all variants of Shape are covered",
+	)
+
+	sw := p.Statements[1].(*ast.FuncDecl).Body[2].(*ast.Switch)
+	r := sw.Cases[0].Values[0].(*ast.Pattern).Bindings[0]
+	if r.Type.Type != types.TypeInt32 {
+		t.Fatalf("expected r to be i32, got %v", r.Type.Type)
+	}
+	ret := sw.Cases[0].Body[0].(*ast.Return).Value.(*ast.Var)
+	if ret.VarDecl != r {
+		t.Fatalf("expected %v, got %v", r, ret.VarDecl)
+	}
+}
+
+func TestTryPropagation(t *testing.T) {
+	input := `
+type Error i32
+type Other i32
+func parse() Error!i32
+func find() ?i32
+func a() Error!i32 {
+	var x i32 = try parse()
+	return x
+}
+func b() Other!i32 {
+	return try parse()
+}
+func c() i32 {
+	return try find()
+}
+func d() ?i32 {
+	try 1
+	return none
+}
+func e() {
+	var x ?i32 = err(1)
+	return 1
+}
+func f() Error!i32 {
+	var e Error = 1
+	return
+}
+	`
+	p := checkErrors(t, input,
+		"cannot propagate err(Error) from b, which returns Other!i32",
+		"cannot propagate none from c, which returns i32",
+		"try requires an optional or a result, got i32",
+		"cannot use err(i32) as ?i32",
+		"too many return values, e returns nothing",
+		"missing return value, f returns Error!i32",
+	)
+
+	try := p.Statements[4].(*ast.FuncDecl).Body[0].(*ast.VarDecl).Value
+	if try.Type() != types.TypeInt32 {
+		t.Fatalf("expected i32, got %v", try.Type())
+	}
+}
+
+func TestFuncValues(t *testing.T) {
+	input := `
+func inc(x i32) i32 {
+	return x + 1
+}
+func id[T](x T) T {
+	return x
+}
+func apply(f func(i32) i32, x i32) i32 {
+	return f(x)
+}
+func main() i32 {
+	var f func(i32) i32 = inc
+	var g func() = inc
+	var h func(i32) i32 = id
+	var x i32 = 1
+	x(2)
+	f(1, 2)
+	return apply(f, 1)
+}
+func get(g func() i32) i32 {
+	return g
+}
+	`
+	p := checkErrors(t, input,
+		"cannot use func(i32) i32 as func()",
+		"cannot use generic function id without instantiation",
+		"cannot call non-function x of type i32",
+		"wrong number of arguments to f: got 2, want 1",
+		"cannot use func() i32 as i32",
+	)
+
+	body := p.Statements[3].(*ast.FuncDecl).Body
+	if v := body[0].(*ast.VarDecl).Value.(*ast.Var); v.FuncDecl != p.Statements[0] {
+		t.Fatalf("expected %v, got %v", p.Statements[0], v.FuncDecl)
+	}
+	if call := p.Statements[2].(*ast.FuncDecl).Body[0].(*ast.Return).Value.(*ast.FuncCall); call.Type() != types.TypeInt32 {
+		t.Fatalf("expected i32, got %v", call.Type())
+	}
+}
+
+func TestClosureCaptures(t *testing.T) {
+	input := `
+var g i32 = 1
+func each(cb func(i32) i32)
+func main() {
+	var a i32 = 1
+	var b i32 = 2
+	var outer func() = func() {
+		var c i32 = a
+		var inner func() = func() {
+			b = c + g
+		}
+	}
+	each(func(x i32) i32 {
+		return x
+	})
+}
+	`
+	p := checkErrors(t, input, "only named functions can be passed as callbacks to extern function each")
+
+	body := p.Statements[2].(*ast.FuncDecl).Body
+	a, b := body[0].(*ast.VarDecl), body[1].(*ast.VarDecl)
+	outer := body[2].(*ast.VarDecl).Value.(*ast.FuncLit)
+	c := outer.Decl.Body[0].(*ast.VarDecl)
+	inner := outer.Decl.Body[1].(*ast.VarDecl).Value.(*ast.FuncLit)
+
+	checkCaptures := func(lit *ast.FuncLit, want ...*ast.VarDecl) {
+		if len(lit.Captures) != len(want) {
+			t.Fatalf("expected %d captures, got %d", len(want), len(lit.Captures))
+		}
+		for i := range want {
+			if lit.Captures[i] != want[i] {
+				t.Fatalf("[%d] expected %s, got %s", i, want[i].Token.Value, lit.Captures[i].Token.Value)
+			}
+		}
+	}
+	checkCaptures(outer, a, b)
+	checkCaptures(inner, b, c)
+
+	if !a.Captured || !b.Captured || !c.Captured {
+		t.Fatalf("expected a, b and c to be captured")
+	}
+}
+
+func TestVariadic(t *testing.T) {
+	input := `
+type Point interface {
+	X() i32
+}
+func printf(format ^u8, ...) i32
+func sum(xs ...i32) i32 {
+	return xs[0] + len(xs)
+}
+func first[T](xs ...T) T {
+	return xs[0]
+}
+func bad(x i32, ...) {}
+func main() i32 {
+	var p Point = none
+	var f func(^u8) i32 = printf
+	var g func(...i32) i32 = sum
+	printf("%d %s\n", 1, "x")
+	printf("%d", p)
+	printf()
+	sum()
+	sum(1, none)
+	var x i32 = first(1, 2)
+	var y i32 = x[0] + len(x)
+	return g(1, 2) + sum(1, 2, 3)
+}
+	`
+	checkErrors(t, input,
+		"only extern functions can take C varargs",
+		"cannot use none as Point",
+		"cannot use variadic function printf as a value",
+		"cannot pass Point as a variadic argument to C function printf",
+		"wrong number of arguments to printf: got 0, want at least 1",
+		"cannot use none as i32",
+		"cannot index value of type i32",
+		"invalid argument of type i32 for len",
+	)
+}
+
+func TestPackages(t *testing.T) {
+	geom := parse(t, `
+package geom
+
+type Len i32
+
+func (l Len) Double() i32 {
+	return l + l
+}
+
+func (l Len) half() i32 {
+	return l
+}
+
+func Area(w Len, h Len) i32 {
+	return w * h
+}
+
+func scale(x i32) i32 {
+	return x
+}
+	`)
+	main := parse(t, `
+import "lib/geom"
+
+func (l geom.Len) Triple() i32 {
+	return 3
+}
+
+func main() i32 {
+	var w geom.Len = 2
+	var f func(geom.Len, geom.Len) i32 = geom.Area
+	var x geom.hidden = 1
+	var y i32 = geom.scale(1)
+	var z i32 = geom.Volume(1)
+	var h i32 = w.half()
+	return f(w, w) + w.Double() + geom.Area(w, 3)
+}
+	`)
+	main.Imports[0].Program = geom
+
+	errs := CheckPackages([]*ast.Program{geom, main})
+
+	expectErrors(t, errs,
+		"cannot define methods on geom.Len of another package",
+		"cannot refer to unexported name geom.hidden",
+		"cannot refer to unexported name geom.scale",
+		"geom.Volume not declared",
+		"cannot refer to unexported method half of geom.Len",
+	)
+}
+
+func TestExport(t *testing.T) {
+	geom := parse(t, `
+package geom
+
+type Len i32
+
+export func Area(w Len, h Len) i32 {
+	return w * h
+}
+
+export func add(a i32, b i32) i32 {
+	return a + b
+}
+
+export func (l Len) Double() i32 {
+	return l + l
+}
+
+export func sqrt(x i32) i32
+export func id[T](x T) T {
+	return x
+}
+export func apply(f func(i32) i32) i32 {
+	return f(1)
+}
+	`)
+	main := parse(t, `
+import "lib/geom"
+
+func add(a i32, b i32) i32 {
+	return a + b
+}
+
+export func Area(w i32) i32 {
+	return w
+}
+	`)
+	main.Imports[0].Program = geom
+
+	errs := CheckPackages([]*ast.Program{geom, main})
+
+	expectErrors(t, errs,
+		"methods cannot be exported",
+		"exported function sqrt must have a body",
+		"generic function id cannot be exported",
+		"exported function apply cannot take or return functions",
+		"symbol add is already defined",
+		"symbol Area is already defined",
+	)
+}
+
+func TestBadNodes(t *testing.T) {
+	input := `
+var g i32 = )
+func f(x i32) i32 {
+	var y i32 = * x
+	y = )
+	foo bar
+	return y + g
+}
+func h( {}
+	`
+	p := parser.New(lexer.New(input))
+	prog, _ := p.ParseProgram()
+	if len(p.Errors) == 0 {
+		t.Fatalf("expected syntax errors")
+	}
+
+	checker := New(prog)
+	checker.Check()
+	if len(checker.Errors) != 0 {
+		t.Fatalf("expected no errors, got %v", checker.Errors)
+	}
+}
+
+func TestCheckInputs(t *testing.T) {
+	c := New(parse(t, "type Meters i32\n"))
+	c.Check()
+
+	inputs := []struct {
+		input string
+		want  string
+	}{
+		{"func double(x i32) i32 {\n\treturn x * 2\n}", ""},
+		{"var x i32 = double(21)", ""},
+		{"func (m Meters) Len() i32 {\n\treturn y\n}", "y not declared"},
+		{"func (m Meters) Len() i32 {\n\treturn m\n}", ""},
+		{"func double(x i32) i32 {\n\treturn x\n}", "duplicate declaration of 'double'"},
+		{"func bad() i32 {\n\treturn z\n}", "z not declared"},
+		{"bad()", "bad not declared"},
+		{"var y i32 = x + q", "q not declared"},
+		{"var y i32 = x + 1", ""},
+		{"x = double(y)", ""},
+		{"return 1", "return outside of a function"},
+		{"try double(1)", "try outside of a function"},
+		{"double(x) + y", ""},
+	}
+	for _, tt := range inputs {
+		p := parser.New(lexer.New(tt.input))
+		n, ok := p.ParseInput()
+		if !ok {
+			t.Fatalf("%q: %v", tt.input, p.Errors)
+		}
+
+		var errs []*diag.Diagnostic
+		switch v := n.(type) {
+		case *ast.FuncDecl, *ast.TypeDecl, *ast.VarDecl:
+			errs = c.CheckDecl(v.(ast.Statement))
+		case ast.Statement:
+			errs = c.CheckStatement(v)
+		case ast.Expression:
+			errs = c.CheckExpression(v)
+		}
+
+		switch {
+		case tt.want == "" && len(errs) > 0:
+			t.Errorf("%q: unexpected errors %v", tt.input, errs)
+		case tt.want != "" && (len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.want)):
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestAssignability(t *testing.T) {
+	input := `
+type Meters i32
+func f() ?i32 {
+	return none
+}
+func g() ?i32 {
+	var s ^u8 = "a"
+	return s
+}
+func main() i32 {
+	var x ?i32 = 1
+	var m Meters = x
+	var p ^i32 = none
+	var q ^u8 = "b"
+	var b u8 = 1
+	m = b
+	return f()
+}
+	`
+	checkErrors(t, input,
+		"cannot use ^u8 as i32",
+		"cannot use ?i32 as Meters",
+		"cannot use none as ^i32",
+		"cannot use ?i32 as i32",
+	)
+}
+
+func TestMissingReturn(t *testing.T) {
+	input := `
+enum Color {
+	Red, Blue
+}
+func a(x i32) i32 {
+	var y i32 = x
+}
+func b(x i32) i32 {
+	switch x {
+	case 1:
+		return 1
+	}
+}
+func c(x i32) i32 {
+	switch x {
+	case 1:
+		return 1
+	default:
+		return 2
+	}
+}
+func d(c Color) i32 {
+	switch c {
+	case Color.Red:
+		return 1
+	case Color.Blue:
+	}
+}
+func e(c Color) i32 {
+	switch c {
+	case Color.Red:
+		return 1
+	case Color.Blue:
+		return 2
+	}
+}
+func f() {
+	var g func() i32 = func() i32 {
+	}
+}
+	`
+	checkErrors(t, input,
+		"missing return at the end of a",
+		"missing return at the end of b",
+		"missing return at the end of d",
+		"missing return at the end of function literal",
+	)
+}
+
+func TestArgumentTypes(t *testing.T) {
+	input := `
+type Small u8
+func f(x i32) i32 {
+	return x
+}
+func puts(s ^u8) i32
+func main() i32 {
+	f("a")
+	puts(1)
+	puts("b")
+	var u u8 = 300
+	var v u8 = 255
+	var s Small = 256
+	return "abc"
+}
+	`
+	checkErrors(t, input,
+		"cannot use ^u8 as i32",
+		"cannot use i32 as ^u8",
+		"constant 300 overflows u8",
+		"constant 256 overflows Small",
+		"cannot use ^u8 as i32",
+	)
+
+	c := New(parse(t, "var u u8 = 300"))
+	c.Check()
+	if d := c.Errors[0]; d.Span.Start.Column != 12 || d.Span.End.Column != 15 {
+		t.Errorf("expected the error at columns 12 to 15, got %v", d.Span)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	input := `
+func main() {
+	var x i32 = 1
+	var f func(i32) i32 = func(y i32) i32 {
+		return y
+	}
+	x = "s"
+	x = 2
+	f = func() {}
+	f = func(y i32) i32 {
+		x = y
+		return x
+	}
+}
+	`
+	checkErrors(t, input,
+		"cannot use ^u8 as i32",
+		"cannot use func() as func(i32) i32",
+	)
+}
+
+func TestEnumRange(t *testing.T) {
+	input := `
+enum C u8 {
+	A = 1, B = 257
+}
+enum D u8 {
+	A = 254, B, C
+}
+	`
+	checkErrors(t, input,
+		"value 257 of C.B overflows u8",
+		"value 256 of D.C overflows u8",
+	)
+}
+
+func TestCaseRange(t *testing.T) {
+	input := `
+enum E {
+	A = 1, B = 1, C
+}
+func test(x u8, e E) {
+	switch x {
+	case 44:
+	case 300:
+	case 255:
+	}
+	switch e {
+	case E.A:
+	case E.C:
+	}
+}
+	`
+	p := parse(t, input)
+	c := New(p)
+	c.Check()
+	expectErrors(t, c.Errors,
+		"duplicate value 1 of E.B",
+		"constant 300 overflows u8",
+	)
+	if notes := c.Errors[0].Notes; len(notes) != 1 || notes[0].Span.Start.Line != 3 || notes[0].Span.Start.Column != 2 {
+		t.Errorf("expected a note at E.A, got %v", notes)
+	}
+}
+
+func TestSwitchOperand(t *testing.T) {
+	input := `
+func inc(x i32) i32 {
+	return x + 1
+}
+func test(s ^u8) {
+	switch inc {
+	case 1:
+		var x i32 = "a"
+	}
+	switch s {
+	default:
+	}
+}
+	`
+	checkErrors(t, input,
+		"cannot switch on func(i32) i32, which is not an integer, an enum or a union",
+		"cannot use ^u8 as i32",
+		"cannot switch on ^u8, which is not an integer, an enum or a union",
+	)
+}
+
+func TestOperands(t *testing.T) {
+	input := `
+enum Shape {
+	A, B
+}
+union U {
+	A(i32), B
+}
+func main() i32 {
+	var x i32 = Shape.B
+	var y i32 = U.A(1) + 1
+	var o ?i32 = 1
+	var s Shape = Shape.A
+	var b u8 = 1
+	b = b + 256
+	b = 256 + b
+	b = b + x
+	x = s * 2
+	return o + 1
+}
+	`
+	checkErrors(t, input,
+		"cannot use Shape as i32",
+		"operator + not defined on U",
+		"constant 256 overflows u8",
+		"constant 256 overflows u8",
+		"operator * not defined on Shape",
+		"operator + not defined on ?i32",
+	)
+}
+
+func TestGlobalTypes(t *testing.T) {
+	input := `
+type Meters i32
+var p ^u8 = 0
+var o ?i32 = 0
+var m Meters = 3
+var b u8 = 4
+	`
+	checkErrors(t, input,
+		"cannot use i32 as ^u8",
+		"global p must have an integer type, not ^u8",
+		"global o must have an integer type, not ?i32",
+	)
+}
+
+func TestVoidValues(t *testing.T) {
+	input := `
+type Dog i32
+func (d Dog) bark() {}
+func f() {}
+func main() i32 {
+	var g func() = f
+	var d Dog = 1
+	var x i32 = f()
+	x = g()
+	x = d.bark() + 1
+	switch f() {
+	default:
+	}
+	return x + f()
+}
+	`
+	checkErrors(t, input,
+		"f() used as value",
+		"g() used as value",
+		"bark() used as value",
+		"f() used as value",
+		"f() used as value",
+	)
+}
+
+func TestDeferMethod(t *testing.T) {
+	input := `
+type File i32
+func (f File) close() {}
+func main() {
+	var d File = 3
+	defer d.close()
+	var close func() = func() {
+		d.close()
+	}
+	defer close()
+}
+	`
+	checkErrors(t, input, "cannot defer method call close, defer a function literal that calls it")
+}
+
+func TestUndefinedTypes(t *testing.T) {
+	input := `
+var x i21 = 1
+type Len foo
+func f(a foo) ?bar {
+	var y []baz = none
+	return none
+}
+func (q qux) walk() {}
+func g[T Walker](b T) {}
+	`
+	checkErrors(t, input,
+		"undefined type foo",
+		"undefined type i21",
+		"undefined type foo",
+		"undefined type bar",
+		"undefined type qux",
+		"undefined type Walker",
+		"undefined type baz",
+	)
+
+	c := New(parse(t, "var x i21 = 1"))
+	c.Check()
+	if d := c.Errors[0]; d.Span.Start.Column != 7 || d.Span.End.Column != 10 {
+		t.Errorf("expected the error at columns 7 to 10, got %v", d.Span)
+	}
+}
+
+func TestMainSignature(t *testing.T) {
+	checkErrors(t, `
+func main(x i32) i32 {
+	return x
+}
+	`, "main must take no parameters or (i32, ^^u8), not func(i32) i32")
+	checkErrors(t, `
+func main(x ^u8, y i32) i32 {
+	return y
+}
+	`, "main must take no parameters or (i32, ^^u8), not func(^u8, i32) i32")
+	checkErrors(t, `
+func main() ?i32 {
+	return 1
+}
+	`, "main must return an integer or nothing, not ?i32")
+	checkErrors(t, `
+func main(argc i32, argv ^^u8) u8 {
+	return 0
+}
+	`)
+}
+
+func TestUnderlyingTypes(t *testing.T) {
+	input := `
+type O ?i32
+type F func() i32
+type Sl []i32
+type R O!i32
+type P ^i32
+func main() {
+	var o O = none
+	var f F = func() i32 {
+		return 1
+	}
+}
+	`
+	checkErrors(t, input,
+		"invalid underlying type ?i32",
+		"invalid underlying type func() i32",
+		"invalid underlying type []i32",
+		"invalid underlying type O!i32",
+	)
+}
+
+func TestInferNone(t *testing.T) {
+	input := `
+func id[T](x T) T {
+	return x
+}
+func pick[T](x T, y T) T {
+	return x
+}
+func main() {
+	var a ?i32 = id(none)
+	var b i32 = id(err(1))
+	var c ?i32 = pick(none, a)
+}
+	`
+	checkErrors(t, input,
+		"cannot infer T in call to id",
+		"cannot infer T in call to id",
+	)
+}
+
 func parse(t *testing.T, input string) *ast.Program {
 	l := lexer.New(input)
 	p := parser.New(l)
 	prog, ok := p.ParseProgram()
 	if !ok {
 		for _, err := range p.Errors {
-			t.Fatalf(err)
+			t.Fatal(err)
 		}
 		return nil
 	}
 	return prog
 }
+
+// checkErrors checks input and fails the test unless it has the errors in
+// want, in order. It returns the checked program.
+func checkErrors(t *testing.T, input string, want ...string) *ast.Program {
+	t.Helper()
+	p := parse(t, input)
+	checker := New(p)
+	checker.Check()
+	expectErrors(t, checker.Errors, want...)
+	return p
+}
+
+// expectErrors fails the test unless errs contain the messages in want, in
+// order.
+func expectErrors(t *testing.T, errs []*diag.Diagnostic, want ...string) {
+	t.Helper()
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), errs)
+	}
+	for i := range want {
+		if !strings.Contains(errs[i].Error(), want[i]) {
+			t.Fatalf("[%d] expected %q, got %q", i, want[i], errs[i].Error())
+		}
+	}
+}
@@ -1,11 +1,23 @@
 package checker
 
-import "lang/ast"
+import (
+	"lang/ast"
+	"lang/types"
+)
 
+// Context holds the declarations of a scope, which is nested in the context
+// of its enclosing scope.
 type Context struct {
 	outer *Context
+
+	// closure is the function literal whose body this context is for
+	closure *ast.FuncLit
+
 	vars  map[string]*ast.VarDecl
 	funcs map[string]*ast.FuncDecl
+	types map[string]*ast.TypeDecl
+
+	typeParams map[string]*types.TypeParam
 }
 
 func newContext(outer *Context) *Context {
@@ -13,9 +25,32 @@ func newContext(outer *Context) *Context {
 		outer: outer,
 		vars:  make(map[string]*ast.VarDecl),
 		funcs: make(map[string]*ast.FuncDecl),
+		types: make(map[string]*ast.TypeDecl),
+
+		typeParams: make(map[string]*types.TypeParam),
 	}
 }
 
+// Outer returns the context that c is nested in, or nil for the context of
+// the package.
+func (c *Context) Outer() *Context {
+	return c.outer
+}
+
+// Vars, Funcs and Types return the declarations of c by name, without those
+// of the contexts it is nested in. The maps must not be modified.
+func (c *Context) Vars() map[string]*ast.VarDecl {
+	return c.vars
+}
+
+func (c *Context) Funcs() map[string]*ast.FuncDecl {
+	return c.funcs
+}
+
+func (c *Context) Types() map[string]*ast.TypeDecl {
+	return c.types
+}
+
 func (c *Context) getVar(name string) (*ast.VarDecl, bool) {
 	for ctx := c; ctx != nil; ctx = ctx.outer {
 		if vd, ok := ctx.vars[name]; ok {
@@ -25,6 +60,25 @@ func (c *Context) getVar(name string) (*ast.VarDecl, bool) {
 	return nil, false
 }
 
+// lookupVar returns the named variable and the function literals between its
+// use and its declaration, which capture it. Variables of the outermost
+// context are globals and are never captured.
+func (c *Context) lookupVar(name string) (*ast.VarDecl, []*ast.FuncLit, bool) {
+	lits := make([]*ast.FuncLit, 0)
+	for ctx := c; ctx != nil; ctx = ctx.outer {
+		if vd, ok := ctx.vars[name]; ok {
+			if ctx.outer == nil {
+				return vd, nil, true
+			}
+			return vd, lits, true
+		}
+		if ctx.closure != nil {
+			lits = append(lits, ctx.closure)
+		}
+	}
+	return nil, nil, false
+}
+
 func (c *Context) getFuncDecl(name string) (*ast.FuncDecl, bool) {
 	for ctx := c; ctx != nil; ctx = ctx.outer {
 		if fd, ok := ctx.funcs[name]; ok {
@@ -33,3 +87,21 @@ func (c *Context) getFuncDecl(name string) (*ast.FuncDecl, bool) {
 	}
 	return nil, false
 }
+
+func (c *Context) getTypeDecl(name string) (*ast.TypeDecl, bool) {
+	for ctx := c; ctx != nil; ctx = ctx.outer {
+		if td, ok := ctx.types[name]; ok {
+			return td, true
+		}
+	}
+	return nil, false
+}
+
+func (c *Context) getTypeParam(name string) (*types.TypeParam, bool) {
+	for ctx := c; ctx != nil; ctx = ctx.outer {
+		if tp, ok := ctx.typeParams[name]; ok {
+			return tp, true
+		}
+	}
+	return nil, false
+}
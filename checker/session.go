@@ -0,0 +1,111 @@
+package checker
+
+import (
+	"lang/ast"
+	"lang/diag"
+	"lang/types"
+)
+
+// CheckDecl checks a declaration that is added to the package after Check,
+// like the inputs of an interactive session, against the declarations of the
+// package and those added before it. Variables can be initialized with any
+// expression. The declaration is only added to the package if it has no
+// errors, which are returned.
+func (c *Checker) CheckDecl(stmt ast.Statement) []*diag.Diagnostic {
+	n := len(c.Errors)
+	c.funcDecl = nil
+
+	switch v := stmt.(type) {
+	case *ast.TypeDecl:
+		c.checkTypeDeclDup(v)
+		if len(c.Errors) > n {
+			break
+		}
+		c.scope.types[v.Token.Value] = v
+		c.checkTypeDecl(v)
+		if len(c.Errors) > n {
+			delete(c.scope.types, v.Token.Value)
+		}
+	case *ast.FuncDecl:
+		if v.Receiver != nil {
+			c.checkMethod(v, n)
+			break
+		}
+		c.checkExport(v)
+		c.checkFuncDeclDup(v)
+		if len(c.Errors) > n {
+			break
+		}
+		c.checkVariadic(v)
+		c.checkSignature(v)
+		c.scope.funcs[v.Token.Value] = v
+		c.checkFuncDecl(v)
+		if len(c.Errors) > n {
+			delete(c.scope.funcs, v.Token.Value)
+		}
+	case *ast.VarDecl:
+		if dup, ok := c.scope.getVar(v.Token.Value); ok {
+			c.errorDuplicate(v.Token, dup.Token)
+			break
+		}
+		c.checkVarDecl(v)
+		if len(c.Errors) > n {
+			delete(c.scope.vars, v.Token.Value)
+		}
+	case *ast.BadStmt:
+	default:
+		panic("unsupported type")
+	}
+
+	c.funcDecl = nil
+	return c.Errors[n:]
+}
+
+// checkMethod checks a method of CheckDecl. The method tables are restored
+// if it has errors, which removes the method or puts back the one it
+// duplicates.
+func (c *Checker) checkMethod(fd *ast.FuncDecl, n int) {
+	saved := make(map[*types.Named]map[string]*ast.FuncDecl)
+	for named, methods := range c.methods {
+		saved[named] = make(map[string]*ast.FuncDecl)
+		for name, m := range methods {
+			saved[named][name] = m
+		}
+	}
+
+	c.checkExport(fd)
+	c.checkMethodDecl(fd)
+	if len(c.Errors) == n {
+		c.checkFuncDecl(fd)
+	}
+	if len(c.Errors) == n {
+		return
+	}
+
+	for named := range c.methods {
+		if _, ok := saved[named]; !ok {
+			delete(c.methods, named)
+		}
+	}
+	for named, methods := range saved {
+		c.methods[named] = methods
+	}
+}
+
+// CheckStatement checks a statement outside of any function, against the
+// declarations of the package, and returns its errors.
+func (c *Checker) CheckStatement(stmt ast.Statement) []*diag.Diagnostic {
+	n := len(c.Errors)
+	c.funcDecl = nil
+	c.checkStatements([]ast.Statement{stmt})
+	return c.Errors[n:]
+}
+
+// CheckExpression checks an expression outside of any function, against the
+// declarations of the package, and returns its errors.
+func (c *Checker) CheckExpression(e ast.Expression) []*diag.Diagnostic {
+	n := len(c.Errors)
+	c.funcDecl = nil
+	c.checkExpression(e)
+	return c.Errors[n:]
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// toolchain holds the flags of the commands that compile IR to executables.
+// The IR is compiled with clang if it is installed, or else with llc and
+// linked with the C compiler in CC.
+type toolchain struct {
+	opt     int
+	ldflags string
+}
+
+func (t *toolchain) register(fs *flag.FlagSet) {
+	fs.IntVar(&t.opt, "O", 0, "optimization `level` from 0 to 3")
+	fs.StringVar(&t.ldflags, "ldflags", "", "pass the space separated `flags` to the linker, e.g. -lm")
+}
+
+// link compiles the IR file ll to the executable out. The target is the
+// triple of the module.
+func (t *toolchain) link(ll, out string) error {
+	if t.opt < 0 || t.opt > 3 {
+		return fmt.Errorf("invalid optimization level %d", t.opt)
+	}
+	opt := "-O" + strconv.Itoa(t.opt)
+	ldflags := strings.Fields(t.ldflags)
+
+	if clang, err := exec.LookPath("clang"); err == nil {
+		args := append([]string{opt, "-Wno-override-module", "-o", out, ll}, ldflags...)
+		return tool(clang, args...)
+	}
+
+	llc, err := exec.LookPath("llc")
+	if err != nil {
+		return errors.New("cannot find clang or llc in PATH")
+	}
+	obj := strings.TrimSuffix(ll, filepath.Ext(ll)) + ".o"
+	if err := tool(llc, opt, "-filetype=obj", "-relocation-model=pic", "-o", obj, ll); err != nil {
+		return err
+	}
+
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	return tool(cc, append([]string{"-o", out, obj}, ldflags...)...)
+}
+
+// tool runs a tool of the toolchain, whose output goes to stderr.
+func tool(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(name), err)
+	}
+	return nil
+}
+
+// execute runs the executable exe with args and returns its exit code.
+func execute(exe string, args []string) int {
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit) && exit.ExitCode() >= 0:
+		return exit.ExitCode()
+	default:
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+}
@@ -1,50 +1,420 @@
+// Command lang checks, compiles and runs programs.
+//
+// Usage:
+//
+//	lang check path
+//	lang emit [--ir | --ast | --tokens] [-o file] path
+//	lang build [-o file] path
+//	lang run [--interp] path [args...]
+//	lang fmt [-w] [-d] path
+//	lang lsp
+//	lang repl
+//
+// The path is a source file or a directory of source files, which is the main
+// package. It defaults to the current directory. Every command takes
+// -diagnostics text|json|sarif, the format that errors are written in.
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"lang/ast"
 	"lang/checker"
+	"lang/diag"
+	"lang/interp"
 	"lang/lexer"
 	"lang/llvm"
-	"lang/parser"
+	"lang/loader"
+	"lang/token"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+// The output of the commands, which tests replace.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(args []string) int
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"check", "path", "parse and check a program", runCheck},
+		{"emit", "[--ir | --ast | --tokens] [-o file] path", "write the IR, the syntax tree or the tokens of a program", runEmit},
+		{"build", "[-o file] path", "compile a program to an executable", runBuild},
+		{"run", "[--interp] path [args...]", "compile and run a program, exiting with its exit code", runRun},
+		{"fmt", "[-w] [-d] path", "format the source files of a program", runFmt},
+		{"lsp", "", "run a language server on the standard input and output", runLsp},
+		{"repl", "", "read, check and run declarations and expressions interactively", runRepl},
+	}
+}
+
 func main() {
-	inputFile := "examples/testfile"
-	if len(os.Args) > 1 {
-		inputFile = os.Args[1]
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			os.Exit(cmd.run(os.Args[2:]))
+		}
+	}
+	if os.Args[1] != "help" && os.Args[1] != "-h" && os.Args[1] != "--help" {
+		fmt.Fprintf(stderr, "lang: unknown command %q\n", os.Args[1])
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(stderr, "usage: lang <command> [flags] [path]")
+	fmt.Fprintln(stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(stderr, "\t%-6s %s\n", cmd.name, cmd.short)
+	}
+	fmt.Fprintln(stderr)
+	fmt.Fprintln(stderr, "Run lang <command> -h for the flags of a command.")
+}
+
+// diagnostics is the format that errors are written in.
+var diagnostics string
+
+// newFlags returns the flag set of cmd.
+func newFlags(name string) *flag.FlagSet {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			fs := flag.NewFlagSet(name, flag.ContinueOnError)
+			fs.SetOutput(stderr)
+			fs.StringVar(&diagnostics, "diagnostics", "text", "write errors as `format` text, json or sarif")
+			fs.Usage = func() {
+				fmt.Fprintf(stderr, "usage: lang %s %s\n", cmd.name, cmd.args)
+				fs.PrintDefaults()
+			}
+			return fs
+		}
+	}
+	panic("unknown command " + name)
+}
+
+// codegen holds the flags of the commands that generate code.
+type codegen struct {
+	triple string
+	header string
+}
+
+func (c *codegen) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.triple, "target", "", "generate code for the target `triple`")
+	fs.StringVar(&c.header, "header", "", "write a C header that declares the exported functions to `file`")
+}
+
+// parse parses the flags of a command and returns its path argument and the
+// arguments after it. It returns false if the flags are invalid.
+func parse(fs *flag.FlagSet, args []string) (string, []string, bool) {
+	if err := fs.Parse(args); err != nil {
+		return "", nil, false
 	}
-	outputFile := "out.ll"
-	if len(os.Args) > 2 {
-		outputFile = os.Args[2]
+	switch diagnostics {
+	case "text", "json", "sarif":
+	default:
+		fmt.Fprintf(stderr, "invalid -diagnostics format %q\n", diagnostics)
+		return "", nil, false
 	}
-	l, err := lexer.FromFile(inputFile)
+	if fs.NArg() == 0 {
+		return ".", nil, true
+	}
+	return fs.Arg(0), fs.Args()[1:], true
+}
+
+func runCheck(args []string) int {
+	fs := newFlags("check")
+	path, rest, ok := parse(fs, args)
+	if !ok || len(rest) > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if _, ok := load(path); !ok {
+		return 1
+	}
+	return 0
+}
+
+func runEmit(args []string) int {
+	fs := newFlags("emit")
+	var c codegen
+	c.register(fs)
+	ir := fs.Bool("ir", false, "write the LLVM IR (default)")
+	tree := fs.Bool("ast", false, "write the syntax tree of the main package")
+	tokens := fs.Bool("tokens", false, "write the tokens of the main package")
+	output := fs.String("o", "", "write to `file` instead of the standard output")
+	path, rest, ok := parse(fs, args)
+	if !ok || len(rest) > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	n := 0
+	for _, set := range []bool{*ir, *tree, *tokens} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		fmt.Fprintln(stderr, "lang emit: --ir, --ast and --tokens are exclusive")
+		return 2
+	}
+
+	var b strings.Builder
+	switch {
+	case *tokens:
+		if !emitTokens(&b, path) {
+			return 1
+		}
+	case *tree:
+		ld := newLoader(path)
+		progs, ok := ld.Load(path)
+		report(append(ld.Warnings, ld.Errors...))
+		if !ok {
+			return 1
+		}
+		ast.Fprint(&b, progs[len(progs)-1])
+	default:
+		code, ok := compile(path, &c)
+		if !ok {
+			return 1
+		}
+		b.WriteString(code)
+	}
+
+	if *output == "" || *output == "-" {
+		io.WriteString(stdout, b.String())
+		return 0
+	}
+	if err := os.WriteFile(*output, []byte(b.String()), 0666); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// emitTokens writes the tokens of the source files of the main package to w
+// and reports the errors of the lexer.
+func emitTokens(w io.Writer, path string) bool {
+	files, err := loader.SourceFiles(path)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(stderr, err)
+		return false
 	}
-	p := parser.New(l)
 
-	prog, _ := p.ParseProgram()
-	if len(p.Errors) > 0 {
-		for _, err := range p.Errors {
-			fmt.Println(err)
+	diags := make([]*diag.Diagnostic, 0)
+	for _, file := range files {
+		l, err := lexer.FromFile(file)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return false
+		}
+		for {
+			tok := l.NextToken()
+			fmt.Fprintf(w, "%s %s %q\n", tok.Path(), tok.Type, tok.Value)
+			if tok.Type == token.EOF {
+				break
+			}
 		}
-		return
+		diags = append(diags, l.Errors...)
 	}
+	report(diags)
+	return len(diags) == 0
+}
 
-	ch := checker.New(prog)
-	ch.Check()
+func runBuild(args []string) int {
+	fs := newFlags("build")
+	var c codegen
+	c.register(fs)
+	var t toolchain
+	t.register(fs)
+	output := fs.String("o", "", "write the executable to `file` (default: the name of the main package's file or directory)")
+	path, rest, ok := parse(fs, args)
+	if !ok || len(rest) > 0 {
+		fs.Usage()
+		return 2
+	}
 
-	if len(ch.Errors) > 0 {
-		for _, err := range ch.Errors {
-			fmt.Println(err)
+	if *output == "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
 		}
-		return
+		*output = strings.TrimSuffix(filepath.Base(abs), loader.Ext)
+	}
+
+	if !build(path, *output, &c, &t) {
+		return 1
+	}
+	return 0
+}
+
+func runRun(args []string) int {
+	fs := newFlags("run")
+	var c codegen
+	c.register(fs)
+	var t toolchain
+	t.register(fs)
+	useInterp := fs.Bool("interp", false, "run the program with the interpreter instead of compiling it")
+	path, rest, ok := parse(fs, args)
+	if !ok {
+		fs.Usage()
+		return 2
+	}
+	if *useInterp {
+		return interpret(path, rest)
+	}
+
+	dir, err := os.MkdirTemp("", "lang-run-")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	exe := filepath.Join(dir, "main")
+	if !build(path, exe, &c, &t) {
+		return 1
+	}
+	return execute(exe, rest)
+}
+
+// interpret runs the program at path with the interpreter. Errors of the
+// running program exit with 2.
+func interpret(path string, args []string) int {
+	progs, ok := load(path)
+	if !ok {
+		return 1
+	}
+
+	in := interp.New(progs...)
+	in.Stdout = stdout
+	code, err := in.Run(append([]string{path}, args...))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	return code
+}
+
+// build compiles the program at path to the executable out.
+func build(path, out string, c *codegen, t *toolchain) bool {
+	code, ok := compile(path, c)
+	if !ok {
+		return false
+	}
+
+	dir, err := os.MkdirTemp("", "lang-build-")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	ll := filepath.Join(dir, "main.ll")
+	if err := os.WriteFile(ll, []byte(code), 0666); err != nil {
+		fmt.Fprintln(stderr, err)
+		return false
+	}
+	if err := t.link(ll, out); err != nil {
+		fmt.Fprintln(stderr, err)
+		return false
+	}
+	return true
+}
+
+// compile loads, checks and generates the program at path. It writes the
+// header of the exported functions if c asks for one.
+func compile(path string, c *codegen) (string, bool) {
+	progs, ok := load(path)
+	if !ok {
+		return "", false
 	}
 
 	gen := llvm.NewGenerator()
-	code := gen.Generate(prog)
-	// code := a.Generate()
-	fmt.Println(code)
-	os.WriteFile(outputFile, []byte(code), 0666)
+	gen.Triple = c.triple
+	code := gen.Generate(progs...)
+
+	if c.header != "" {
+		h := gen.Header(filepath.Base(c.header))
+		if err := os.WriteFile(c.header, []byte(h), 0666); err != nil {
+			fmt.Fprintln(stderr, err)
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// load loads and checks the program at path, printing its errors.
+func load(path string) ([]*ast.Program, bool) {
+	ld := newLoader(path)
+	progs, ok := ld.Load(path)
+	diags := append(ld.Warnings, ld.Errors...)
+	if ok {
+		diags = append(diags, checker.CheckPackages(progs)...)
+	}
+	report(diags)
+	if diag.HasErrors(diags) {
+		return nil, false
+	}
+	return progs, true
+}
+
+// newLoader returns a loader for the program at path. Imports are resolved
+// next to the main package first, then in the directories listed in
+// LANGPATH.
+func newLoader(path string) *loader.Loader {
+	dirs := []string{filepath.Dir(path)}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		dirs[0] = path
+	}
+	dirs = append(dirs, langPath()...)
+
+	ld := loader.New(dirs)
+	ld.Include = include
+	return ld
+}
+
+// include lists the directories that C headers are looked up in.
+var include = []string{"/usr/local/include", "/usr/include"}
+
+// langPath returns the directories listed in LANGPATH.
+func langPath() []string {
+	if env := os.Getenv("LANGPATH"); env != "" {
+		return filepath.SplitList(env)
+	}
+	return nil
+}
+
+// report writes diags to the standard error in the format of the
+// -diagnostics flag. JSON and SARIF are written even without diagnostics, so
+// that tools always have a report to read.
+func report(diags []*diag.Diagnostic) {
+	var err error
+	switch diagnostics {
+	case "json":
+		err = diag.WriteJSON(stderr, diags)
+	case "sarif":
+		err = diag.WriteSARIF(stderr, "lang", diags)
+	default:
+		diag.NewPrinter(stderr).PrintAll(diags)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+	}
 }
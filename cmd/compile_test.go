@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lang runs a command with the given source as the main package and returns
+// its exit code and output.
+func lang(t *testing.T, source string, args ...string) (int, string, string) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.lang")
+	if err := os.WriteFile(file, []byte(source), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errs bytes.Buffer
+	stdout, stderr = &out, &errs
+	defer func() { stdout, stderr = os.Stdout, os.Stderr }()
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			code := cmd.run(append(args[1:], file))
+			return code, out.String(), errs.String()
+		}
+	}
+	t.Fatalf("unknown command %s", args[0])
+	return 0, "", ""
+}
+
+func TestCheck(t *testing.T) {
+	if code, _, errs := lang(t, "func main() i32 {\n\treturn 0\n}\n", "check"); code != 0 {
+		t.Fatalf("check exited with %d: %s", code, errs)
+	}
+
+	code, _, errs := lang(t, "func main() i32 {\n\treturn x\n}\n", "check")
+	if code != 1 || !strings.Contains(errs, "x not declared") || !strings.Contains(errs, "\treturn x\n") {
+		t.Fatalf("check exited with %d: %s", code, errs)
+	}
+
+	code, _, errs = lang(t, "func main() i32 {\n\treturn x\n}\n", "check", "-diagnostics", "json")
+	if code != 1 || !strings.Contains(errs, `"code": "undeclared"`) {
+		t.Fatalf("check -diagnostics json exited with %d: %s", code, errs)
+	}
+}
+
+func TestEmit(t *testing.T) {
+	source := "func main() i32 {\n\treturn 0\n}\n"
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"emit"}, "define i32 @main()"},
+		{[]string{"emit", "--ir", "-target", "x86_64-pc-linux-gnu"}, `target triple = "x86_64-pc-linux-gnu"`},
+		{[]string{"emit", "--ast"}, "Token: IDENT \"main\""},
+		{[]string{"emit", "--tokens"}, "main.lang:2:2 RETURN \"return\""},
+	}
+	for _, tt := range tests {
+		code, out, errs := lang(t, source, tt.args...)
+		if code != 0 || !strings.Contains(out, tt.want) {
+			t.Errorf("%v exited with %d, want %q in\n%s%s", tt.args, code, tt.want, out, errs)
+		}
+	}
+
+	if code, _, _ := lang(t, source, "emit", "--ast", "--tokens"); code != 2 {
+		t.Errorf("emit --ast --tokens exited with %d, want 2", code)
+	}
+
+	out := filepath.Join(t.TempDir(), "out.ll")
+	if code, _, errs := lang(t, source, "emit", "-o", out); code != 0 {
+		t.Fatalf("emit -o exited with %d: %s", code, errs)
+	}
+	if b, err := os.ReadFile(out); err != nil || !strings.Contains(string(b), "@main") {
+		t.Errorf("emit -o wrote %q, %v", b, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if _, err := exec.LookPath("clang"); err != nil {
+		if _, err := exec.LookPath("llc"); err != nil {
+			t.Skip("no clang or llc in PATH")
+		}
+	}
+
+	code, _, errs := lang(t, "func main() i32 {\n\treturn 6 * 7\n}\n", "run", "-O", "2")
+	if code != 42 {
+		t.Fatalf("run exited with %d, want 42: %s", code, errs)
+	}
+
+	source := "func main() i32 {\n\tvar b u8 = 200\n\tb = b / 3 - 1\n\treturn 7 - 2 * 10 / 4 + 30 + b\n}\n"
+	for _, args := range [][]string{{"run"}, {"run", "--interp"}} {
+		if code, _, errs := lang(t, source, args...); code != 97 {
+			t.Errorf("%v exited with %d, want 97: %s", args, code, errs)
+		}
+	}
+}
+
+func TestRunInterp(t *testing.T) {
+	source := "func puts(s ^u8) i32\nfunc main() i32 {\n\tputs(\"hi\")\n\treturn 6 * 7\n}\n"
+	code, out, errs := lang(t, source, "run", "--interp")
+	if code != 42 || out != "hi\n" {
+		t.Fatalf("run --interp exited with %d and wrote %q: %s", code, out, errs)
+	}
+
+	code, _, errs = lang(t, "func main() i32 {\n\treturn 1 / 0\n}\n", "run", "--interp")
+	if code != 2 || !strings.Contains(errs, "main.lang:2:11: division by zero") {
+		t.Fatalf("run --interp exited with %d: %s", code, errs)
+	}
+}
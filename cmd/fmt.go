@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"lang/diag"
+	"lang/format"
+	"lang/loader"
+	"os"
+	"strings"
+)
+
+func runFmt(args []string) int {
+	fs := newFlags("fmt")
+	write := fs.Bool("w", false, "write the formatted source back to the files instead of the standard output")
+	showDiff := fs.Bool("d", false, "write diffs of the changes instead of the formatted source")
+	path, rest, ok := parse(fs, args)
+	if !ok || len(rest) > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	files, err := loader.SourceFiles(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	diags := make([]*diag.Diagnostic, 0)
+	code := 0
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			code = 1
+			continue
+		}
+		out, errs := format.Source(file, src)
+		if errs != nil {
+			diags = append(diags, errs...)
+			continue
+		}
+
+		if *showDiff && !bytes.Equal(src, out) {
+			stdout.Write(unifiedDiff(file+".orig", file, src, out))
+		}
+		if *write && !bytes.Equal(src, out) {
+			if err := os.WriteFile(file, out, 0666); err != nil {
+				fmt.Fprintln(stderr, err)
+				code = 1
+			}
+		}
+		if !*write && !*showDiff {
+			stdout.Write(out)
+		}
+	}
+	report(diags)
+	if len(diags) > 0 {
+		return 1
+	}
+	return code
+}
+
+// unifiedDiff returns the changes from a to b in the unified format, with
+// three lines of context around each change.
+func unifiedDiff(aName, bName string, a, b []byte) []byte {
+	const context = 3
+
+	edits := diffLines(splitLines(a), splitLines(b))
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", aName, bName)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk starts with the context before a change and ends where the
+		// context after the last change of the hunk ends.
+		start := i
+		for start > 0 && i-start < context && edits[start-1].op == ' ' {
+			start--
+		}
+		end, same := i, 0
+		for end < len(edits) && same < 2*context {
+			if edits[end].op == ' ' {
+				same++
+			} else {
+				same = 0
+			}
+			end++
+		}
+		if same > context {
+			end -= same - context
+		}
+
+		aLine, bLine, aCount, bCount := edits[start].a, edits[start].b, 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, e := range edits[start:end] {
+			out.WriteByte(e.op)
+			out.WriteString(e.text)
+			if !strings.HasSuffix(e.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return out.Bytes()
+}
+
+// hunkRange returns the range of count lines from the 0-based line of a hunk.
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+// edit is a line that is the same in both files, removed or added. The lines
+// a and b are the 0-based lines of both files that the edit is at.
+type edit struct {
+	op   byte
+	text string
+	a, b int
+}
+
+// diffLines returns the edits that turn the lines a into the lines b, based
+// on their longest common subsequence. Lines that differ in long runs are
+// replaced as a whole rather than matched, to bound the time taken.
+func diffLines(a, b []string) []edit {
+	edits := make([]edit, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) && a[i] == b[j] {
+		edits = append(edits, edit{' ', a[i], i, j})
+		i, j = i+1, j+1
+	}
+	n, m := len(a), len(b)
+	for n > i && m > j && a[n-1] == b[m-1] {
+		n, m = n-1, m-1
+	}
+
+	if (n-i)*(m-j) > 1<<22 {
+		for ; i < n; i++ {
+			edits = append(edits, edit{'-', a[i], i, j})
+		}
+		for ; j < m; j++ {
+			edits = append(edits, edit{'+', b[j], i, j})
+		}
+	} else {
+		// lcs[x][y] is the length of the longest common subsequence of
+		// a[i+x:n] and b[j+y:m].
+		lcs := make([][]int32, n-i+1)
+		for x := range lcs {
+			lcs[x] = make([]int32, m-j+1)
+		}
+		for x := n - i - 1; x >= 0; x-- {
+			for y := m - j - 1; y >= 0; y-- {
+				switch {
+				case a[i+x] == b[j+y]:
+					lcs[x][y] = lcs[x+1][y+1] + 1
+				case lcs[x+1][y] >= lcs[x][y+1]:
+					lcs[x][y] = lcs[x+1][y]
+				default:
+					lcs[x][y] = lcs[x][y+1]
+				}
+			}
+		}
+		x, y := 0, 0
+		for x < n-i || y < m-j {
+			switch {
+			case x < n-i && y < m-j && a[i+x] == b[j+y]:
+				edits = append(edits, edit{' ', a[i+x], i + x, j + y})
+				x, y = x+1, y+1
+			case y == m-j || x < n-i && lcs[x+1][y] >= lcs[x][y+1]:
+				edits = append(edits, edit{'-', a[i+x], i + x, j + y})
+				x++
+			default:
+				edits = append(edits, edit{'+', b[j+y], i + x, j + y})
+				y++
+			}
+		}
+		i, j = n, m
+	}
+
+	for ; i < len(a); i, j = i+1, j+1 {
+		edits = append(edits, edit{' ', a[i], i, j})
+	}
+	return edits
+}
+
+// splitLines splits src into lines that keep their newlines.
+func splitLines(src []byte) []string {
+	lines := strings.SplitAfter(string(src), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFmt(t *testing.T) {
+	source := "func main() i32 { return 0 }\n"
+	want := "func main() i32 {\n\treturn 0\n}\n"
+
+	if code, out, errs := lang(t, source, "fmt"); code != 0 || out != want {
+		t.Fatalf("fmt exited with %d, wrote %q: %s", code, out, errs)
+	}
+
+	code, out, errs := lang(t, source, "fmt", "-d")
+	if code != 0 || !strings.Contains(out, "@@ -1 +1,3 @@\n-func main() i32 { return 0 }\n+func main() i32 {\n+\treturn 0\n+}\n") {
+		t.Fatalf("fmt -d exited with %d, wrote %q: %s", code, out, errs)
+	}
+
+	code, _, errs = lang(t, "func main() i32 {\n\treturn )\n}\n", "fmt")
+	if code != 1 || !strings.Contains(errs, "main.lang:2:9: error") {
+		t.Fatalf("fmt of invalid source exited with %d: %s", code, errs)
+	}
+}
+
+func TestFmtWrite(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "main.lang")
+	if err := os.WriteFile(file, []byte("func main() i32 { return 0 }\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var out, errs bytes.Buffer
+	stdout, stderr = &out, &errs
+	defer func() { stdout, stderr = os.Stdout, os.Stderr }()
+
+	if code := runFmt([]string{"-w", file}); code != 0 || out.Len() > 0 {
+		t.Fatalf("fmt -w exited with %d, wrote %q: %s", code, out.String(), errs.String())
+	}
+	if b, _ := os.ReadFile(file); string(b) != "func main() i32 {\n\treturn 0\n}\n" {
+		t.Fatalf("fmt -w wrote %q", b)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm"
+	want := `--- x.orig
++++ x
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+\ No newline at end of file
+`
+	if got := string(unifiedDiff("x.orig", "x", []byte(a), []byte(b))); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
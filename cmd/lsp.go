@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"lang/lsp"
+	"os"
+)
+
+// stdin is the input of the language server, which tests replace.
+var stdin io.Reader = os.Stdin
+
+func runLsp(args []string) int {
+	fs := newFlags("lsp")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	s := lsp.NewServer(stdin, stdout)
+	s.Path = langPath()
+	s.Include = include
+	if err := s.Run(); err != nil {
+		fmt.Fprintln(stderr, "lang lsp:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLsp(t *testing.T) {
+	var in bytes.Buffer
+	for _, msg := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	} {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+
+	var out, errs bytes.Buffer
+	stdin, stdout, stderr = &in, &out, &errs
+	defer func() { stdin, stdout, stderr = os.Stdin, os.Stdout, os.Stderr }()
+
+	if code := runLsp(nil); code != 0 {
+		t.Fatalf("lsp exited with %d: %s", code, errs.String())
+	}
+	if !strings.Contains(out.String(), `"definitionProvider":true`) || !strings.Contains(out.String(), `"id":2,"result":null`) {
+		t.Fatalf("unexpected output %s", out.String())
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"lang/interp"
+	"lang/repl"
+)
+
+func runRepl(args []string) int {
+	fs := newFlags("repl")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	err := repl.New(stdout, stderr).Run(stdin)
+	var exit interp.Exit
+	switch {
+	case errors.As(err, &exit):
+		return int(exit)
+	case err != nil:
+		fmt.Fprintln(stderr, "lang repl:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	var out, errs bytes.Buffer
+	stdin, stdout, stderr = strings.NewReader("func exit(status i32)\n6 * 7\nexit(3)\n"), &out, &errs
+	defer func() { stdin, stdout, stderr = os.Stdin, os.Stdout, os.Stderr }()
+
+	if code := runRepl(nil); code != 3 {
+		t.Fatalf("repl exited with %d: %s", code, errs.String())
+	}
+	if !strings.Contains(out.String(), "42 : i32\n") {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+}
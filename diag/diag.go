@@ -0,0 +1,165 @@
+// Package diag describes the errors and warnings that the compiler reports
+// about source files, and writes them for people, editors and CI systems.
+package diag
+
+import (
+	"fmt"
+	"lang/token"
+	"unicode/utf8"
+)
+
+// Severity is how serious a diagnostic is. Only errors stop compilation.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Info
+)
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// Codes identify the kind of a diagnostic, so that tools can filter and look
+// up diagnostics without matching messages.
+const (
+	Syntax     = "syntax"
+	Undeclared = "undeclared"
+	Duplicate  = "duplicate"
+	Type       = "type"
+	Import     = "import"
+	Foreign    = "foreign"
+)
+
+// Pos is a position in a source file. Lines and columns start at 1, and
+// columns count characters. The zero Pos is unknown.
+type Pos struct {
+	Filename string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+}
+
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	switch {
+	case !p.IsValid() && p.Filename == "":
+		return ""
+	case !p.IsValid():
+		return p.Filename
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+}
+
+// Span is the range of source from Start up to End, which is exclusive.
+type Span struct {
+	Start Pos `json:"start"`
+	End   Pos `json:"end"`
+}
+
+// At returns the span of the token t.
+func At(t token.Token) Span {
+	start := Pos{Filename: t.Filename, Line: t.Line, Column: t.Column}
+	end := start
+	end.Column += utf8.RuneCountInString(t.Value)
+	return Span{Start: start, End: end}
+}
+
+// Positioner maps positions to lines and columns, like a token.FileSet or a
+// token.File.
+type Positioner interface {
+	Position(p token.Pos) token.Position
+}
+
+// Range returns the span from the position from up to the position to.
+func Range(p Positioner, from, to token.Pos) Span {
+	return Span{Start: position(p.Position(from)), End: position(p.Position(to))}
+}
+
+func position(p token.Position) Pos {
+	return Pos{Filename: p.Filename, Line: p.Line, Column: p.Column}
+}
+
+// InFile returns a span that refers to a file as a whole.
+func InFile(filename string) Span {
+	return Span{Start: Pos{Filename: filename}, End: Pos{Filename: filename}}
+}
+
+// Note is a message about a related location, like the previous declaration
+// of a duplicate.
+type Note struct {
+	Span    Span   `json:"span"`
+	Message string `json:"message"`
+}
+
+// FixIt is an edit that fixes a diagnostic by replacing the source in Span
+// with Text. Spans that start and end at the same position insert Text.
+type FixIt struct {
+	Span Span   `json:"span"`
+	Text string `json:"text"`
+}
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Span     Span     `json:"span"`
+	Message  string   `json:"message"`
+	Notes    []Note   `json:"notes,omitempty"`
+	FixIts   []FixIt  `json:"fixits,omitempty"`
+}
+
+// Errorf returns an error diagnostic with a formatted message.
+func Errorf(span Span, code, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Error, Code: code, Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// Warningf returns a warning diagnostic with a formatted message.
+func Warningf(span Span, code, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{Severity: Warning, Code: code, Span: span, Message: fmt.Sprintf(format, args...)}
+}
+
+// Note adds a note about a related location to d and returns d.
+func (d *Diagnostic) Note(span Span, format string, args ...interface{}) *Diagnostic {
+	d.Notes = append(d.Notes, Note{Span: span, Message: fmt.Sprintf(format, args...)})
+	return d
+}
+
+// Fix adds a fix to d and returns d.
+func (d *Diagnostic) Fix(span Span, text string) *Diagnostic {
+	d.FixIts = append(d.FixIts, FixIt{Span: span, Text: text})
+	return d
+}
+
+// Error returns the diagnostic on one line, like
+// main.lang:3:5: error: x not declared.
+func (d *Diagnostic) Error() string {
+	if pos := d.Span.Start.String(); pos != "" {
+		return fmt.Sprintf("%s: %s: %s", pos, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+}
+
+// HasErrors reports whether any of the diagnostics is an error.
+func HasErrors(diags []*Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
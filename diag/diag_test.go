@@ -0,0 +1,166 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"lang/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+const source = "func main() i32 {\n\treturn y\n}\n"
+
+func span(line, col, end int) Span {
+	return Span{
+		Start: Pos{Filename: "main.lang", Line: line, Column: col},
+		End:   Pos{Filename: "main.lang", Line: line, Column: end},
+	}
+}
+
+func TestPrint(t *testing.T) {
+	d := Errorf(span(2, 9, 10), Undeclared, "y not declared").
+		Note(span(1, 6, 10), "in function main").
+		Fix(span(2, 9, 10), "0")
+
+	var b strings.Builder
+	p := NewPrinter(&b)
+	p.ReadFile = func(filename string) ([]byte, error) {
+		if filename != "main.lang" {
+			return nil, os.ErrNotExist
+		}
+		return []byte(source), nil
+	}
+	p.Print(d)
+	p.Print(Warningf(InFile("other.lang"), Foreign, "skipped x"))
+
+	want := `main.lang:2:9: error[undeclared]: y not declared
+   2 | 	return y
+     | 	       ^
+main.lang:1:6: note: in function main
+   1 | func main() i32 {
+     |      ^~~~
+main.lang:2:9: help: replace with "0"
+other.lang: warning[foreign]: skipped x
+`
+	if b.String() != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		d    *Diagnostic
+		want string
+	}{
+		{Errorf(span(3, 5, 6), Type, "bad"), "main.lang:3:5: error: bad"},
+		{Errorf(InFile("a.lang"), Import, "bad"), "a.lang: error: bad"},
+		{Warningf(Span{}, Import, "bad"), "warning: bad"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.Error(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteJSON(&b, nil); err != nil || strings.TrimSpace(b.String()) != "[]" {
+		t.Fatalf("expected [], got %q, %v", b.String(), err)
+	}
+
+	b.Reset()
+	d := Errorf(span(2, 9, 10), Undeclared, "y not declared").Note(span(1, 6, 10), "here")
+	if err := WriteJSON(&b, []*Diagnostic{d}); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0]["severity"] != "error" || got[0]["code"] != "undeclared" {
+		t.Fatalf("unexpected JSON %s", b.String())
+	}
+	if notes, ok := got[0]["notes"].([]interface{}); !ok || len(notes) != 1 {
+		t.Fatalf("expected one note in %s", b.String())
+	}
+}
+
+func TestSARIF(t *testing.T) {
+	diags := []*Diagnostic{
+		Errorf(span(2, 9, 10), Duplicate, "duplicate declaration of 'y'").Note(span(1, 5, 6), "previous declaration of 'y'"),
+		Warningf(InFile("main.lang"), Foreign, "skipped x").Fix(span(4, 1, 1), ";"),
+	}
+	var b bytes.Buffer
+	if err := WriteSARIF(&b, "lang", diags); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           *struct{ StartLine, StartColumn, EndColumn int }
+					}
+				}
+				RelatedLocations []struct{ Message struct{ Text string } }
+				Fixes            []interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || log.Runs[0].Tool.Driver.Name != "lang" {
+		t.Fatalf("unexpected log %s", b.String())
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || len(run.Results) != 2 {
+		t.Fatalf("expected 2 rules and 2 results in %s", b.String())
+	}
+
+	r := run.Results[0]
+	loc := r.Locations[0].PhysicalLocation
+	got := fmt.Sprintf("%s %s %s %+v", r.RuleID, r.Level, loc.ArtifactLocation.URI, *loc.Region)
+	if want := "duplicate error main.lang {StartLine:2 StartColumn:9 EndColumn:10}"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if len(r.RelatedLocations) != 1 || r.RelatedLocations[0].Message.Text != "previous declaration of 'y'" {
+		t.Errorf("unexpected related locations %+v", r.RelatedLocations)
+	}
+
+	r = run.Results[1]
+	if r.Level != "warning" || r.Locations[0].PhysicalLocation.Region != nil || len(r.Fixes) != 1 {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	tok := token.Token{Filename: "main.lang", Line: 1, Column: 10, Value: `"héllo"`}
+	d := Errorf(At(tok), Syntax, "bad")
+	if d.Span.End.Column != 17 {
+		t.Fatalf("expected the span to end at column 17, got %v", d.Span)
+	}
+
+	var b strings.Builder
+	p := NewPrinter(&b)
+	p.ReadFile = func(string) ([]byte, error) { return []byte(`var π = "héllo"`), nil }
+	p.Print(d)
+	want := "main.lang:1:10: error[syntax]: bad\n   1 | var π = \"héllo\"\n     |          ^~~~~~\n"
+	if b.String() != want {
+		t.Fatalf("expected\n%s\ngot\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,129 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Printer writes diagnostics for people. Each span is shown as its source
+// line with the span underlined.
+type Printer struct {
+	// ReadFile returns the contents of a source file. It defaults to
+	// os.ReadFile.
+	ReadFile func(filename string) ([]byte, error)
+
+	w     io.Writer
+	lines map[string][]string
+}
+
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{ReadFile: os.ReadFile, w: w, lines: make(map[string][]string)}
+}
+
+// Print writes d, its notes and its fixes, like
+//
+//	main.lang:3:9: error[undeclared]: y not declared
+//	   3 | 	return y
+//	     | 	       ^
+func (p *Printer) Print(d *Diagnostic) {
+	kind := d.Severity.String()
+	if d.Code != "" {
+		kind += "[" + d.Code + "]"
+	}
+	p.header(d.Span.Start, kind, d.Message)
+	p.snippet(d.Span)
+
+	for _, n := range d.Notes {
+		p.header(n.Span.Start, "note", n.Message)
+		p.snippet(n.Span)
+	}
+
+	for _, f := range d.FixIts {
+		switch {
+		case f.Span.Start == f.Span.End:
+			p.header(f.Span.Start, "help", fmt.Sprintf("insert %q", f.Text))
+		case f.Text == "":
+			p.header(f.Span.Start, "help", "remove this")
+		default:
+			p.header(f.Span.Start, "help", fmt.Sprintf("replace with %q", f.Text))
+		}
+	}
+}
+
+// PrintAll writes the diagnostics in order.
+func (p *Printer) PrintAll(diags []*Diagnostic) {
+	for _, d := range diags {
+		p.Print(d)
+	}
+}
+
+func (p *Printer) header(pos Pos, kind, msg string) {
+	if s := pos.String(); s != "" {
+		fmt.Fprintf(p.w, "%s: %s: %s\n", s, kind, msg)
+		return
+	}
+	fmt.Fprintf(p.w, "%s: %s\n", kind, msg)
+}
+
+// snippet writes the line of the start of span and underlines the span up to
+// the end of the line.
+func (p *Printer) snippet(span Span) {
+	text, ok := p.line(span.Start)
+	if !ok {
+		return
+	}
+	line := []rune(text)
+	col := span.Start.Column - 1
+	if col > len(line) {
+		col = len(line)
+	}
+
+	width := 1
+	if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		width = span.End.Column - span.Start.Column
+	}
+	if span.End.Line > span.Start.Line {
+		width = len(line) - col
+	}
+	if col+width > len(line) {
+		width = len(line) - col
+	}
+	if width < 1 {
+		width = 1
+	}
+
+	// Tabs before the span are kept, so that the underline lines up.
+	indent := make([]rune, col)
+	for i, r := range line[:col] {
+		indent[i] = ' '
+		if r == '\t' {
+			indent[i] = '\t'
+		}
+	}
+
+	gutter := fmt.Sprintf("%4d | ", span.Start.Line)
+	fmt.Fprintf(p.w, "%s%s\n", gutter, text)
+	fmt.Fprintf(p.w, "%s| %s^%s\n", strings.Repeat(" ", len(gutter)-2), string(indent), strings.Repeat("~", width-1))
+}
+
+// line returns the source line at pos.
+func (p *Printer) line(pos Pos) (string, bool) {
+	if !pos.IsValid() || pos.Filename == "" {
+		return "", false
+	}
+
+	lines, ok := p.lines[pos.Filename]
+	if !ok {
+		b, err := p.ReadFile(pos.Filename)
+		if err == nil {
+			lines = strings.Split(strings.TrimPrefix(string(b), "\uFEFF"), "\n")
+		}
+		p.lines[pos.Filename] = lines
+	}
+	if pos.Line > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[pos.Line-1], "\r"), true
+}
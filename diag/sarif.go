@@ -0,0 +1,163 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+)
+
+// WriteJSON writes the diagnostics as a JSON array.
+func WriteJSON(w io.Writer, diags []*Diagnostic) error {
+	if diags == nil {
+		diags = make([]*Diagnostic, 0)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
+
+// WriteSARIF writes the diagnostics as a SARIF 2.1.0 log of one run of the
+// named tool, which CI systems show as annotations.
+func WriteSARIF(w io.Writer, tool string, diags []*Diagnostic) error {
+	run := sarifRun{Results: make([]sarifResult, 0)}
+	run.Tool.Driver.Name = tool
+
+	seen := make(map[string]bool)
+	for _, d := range diags {
+		if d.Code != "" && !seen[d.Code] {
+			seen[d.Code] = true
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Code})
+		}
+
+		r := sarifResult{
+			RuleID:    d.Code,
+			Level:     sarifLevel(d.Severity),
+			Message:   sarifMessage{Text: d.Message},
+			Locations: []sarifLocation{sarifLocate(d.Span)},
+		}
+		for _, n := range d.Notes {
+			loc := sarifLocate(n.Span)
+			loc.Message = &sarifMessage{Text: n.Message}
+			r.RelatedLocations = append(r.RelatedLocations, loc)
+		}
+		for _, f := range d.FixIts {
+			r.Fixes = append(r.Fixes, sarifFix{ArtifactChanges: []sarifChange{{
+				ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(f.Span.Start.Filename)},
+				Replacements: []sarifReplacement{{
+					DeletedRegion:   sarifRegionOf(f.Span),
+					InsertedContent: &sarifMessage{Text: f.Text},
+				}},
+			}}})
+		}
+		run.Results = append(run.Results, r)
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name  string      `json:"name"`
+			Rules []sarifRule `json:"rules,omitempty"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId,omitempty"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysical `json:"physicalLocation"`
+	Message          *sarifMessage `json:"message,omitempty"`
+}
+
+type sarifPhysical struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           *sarifRegion  `json:"region,omitempty"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifFix struct {
+	ArtifactChanges []sarifChange `json:"artifactChanges"`
+}
+
+type sarifChange struct {
+	ArtifactLocation sarifArtifact      `json:"artifactLocation"`
+	Replacements     []sarifReplacement `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   *sarifRegion  `json:"deletedRegion"`
+	InsertedContent *sarifMessage `json:"insertedContent,omitempty"`
+}
+
+func sarifLevel(s Severity) string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+func sarifLocate(span Span) sarifLocation {
+	return sarifLocation{PhysicalLocation: sarifPhysical{
+		ArtifactLocation: sarifArtifact{URI: filepath.ToSlash(span.Start.Filename)},
+		Region:           sarifRegionOf(span),
+	}}
+}
+
+// sarifRegionOf returns the region of span, or nil if its position is
+// unknown.
+func sarifRegionOf(span Span) *sarifRegion {
+	if !span.Start.IsValid() {
+		return nil
+	}
+	r := &sarifRegion{StartLine: span.Start.Line, StartColumn: span.Start.Column}
+	if span.End.IsValid() {
+		r.EndLine = span.End.Line
+		r.EndColumn = span.End.Column
+	}
+	return r
+}
@@ -0,0 +1,607 @@
+// Package format writes programs in the canonical style of the language.
+// Declarations, statements and the members of types are written on lines of
+// their own and indented with tabs, binary operators are surrounded by spaces,
+// commas are followed by one, and the values of enum members are aligned.
+// Comments are kept next to the code they are written next to, and blank
+// lines between statements are kept, but never more than one.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"lang/ast"
+	"lang/diag"
+	"lang/lexer"
+	"lang/parser"
+	"lang/token"
+	"lang/types"
+	"strings"
+	"unicode/utf8"
+)
+
+// Source formats the source of the file filename. Files with syntax errors
+// are not formatted, and the errors are returned instead.
+func Source(filename string, src []byte) ([]byte, []*diag.Diagnostic) {
+	file := token.NewFileSet().AddFile(filename, src)
+	l := lexer.NewFile(file)
+	l.ScanComments = true
+
+	p := parser.New(l)
+	prog, ok := p.ParseProgram()
+	if !ok {
+		return nil, p.Errors
+	}
+
+	var b bytes.Buffer
+	Fprint(&b, file, prog)
+	return b.Bytes(), nil
+}
+
+// Fprint writes prog, which is parsed from file with its comments, to w.
+func Fprint(w io.Writer, file *token.File, prog *ast.Program) error {
+	p := &printer{file: file, comments: prog.Comments}
+	p.program(prog)
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+type printer struct {
+	file     *token.File
+	buf      bytes.Buffer
+	indent   int
+	comments []*ast.Comment
+
+	// bol is set at the beginning of a line, whose indentation is written
+	// with its first text, and last is the source line that the last
+	// element of the current block ends on, or 0 before its first element.
+	bol  bool
+	last int
+}
+
+func (p *printer) program(prog *ast.Program) {
+	if prog.Package != "" {
+		p.leading(prog.PackagePos)
+		p.write("package ", prog.Package)
+		p.trailing(prog.PackagePos)
+	}
+
+	nodes := make([]ast.Node, 0, len(prog.Imports)+len(prog.Statements))
+	for _, imp := range prog.Imports {
+		nodes = append(nodes, imp)
+	}
+	for _, stmt := range prog.Statements {
+		nodes = append(nodes, stmt)
+	}
+	p.elements(nodes, p.eof(), func(n ast.Node) {
+		if imp, ok := n.(*ast.Import); ok {
+			p.importSpec(imp)
+		} else {
+			p.stmt(n.(ast.Statement))
+		}
+	})
+}
+
+// eof returns the position of the end of the file.
+func (p *printer) eof() token.Pos {
+	return p.file.Pos(p.file.Size())
+}
+
+func (p *printer) importSpec(imp *ast.Import) {
+	p.write("import ")
+	if imp.Name != strings.TrimSuffix(imp.Path[strings.LastIndex(imp.Path, "/")+1:], ".h") {
+		p.write(imp.Name, " ")
+	}
+	p.write(imp.Token.Value)
+}
+
+// elements writes each of nodes on a line of its own with print. The comments
+// before a node are written on the lines before it, or before it on its line
+// if they end on that line, and comments on its last line after it. The
+// comments before end, the closing brace of the block or the end of the file,
+// are written after the nodes.
+func (p *printer) elements(nodes []ast.Node, end token.Pos, print func(ast.Node)) {
+	for _, n := range nodes {
+		p.leading(n.Pos())
+		p.blankLine(n.Pos())
+		print(n)
+		p.trailing(n.End())
+	}
+	p.leading(end)
+}
+
+// leading writes the comments before pos.
+func (p *printer) leading(pos token.Pos) {
+	for p.hasComments(pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.blankLine(c.Pos())
+		p.write(comment(c))
+		if !isLineComment(c) && p.line(c.End()) == p.line(pos) && pos != p.eof() {
+			p.write(" ")
+			continue
+		}
+		p.newline()
+		p.last = p.line(c.End())
+	}
+}
+
+// trailing writes the comments before end and on the line of end, which ends
+// the line of the node before it.
+func (p *printer) trailing(end token.Pos) {
+	line, last := p.line(end), p.line(end)
+	for len(p.comments) > 0 && (p.comments[0].Pos() < end || p.line(p.comments[0].Pos()) == line) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.write(" ", comment(c))
+		if l := p.line(c.End()); l > last {
+			last = l
+		}
+	}
+	p.newline()
+	p.last = last
+}
+
+// blankLine writes a blank line if the source has one before pos, except
+// before the first element of a block.
+func (p *printer) blankLine(pos token.Pos) {
+	if p.bol && p.last > 0 && p.line(pos) > p.last+1 {
+		p.newline()
+	}
+}
+
+func (p *printer) line(pos token.Pos) int {
+	return p.file.Position(pos).Line
+}
+
+func comment(c *ast.Comment) string {
+	if isLineComment(c) {
+		return strings.TrimRight(c.Token.Value, " \t\r")
+	}
+	return c.Token.Value
+}
+
+func isLineComment(c *ast.Comment) bool {
+	return strings.HasPrefix(c.Token.Value, "//")
+}
+
+// block writes a block of statements that ends with the brace at rbrace and
+// starts with a brace after open, which is followed by the comments on its
+// line.
+func (p *printer) block(body []ast.Statement, open, rbrace token.Pos) {
+	if len(body) == 0 && !p.hasComments(rbrace) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.trailing(open)
+	p.indent++
+	p.last = 0
+	nodes := make([]ast.Node, 0, len(body))
+	for _, stmt := range body {
+		nodes = append(nodes, stmt)
+	}
+	p.elements(nodes, rbrace, func(n ast.Node) { p.stmt(n.(ast.Statement)) })
+	p.indent--
+	p.write("}")
+}
+
+// hasComments reports whether there are comments left before pos.
+func (p *printer) hasComments(pos token.Pos) bool {
+	return len(p.comments) > 0 && p.comments[0].Pos() < pos
+}
+
+func (p *printer) stmt(stmt ast.Statement) {
+	switch s := stmt.(type) {
+	case *ast.FuncDecl:
+		p.funcDecl(s)
+	case *ast.TypeDecl:
+		p.typeDecl(s)
+	case *ast.VarDecl:
+		p.write("var ", s.Token.Value, " ", p.typ(s.Type), " = ")
+		p.expr(s.Value)
+	case *ast.Assign:
+		p.expr(s.X)
+		p.write(" = ")
+		p.expr(s.Value)
+	case *ast.Return:
+		p.write("return")
+		if s.HasValue {
+			p.write(" ")
+			p.expr(s.Value)
+		}
+	case *ast.Defer:
+		p.write("defer ")
+		p.expr(s.Call)
+	case *ast.Switch:
+		p.switchStmt(s)
+	case ast.Expression:
+		p.expr(s)
+	default:
+		panic(fmt.Sprintf("cannot format %T", stmt))
+	}
+}
+
+func (p *printer) funcDecl(fd *ast.FuncDecl) {
+	if fd.Export {
+		p.write("export ")
+	}
+	p.write("func ")
+	if fd.Receiver != nil {
+		p.write("(", fd.Receiver.Token.Value, " ", p.typ(fd.Receiver.Type), ") ")
+	}
+	p.signature(fd)
+	if !fd.Extern {
+		p.write(" ")
+		p.block(fd.Body, signatureEnd(fd), fd.Rbrace)
+	}
+}
+
+// signatureEnd returns the end of the parameters and result of fd.
+func signatureEnd(fd *ast.FuncDecl) token.Pos {
+	if fd.HasReturn {
+		return fd.ReturnType.End()
+	}
+	return fd.Rparen + 1
+}
+
+// signature writes the name, type parameters, parameters and result of fd.
+func (p *printer) signature(fd *ast.FuncDecl) {
+	p.write(fd.Token.Value)
+	if len(fd.TypeParams) > 0 {
+		params := make([]string, 0, len(fd.TypeParams))
+		for _, tp := range fd.TypeParams {
+			if tp.Constraint != nil {
+				params = append(params, tp.Token.Value+" "+p.typ(tp.Constraint))
+			} else {
+				params = append(params, tp.Token.Value)
+			}
+		}
+		p.write("[", strings.Join(params, ", "), "]")
+	}
+	p.params(fd)
+}
+
+// params writes the parameters and the result of fd.
+func (p *printer) params(fd *ast.FuncDecl) {
+	params := make([]string, 0, len(fd.Params)+1)
+	for _, vd := range fd.Params {
+		params = append(params, vd.Token.Value+" "+p.typ(vd.Type))
+	}
+	if fd.VarArgs {
+		params = append(params, "...")
+	}
+	p.write("(", strings.Join(params, ", "), ")")
+	if fd.HasReturn {
+		p.write(" ", p.typ(fd.ReturnType))
+	}
+}
+
+func (p *printer) typeDecl(td *ast.TypeDecl) {
+	switch td.Type.Type.(type) {
+	case *types.Enum:
+		p.write("enum ", td.Token.Value, " ")
+		if backing := p.enumBacking(td); backing != "" {
+			p.write(backing, " ")
+		}
+		nodes := make([]ast.Node, 0, len(td.Members))
+		for _, em := range td.Members {
+			nodes = append(nodes, em)
+		}
+		widths := p.alignment(td.Members)
+		p.members(nodes, td.Token.End(), td.Rbrace, func(n ast.Node) {
+			em := n.(*ast.EnumMember)
+			p.write(em.Token.Value)
+			if em.Value != nil {
+				pad := widths[em] - utf8.RuneCountInString(em.Token.Value)
+				p.write(strings.Repeat(" ", pad), " = ", em.Value.Token.Value)
+			}
+		})
+	case *types.Union:
+		p.write("union ", td.Token.Value, " ")
+		nodes := make([]ast.Node, 0, len(td.Variants))
+		for _, v := range td.Variants {
+			nodes = append(nodes, v)
+		}
+		p.members(nodes, td.Token.End(), td.Rbrace, func(n ast.Node) {
+			v := n.(*ast.Variant)
+			p.write(v.Token.Value)
+			if v.Rparen.IsValid() {
+				fields := make([]string, 0, len(v.Fields))
+				for _, f := range v.Fields {
+					fields = append(fields, p.typ(f))
+				}
+				p.write("(", strings.Join(fields, ", "), ")")
+			}
+		})
+	case *types.Interface:
+		p.write("type ", td.Token.Value, " interface ")
+		nodes := make([]ast.Node, 0, len(td.Methods))
+		for _, fd := range td.Methods {
+			nodes = append(nodes, fd)
+		}
+		p.members(nodes, td.Token.End(), td.Rbrace, func(n ast.Node) { p.signature(n.(*ast.FuncDecl)) })
+	default:
+		p.write("type ", td.Token.Value, " ", p.typ(td.Type))
+	}
+}
+
+// members writes the members of an enum, a union or an interface between
+// braces like block does.
+func (p *printer) members(nodes []ast.Node, open, rbrace token.Pos, print func(ast.Node)) {
+	if len(nodes) == 0 && !p.hasComments(rbrace) {
+		p.write("{}")
+		return
+	}
+
+	p.write("{")
+	p.trailing(open)
+	p.indent++
+	p.last = 0
+	p.elements(nodes, rbrace, print)
+	p.indent--
+	p.write("}")
+}
+
+// alignment returns the width that the names of enum members with values are
+// padded to, so that the values of consecutive members line up. Blank lines
+// and members without values end a run of aligned members.
+func (p *printer) alignment(members []*ast.EnumMember) map[*ast.EnumMember]int {
+	widths := make(map[*ast.EnumMember]int)
+	run := make([]*ast.EnumMember, 0)
+	flush := func() {
+		width := 0
+		for _, em := range run {
+			if n := utf8.RuneCountInString(em.Token.Value); n > width {
+				width = n
+			}
+		}
+		for _, em := range run {
+			widths[em] = width
+		}
+		run = run[:0]
+	}
+
+	for i, em := range members {
+		if em.Value == nil || i > 0 && p.line(em.Pos()) > p.line(members[i-1].End())+1 {
+			flush()
+		}
+		if em.Value != nil {
+			run = append(run, em)
+		}
+	}
+	flush()
+	return widths
+}
+
+// enumBacking returns the source of the backing type of an enum, which is
+// between its name and its opening brace, or "" if it has none.
+func (p *printer) enumBacking(td *ast.TypeDecl) string {
+	from := td.Token.End()
+	to := bytes.IndexByte(p.file.Source()[p.file.Offset(from):], '{')
+	if to < 0 {
+		return ""
+	}
+	return p.source(from, from+token.Pos(to))
+}
+
+func (p *printer) switchStmt(s *ast.Switch) {
+	p.write("switch ")
+	p.expr(s.Value)
+	p.write(" {")
+	p.trailing(s.Value.End())
+	p.last = 0
+
+	// Cases are indented like the switch, and their bodies end with the
+	// comments before the next case.
+	for i, c := range s.Cases {
+		p.leading(c.Pos())
+		p.blankLine(c.Pos())
+		if c.Default {
+			p.write("default:")
+		} else {
+			p.write("case ")
+			p.exprList(c.Values)
+			p.write(":")
+		}
+		p.trailing(c.Colon + 1)
+
+		end := s.Rbrace
+		if i+1 < len(s.Cases) {
+			end = s.Cases[i+1].Pos()
+		}
+		end = p.outdented(end, c.Pos())
+		body := make([]ast.Node, 0, len(c.Body))
+		for _, stmt := range c.Body {
+			body = append(body, stmt)
+		}
+		p.indent++
+		p.elements(body, end, func(n ast.Node) { p.stmt(n.(ast.Statement)) })
+		p.indent--
+	}
+	p.leading(s.Rbrace)
+	p.write("}")
+}
+
+// outdented returns the position of the first comment before end that is
+// not indented more than the node at pos, or end if there is none. Such
+// comments belong to the node after end rather than to the body before it.
+func (p *printer) outdented(end, pos token.Pos) token.Pos {
+	column := p.file.Position(pos).Column
+	for _, c := range p.comments {
+		if c.Pos() >= end {
+			break
+		}
+		if p.file.Position(c.Pos()).Column <= column {
+			return c.Pos()
+		}
+	}
+	return end
+}
+
+func (p *printer) exprList(list []ast.Expression) {
+	for i, e := range list {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(e)
+	}
+}
+
+func (p *printer) expr(e ast.Expression) {
+	switch x := e.(type) {
+	case *ast.IntLiteral:
+		p.write(x.Token.Value)
+	case *ast.StringLiteral:
+		p.write(x.Token.Value)
+	case *ast.None:
+		p.write("none")
+	case *ast.Var:
+		p.qualified(x.Package, x.Token.Value)
+	case *ast.FuncCall:
+		p.qualified(x.Package, x.Token.Value)
+		p.typeArgs(x.TypeArgs)
+		p.args(x.Args)
+	case *ast.MethodCall:
+		p.expr(x.Receiver)
+		p.write(".", x.Token.Value)
+		p.args(x.Args)
+	case *ast.Index:
+		p.expr(x.X)
+		p.write("[")
+		p.expr(x.Index)
+		p.write("]")
+	case *ast.Selector:
+		if x.X != nil {
+			p.expr(x.X)
+			p.write(".")
+		}
+		p.write(x.Token.Value)
+	case *ast.Pattern:
+		if x.X != nil {
+			p.expr(x.X)
+			p.write(".")
+		}
+		names := make([]string, 0, len(x.Bindings))
+		for _, vd := range x.Bindings {
+			names = append(names, vd.Token.Value)
+		}
+		p.write(x.Token.Value, "(", strings.Join(names, ", "), ")")
+	case *ast.InfixExpression:
+		p.expr(x.Left)
+		p.write(" ", x.Token.Value, " ")
+		p.expr(x.Right)
+	case *ast.Try:
+		p.write("try ")
+		p.expr(x.X)
+	case *ast.Err:
+		p.write("err(")
+		p.expr(x.X)
+		p.write(")")
+	case *ast.FuncLit:
+		p.write("func")
+		p.params(x.Decl)
+		p.write(" ")
+		p.block(x.Decl.Body, signatureEnd(x.Decl), x.Decl.Rbrace)
+	case *ast.EmptyExpression:
+	default:
+		panic(fmt.Sprintf("cannot format %T", e))
+	}
+}
+
+func (p *printer) qualified(pkg, name string) {
+	if pkg != "" {
+		p.write(pkg, ".")
+	}
+	p.write(name)
+}
+
+func (p *printer) typeArgs(list []*ast.Type) {
+	if len(list) == 0 {
+		return
+	}
+	args := make([]string, 0, len(list))
+	for _, t := range list {
+		args = append(args, p.typ(t))
+	}
+	p.write("[", strings.Join(args, ", "), "]")
+}
+
+func (p *printer) args(list []ast.Expression) {
+	p.write("(")
+	p.exprList(list)
+	p.write(")")
+}
+
+// typ returns the source of t in canonical form. Types that are not in the
+// source are written like the checker writes them.
+func (p *printer) typ(t *ast.Type) string {
+	if !t.From.IsValid() || !t.To.IsValid() {
+		return types.String(t.Type)
+	}
+	return p.source(t.From, t.To)
+}
+
+// source returns the source from the position from up to to in canonical
+// form. The comments in it are written with it.
+func (p *printer) source(from, to token.Pos) string {
+	comments := p.comments[:0:0]
+	for _, c := range p.comments {
+		if c.Pos() < from || c.Pos() >= to {
+			comments = append(comments, c)
+		}
+	}
+	p.comments = comments
+	return canonical(string(p.file.Source()[p.file.Offset(from):p.file.Offset(to)]))
+}
+
+// canonical returns the tokens of the type src with a space after commas, after
+// the parameters of function types and around comments, and no other spaces.
+func canonical(src string) string {
+	var b strings.Builder
+	l := lexer.New(src)
+	l.ScanComments = true
+	prev := token.Token{}
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.SEMICOLON && tok.Value != ";" {
+			continue
+		}
+		if b.Len() > 0 && spaceBetween(prev.Type, tok.Type) {
+			b.WriteString(" ")
+		}
+		b.WriteString(tok.Value)
+		prev = tok
+	}
+	return b.String()
+}
+
+func spaceBetween(prev, next token.TokenType) bool {
+	switch {
+	case next == token.COMMA, next == token.RPAREN, next == token.RBRACKET:
+		return false
+	case prev == token.COMMA, prev == token.COMMENT, next == token.COMMENT:
+		return true
+	default:
+		return prev == token.RPAREN && next != token.BANG
+	}
+}
+
+func (p *printer) write(ss ...string) {
+	for _, s := range ss {
+		if s == "" {
+			continue
+		}
+		if p.bol {
+			p.buf.WriteString(strings.Repeat("\t", p.indent))
+			p.bol = false
+		}
+		p.buf.WriteString(s)
+	}
+}
+
+func (p *printer) newline() {
+	p.buf.WriteByte('\n')
+	p.bol = true
+}
@@ -0,0 +1,128 @@
+package format
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{
+			"package geom\nimport   \"lib/io\"\nimport g \"lib/geom\"\n",
+			"package geom\nimport \"lib/io\"\nimport g \"lib/geom\"\n",
+		},
+		{
+			"func f(a i32,b ^u8,...) i32 { var x i32=a+1*2; x=g(a,b) ; return x }\n",
+			"func f(a i32, b ^u8, ...) i32 {\n\tvar x i32 = a + 1 * 2\n\tx = g(a, b)\n\treturn x\n}\n",
+		},
+		{
+			"func f() {\n}\nfunc puts(s ^u8) i32\n",
+			"func f() {}\nfunc puts(s ^u8) i32\n",
+		},
+		{
+			"func f() {\n\n\n\tg()\n\n\n\th()\n\n}\n",
+			"func f() {\n\tg()\n\n\th()\n}\n",
+		},
+		{
+			"enum Color u8 { Red; Green=4\n  LongName = 5\n\n  Blue=6 }\n",
+			"enum Color u8 {\n\tRed\n\tGreen    = 4\n\tLongName = 5\n\n\tBlue = 6\n}\n",
+		},
+		{
+			"union Shape { Rect(i32,i32), Circle(i32), Empty }\n",
+			"union Shape {\n\tRect(i32, i32)\n\tCircle(i32)\n\tEmpty\n}\n",
+		},
+		{
+			"type Sizer interface { Size() i32; Pick[T](xs ...T) ?T }\ntype F func(i32,func() i32)E!^i32\n",
+			"type Sizer interface {\n\tSize() i32\n\tPick[T](xs ...T) ?T\n}\ntype F func(i32, func() i32) E!^i32\n",
+		},
+		{
+			"func (m ^M) Len[T Ordered,U](a T) i32 { switch a { case S.R(w,h): return w\n case 1,2:\n default: return try f[i32](err(x)) } }\n",
+			"func (m ^M) Len[T Ordered, U](a T) i32 {\n\tswitch a {\n\tcase S.R(w, h):\n\t\treturn w\n\tcase 1, 2:\n\tdefault:\n\t\treturn try f[i32](err(x))\n\t}\n}\n",
+		},
+		{
+			"func f() { var g func() i32 = func() i32 { return 1 }; defer g() }\n",
+			"func f() {\n\tvar g func() i32 = func() i32 {\n\t\treturn 1\n\t}\n\tdefer g()\n}\n",
+		},
+	}
+	for _, tt := range tests {
+		got, errs := Source("main.lang", []byte(tt.input))
+		if errs != nil {
+			t.Fatalf("%q: %v", tt.input, errs)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%q:\ngot\n%s\nwant\n%s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// Package main is a test.
+package main // main
+
+
+/* f does
+   nothing */
+func f(x i32) i32 { // f
+	// leading
+
+
+	var y i32 = g(x, // x
+		1) /* one */
+	switch y {
+	case 1:
+		return 1
+	// before default
+	default: // default
+		// return
+	}
+	/* a */ return y
+	// last
+}
+
+// end
+`
+	want := `// Package main is a test.
+package main // main
+
+/* f does
+   nothing */
+func f(x i32) i32 { // f
+	// leading
+
+	var y i32 = g(x, 1) // x /* one */
+	switch y {
+	case 1:
+		return 1
+	// before default
+	default: // default
+		// return
+	}
+	/* a */ return y
+	// last
+}
+
+// end
+`
+	got, errs := Source("main.lang", []byte(input))
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if string(got) != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	again, _ := Source("main.lang", got)
+	if string(again) != string(got) {
+		t.Fatalf("formatting again changed the source to\n%s", again)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	got, errs := Source("main.lang", []byte("func f() {\n\treturn )\n}\n"))
+	if got != nil || len(errs) == 0 || !strings.HasPrefix(errs[0].Error(), "main.lang:2:9: error:") {
+		t.Fatalf("got %q, %v", got, errs)
+	}
+}
@@ -0,0 +1,263 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Externs holds the extern functions of the C library that interpreters
+// implement by default, by name.
+var Externs = map[string]Extern{
+	"puts":    puts,
+	"putchar": putchar,
+	"printf":  printf,
+	"rand":    randInt,
+	"srand":   srand,
+	"malloc":  malloc,
+	"calloc":  calloc,
+	"free":    free,
+	"exit":    exit,
+	"strlen":  strlen,
+	"abs":     abs,
+}
+
+// argCount returns an error if the call of an extern function has fewer than
+// n arguments, like when it is declared with different parameters.
+func argCount(args []Value, n int) error {
+	if len(args) < n {
+		return fmt.Errorf("got %d arguments, want %d", len(args), n)
+	}
+	return nil
+}
+
+func intArg(args []Value, i int) (Int, error) {
+	v, ok := args[i].(Int)
+	if !ok {
+		return 0, fmt.Errorf("argument %d is %T, want an integer", i+1, args[i])
+	}
+	return v, nil
+}
+
+func pointerArg(args []Value, i int) (Pointer, error) {
+	v, ok := args[i].(Pointer)
+	if !ok {
+		return Pointer{}, fmt.Errorf("argument %d is %T, want a pointer", i+1, args[i])
+	}
+	return v, nil
+}
+
+func stringArg(args []Value, i int) (string, error) {
+	p, err := pointerArg(args, i)
+	if err != nil {
+		return "", err
+	}
+	return p.GoString()
+}
+
+func puts(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintln(in.Stdout, s); err != nil {
+		return Int(-1), nil
+	}
+	return Int(0), nil
+}
+
+func putchar(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	c, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := in.Stdout.Write([]byte{byte(c)}); err != nil {
+		return Int(-1), nil
+	}
+	return Int(uint8(c)), nil
+}
+
+// printf supports the conversions %d, %i, %u, %x, %c, %s, %p and %%, with
+// the flags -, 0 and a width.
+func printf(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	format, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	next := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		spec := "%"
+		for i++; i < len(format) && strings.IndexByte("-0123456789", format[i]) >= 0; i++ {
+			spec += string(format[i])
+		}
+		if i == len(format) {
+			return nil, errors.New("format ends with %")
+		}
+		verb := format[i]
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if next == len(args) {
+			return nil, fmt.Errorf("missing argument for %%%c", verb)
+		}
+
+		switch verb {
+		case 'd', 'i':
+			v, err := intArg(args, next)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"d", int32(v))
+		case 'u', 'x':
+			v, err := intArg(args, next)
+			if err != nil {
+				return nil, err
+			}
+			if verb == 'u' {
+				verb = 'd'
+			}
+			fmt.Fprintf(&b, spec+string(verb), uint32(v))
+		case 'c':
+			v, err := intArg(args, next)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"c", rune(uint8(v)))
+		case 's':
+			s, err := stringArg(args, next)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"s", s)
+		case 'p':
+			p, err := pointerArg(args, next)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"s", p.String())
+		default:
+			return nil, fmt.Errorf("unsupported conversion %%%c", verb)
+		}
+		next++
+	}
+
+	n, err := in.Stdout.Write([]byte(b.String()))
+	if err != nil {
+		return Int(-1), nil
+	}
+	return Int(n), nil
+}
+
+func randInt(in *Interp, args []Value) (Value, error) {
+	return Int(in.Rand.Int31()), nil
+}
+
+func srand(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	seed, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	in.Rand.Seed(int64(uint32(seed)))
+	return nil, nil
+}
+
+// malloc allocates one cell for each byte asked for, so that pointers to
+// bytes can walk the memory. Cells can hold values of any type.
+func malloc(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	n, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return Pointer{}, nil
+	}
+	return Alloc(int(n)), nil
+}
+
+func calloc(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 2); err != nil {
+		return nil, err
+	}
+	n, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	size, err := intArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || size < 0 {
+		return Pointer{}, nil
+	}
+	return Alloc(int(n * size)), nil
+}
+
+func free(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	p, err := pointerArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return nil, p.Free()
+}
+
+func exit(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	status, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return nil, Exit(status)
+}
+
+func strlen(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return Int(len(s)), nil
+}
+
+func abs(in *Interp, args []Value) (Value, error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	v, err := intArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	if v < 0 {
+		v = -v
+	}
+	return v, nil
+}
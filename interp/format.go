@@ -0,0 +1,100 @@
+package interp
+
+import (
+	"lang/token"
+	"lang/types"
+	"strconv"
+	"strings"
+)
+
+// Format returns a value of the type t the way a program would write it
+// where it can: enum members and union variants by name, optionals as none
+// or some(x) and results as ok(x) or err(e) like in cases. Pointers are
+// shown by address, followed by the string they point to for ^u8.
+func Format(v Value, t types.Type) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case Int:
+		if e, ok := asEnum(t); ok {
+			for _, m := range e.Members {
+				if Int(m.Value) == v {
+					return e.Name() + "." + m.Name
+				}
+			}
+		}
+		return strconv.FormatInt(int64(v), 10)
+	case Pointer:
+		s := v.String()
+		if p, ok := t.(*types.Pointer); ok && types.IntBits(p.To) == 8 && !v.IsNil() {
+			if str, err := v.GoString(); err == nil {
+				s += " " + strconv.Quote(str)
+			}
+		}
+		return s
+	case Slice:
+		var elem types.Type = types.TypeNil
+		if s, ok := t.(*types.Slice); ok {
+			elem = s.Elem
+		}
+		elems := make([]string, 0, v.Len)
+		for i := 0; i < v.Len; i++ {
+			x, err := v.Data.Add(i).Load()
+			if err != nil {
+				elems = append(elems, "?")
+				continue
+			}
+			elems = append(elems, Format(x, elem))
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case Union:
+		return formatUnion(v, t)
+	case *Closure:
+		if v.Decl.Token.Type == token.FUNC {
+			return "func literal"
+		}
+		return "func " + v.Decl.Token.Value
+	case Iface:
+		if _, ok := v.Type.(*types.Pointer); ok {
+			return Format(v.Data, v.Type)
+		}
+		x, err := v.Data.Load()
+		if err != nil {
+			return "?"
+		}
+		return Format(x, v.Type)
+	}
+	return "?"
+}
+
+func formatUnion(v Union, t types.Type) string {
+	u, ok := types.AsUnion(t)
+	if !ok || v.Tag >= len(u.Variants) {
+		return "?"
+	}
+	variant := u.Variants[v.Tag]
+
+	name := variant.Name
+	switch t.(type) {
+	case *types.Optional, *types.Result:
+	default:
+		name = t.Name() + "." + name
+	}
+	if len(variant.Fields) == 0 {
+		return name
+	}
+
+	fields := make([]string, 0, len(v.Fields))
+	for i, f := range v.Fields {
+		fields = append(fields, Format(f, variant.Fields[i]))
+	}
+	return name + "(" + strings.Join(fields, ", ") + ")"
+}
+
+func asEnum(t types.Type) (*types.Enum, bool) {
+	if n, ok := t.(*types.Named); ok {
+		t = n.Underlying
+	}
+	e, ok := t.(*types.Enum)
+	return e, ok
+}
@@ -0,0 +1,729 @@
+// Package interp runs checked programs by walking their syntax trees, without
+// generating code. Extern functions are implemented by Go functions, which
+// are looked up by name in a table.
+//
+// Values of pointer types point into a simulated memory, which is made of
+// one block of cells for each variable, string literal and allocation.
+// Invalid uses of memory, division by zero and indexes out of range stop the
+// program with an *Error instead of crashing it.
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"lang/ast"
+	"lang/diag"
+	"lang/token"
+	"lang/types"
+	"math/rand"
+	"os"
+)
+
+// Interp runs the packages of a program.
+type Interp struct {
+	// Stdout is where extern functions like puts write to.
+	Stdout io.Writer
+
+	// Externs holds the functions that implement extern functions by
+	// name. New copies the package's Externs into it.
+	Externs map[string]Extern
+
+	// Rand is the source of the numbers of rand, which is seeded with 1
+	// like in C.
+	Rand *rand.Rand
+
+	// MaxDepth is the depth of calls beyond which a program stops with a
+	// stack overflow.
+	MaxDepth int
+
+	progs   []*ast.Program
+	globals map[*ast.VarDecl]Pointer
+	methods map[string]*ast.FuncDecl
+	strings map[*ast.StringLiteral]Pointer
+	depth   int
+}
+
+// Extern is a Go function that implements an extern function. It receives
+// the arguments of the call converted to the parameter types, and can read
+// and write memory through pointers and call closures with Call.
+type Extern func(in *Interp, args []Value) (Value, error)
+
+// Error is an error of a running program, like a division by zero, at the
+// position of the expression that caused it.
+type Error struct {
+	Pos diag.Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Msg
+	}
+	return e.Msg
+}
+
+// Exit is the error of extern functions that exit the program with a status,
+// like exit.
+type Exit int
+
+func (e Exit) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// New returns an interpreter for the packages of a program, which must be
+// checked and ordered like the loader orders them. The main package is the
+// last one.
+func New(progs ...*ast.Program) *Interp {
+	in := &Interp{
+		Stdout:   os.Stdout,
+		Externs:  make(map[string]Extern),
+		Rand:     rand.New(rand.NewSource(1)),
+		MaxDepth: 10000,
+		progs:    progs,
+		globals:  make(map[*ast.VarDecl]Pointer),
+		methods:  make(map[string]*ast.FuncDecl),
+		strings:  make(map[*ast.StringLiteral]Pointer),
+	}
+	for name, ext := range Externs {
+		in.Externs[name] = ext
+	}
+	for _, prog := range progs {
+		in.Declare(prog.Statements...)
+	}
+	return in
+}
+
+// Declare adds the global variables and the methods among stmts, which are
+// checked declarations of a package, to the program.
+func (in *Interp) Declare(stmts ...ast.Statement) {
+	for _, s := range stmts {
+		switch v := s.(type) {
+		case *ast.VarDecl:
+			lit := v.Value.(*ast.IntLiteral)
+			p := Alloc(1)
+			p.Store(normalize(Int(lit.Value), v.Type.Type))
+			in.globals[v] = p
+		case *ast.FuncDecl:
+			if v.Receiver != nil {
+				in.methods[methodName(v.Receiver.Type.Type, v.Token.Value)] = v
+			}
+		}
+	}
+}
+
+// Run calls the main function of the main package and returns its exit
+// status, which is the value main returns or 0 if it returns nothing. If
+// main takes parameters, it is passed args like argc and argv in C, where
+// the first argument is the name of the program.
+func (in *Interp) Run(args []string) (int, error) {
+	var main *ast.FuncDecl
+	for _, s := range in.progs[len(in.progs)-1].Statements {
+		if fd, ok := s.(*ast.FuncDecl); ok && fd.Receiver == nil && fd.Token.Value == "main" {
+			main = fd
+		}
+	}
+	if main == nil {
+		return 0, errors.New("function main is undeclared in the main package")
+	}
+
+	params := make([]Value, 0)
+	switch len(main.Params) {
+	case 0:
+	case 2:
+		argv := Alloc(len(args) + 1)
+		for i, arg := range args {
+			argv.Add(i).Store(CString(arg))
+		}
+		argv.Add(len(args)).Store(Pointer{})
+		params = append(params, Int(len(args)), argv)
+	default:
+		return 0, errors.New("main must take no parameters or argc and argv")
+	}
+
+	v, err := in.Call(&Closure{Decl: main}, params...)
+	var exit Exit
+	switch {
+	case errors.As(err, &exit):
+		return int(exit), nil
+	case err != nil:
+		return 0, err
+	case v == nil:
+		return 0, nil
+	}
+	code, ok := v.(Int)
+	if !ok {
+		return 0, fmt.Errorf("main returns %s, which is not an exit status", types.String(main.Result()))
+	}
+	return int(code), nil
+}
+
+// Call calls the function of a closure with arguments of its parameter
+// types and returns its result.
+func (in *Interp) Call(c *Closure, args ...Value) (v Value, err error) {
+	defer catch(&err)
+	return in.callClosure(c.Decl.Token, c, args), nil
+}
+
+// Define adds a global variable that is initialized with any expression,
+// like the variables of an interactive session, and evaluates its value.
+func (in *Interp) Define(vd *ast.VarDecl) (err error) {
+	defer catch(&err)
+	f := &frame{vars: make(map[*ast.VarDecl]Pointer)}
+	v := f.convert(in.eval(f, vd.Value), vd.Value.Type(), vd.Type.Type)
+	p := Alloc(1)
+	p.Store(v)
+	in.globals[vd] = p
+	return nil
+}
+
+// Exec runs a checked statement outside of any function.
+func (in *Interp) Exec(s ast.Statement) (err error) {
+	defer catch(&err)
+	in.exec(&frame{vars: make(map[*ast.VarDecl]Pointer)}, []ast.Statement{s})
+	return nil
+}
+
+// Eval evaluates a checked expression outside of any function.
+func (in *Interp) Eval(e ast.Expression) (v Value, err error) {
+	defer catch(&err)
+	f := &frame{vars: make(map[*ast.VarDecl]Pointer)}
+	return in.eval(f, e), nil
+}
+
+// catch recovers from the errors that stop a program and sets err to them.
+func catch(err *error) {
+	switch r := recover().(type) {
+	case nil:
+	case *Error:
+		*err = r
+	case Exit:
+		*err = r
+	default:
+		panic(r)
+	}
+}
+
+// fail stops the program with an error at the token t.
+func fail(t token.Token, format string, args ...interface{}) {
+	panic(&Error{Pos: diag.At(t).Start, Msg: fmt.Sprintf(format, args...)})
+}
+
+// frame holds the variables of a call. Each variable has a cell in memory,
+// so that pointers and closures can refer to it.
+type frame struct {
+	decl     *ast.FuncDecl
+	vars     map[*ast.VarDecl]Pointer
+	typeArgs map[*types.TypeParam]types.Type
+	defers   []*deferred
+	result   Value
+}
+
+// deferred is a call of a defer statement, whose function value and
+// arguments are evaluated when the statement runs.
+type deferred struct {
+	call     *ast.FuncCall
+	closure  *Closure
+	args     []Value
+	typeArgs map[*types.TypeParam]types.Type
+}
+
+// unwind is panicked with to return from the function of a frame in the
+// middle of an expression, like try does.
+type unwind struct {
+	f *frame
+}
+
+func (f *frame) declare(vd *ast.VarDecl, v Value) {
+	p := Alloc(1)
+	p.Store(v)
+	f.vars[vd] = p
+}
+
+// subst replaces the type parameters of the generic function that f calls.
+func (f *frame) subst(t types.Type) types.Type {
+	return types.Subst(t, f.typeArgs)
+}
+
+func (f *frame) convert(v Value, from, to types.Type) Value {
+	return convert(v, f.subst(from), f.subst(to))
+}
+
+// cell returns the cell of a local or a global variable.
+func (in *Interp) cell(f *frame, t token.Token, vd *ast.VarDecl) Pointer {
+	if p, ok := f.vars[vd]; ok {
+		return p
+	}
+	if p, ok := in.globals[vd]; ok {
+		return p
+	}
+	fail(t, "variable %s has no value", t.Value)
+	return Pointer{}
+}
+
+func (in *Interp) load(t token.Token, p Pointer) Value {
+	v, err := p.Load()
+	if err != nil {
+		fail(t, "%s", err)
+	}
+	return v
+}
+
+func (in *Interp) store(t token.Token, p Pointer, v Value) {
+	if err := p.Store(v); err != nil {
+		fail(t, "%s", err)
+	}
+}
+
+// call calls fd with args. Closures pass the variables they capture and the
+// type arguments of the function they were created in.
+func (in *Interp) call(t token.Token, fd *ast.FuncDecl, args []Value, c *Closure, typeArgs map[*types.TypeParam]types.Type) Value {
+	if fd.Extern {
+		return in.callExtern(t, fd, args)
+	}
+
+	in.depth++
+	defer func() { in.depth-- }()
+	if in.depth > in.MaxDepth {
+		fail(t, "stack overflow calling %s", fd.Token.Value)
+	}
+
+	f := &frame{decl: fd, vars: make(map[*ast.VarDecl]Pointer), typeArgs: typeArgs}
+	if c != nil {
+		for vd, p := range c.env {
+			f.vars[vd] = p
+		}
+	}
+	params := fd.Params
+	if fd.Receiver != nil {
+		params = append([]*ast.VarDecl{fd.Receiver}, params...)
+	}
+	for i, vd := range params {
+		f.declare(vd, args[i])
+	}
+
+	if !in.run(f) && fd.HasReturn {
+		fail(fd.Token, "function %s returns without a value", fd.Token.Value)
+	}
+	for i := len(f.defers) - 1; i >= 0; i-- {
+		d := f.defers[i]
+		if d.closure != nil {
+			in.callClosure(d.call.Token, d.closure, d.args)
+		} else {
+			in.call(d.call.Token, d.call.FuncDecl, d.args, nil, d.typeArgs)
+		}
+	}
+	return f.result
+}
+
+// run runs the body of the function of f and reports whether it returned.
+func (in *Interp) run(f *frame) (returned bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if u, ok := r.(unwind); ok && u.f == f {
+				returned = true
+				return
+			}
+			panic(r)
+		}
+	}()
+	return in.exec(f, f.decl.Body)
+}
+
+func (in *Interp) callClosure(t token.Token, c *Closure, args []Value) Value {
+	return in.call(t, c.Decl, args, c, c.typeArgs)
+}
+
+// callExtern calls the Go function that implements an extern function.
+func (in *Interp) callExtern(t token.Token, fd *ast.FuncDecl, args []Value) Value {
+	ext, ok := in.Externs[fd.Token.Value]
+	if !ok {
+		fail(t, "extern function %s is not implemented", fd.Token.Value)
+	}
+
+	v, err := ext(in, args)
+	var rerr *Error
+	var exit Exit
+	switch {
+	case errors.As(err, &rerr):
+		panic(rerr)
+	case errors.As(err, &exit):
+		panic(exit)
+	case err != nil:
+		fail(t, "%s: %s", fd.Token.Value, err)
+	}
+	if i, ok := v.(Int); ok {
+		v = normalize(i, fd.Result())
+	}
+	return v
+}
+
+// exec runs statements and reports whether one of them returned.
+func (in *Interp) exec(f *frame, stmts []ast.Statement) bool {
+	for _, s := range stmts {
+		switch v := s.(type) {
+		case *ast.VarDecl:
+			f.declare(v, f.convert(in.eval(f, v.Value), v.Value.Type(), v.Type.Type))
+		case *ast.Assign:
+			x := f.convert(in.eval(f, v.Value), v.Value.Type(), v.X.VarDecl.Type.Type)
+			in.store(v.X.Token, in.cell(f, v.X.Token, v.X.VarDecl), x)
+		case *ast.Return:
+			if v.HasValue {
+				f.result = f.convert(in.eval(f, v.Value), v.Value.Type(), f.decl.ReturnType.Type)
+			}
+			return true
+		case *ast.FuncCall:
+			in.eval(f, v)
+		case *ast.MethodCall:
+			in.eval(f, v)
+		case *ast.Try:
+			in.eval(f, v)
+		case *ast.Defer:
+			in.deferCall(f, v)
+		case *ast.Switch:
+			if in.execSwitch(f, v) {
+				return true
+			}
+		case *ast.BadStmt:
+			fail(v.From, "invalid statement")
+		default:
+			panic(fmt.Sprintf("cannot run %T", v))
+		}
+	}
+	return false
+}
+
+func (in *Interp) deferCall(f *frame, d *ast.Defer) {
+	fc := d.Call.(*ast.FuncCall)
+	df := &deferred{call: fc, args: in.args(f, fc), typeArgs: in.typeArgs(f, fc)}
+	if fc.VarDecl != nil {
+		df.closure = in.closure(f, fc)
+	}
+	f.defers = append(f.defers, df)
+}
+
+// execSwitch runs the case that matches the value of a switch, or its
+// default case. Unions are matched by the tag of their variant, and
+// patterns bind the fields of the variant.
+func (in *Interp) execSwitch(f *frame, s *ast.Switch) bool {
+	x := in.eval(f, s.Value)
+	t := f.subst(s.Value.Type())
+
+	var tag Int
+	u, isUnion := x.(Union)
+	if isUnion {
+		tag = Int(u.Tag)
+	} else {
+		tag = x.(Int)
+	}
+
+	var match *ast.Case
+	var pattern *ast.Pattern
+	for _, cs := range s.Cases {
+		if cs.Default && match == nil {
+			match = cs
+		}
+		for _, e := range cs.Values {
+			v := Int(caseValue(e))
+			if !isUnion {
+				v = normalize(v, t)
+			}
+			if v == tag {
+				match = cs
+				pattern, _ = e.(*ast.Pattern)
+				goto found
+			}
+		}
+	}
+found:
+	if match == nil {
+		return false
+	}
+	if pattern != nil {
+		for i, vd := range pattern.Bindings {
+			if vd.Token.Value != "_" {
+				f.declare(vd, u.Fields[i])
+			}
+		}
+	}
+	return in.exec(f, match.Body)
+}
+
+func caseValue(e ast.Expression) int {
+	switch v := e.(type) {
+	case *ast.IntLiteral:
+		return v.Value
+	case *ast.Selector:
+		if v.Variant != nil {
+			return v.Variant.Tag
+		}
+		return v.Member.Value
+	case *ast.Pattern:
+		return v.Variant.Tag
+	case *ast.None:
+		return 0
+	default:
+		panic(fmt.Sprintf("cannot use %T as case value", v))
+	}
+}
+
+func (in *Interp) eval(f *frame, e ast.Expression) Value {
+	switch v := e.(type) {
+	case *ast.IntLiteral:
+		return normalize(Int(v.Value), types.TypeInt32)
+	case *ast.StringLiteral:
+		p, ok := in.strings[v]
+		if !ok {
+			p = CString(v.Value)
+			in.strings[v] = p
+		}
+		return p
+	case *ast.None:
+		return nil
+	case *ast.Err:
+		return in.eval(f, v.X)
+	case *ast.Try:
+		return in.evalTry(f, v)
+	case *ast.FuncLit:
+		c := &Closure{Decl: v.Decl, env: make(map[*ast.VarDecl]Pointer), typeArgs: f.typeArgs}
+		for _, vd := range v.Captures {
+			if p, ok := f.vars[vd]; ok {
+				c.env[vd] = p
+			}
+		}
+		return c
+	case *ast.InfixExpression:
+		return in.evalInfix(f, v)
+	case *ast.Index:
+		s := in.eval(f, v.X).(Slice)
+		i := in.eval(f, v.Index).(Int)
+		if i < 0 || int(i) >= s.Len {
+			fail(v.Token, "index %d out of range [0, %d)", i, s.Len)
+		}
+		return in.load(v.Token, s.Data.Add(int(i)))
+	case *ast.Var:
+		if v.FuncDecl != nil {
+			return &Closure{Decl: v.FuncDecl}
+		}
+		return in.load(v.Token, in.cell(f, v.Token, v.VarDecl))
+	case *ast.FuncCall:
+		return in.evalCall(f, v)
+	case *ast.MethodCall:
+		return in.evalMethodCall(f, v)
+	case *ast.Selector:
+		if v.Variant != nil {
+			return Union{Tag: v.Variant.Tag}
+		}
+		return normalize(Int(v.Member.Value), v.Member.Enum)
+	case *ast.BadExpr:
+		fail(v.From, "invalid expression")
+	}
+	panic(fmt.Sprintf("cannot evaluate %T", e))
+}
+
+// evalTry returns the value of an optional or a result, or returns its none
+// or its error from the function if it holds no value.
+func (in *Interp) evalTry(f *frame, t *ast.Try) Value {
+	x := in.eval(f, t.X).(Union)
+
+	u, _ := types.AsUnion(f.subst(t.X.Type()))
+	success := u.Variants[1]
+	if _, ok := t.X.Type().(*types.Result); ok {
+		success = u.Variants[0]
+	}
+	if x.Tag == success.Tag {
+		return x.Fields[0]
+	}
+
+	if f.decl == nil {
+		fail(t.Token, "try outside of a function")
+	}
+	switch ret := f.subst(f.decl.Result()).(type) {
+	case *types.Optional:
+		f.result = Union{Tag: ret.Union.Variants[0].Tag}
+	case *types.Result:
+		f.result = Union{Tag: ret.Union.Variants[1].Tag, Fields: []Value{x.Fields[0]}}
+	}
+	panic(unwind{f})
+}
+
+func (in *Interp) evalInfix(f *frame, ie *ast.InfixExpression) Value {
+	l, lok := in.eval(f, ie.Left).(Int)
+	r, rok := in.eval(f, ie.Right).(Int)
+	if !lok || !rok {
+		fail(ie.Token, "invalid operation %s on %s", ie.Token.Value, types.String(f.subst(ie.Type())))
+	}
+
+	var v Int
+	switch ie.Token.Type {
+	case token.PLUS:
+		v = l + r
+	case token.MINUS:
+		v = l - r
+	case token.ASTERISK:
+		v = l * r
+	case token.SLASH:
+		if r == 0 {
+			fail(ie.Token, "division by zero")
+		}
+		v = l / r
+	default:
+		panic(fmt.Sprintf("cannot evaluate %s", ie.Token.Type))
+	}
+	return normalize(v, f.subst(ie.Type()))
+}
+
+func (in *Interp) evalCall(f *frame, fc *ast.FuncCall) Value {
+	if fc.Builtin {
+		return Int(in.eval(f, fc.Args[0]).(Slice).Len)
+	}
+
+	args := in.args(f, fc)
+	if fc.VarDecl != nil {
+		return in.callClosure(fc.Token, in.closure(f, fc), args)
+	}
+	return in.call(fc.Token, fc.FuncDecl, args, nil, in.typeArgs(f, fc))
+}
+
+// closure returns the function value that a call through a variable calls.
+func (in *Interp) closure(f *frame, fc *ast.FuncCall) *Closure {
+	c, ok := in.load(fc.Token, in.cell(f, fc.Token, fc.VarDecl)).(*Closure)
+	if !ok {
+		fail(fc.Token, "call of nil function %s", fc.Token.Value)
+	}
+	return c
+}
+
+// typeArgs returns the type arguments of a call of a generic function.
+func (in *Interp) typeArgs(f *frame, fc *ast.FuncCall) map[*types.TypeParam]types.Type {
+	if len(fc.Instance) == 0 {
+		return nil
+	}
+	typeArgs := make(map[*types.TypeParam]types.Type)
+	for i, tp := range fc.FuncDecl.TypeParams {
+		typeArgs[tp.Type] = f.subst(fc.Instance[i])
+	}
+	return typeArgs
+}
+
+// signature returns the type of the called function, with the type
+// parameters of generic functions replaced.
+func (in *Interp) signature(f *frame, fc *ast.FuncCall) *types.Func {
+	return f.subst(fc.Signature()).(*types.Func)
+}
+
+// args evaluates the arguments of a call. The arguments after the fixed
+// parameters of a variadic function are passed as a slice, and those of a C
+// function with varargs are promoted like in C.
+func (in *Interp) args(f *frame, fc *ast.FuncCall) []Value {
+	sig := in.signature(f, fc)
+	rest := sig.Rest()
+	varArgs := fc.FuncDecl != nil && fc.FuncDecl.VarArgs
+
+	n := len(fc.Args)
+	switch {
+	case rest != nil:
+		n = len(sig.Params) - 1
+	case varArgs:
+		n = len(sig.Params)
+	}
+
+	args := make([]Value, 0)
+	for i, arg := range fc.Args[:n] {
+		args = append(args, f.convert(in.eval(f, arg), arg.Type(), sig.Params[i]))
+	}
+
+	switch {
+	case rest != nil:
+		s := Slice{Len: len(fc.Args) - n}
+		if s.Len > 0 {
+			s.Data = Alloc(s.Len)
+		}
+		for i, arg := range fc.Args[n:] {
+			s.Data.Add(i).Store(f.convert(in.eval(f, arg), arg.Type(), rest))
+		}
+		args = append(args, s)
+	case varArgs:
+		for _, arg := range fc.Args[n:] {
+			t := f.subst(arg.Type())
+			args = append(args, convert(in.eval(f, arg), t, types.Promote(t)))
+		}
+	}
+
+	return args
+}
+
+func (in *Interp) evalMethodCall(f *frame, mc *ast.MethodCall) Value {
+	if v := mc.Variant; v != nil {
+		fields := make([]Value, 0)
+		for i, arg := range mc.Args {
+			fields = append(fields, f.convert(in.eval(f, arg), arg.Type(), v.Fields[i]))
+		}
+		return Union{Tag: v.Tag, Fields: fields}
+	}
+
+	if mc.FuncDecl != nil {
+		return in.staticMethodCall(f, mc, mc.FuncDecl)
+	}
+
+	t := f.subst(mc.Receiver.Type())
+	iface, ok := t.(*types.Interface)
+	if !ok {
+		fd, ok := in.methods[methodName(t, mc.Token.Value)]
+		if !ok {
+			fail(mc.Token, "%s has no method %s", types.String(t), mc.Token.Value)
+		}
+		return in.staticMethodCall(f, mc, fd)
+	}
+
+	// Methods with a pointer receiver get the data of the interface value,
+	// others the value it points to.
+	x := in.eval(f, mc.Receiver).(Iface)
+	fd, ok := in.methods[methodName(x.Type, mc.Token.Value)]
+	if !ok {
+		fail(mc.Token, "%s has no method %s", types.String(x.Type), mc.Token.Value)
+	}
+	var recv Value = x.Data
+	if _, ok := fd.Receiver.Type.Type.(*types.Pointer); !ok {
+		recv = in.load(mc.Token, x.Data)
+	}
+
+	_, m, _ := iface.Method(mc.Token.Value)
+	args := []Value{recv}
+	for i, arg := range mc.Args {
+		args = append(args, f.convert(in.eval(f, arg), arg.Type(), m.Sig.Params[i]))
+	}
+	return in.call(mc.Token, fd, args, nil, nil)
+}
+
+// staticMethodCall calls a method of a named type, taking the address of or
+// dereferencing the receiver to match the method's receiver type.
+func (in *Interp) staticMethodCall(f *frame, mc *ast.MethodCall, fd *ast.FuncDecl) Value {
+	var recv Value
+	_, wantPtr := fd.Receiver.Type.Type.(*types.Pointer)
+	_, isPtr := f.subst(mc.Receiver.Type()).(*types.Pointer)
+	switch {
+	case wantPtr && !isPtr:
+		v := mc.Receiver.(*ast.Var)
+		recv = in.cell(f, v.Token, v.VarDecl)
+	case !wantPtr && isPtr:
+		recv = in.load(mc.Token, in.eval(f, mc.Receiver).(Pointer))
+	default:
+		recv = in.eval(f, mc.Receiver)
+	}
+
+	args := []Value{recv}
+	for i, arg := range mc.Args {
+		args = append(args, f.convert(in.eval(f, arg), arg.Type(), fd.Params[i].Type.Type))
+	}
+	return in.call(mc.Token, fd, args, nil, nil)
+}
+
+// methodName returns the name of the method of t, which is a named type or a
+// pointer to one, that methods are looked up by.
+func methodName(t types.Type, name string) string {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.To
+	}
+	return t.Name() + "." + name
+}
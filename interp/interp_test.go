@@ -0,0 +1,395 @@
+package interp
+
+import (
+	"bytes"
+	"lang/checker"
+	"lang/loader"
+	"lang/types"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// run checks source as the main package and runs it, returning its exit
+// status and output.
+func run(t *testing.T, source string) (int, string, error) {
+	file := filepath.Join(t.TempDir(), "main.lang")
+	ld := loader.New([]string{filepath.Dir(file)})
+	ld.Overlay = map[string][]byte{file: []byte(source)}
+	progs, ok := ld.Load(file)
+	if !ok {
+		t.Fatalf("load: %v", ld.Errors)
+	}
+	if diags := checker.CheckPackages(progs); len(diags) > 0 {
+		t.Fatalf("check: %v", diags)
+	}
+
+	var out bytes.Buffer
+	in := New(progs...)
+	in.Stdout = &out
+	code, err := in.Run([]string{file})
+	return code, out.String(), err
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		code   int
+		out    string
+	}{
+		{"arithmetic", `
+func main() i32 {
+	return 7 - 2 * 10 / 4 + 30
+}
+`, 32, ""},
+		{"wraparound", `
+func main() i32 {
+	var x i32 = 2147483647
+	var b u8 = 255
+	b = b + 2
+	switch b {
+	case 1:
+		return x + 1 + 2147483647 + 43
+	}
+	return 0
+}
+`, 42, ""},
+		{"widening", `
+func main() i32 {
+	var a i32 = 300
+	var b u8 = 1
+	var x i32 = a + b
+	var y i32 = b + a
+	switch x - y {
+	case 0:
+		return y - 259
+	}
+	return 0
+}
+`, 42, ""},
+		{"recursion", `
+func fib(n i32) i32 {
+	switch n {
+	case 0, 1:
+		return n
+	}
+	return fib(n - 1) + fib(n - 2)
+}
+func main() i32 {
+	return fib(10)
+}
+`, 55, ""},
+		{"globals", `
+var count i32 = 40
+func inc() {
+	count = count + 1
+}
+func main() i32 {
+	inc()
+	inc()
+	return count
+}
+`, 42, ""},
+		{"enum", `
+enum Color {
+	Red,
+	Green = 5,
+	Blue
+}
+func value(c Color) i32 {
+	switch c {
+	case Color.Red:
+		return 1
+	case Color.Green:
+		return 2
+	case Color.Blue:
+		return 3
+	}
+}
+func main() i32 {
+	return value(Color.Blue) * 10 + value(Color.Red)
+}
+`, 31, ""},
+		{"union", `
+union Shape {
+	Circle(i32),
+	Rect(i32, i32),
+	Empty
+}
+func area(s Shape) i32 {
+	switch s {
+	case Shape.Circle(r):
+		return 3 * r * r
+	case Shape.Rect(w, h):
+		return w * h
+	case Shape.Empty:
+		return 0
+	}
+}
+func main() i32 {
+	return area(Shape.Circle(2)) + area(Shape.Rect(3, 10)) + area(Shape.Empty)
+}
+`, 42, ""},
+		{"optional", `
+func find(x i32) ?i32 {
+	switch x {
+	case 0:
+		return none
+	}
+	return x * 2
+}
+func twice(x i32) ?i32 {
+	var y i32 = try find(x)
+	return try find(y)
+}
+func get(o ?i32) i32 {
+	switch o {
+	case none:
+		return 100
+	case some(x):
+		return x
+	}
+}
+func main() i32 {
+	return get(twice(3)) + get(twice(0))
+}
+`, 112, ""},
+		{"result", `
+type Error i32
+func check(x i32) Error!i32 {
+	switch x {
+	case 0:
+		var e Error = 7
+		return err(e)
+	}
+	return x
+}
+func sum(a i32, b i32) Error!i32 {
+	return try check(a) + try check(b)
+}
+func get(r Error!i32) i32 {
+	switch r {
+	case ok(x):
+		return x
+	case err(e):
+		return 0 - e
+	}
+}
+func main() i32 {
+	return get(sum(20, 22)) + get(sum(1, 0))
+}
+`, 35, ""},
+		{"defer", `
+func puts(s ^u8) i32
+func main() {
+	defer puts("one")
+	defer puts("two")
+	puts("body")
+}
+`, 0, "body\ntwo\none\n"},
+		{"closures", `
+func apply(f func(i32) i32, x i32) i32 {
+	return f(x)
+}
+func main() i32 {
+	var total i32 = 1
+	var add func(i32) i32 = func(x i32) i32 {
+		total = total + x
+		return total
+	}
+	apply(add, 10)
+	add(30)
+	return total
+}
+`, 41, ""},
+		{"generics", `
+func first[T](a T, b T) T {
+	return a
+}
+func wrap[T](x T) ?T {
+	return x
+}
+func main() i32 {
+	var b u8 = first(250, 1)
+	switch wrap(b) {
+	case some(x):
+		var z u8 = 0
+		return first(x, z) + 2
+	case none:
+		return 0
+	}
+}
+`, 252, ""},
+		{"methods", `
+type Counter i32
+type Speaker interface {
+	speak() i32
+}
+func (c ^Counter) get() i32 {
+	return c.speak()
+}
+func (c Counter) speak() i32 {
+	return c * 2
+}
+func talk(s Speaker) i32 {
+	return s.speak()
+}
+func main() i32 {
+	var c Counter = 10
+	return talk(c) + c.get() + 2
+}
+`, 42, ""},
+		{"variadic", `
+func sum(xs ...i32) i32 {
+	switch len(xs) {
+	case 0:
+		return 0
+	case 1:
+		return xs[0]
+	case 2:
+		return xs[0] + xs[1]
+	}
+	return xs[0] + xs[1] + xs[2]
+}
+func main() i32 {
+	return sum() + sum(1) + sum(2, 3) + sum(10, 11, 15)
+}
+`, 42, ""},
+		{"externs", `
+func printf(format ^u8, ...) i32
+func puts(s ^u8) i32
+func strlen(s ^u8) i32
+func main(argc i32, argv ^^u8) i32 {
+	puts("hello")
+	var b u8 = 65
+	printf("%d %3d|%-3d|%03x %c %s %u %%\n", 42, 7, 7, 255, b, "str", 0 - 1)
+	return strlen("four")
+}
+`, 4, "hello\n42   7|7  |0ff A str 4294967295 %\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, out, err := run(t, tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code || out != tt.out {
+				t.Errorf("got %d and %q, want %d and %q", code, out, tt.code, tt.out)
+			}
+		})
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"division by zero", `
+func div(a i32, b i32) i32 {
+	return a / b
+}
+func main() i32 {
+	return div(1, 0)
+}
+`, "main.lang:3:11: division by zero"},
+		{"index", `
+func get(xs ...i32) i32 {
+	return xs[2]
+}
+func main() i32 {
+	return get(1, 2)
+}
+`, "main.lang:3:11: index 2 out of range [0, 2)"},
+		{"stack overflow", `
+func loop(x i32) i32 {
+	return loop(x + 1)
+}
+func main() i32 {
+	return loop(0)
+}
+`, "main.lang:3:9: stack overflow calling loop"},
+		{"missing extern", `
+func launch() i32
+func main() i32 {
+	return launch()
+}
+`, "main.lang:4:9: extern function launch is not implemented"},
+		{"double free", `
+func malloc(n i32) ^u8
+func free(p ^u8)
+func main() {
+	var p ^u8 = malloc(4)
+	free(p)
+	free(p)
+}
+`, "main.lang:7:2: free: double free"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := run(t, tt.source)
+			if err == nil || !strings.HasSuffix(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExit(t *testing.T) {
+	code, out, err := run(t, `
+func exit(status i32)
+func puts(s ^u8) i32
+func main() i32 {
+	defer puts("deferred")
+	puts("before")
+	exit(3)
+	puts("after")
+	return 0
+}
+`)
+	if err != nil || code != 3 || out != "before\n" {
+		t.Errorf("got %d, %q and %v, want 3 and %q", code, out, err, "before\n")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	color := types.NewEnum("Color", types.TypeInt32)
+	color.Members = []*types.EnumMember{{Name: "Red", Value: 0, Enum: color}, {Name: "Blue", Value: 4, Enum: color}}
+	shape := types.NewUnion("Shape")
+	shape.Variants = []*types.Variant{
+		{Name: "Empty", Tag: 0, Union: shape},
+		{Name: "Rect", Tag: 1, Fields: []types.Type{types.TypeInt32, types.TypeInt32}, Union: shape},
+	}
+	xs := Alloc(2)
+	xs.Store(Int(1))
+	xs.Add(1).Store(Int(2))
+
+	tests := []struct {
+		v    Value
+		t    types.Type
+		want string
+	}{
+		{Int(-3), types.TypeInt32, "-3"},
+		{Int(4), color, "Color.Blue"},
+		{Int(7), color, "7"},
+		{Union{Tag: 1, Fields: []Value{Int(2), Int(3)}}, shape, "Shape.Rect(2, 3)"},
+		{Union{Tag: 0}, shape, "Shape.Empty"},
+		{Union{Tag: 0}, types.NewOptional(types.TypeInt32), "none"},
+		{Union{Tag: 1, Fields: []Value{Int(4)}}, types.NewOptional(color), "some(Color.Blue)"},
+		{Union{Tag: 1, Fields: []Value{Int(0)}}, types.NewResult(color, types.TypeInt32), "err(Color.Red)"},
+		{Slice{Data: xs, Len: 2}, &types.Slice{Elem: types.TypeInt32}, "[1, 2]"},
+		{Pointer{}, &types.Pointer{To: types.TypeUint8}, "(nil)"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.v, tt.t); got != tt.want {
+			t.Errorf("Format(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+
+	s := Format(CString("hi"), &types.Pointer{To: types.TypeUint8})
+	if !strings.HasPrefix(s, "0x") || !strings.HasSuffix(s, ` "hi"`) {
+		t.Errorf("Format of a string = %q", s)
+	}
+}
@@ -0,0 +1,219 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"lang/ast"
+	"lang/types"
+)
+
+// Value is a value of a program, which is one of Int, Pointer, Slice,
+// Union, *Closure and Iface, or nil for functions that return nothing and
+// for none before it is converted to an optional.
+type Value interface{}
+
+// Int is a value of an integer type or an enum. Values are kept in the range
+// of their type, so u8 values are never negative and i32 values wrap around.
+type Int int64
+
+// Pointer is the address of a cell in the simulated memory. The zero Pointer
+// is the nil pointer.
+type Pointer struct {
+	block *block
+	off   int
+}
+
+// block is an allocation of cells, like a variable, the bytes of a string or
+// the memory returned by malloc.
+type block struct {
+	id    int
+	cells []Value
+	freed bool
+}
+
+// blocks counts the allocations, to number them.
+var blocks int
+
+// Slice is a sequence of Len values starting at Data.
+type Slice struct {
+	Data Pointer
+	Len  int
+}
+
+// Union is a value of a union, an optional or a result, which holds the
+// fields of the variant with the tag Tag.
+type Union struct {
+	Tag    int
+	Fields []Value
+}
+
+// Closure is a function value, a function and the variables of enclosing
+// functions that it captures.
+type Closure struct {
+	Decl *ast.FuncDecl
+
+	env      map[*ast.VarDecl]Pointer
+	typeArgs map[*types.TypeParam]types.Type
+}
+
+// Iface is a value of an interface, which holds a value of the type Type
+// that implements it. Data is the value itself if Type is a pointer, and
+// otherwise points to a copy of it, which methods with a pointer receiver
+// can change.
+type Iface struct {
+	Type types.Type
+	Data Pointer
+}
+
+// Alloc allocates n cells that hold zero.
+func Alloc(n int) Pointer {
+	blocks++
+	b := &block{id: blocks, cells: make([]Value, n)}
+	for i := range b.cells {
+		b.cells[i] = Int(0)
+	}
+	return Pointer{block: b}
+}
+
+// CString allocates the bytes of s followed by a NUL byte.
+func CString(s string) Pointer {
+	p := Alloc(len(s) + 1)
+	for i := 0; i < len(s); i++ {
+		p.block.cells[i] = Int(s[i])
+	}
+	return p
+}
+
+func (p Pointer) IsNil() bool {
+	return p.block == nil
+}
+
+// String returns a made-up address of p, which is the number of its
+// allocation followed by its offset.
+func (p Pointer) String() string {
+	if p.IsNil() {
+		return "(nil)"
+	}
+	return fmt.Sprintf("0x%x%04x", p.block.id, p.off)
+}
+
+// Add returns the pointer n cells after p.
+func (p Pointer) Add(n int) Pointer {
+	return Pointer{block: p.block, off: p.off + n}
+}
+
+func (p Pointer) check() error {
+	switch {
+	case p.block == nil:
+		return errors.New("nil pointer dereference")
+	case p.block.freed:
+		return errors.New("use of freed memory")
+	case p.off < 0 || p.off >= len(p.block.cells):
+		return errors.New("pointer out of bounds")
+	}
+	return nil
+}
+
+// Load returns the value of the cell at p.
+func (p Pointer) Load() (Value, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return p.block.cells[p.off], nil
+}
+
+// Store sets the value of the cell at p.
+func (p Pointer) Store(v Value) error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	p.block.cells[p.off] = v
+	return nil
+}
+
+// Free frees the allocation that p points to the start of.
+func (p Pointer) Free() error {
+	switch {
+	case p.block == nil:
+		return nil
+	case p.block.freed:
+		return errors.New("double free")
+	case p.off != 0:
+		return errors.New("free of a pointer into an allocation")
+	}
+	p.block.freed = true
+	return nil
+}
+
+// GoString returns the bytes at p up to the first NUL byte.
+func (p Pointer) GoString() (string, error) {
+	b := make([]byte, 0)
+	for ; ; p = p.Add(1) {
+		v, err := p.Load()
+		if err != nil {
+			return "", err
+		}
+		c, ok := v.(Int)
+		if !ok {
+			return "", fmt.Errorf("cannot read %T as a byte", v)
+		}
+		if c == 0 {
+			return string(b), nil
+		}
+		b = append(b, byte(c))
+	}
+}
+
+// normalize returns v in the range of the integer type t, or v if t is not
+// an integer type.
+func normalize(v Int, t types.Type) Int {
+	switch types.IntBits(t) {
+	case 32:
+		return Int(int32(v))
+	case 8:
+		return Int(uint8(v))
+	default:
+		return v
+	}
+}
+
+// convert converts v from type from to type to, like assignments do.
+// Integers are converted to the range of their new type, values become
+// optionals, results and interfaces that hold them.
+func convert(v Value, from, to types.Type) Value {
+	if i, ok := v.(Int); ok && types.IntBits(to) != 0 {
+		return normalize(i, to)
+	}
+
+	switch t := to.(type) {
+	case *types.Optional:
+		switch from.(type) {
+		case *types.None:
+			return Union{Tag: t.Union.Variants[0].Tag}
+		case *types.Optional:
+			return v
+		default:
+			return Union{Tag: t.Union.Variants[1].Tag, Fields: []Value{convert(v, from, t.Elem)}}
+		}
+	case *types.Result:
+		switch f := from.(type) {
+		case *types.Error:
+			return Union{Tag: t.Union.Variants[1].Tag, Fields: []Value{convert(v, f.Err, t.Err)}}
+		case *types.Result:
+			return v
+		default:
+			return Union{Tag: t.Union.Variants[0].Tag, Fields: []Value{convert(v, from, t.Value)}}
+		}
+	case *types.Interface:
+		if _, ok := from.(*types.Interface); ok {
+			return v
+		}
+		if _, ok := from.(*types.Pointer); ok {
+			return Iface{Type: from, Data: v.(Pointer)}
+		}
+		data := Alloc(1)
+		data.Store(v)
+		return Iface{Type: from, Data: data}
+	}
+	return v
+}
@@ -1,25 +1,64 @@
 package lexer
 
 import (
+	"lang/diag"
 	"lang/token"
 	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// Lexer splits UTF-8 source into tokens and skips whitespace and comments,
+// which are either line comments starting with // or block comments between
+// /* and */. Characters that cannot start a token are returned as ILLEGAL
+// tokens. Errors are collected in Errors, and lexing goes on after them.
+//
+// Like in Go, a semicolon is inserted at the end of a line whose last token
+// can end a statement, which is a name, a literal, return, none or a closing
+// parenthesis, bracket or brace. Inserted semicolons have the value "\n", or
+// "" at the end of the file.
 type Lexer struct {
-	pos      int
-	next     int
-	ch       byte
-	data     string
-	line     int
-	col      int
-	Filename string
+	Errors []*diag.Diagnostic
+
+	// ScanComments makes the lexer return comments as COMMENT tokens instead
+	// of skipping them, which must be set before the first token is read. A
+	// comment that ends a line comes after the semicolon inserted there.
+	ScanComments bool
+
+	file *token.File
+	data string
+
+	// ch is the current character, which starts at the offset pos, and next
+	// the offset of the character after it.
+	ch   rune
+	pos  int
+	next int
+
+	// insertSemi is set if a line break after the last token ends a
+	// statement.
+	insertSemi bool
 }
 
+const (
+	eof = -1
+	bom = 0xFEFF
+)
+
+// New returns a lexer for the source s, which is added to a new FileSet
+// without a name.
 func New(s string) *Lexer {
-	l := &Lexer{data: s}
+	return NewFile(token.NewFileSet().AddFile("", []byte(s)))
+}
+
+// NewFile returns a lexer for the source of file. A byte order mark at the
+// start of the file is skipped.
+func NewFile(file *token.File) *Lexer {
+	l := &Lexer{file: file, data: string(file.Source())}
 	l.advance()
-	l.line = 1
-	l.col = 0
+	if l.ch == bom {
+		l.advance()
+	}
 	return l
 }
 
@@ -28,58 +67,110 @@ func FromFile(f string) (*Lexer, error) {
 	if err != nil {
 		return nil, err
 	}
-	l := New(string(b))
-	l.Filename = f
-	return l, nil
+	return NewFile(token.NewFileSet().AddFile(f, b)), nil
+}
+
+// File returns the file that the lexer reads.
+func (l *Lexer) File() *token.File {
+	return l.file
 }
 
 func (l *Lexer) NextToken() token.Token {
+	if nl := l.skipWhitespace(l.insertSemi); nl >= 0 {
+		l.insertSemi = false
+		if nl == len(l.data) {
+			return l.token(token.SEMICOLON, "", nl)
+		}
+		return l.token(token.SEMICOLON, "\n", nl)
+	}
+
+	tok := l.scan()
+	switch tok.Type {
+	case token.IDENT, token.INT, token.STRING, token.RETURN, token.NONE, token.RPAREN, token.RBRACKET, token.RBRACE:
+		l.insertSemi = true
+	case token.ILLEGAL, token.COMMENT:
+	default:
+		l.insertSemi = false
+	}
+	return tok
+}
+
+func (l *Lexer) scan() token.Token {
 	var tok token.Token
-	l.eatWhitespace()
 
-	line, col := l.line, l.col
+	start := l.pos
 	switch ch := l.ch; ch {
 	case '(':
-		tok = token.New(token.LPAREN, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.LPAREN, string(l.ch), start)
 	case ')':
-		tok = token.New(token.RPAREN, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.RPAREN, string(l.ch), start)
 	case '{':
-		tok = token.New(token.LBRACE, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.LBRACE, string(l.ch), start)
 	case '}':
-		tok = token.New(token.RBRACE, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.RBRACE, string(l.ch), start)
+	case '[':
+		tok = l.token(token.LBRACKET, string(l.ch), start)
+	case ']':
+		tok = l.token(token.RBRACKET, string(l.ch), start)
 	case '*':
-		tok = token.New(token.ASTERISK, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.ASTERISK, string(l.ch), start)
 	case '+':
-		tok = token.New(token.PLUS, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.PLUS, string(l.ch), start)
 	case ';':
-		tok = token.New(token.SEMICOLON, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.SEMICOLON, string(l.ch), start)
 	case '=':
-		tok = token.New(token.ASSIGN, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.ASSIGN, string(l.ch), start)
 	case '/':
-		tok = token.New(token.SLASH, string(l.ch), line, col, l.Filename)
+		if l.ScanComments && (l.peek() == '/' || l.peek() == '*') {
+			l.skipComment()
+			return l.token(token.COMMENT, l.data[start:l.pos], start)
+		}
+		tok = l.token(token.SLASH, string(l.ch), start)
 	case '-':
-		tok = token.New(token.MINUS, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.MINUS, string(l.ch), start)
 	case ',':
-		tok = token.New(token.COMMA, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.COMMA, string(l.ch), start)
+	case ':':
+		tok = l.token(token.COLON, string(l.ch), start)
+	case '.':
+		if strings.HasPrefix(l.data[l.pos:], "...") {
+			l.advance()
+			l.advance()
+			tok = l.token(token.ELLIPSIS, "...", start)
+		} else {
+			tok = l.token(token.DOT, string(l.ch), start)
+		}
 	case '^':
-		tok = token.New(token.POINTER, string(l.ch), line, col, l.Filename)
+		tok = l.token(token.POINTER, string(l.ch), start)
+	case '!':
+		tok = l.token(token.BANG, string(l.ch), start)
+	case '?':
+		tok = l.token(token.QUESTION, string(l.ch), start)
 	case '"':
 		value := l.eatString()
-		return token.New(token.STRING, value, line, col, l.Filename)
+		return l.token(token.STRING, value, start)
 	default:
-		if isAlpha(ch) {
+		if isLetter(ch) {
 			value := l.eatIdent()
 			tokenType, ok := token.KeywordsMap[value]
 			if ok {
-				return token.New(tokenType, value, line, col, l.Filename)
+				return l.token(tokenType, value, start)
 			} else {
-				return token.New(token.IDENT, value, line, col, l.Filename)
+				return l.token(token.IDENT, value, start)
 			}
-		} else if isDigit(ch) {
+		} else if isDecimal(ch) {
 			value := l.eatInt()
-			return token.New(token.INT, value, line, col, l.Filename)
+			return l.token(token.INT, value, start)
+		} else if ch == eof {
+			return l.token(token.EOF, "", start)
 		} else {
-			return token.New(token.EOF, "", line, col, l.Filename)
+			l.advance()
+			if ch == utf8.RuneError && l.pos-start == 1 {
+				l.error(start, l.pos, "invalid UTF-8 encoding")
+			} else {
+				l.error(start, l.pos, "invalid character %U %q", ch, ch)
+			}
+			return l.token(token.ILLEGAL, l.data[start:l.pos], start)
 		}
 	}
 
@@ -88,33 +179,125 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// token returns a token whose source starts at the offset start.
+func (l *Lexer) token(t token.TokenType, value string, start int) token.Token {
+	pos := l.file.Pos(start)
+	p := l.file.Position(pos)
+	tok := token.New(t, value, p.Line, p.Column, p.Filename)
+	tok.Pos = pos
+	return tok
+}
+
+// advance moves to the next character. Bytes that are not valid UTF-8 are
+// read as utf8.RuneError one at a time.
 func (l *Lexer) advance() {
-	if l.ch == '\n' {
-		l.line += 1
-		l.col = 0
-	} else {
-		l.col += 1
+	l.pos = l.next
+	if l.next >= len(l.data) {
+		l.ch = eof
+		return
 	}
 
-	if l.next >= len(l.data) {
-		l.pos = l.next
-		l.ch = 0
-	} else {
-		l.pos = l.next
-		l.ch = l.data[l.pos]
-		l.next += 1
+	r, w := rune(l.data[l.next]), 1
+	if r >= utf8.RuneSelf {
+		r, w = utf8.DecodeRuneInString(l.data[l.next:])
 	}
+	l.ch = r
+	l.next += w
 }
 
-func (l *Lexer) eatWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+// error reports an error about the source from the offset start up to end.
+func (l *Lexer) error(start, end int, msg string, args ...interface{}) {
+	span := diag.Range(l.file, l.file.Pos(start), l.file.Pos(end))
+	l.Errors = append(l.Errors, diag.Errorf(span, diag.Syntax, msg, args...))
+}
+
+// peek returns the byte after the current character, or 0 at the end.
+func (l *Lexer) peek() byte {
+	if l.next < len(l.data) {
+		return l.data[l.next]
+	}
+	return 0
+}
+
+// skipWhitespace skips whitespace and comments. If stop is set, it stops
+// after the first line break, which is a newline, a block comment with a
+// newline or the end of the file, and returns its offset. Otherwise it
+// returns -1.
+func (l *Lexer) skipWhitespace(stop bool) int {
+	for {
+		switch {
+		case l.ch == '\n' && stop:
+			nl := l.pos
+			l.advance()
+			return nl
+		case l.ch == eof && stop:
+			return l.pos
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.advance()
+		case l.ch == '/' && (l.peek() == '/' || l.peek() == '*') && l.ScanComments:
+			if stop && l.commentEndsLine() {
+				return l.pos
+			}
+			return -1
+		case l.ch == '/' && (l.peek() == '/' || l.peek() == '*'):
+			start := l.pos
+			if l.skipComment() && stop {
+				return start
+			}
+		default:
+			return -1
+		}
+	}
+}
+
+// skipComment skips a line or block comment and reports whether it is a
+// block comment that contains a newline. The newline that ends a line
+// comment is not skipped.
+func (l *Lexer) skipComment() bool {
+	if l.peek() == '/' {
+		for l.ch != '\n' && l.ch != eof {
+			l.advance()
+		}
+		return false
+	}
+
+	start := l.pos
+	newline := false
+	l.advance()
+	l.advance()
+	for l.ch != eof {
+		if l.ch == '*' && l.peek() == '/' {
+			l.advance()
+			l.advance()
+			return newline
+		}
+		if l.ch == '\n' {
+			newline = true
+		}
 		l.advance()
 	}
+	l.error(start, start+2, "comment not terminated")
+	return newline
+}
+
+// commentEndsLine reports whether the comment at the current character is
+// followed by a line break, either in it or after it and whitespace.
+func (l *Lexer) commentEndsLine() bool {
+	rest := l.data[l.pos:]
+	if strings.HasPrefix(rest, "//") {
+		return true
+	}
+	end := strings.Index(rest[2:], "*/")
+	if end < 0 || strings.Contains(rest[:end+2], "\n") {
+		return true
+	}
+	rest = strings.TrimLeft(rest[end+4:], " \t\r")
+	return rest == "" || rest[0] == '\n' || strings.HasPrefix(rest, "//")
 }
 
 func (l *Lexer) eatIdent() string {
 	pos := l.pos
-	for isAlpha(l.ch) || isDigit(l.ch) {
+	for isLetter(l.ch) || isDigit(l.ch) {
 		l.advance()
 	}
 	return l.data[pos:l.pos]
@@ -122,26 +305,107 @@ func (l *Lexer) eatIdent() string {
 
 func (l *Lexer) eatInt() string {
 	pos := l.pos
-	for isDigit(l.ch) {
+	for isDecimal(l.ch) {
 		l.advance()
 	}
 	return l.data[pos:l.pos]
 }
 
+// eatString returns the source of a string literal. A literal that is not
+// closed before the end of its line is reported and ends there.
 func (l *Lexer) eatString() string {
 	pos := l.pos
 	l.advance()
 	for l.ch != '"' {
-		l.advance()
+		switch l.ch {
+		case '\n', eof:
+			l.error(pos, l.pos, "string literal not terminated")
+			return l.data[pos:l.pos]
+		case '\\':
+			l.eatEscape()
+		default:
+			l.advance()
+		}
 	}
 	l.advance()
 	return l.data[pos:l.pos]
 }
 
-func isAlpha(b byte) bool {
-	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '_'
+// eatEscape reads an escape sequence of a string, which are those of Go
+// strings, and reports invalid ones.
+func (l *Lexer) eatEscape() {
+	start := l.pos
+	l.advance()
+
+	var n, base int
+	var max rune
+	switch l.ch {
+	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', '"':
+		l.advance()
+		return
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		n, base, max = 3, 8, 255
+	case 'x':
+		l.advance()
+		n, base, max = 2, 16, 255
+	case 'u':
+		l.advance()
+		n, base, max = 4, 16, unicode.MaxRune
+	case 'U':
+		l.advance()
+		n, base, max = 8, 16, unicode.MaxRune
+	default:
+		if l.ch != '\n' && l.ch != eof {
+			l.advance()
+		}
+		l.error(start, l.pos, "unknown escape sequence")
+		return
+	}
+
+	var r rune
+	for i := 0; i < n; i++ {
+		d := digitVal(l.ch)
+		if d >= base {
+			if l.ch != '\n' && l.ch != eof && l.ch != '"' {
+				l.advance()
+			}
+			l.error(start, l.pos, "invalid escape sequence %s", l.data[start:l.pos])
+			return
+		}
+		r = r*rune(base) + rune(d)
+		l.advance()
+	}
+	if r > max || 0xD800 <= r && r < 0xE000 {
+		l.error(start, l.pos, "escape sequence %s is an invalid code point", l.data[start:l.pos])
+	}
+}
+
+// isLetter reports whether r can start an identifier, which is a letter or _.
+func isLetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '_' || r >= utf8.RuneSelf && unicode.IsLetter(r)
+}
+
+// isDigit reports whether r is a digit, which can appear in identifiers.
+func isDigit(r rune) bool {
+	return isDecimal(r) || r >= utf8.RuneSelf && unicode.IsDigit(r)
+}
+
+// digitVal returns the value of the hexadecimal digit r, or 16 if r is not
+// one.
+func digitVal(r rune) int {
+	switch {
+	case '0' <= r && r <= '9':
+		return int(r - '0')
+	case 'a' <= r && r <= 'f':
+		return int(r - 'a' + 10)
+	case 'A' <= r && r <= 'F':
+		return int(r - 'A' + 10)
+	default:
+		return 16
+	}
 }
 
-func isDigit(b byte) bool {
-	return '0' <= b && b <= '9'
+// isDecimal reports whether r is one of the digits of integer literals.
+func isDecimal(r rune) bool {
+	return '0' <= r && r <= '9'
 }
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"lang/token"
+	"strings"
 	"testing"
 )
 
@@ -39,6 +40,7 @@ func main() {
 		token.Token{Type: token.RETURN, Value: "return"},
 		token.Token{Type: token.SEMICOLON, Value: ";"},
 		token.Token{Type: token.RBRACE, Value: "}"},
+		token.Token{Type: token.SEMICOLON, Value: "\n"},
 	}
 	testIndex := 0
 	for got := lexer.NextToken(); got.Type != token.EOF; got = lexer.NextToken() {
@@ -63,6 +65,7 @@ func TestLexInt(t *testing.T) {
 		token.Token{Type: token.IDENT, Value: "x"},
 		token.Token{Type: token.ASSIGN, Value: "="},
 		token.Token{Type: token.INT, Value: "10"},
+		token.Token{Type: token.SEMICOLON, Value: ""},
 	}
 	testIndex := 0
 	for got := lexer.NextToken(); got.Type != token.EOF; got = lexer.NextToken() {
@@ -93,6 +96,7 @@ func TestLexMath(t *testing.T) {
 		token.Token{Type: token.INT, Value: "3"},
 		token.Token{Type: token.SLASH, Value: "/"},
 		token.Token{Type: token.INT, Value: "4"},
+		token.Token{Type: token.SEMICOLON, Value: ""},
 	}
 	testIndex := 0
 	for got := lexer.NextToken(); got.Type != token.EOF; got = lexer.NextToken() {
@@ -115,12 +119,14 @@ y = 2`
 	lexer := New(input)
 
 	tests := []token.Token{
-		token.New(token.IDENT, "x", 1, 0, ""),
-		token.New(token.ASSIGN, "=", 1, 2, ""),
-		token.New(token.INT, "1", 1, 4, ""),
-		token.New(token.IDENT, "y", 2, 0, ""),
-		token.New(token.ASSIGN, "=", 2, 2, ""),
-		token.New(token.INT, "2", 2, 4, ""),
+		token.New(token.IDENT, "x", 1, 1, ""),
+		token.New(token.ASSIGN, "=", 1, 3, ""),
+		token.New(token.INT, "1", 1, 5, ""),
+		token.New(token.SEMICOLON, "\n", 1, 6, ""),
+		token.New(token.IDENT, "y", 2, 1, ""),
+		token.New(token.ASSIGN, "=", 2, 3, ""),
+		token.New(token.INT, "2", 2, 5, ""),
+		token.New(token.SEMICOLON, "", 2, 6, ""),
 	}
 	testIndex := 0
 	for got := lexer.NextToken(); got.Type != token.EOF; got = lexer.NextToken() {
@@ -140,3 +146,210 @@ y = 2`
 		t.Fatalf("Only produced %d token(s), wanted: %d", testIndex, len(tests))
 	}
 }
+
+func TestLexEllipsis(t *testing.T) {
+	input := `f(xs ...i32, "a\"b") x.y`
+	lexer := New(input)
+
+	tests := []token.Token{
+		token.Token{Type: token.IDENT, Value: "f"},
+		token.Token{Type: token.LPAREN, Value: "("},
+		token.Token{Type: token.IDENT, Value: "xs"},
+		token.Token{Type: token.ELLIPSIS, Value: "..."},
+		token.Token{Type: token.IDENT, Value: "i32"},
+		token.Token{Type: token.COMMA, Value: ","},
+		token.Token{Type: token.STRING, Value: `"a\"b"`},
+		token.Token{Type: token.RPAREN, Value: ")"},
+		token.Token{Type: token.IDENT, Value: "x"},
+		token.Token{Type: token.DOT, Value: "."},
+		token.Token{Type: token.IDENT, Value: "y"},
+		token.Token{Type: token.SEMICOLON, Value: ""},
+	}
+	testIndex := 0
+	for got := lexer.NextToken(); got.Type != token.EOF; got = lexer.NextToken() {
+		want := tests[testIndex]
+
+		if got.Type != want.Type || got.Value != want.Value {
+			t.Fatalf("[%d] got: %v, want: %v", testIndex, got, want)
+		}
+		testIndex += 1
+	}
+
+	if testIndex < len(tests) {
+		t.Fatalf("Only produced %d token(s), wanted: %d", testIndex, len(tests))
+	}
+}
+
+func TestPositions(t *testing.T) {
+	fset := token.NewFileSet()
+	fset.AddFile("a.lang", []byte("var a i32 = 1\n"))
+	file := fset.AddFile("b.lang", []byte("f(\"é\", xs)\n"))
+	lexer := NewFile(file)
+
+	tests := []struct {
+		value     string
+		offset    int
+		line      int
+		column    int
+		endColumn int
+	}{
+		{"f", 0, 1, 1, 2},
+		{"(", 1, 1, 2, 3},
+		{`"é"`, 2, 1, 3, 6},
+		{",", 6, 1, 6, 7},
+		{"xs", 8, 1, 8, 10},
+		{")", 10, 1, 10, 11},
+		{"\n", 11, 1, 11, 1},
+		{"", 12, 2, 1, 1},
+	}
+	for i, tt := range tests {
+		got := lexer.NextToken()
+		if got.Value != tt.value || got.Filename != "b.lang" || got.Line != tt.line || got.Column != tt.column {
+			t.Fatalf("[%d] got %v, want %q at %d:%d", i, got, tt.value, tt.line, tt.column)
+		}
+		if pos := fset.Position(got.Pos); pos.Offset != tt.offset || pos.Column != tt.column {
+			t.Fatalf("[%d] got position %+v, want offset %d", i, pos, tt.offset)
+		}
+		if end := fset.Position(got.End()); end.Column != tt.endColumn {
+			t.Fatalf("[%d] got end %+v, want column %d", i, end, tt.endColumn)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "\uFEFFvar π i32 = 1\nnaïve_2 @ \xff x٣"
+	lexer := New(input)
+
+	tests := []struct {
+		typ    token.TokenType
+		value  string
+		line   int
+		column int
+	}{
+		{token.VAR, "var", 1, 1},
+		{token.IDENT, "π", 1, 5},
+		{token.IDENT, "i32", 1, 7},
+		{token.ASSIGN, "=", 1, 11},
+		{token.INT, "1", 1, 13},
+		{token.SEMICOLON, "\n", 1, 14},
+		{token.IDENT, "naïve_2", 2, 1},
+		{token.ILLEGAL, "@", 2, 9},
+		{token.ILLEGAL, "\xff", 2, 11},
+		{token.IDENT, "x٣", 2, 13},
+		{token.SEMICOLON, "", 2, 15},
+		{token.EOF, "", 2, 15},
+	}
+	for i, tt := range tests {
+		got := lexer.NextToken()
+		if got.Type != tt.typ || got.Value != tt.value || got.Line != tt.line || got.Column != tt.column {
+			t.Fatalf("[%d] got %v, want %s %q at %d:%d", i, got, tt.typ, tt.value, tt.line, tt.column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := "x // y\n/* z\n*/ w /**/ / v /*\n*/ u"
+	lexer := New(input)
+
+	want := []string{"x", "\n", "w", "/", "v", "\n", "u", "", ""}
+	for i, value := range want {
+		if got := lexer.NextToken(); got.Value != value {
+			t.Fatalf("[%d] got %v, want %q", i, got, value)
+		}
+	}
+	if len(lexer.Errors) > 0 {
+		t.Fatalf("unexpected errors %v", lexer.Errors)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		tokens []string
+		err    string
+	}{
+		{`"abc`, []string{`"abc`, ""}, ":1:1: error: string literal not terminated"},
+		{"\"abc\nx", []string{`"abc`, "\n", "x", ""}, ":1:1: error: string literal not terminated"},
+		{`"a\qb" x`, []string{`"a\qb"`, "x", ""}, ":1:3: error: unknown escape sequence"},
+		{`"\x4g" x`, []string{`"\x4g"`, "x", ""}, `:1:2: error: invalid escape sequence \x4g`},
+		{`"\400"`, []string{`"\400"`, ""}, `:1:2: error: escape sequence \400 is an invalid code point`},
+		{`"\U00110000"`, []string{`"\U00110000"`, ""}, `:1:2: error: escape sequence \U00110000 is an invalid code point`},
+		{"x /* y\nz", []string{"x", "\n"}, ":1:3: error: comment not terminated"},
+		{"x @ y", []string{"x", "@", "y", ""}, ":1:3: error: invalid character U+0040 '@'"},
+		{"x \x80 y", []string{"x", "\x80", "y", ""}, ":1:3: error: invalid UTF-8 encoding"},
+	}
+
+	for i, tt := range tests {
+		lexer := New(tt.input)
+		for _, value := range append(tt.tokens, "") {
+			if got := lexer.NextToken(); got.Value != value {
+				t.Fatalf("[%d] got %v, want %q", i, got, value)
+			}
+		}
+		if len(lexer.Errors) != 1 || lexer.Errors[0].Error() != tt.err {
+			t.Errorf("[%d] expected %q, got %v", i, tt.err, lexer.Errors)
+		}
+	}
+}
+
+func TestSemicolons(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"x\ny", "x ; y ;"},
+		{"return\n1", "return ; 1 ;"},
+		{"f(x)\n}\n]\n", "f ( x ) ; } ; ] ;"},
+		{"none // c\n1 /* a\nb */ 2", "none ; 1 ; 2 ;"},
+		{"a; b", "a ; b ;"},
+		{"x +\ny", "x + y ;"},
+		{"f(x,\ny) {\n}", "f ( x , y ) { } ;"},
+		{"x\n\n\ny", "x ; y ;"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		lexer := New(tt.input)
+		values := make([]string, 0)
+		for tok := lexer.NextToken(); tok.Type != token.EOF; tok = lexer.NextToken() {
+			if tok.Type == token.SEMICOLON {
+				values = append(values, ";")
+			} else {
+				values = append(values, tok.Value)
+			}
+		}
+		if got := strings.Join(values, " "); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestScanComments(t *testing.T) {
+	input := "x // a\n/* b */ y /* c */ z /* d\n*/ return /* e */\n"
+	lexer := New(input)
+	lexer.ScanComments = true
+
+	want := []struct {
+		typ   token.TokenType
+		value string
+	}{
+		{token.IDENT, "x"},
+		{token.SEMICOLON, "\n"},
+		{token.COMMENT, "// a"},
+		{token.COMMENT, "/* b */"},
+		{token.IDENT, "y"},
+		{token.COMMENT, "/* c */"},
+		{token.IDENT, "z"},
+		{token.SEMICOLON, "\n"},
+		{token.COMMENT, "/* d\n*/"},
+		{token.RETURN, "return"},
+		{token.SEMICOLON, "\n"},
+		{token.COMMENT, "/* e */"},
+		{token.EOF, ""},
+	}
+	for i, tt := range want {
+		got := lexer.NextToken()
+		if got.Type != tt.typ || got.Value != tt.value {
+			t.Fatalf("[%d] got %v, want %s %q", i, got, tt.typ, tt.value)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package llvm
+
+import (
+	"fmt"
+	"lang/ast"
+	"lang/types"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	irtypes "github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// abiKind is how a value is passed to or returned from a C function under the
+// System V x86-64 calling convention.
+type abiKind int
+
+const (
+	// abiDirect values are passed as they are.
+	abiDirect abiKind = iota
+
+	// abiCoerce values are aggregates of at most 16 bytes, which are split
+	// into their eightbytes and passed in registers.
+	abiCoerce
+
+	// abiIndirect values are aggregates larger than 16 bytes, which are
+	// passed in memory. Arguments are copied to the stack with byval and
+	// results are written through an sret pointer that the caller passes.
+	abiIndirect
+)
+
+// cValue describes how a value of type typ crosses the C ABI. Parts are the
+// types of the eightbytes of coerced values.
+type cValue struct {
+	typ   irtypes.Type
+	kind  abiKind
+	parts []irtypes.Type
+}
+
+// classify classifies values of type t. Values of this language contain only
+// integers and pointers, so all eightbytes are of class INTEGER.
+func classify(t irtypes.Type) *cValue {
+	switch t.(type) {
+	case *irtypes.StructType, *irtypes.ArrayType:
+	default:
+		return &cValue{typ: t, kind: abiDirect}
+	}
+
+	size := sizeOf(t)
+	if size > 16 {
+		return &cValue{typ: t, kind: abiIndirect}
+	}
+
+	scalars := flatten(t, 0, nil)
+	cv := &cValue{typ: t, kind: abiCoerce}
+	for off := int64(0); off < size; off += 8 {
+		cv.parts = append(cv.parts, eightbyte(scalars, off, size))
+	}
+	return cv
+}
+
+// scalar is an integer or pointer at an offset in an aggregate.
+type scalar struct {
+	off int64
+	typ irtypes.Type
+}
+
+// flatten appends the scalars of t at offset off to scalars.
+func flatten(t irtypes.Type, off int64, scalars []scalar) []scalar {
+	switch v := t.(type) {
+	case *irtypes.StructType:
+		for _, f := range v.Fields {
+			off = align(off, alignOf(f))
+			scalars = flatten(f, off, scalars)
+			off += sizeOf(f)
+		}
+	case *irtypes.ArrayType:
+		for i := uint64(0); i < v.Len; i++ {
+			scalars = flatten(v.ElemType, off+int64(i)*sizeOf(v.ElemType), scalars)
+		}
+	default:
+		scalars = append(scalars, scalar{off: off, typ: t})
+	}
+	return scalars
+}
+
+// eightbyte returns the type that the eightbyte at off of an aggregate of the
+// given size is passed as. Like in clang, a scalar that starts the eightbyte
+// and is the only data in it keeps its type, otherwise the eightbyte becomes
+// an integer that covers the rest of the aggregate, up to 8 bytes.
+func eightbyte(scalars []scalar, off, size int64) irtypes.Type {
+	in := make([]scalar, 0)
+	for _, s := range scalars {
+		if s.off >= off && s.off < off+8 {
+			in = append(in, s)
+		}
+	}
+	if len(in) == 1 && in[0].off == off {
+		return in[0].typ
+	}
+
+	end := off + 8
+	if size < end {
+		end = size
+	}
+	return irtypes.NewInt(uint64(end-off) * 8)
+}
+
+// coerced returns the type that holds the eightbytes of cv in memory.
+func (cv *cValue) coerced() *irtypes.StructType {
+	return irtypes.NewStruct(cv.parts...)
+}
+
+// extends reports whether cv is an integer narrower than int, which C
+// extends to an int. Integers of this language narrower than i32 are
+// unsigned.
+func (cv *cValue) extends() bool {
+	t, ok := cv.typ.(*irtypes.IntType)
+	return ok && t.BitSize < 32
+}
+
+// cFunc is the signature of a function with the C ABI.
+type cFunc struct {
+	params []*cValue
+	result *cValue
+}
+
+// lowerC classifies the parameters and the result of a function.
+func lowerC(params []irtypes.Type, result irtypes.Type) *cFunc {
+	cf := &cFunc{result: classify(result)}
+	for _, p := range params {
+		cf.params = append(cf.params, classify(p))
+	}
+	return cf
+}
+
+// lowered reports whether the C signature differs from the signature that
+// functions of this language have.
+func (cf *cFunc) lowered() bool {
+	if cf.result.kind != abiDirect {
+		return true
+	}
+	for _, p := range cf.params {
+		if p.kind != abiDirect {
+			return true
+		}
+	}
+	return false
+}
+
+// newFunc adds a function with the C signature cf to the module.
+func (cf *cFunc) newFunc(m *ir.Module, name string, names []string) *ir.Func {
+	ret, params := cf.signature(names)
+	f := m.NewFunc(name, ret, params...)
+	if cf.result.kind == abiDirect && cf.result.extends() {
+		f.ReturnAttrs = append(f.ReturnAttrs, enum.ReturnAttrZeroExt)
+	}
+	return f
+}
+
+// signature returns the result type and the parameters of the C signature.
+// Coerced parameters are split into one parameter per eightbyte.
+func (cf *cFunc) signature(names []string) (irtypes.Type, []*ir.Param) {
+	params := make([]*ir.Param, 0)
+	var ret irtypes.Type
+	switch cf.result.kind {
+	case abiIndirect:
+		p := ir.NewParam("", irtypes.NewPointer(cf.result.typ))
+		p.Attrs = append(p.Attrs, ir.SRet{Typ: cf.result.typ})
+		params = append(params, p)
+		ret = irtypes.Void
+	case abiCoerce:
+		ret = cf.result.parts[0]
+		if len(cf.result.parts) > 1 {
+			ret = cf.result.coerced()
+		}
+	default:
+		ret = cf.result.typ
+	}
+
+	for i, cv := range cf.params {
+		switch cv.kind {
+		case abiIndirect:
+			p := ir.NewParam(names[i], irtypes.NewPointer(cv.typ))
+			p.Attrs = append(p.Attrs, ir.Byval{Typ: cv.typ}, ir.Align(alignOf(cv.typ)))
+			params = append(params, p)
+		case abiCoerce:
+			for j, part := range cv.parts {
+				params = append(params, ir.NewParam(fmt.Sprintf("%s.coerce%d", names[i], j), part))
+			}
+		default:
+			p := ir.NewParam(names[i], cv.typ)
+			if cv.extends() {
+				p.Attrs = append(p.Attrs, enum.ParamAttrZeroExt)
+			}
+			params = append(params, p)
+		}
+	}
+
+	return ret, params
+}
+
+// declareC declares an extern function with the C ABI.
+func (g *Generator) declareC(fd *ast.FuncDecl, name string) {
+	params := make([]irtypes.Type, 0)
+	names := make([]string, 0)
+	for _, p := range fd.Params {
+		t := g.irType(p.Type.Type)
+		if sig, ok := p.Type.Type.(*types.Func); ok {
+			t = g.cFuncPtr(sig)
+		}
+		params = append(params, t)
+		names = append(names, p.Token.Value)
+	}
+
+	cf := lowerC(params, g.irType(fd.Result()))
+	f := cf.newFunc(g.module, name, names)
+	f.Sig.Variadic = fd.VarArgs
+
+	g.funcs[name] = f
+	g.decls[name] = fd
+	g.cfuncs[f] = cf
+}
+
+// cFuncPtr returns the type of pointers to C functions with the signature
+// sig, which is how C takes callbacks.
+func (g *Generator) cFuncPtr(sig *types.Func) irtypes.Type {
+	params := make([]irtypes.Type, 0)
+	for _, p := range sig.Params {
+		params = append(params, g.irType(p))
+	}
+	ret, ps := lowerC(params, g.irType(sig.Return)).signature(make([]string, len(params)))
+	pts := make([]irtypes.Type, 0)
+	for _, p := range ps {
+		pts = append(pts, p.Typ)
+	}
+	return irtypes.NewPointer(irtypes.NewFunc(ret, pts...))
+}
+
+// call calls f with arguments of the types of this language. Calls of C
+// functions lower the arguments and the result to the C ABI.
+func (g *Generator) call(b *ir.Block, f *ir.Func, args []value.Value) value.Value {
+	cf, ok := g.cfuncs[f]
+	if !ok {
+		return b.NewCall(f, args...)
+	}
+
+	entry := b.Parent.Blocks[0]
+	cargs := make([]value.Value, 0)
+
+	var sret value.Value
+	if cf.result.kind == abiIndirect {
+		sret = entry.NewAlloca(cf.result.typ)
+		cargs = append(cargs, sret)
+	}
+
+	for i, arg := range args {
+		// The varargs after the fixed parameters are promoted scalars.
+		if i >= len(cf.params) {
+			cargs = append(cargs, arg)
+			continue
+		}
+		switch cv := cf.params[i]; cv.kind {
+		case abiIndirect:
+			tmp := entry.NewAlloca(cv.typ)
+			b.NewStore(arg, tmp)
+			cargs = append(cargs, tmp)
+		case abiCoerce:
+			cargs = append(cargs, split(b, entry, cv, arg)...)
+		default:
+			cargs = append(cargs, arg)
+		}
+	}
+
+	ret := b.NewCall(f, cargs...)
+	switch cv := cf.result; cv.kind {
+	case abiIndirect:
+		return b.NewLoad(cv.typ, sret)
+	case abiCoerce:
+		parts := []value.Value{ret}
+		if len(cv.parts) > 1 {
+			parts = parts[:0]
+			for i := range cv.parts {
+				parts = append(parts, b.NewExtractValue(ret, uint64(i)))
+			}
+		}
+		return join(b, entry, cv, parts)
+	default:
+		return ret
+	}
+}
+
+// cCallback returns a function with the C ABI that calls the function fd,
+// which can be passed to C as a callback. Functions whose signature is the
+// same under the C ABI are passed directly.
+func (g *Generator) cCallback(fd *ast.FuncDecl) *ir.Func {
+	name := funcName(fd)
+	f := g.funcs[name]
+
+	params := make([]irtypes.Type, 0)
+	names := make([]string, 0)
+	for _, p := range f.Params {
+		params = append(params, p.Typ)
+		names = append(names, p.LocalName)
+	}
+	cf := lowerC(params, f.Sig.RetType)
+	if !cf.lowered() {
+		return f
+	}
+
+	if w, ok := g.funcs[name+".c"]; ok {
+		return w
+	}
+	w := cf.newFunc(g.module, name+".c", names)
+	g.funcs[name+".c"] = w
+	g.genCWrapper(w, cf, f)
+
+	return w
+}
+
+// exportC makes the exported function f callable from C under its name. The
+// native function is renamed if its signature changes under the C ABI, and a
+// wrapper takes its symbol.
+func (g *Generator) exportC(fd *ast.FuncDecl, f *ir.Func) {
+	g.exports = append(g.exports, fd)
+
+	params := make([]irtypes.Type, 0)
+	names := make([]string, 0)
+	for _, p := range f.Params {
+		params = append(params, p.Typ)
+		names = append(names, p.LocalName)
+	}
+	cf := lowerC(params, f.Sig.RetType)
+	if !cf.lowered() {
+		if cf.result.extends() {
+			f.ReturnAttrs = append(f.ReturnAttrs, enum.ReturnAttrZeroExt)
+		}
+		for i, cv := range cf.params {
+			if cv.extends() {
+				f.Params[i].Attrs = append(f.Params[i].Attrs, enum.ParamAttrZeroExt)
+			}
+		}
+		return
+	}
+
+	name := f.Name()
+	f.SetName(name + ".native")
+	w := cf.newFunc(g.module, name, names)
+	g.funcs[name+".c"] = w
+	g.genCWrapper(w, cf, f)
+}
+
+// genCWrapper generates the body of w, a function with the C signature cf,
+// which converts its parameters, calls f and converts the result back.
+func (g *Generator) genCWrapper(w *ir.Func, cf *cFunc, f *ir.Func) {
+	b := w.NewBlock("")
+	ps := w.Params
+
+	var sret value.Value
+	if cf.result.kind == abiIndirect {
+		sret = ps[0]
+		ps = ps[1:]
+	}
+
+	args := make([]value.Value, 0)
+	for _, cv := range cf.params {
+		switch cv.kind {
+		case abiIndirect:
+			args = append(args, b.NewLoad(cv.typ, ps[0]))
+			ps = ps[1:]
+		case abiCoerce:
+			parts := make([]value.Value, 0)
+			for range cv.parts {
+				parts = append(parts, ps[0])
+				ps = ps[1:]
+			}
+			args = append(args, join(b, b, cv, parts))
+		default:
+			args = append(args, ps[0])
+			ps = ps[1:]
+		}
+	}
+
+	ret := b.NewCall(f, args...)
+	switch cv := cf.result; cv.kind {
+	case abiIndirect:
+		b.NewStore(ret, sret)
+		b.NewRet(nil)
+	case abiCoerce:
+		parts := split(b, b, cv, ret)
+		if len(parts) == 1 {
+			b.NewRet(parts[0])
+			return
+		}
+		var v value.Value = constant.NewUndef(cv.coerced())
+		for i, part := range parts {
+			v = b.NewInsertValue(v, part, uint64(i))
+		}
+		b.NewRet(v)
+	default:
+		if _, ok := cv.typ.(*irtypes.VoidType); ok {
+			b.NewRet(nil)
+		} else {
+			b.NewRet(ret)
+		}
+	}
+}
+
+// split stores an aggregate in a stack slot and loads its eightbytes.
+func split(b, entry *ir.Block, cv *cValue, v value.Value) []value.Value {
+	tmp := entry.NewAlloca(cv.coerced())
+	tmp.Align = ir.Align(8)
+	b.NewStore(v, b.NewBitCast(tmp, irtypes.NewPointer(cv.typ)))
+
+	parts := make([]value.Value, 0)
+	for i, part := range cv.parts {
+		p := b.NewGetElementPtr(tmp.ElemType, tmp, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, int64(i)))
+		parts = append(parts, b.NewLoad(part, p))
+	}
+	return parts
+}
+
+// join stores the eightbytes of an aggregate in a stack slot and loads the
+// aggregate.
+func join(b, entry *ir.Block, cv *cValue, parts []value.Value) value.Value {
+	tmp := entry.NewAlloca(cv.coerced())
+	tmp.Align = ir.Align(8)
+	for i, part := range parts {
+		p := b.NewGetElementPtr(tmp.ElemType, tmp, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, int64(i)))
+		b.NewStore(part, p)
+	}
+	return b.NewLoad(cv.typ, b.NewBitCast(tmp, irtypes.NewPointer(cv.typ)))
+}
@@ -0,0 +1,91 @@
+package llvm
+
+import (
+	"lang/checker"
+	"lang/lexer"
+	"lang/parser"
+	"strings"
+	"testing"
+
+	irtypes "github.com/llir/llvm/ir/types"
+)
+
+func TestClassify(t *testing.T) {
+	i8, i32, i64 := irtypes.I8, irtypes.I32, irtypes.I64
+	ptr := irtypes.NewPointer(i8)
+	tests := []struct {
+		typ  irtypes.Type
+		kind abiKind
+		want string
+	}{
+		{i32, abiDirect, ""},
+		{irtypes.NewStruct(ptr, i32), abiCoerce, "i8*, i32"},
+		{irtypes.NewStruct(i32, i32), abiCoerce, "i64"},
+		{irtypes.NewStruct(i32, i32, i32), abiCoerce, "i64, i32"},
+		{irtypes.NewStruct(i8, i8, i8), abiCoerce, "i24"},
+		{irtypes.NewStruct(i8, i64), abiCoerce, "i8, i64"},
+		{irtypes.NewStruct(i32, irtypes.NewArray(1, i64)), abiCoerce, "i32, i64"},
+		{irtypes.NewStruct(i64, i64, i64), abiIndirect, ""},
+	}
+
+	for _, tt := range tests {
+		cv := classify(tt.typ)
+		var parts []string
+		for _, p := range cv.parts {
+			parts = append(parts, p.String())
+		}
+		got := strings.Join(parts, ", ")
+		if cv.kind != tt.kind || got != tt.want {
+			t.Errorf("classify(%s) = %d %q, want %d %q", tt.typ, cv.kind, got, tt.kind, tt.want)
+		}
+	}
+}
+
+func TestExternABI(t *testing.T) {
+	input := `
+func total(s []u8) i32
+func mid(s []u8) []u8
+func some(x i32) ?i32
+func wrap(s []u8) ?[]u8
+func count(b ?[]u8) i32
+func first(s []u8) u8
+func apply(f func([]u8) i32, s []u8) i32
+func bytes(xs ...u8) []u8 {
+	return xs
+}
+func size(s []u8) i32 {
+	return len(s)
+}
+func main() i32 {
+	var s []u8 = mid(bytes(1, 2, 3))
+	return apply(size, s)
+}
+`
+	l := lexer.New(input)
+	p := parser.New(l)
+	prog, ok := p.ParseProgram()
+	if !ok {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+	c := checker.New(prog)
+	c.Check()
+	if len(c.Errors) > 0 {
+		t.Fatalf("check errors: %v", c.Errors)
+	}
+
+	code := NewGenerator().Generate(prog)
+	for _, want := range []string{
+		"declare i32 @total(i8* %s.coerce0, i32 %s.coerce1)",
+		"declare { i8*, i32 } @mid(i8* %s.coerce0, i32 %s.coerce1)",
+		"declare { i32, i64 } @some(i32 %x)",
+		`declare void @wrap(%"?[]u8"* sret(%"?[]u8") %0, i8* %s.coerce0, i32 %s.coerce1)`,
+		`declare i32 @count(%"?[]u8"* byval(%"?[]u8") align 8 %b)`,
+		"declare zeroext i8 @first(i8* %s.coerce0, i32 %s.coerce1)",
+		"declare i32 @apply(i32 (i8*, i32)* %f, i8* %s.coerce0, i32 %s.coerce1)",
+		"define i32 @size.c(i8* %s.coerce0, i32 %s.coerce1)",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %q in\n%s", want, code)
+		}
+	}
+}
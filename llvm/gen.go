@@ -5,33 +5,115 @@ import (
 	"lang/ast"
 	"lang/token"
 	"lang/types"
+	"strings"
 
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
 	irtypes "github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
 )
 
 type Generator struct {
+	// Triple is the target triple of the module, or empty for the default
+	// target of the tools that compile it.
+	Triple string
+
 	module   *ir.Module
+	decl     *ast.FuncDecl
 	function *ir.Func
+	entry    *ir.Block
 	block    *ir.Block
+	cleanup  *ir.Block
+	retval   *ir.InstAlloca
+	defers   []*deferred
 	add      *ir.Func
+	malloc   *ir.Func
 	funcs    map[string]*ir.Func
-	vars     map[string]value.Value
+	decls    map[string]*ast.FuncDecl
+	vars     map[*ast.VarDecl]value.Value
+	ifaces   map[*types.Interface]*irtypes.StructType
+	unions   map[string]*irtypes.StructType
+	vtables  map[string]*ir.Global
+	typeArgs map[*types.TypeParam]types.Type
+	pending  []*instance
+	lits     map[*ast.FuncDecl]*ast.FuncLit
+	cfuncs   map[*ir.Func]*cFunc
+	exports  []*ast.FuncDecl
+	closures int
+	strings  int
+}
+
+// instance is a generic function instantiated with concrete type arguments or
+// a function literal, whose body still has to be generated.
+type instance struct {
+	fd       *ast.FuncDecl
+	name     string
+	typeArgs map[*types.TypeParam]types.Type
+}
+
+// deferred holds the stack slots of a defer statement. The arguments are
+// stored at the defer site and flag records whether the site was reached, so
+// that the cleanup blocks only run the calls that were actually deferred.
+type deferred struct {
+	node *ast.Defer
+	flag *ir.InstAlloca
+	fn   *ir.InstAlloca
+	args []*ir.InstAlloca
 }
 
 func NewGenerator() *Generator {
 	return &Generator{
-		module: ir.NewModule(),
-		funcs:  make(map[string]*ir.Func),
-		vars:   make(map[string]value.Value),
+		module:  ir.NewModule(),
+		funcs:   make(map[string]*ir.Func),
+		decls:   make(map[string]*ast.FuncDecl),
+		vars:    make(map[*ast.VarDecl]value.Value),
+		ifaces:  make(map[*types.Interface]*irtypes.StructType),
+		unions:  make(map[string]*irtypes.StructType),
+		lits:    make(map[*ast.FuncDecl]*ast.FuncLit),
+		cfuncs:  make(map[*ir.Func]*cFunc),
+		vtables: make(map[string]*ir.Global),
 	}
 }
 
-func (g *Generator) Generate(program *ast.Program) string {
-	for _, s := range program.Statements {
-		g.genNode(s)
+// Generate generates a module for the packages of a program, which are
+// linked into one module.
+func (g *Generator) Generate(programs ...*ast.Program) string {
+	g.module.TargetTriple = g.Triple
+
+	for _, program := range programs {
+		for _, s := range program.Statements {
+			if vd, ok := s.(*ast.VarDecl); ok {
+				g.declareGlobal(program, vd)
+				continue
+			}
+			fd, ok := s.(*ast.FuncDecl)
+			if !ok || len(fd.TypeParams) > 0 {
+				continue
+			}
+			// Packages can declare the same C function.
+			if _, ok := g.funcs[funcName(fd)]; ok && fd.Extern {
+				continue
+			}
+			g.declareFunc(fd, funcName(fd))
+		}
+	}
+
+	for _, program := range programs {
+		for _, s := range program.Statements {
+			if _, ok := s.(*ast.VarDecl); !ok {
+				g.genNode(s)
+			}
+		}
+	}
+
+	for len(g.pending) > 0 {
+		inst := g.pending[0]
+		g.pending = g.pending[1:]
+
+		g.typeArgs = inst.typeArgs
+		g.genFunc(inst.fd, inst.name)
+		g.typeArgs = nil
 	}
 
 	return g.module.String()
@@ -39,20 +121,44 @@ func (g *Generator) Generate(program *ast.Program) string {
 
 func (g *Generator) genNode(n ast.Node) value.Value {
 	switch v := n.(type) {
+	case *ast.Defer:
+		return g.genDefer(v)
+	case *ast.Err:
+		return g.genNode(v.X)
 	case *ast.FuncCall:
 		return g.genFuncCall(v)
 	case *ast.FuncDecl:
 		return g.genFuncDecl(v)
+	case *ast.FuncLit:
+		return g.genFuncLit(v)
+	case *ast.Assign:
+		return g.genAssign(v)
 	case *ast.InfixExpression:
 		return g.genInfixExpression(v)
 	case *ast.IntLiteral:
 		return g.genIntLiteral(v)
+	case *ast.StringLiteral:
+		return g.genStringLiteral(v)
+	case *ast.Index:
+		return g.genIndex(v)
+	case *ast.MethodCall:
+		return g.genMethodCall(v)
+	case *ast.None:
+		return nil
 	case *ast.Return:
 		return g.genReturn(v)
+	case *ast.Selector:
+		return g.genSelector(v)
+	case *ast.Switch:
+		return g.genSwitch(v)
+	case *ast.Try:
+		return g.genTry(v)
 	case *ast.Var:
 		return g.genVar(v)
 	case *ast.VarDecl:
 		return g.genVarDecl(v)
+	case *ast.TypeDecl:
+		return nil
 	default:
 		panic(fmt.Sprintf("cannot generate %T", v))
 	}
@@ -63,49 +169,576 @@ func (g *Generator) genFuncCall(fc *ast.FuncCall) value.Value {
 		panic("block is nil")
 	}
 
+	if fc.Builtin {
+		return g.block.NewExtractValue(g.genNode(fc.Args[0]), 1)
+	}
+
+	args := g.genArgs(fc)
+	if fc.VarDecl != nil {
+		return g.callClosure(g.block, g.callee(fc), args)
+	}
+	return g.call(g.block, g.getFunc(fc), args)
+}
+
+// genArgs generates the arguments of a call. The arguments after the fixed
+// parameters of a variadic function are passed as a slice, and those of a C
+// function with varargs are promoted like in C.
+func (g *Generator) genArgs(fc *ast.FuncCall) []value.Value {
+	sig := g.signature(fc)
+	rest := sig.Rest()
+	varArgs := fc.FuncDecl != nil && fc.FuncDecl.VarArgs
+
+	n := len(fc.Args)
+	switch {
+	case rest != nil:
+		n = len(sig.Params) - 1
+	case varArgs:
+		n = len(sig.Params)
+	}
+
 	args := make([]value.Value, 0)
-	for _, n := range fc.Args {
-		args = append(args, g.genNode(n))
+	for i, arg := range fc.Args[:n] {
+		args = append(args, g.genArg(fc, i, arg))
+	}
+
+	switch {
+	case rest != nil:
+		args = append(args, g.genSlice(rest, fc.Args[n:]))
+	case varArgs:
+		for _, arg := range fc.Args[n:] {
+			t := g.subst(arg.Type())
+			args = append(args, g.convert(g.genNode(arg), t, types.Promote(t)))
+		}
+	}
+
+	return args
+}
+
+// argTypes returns the types of the arguments that genArgs generates for a
+// call.
+func (g *Generator) argTypes(fc *ast.FuncCall) []irtypes.Type {
+	sig := g.signature(fc)
+	ts := make([]irtypes.Type, 0)
+	for _, p := range sig.Params {
+		t := g.irType(p)
+		if f, ok := p.(*types.Func); ok && fc.FuncDecl != nil && fc.FuncDecl.Extern {
+			t = g.cFuncPtr(f)
+		}
+		ts = append(ts, t)
+	}
+
+	if fc.FuncDecl != nil && fc.FuncDecl.VarArgs {
+		for _, arg := range fc.Args[len(sig.Params):] {
+			ts = append(ts, g.irType(types.Promote(g.subst(arg.Type()))))
+		}
+	}
+
+	return ts
+}
+
+// genSlice stores values in an array on the stack and returns a slice of it.
+func (g *Generator) genSlice(elem types.Type, values []ast.Expression) value.Value {
+	t := g.irType(&types.Slice{Elem: elem}).(*irtypes.StructType)
+
+	var data value.Value = constant.NewNull(t.Fields[0].(*irtypes.PointerType))
+	if len(values) > 0 {
+		arrType := irtypes.NewArray(uint64(len(values)), g.irType(elem))
+		arr := g.entry.NewAlloca(arrType)
+		zero := constant.NewInt(irtypes.I32, 0)
+		for i, n := range values {
+			p := g.block.NewGetElementPtr(arrType, arr, zero, constant.NewInt(irtypes.I32, int64(i)))
+			g.block.NewStore(g.convert(g.genNode(n), n.Type(), elem), p)
+		}
+		data = g.block.NewGetElementPtr(arrType, arr, zero, zero)
+	}
+
+	slice := g.block.NewInsertValue(constant.NewUndef(t), data, 0)
+	return g.block.NewInsertValue(slice, constant.NewInt(irtypes.I32, int64(len(values))), 1)
+}
+
+// genArg generates the i-th argument of a call. Extern functions take
+// callbacks as plain function pointers instead of closures.
+func (g *Generator) genArg(fc *ast.FuncCall, i int, n ast.Expression) value.Value {
+	if fc.FuncDecl != nil && fc.FuncDecl.Extern {
+		if v, ok := n.(*ast.Var); ok && v.FuncDecl != nil {
+			return g.cCallback(v.FuncDecl)
+		}
+	}
+	return g.convert(g.genNode(n), n.Type(), g.paramType(fc, i))
+}
+
+// callee loads the closure called by a call through a function value.
+func (g *Generator) callee(fc *ast.FuncCall) value.Value {
+	slot := g.vars[fc.VarDecl]
+	return g.block.NewLoad(slot.Type().(*irtypes.PointerType).ElemType, slot)
+}
+
+// callClosure calls the function of a closure with its environment.
+func (g *Generator) callClosure(b *ir.Block, closure value.Value, args []value.Value) value.Value {
+	f := b.NewExtractValue(closure, 0)
+	env := b.NewExtractValue(closure, 1)
+	return b.NewCall(f, append([]value.Value{env}, args...)...)
+}
+
+// signature returns the type of the called function, with the type
+// parameters of generic functions replaced.
+func (g *Generator) signature(fc *ast.FuncCall) *types.Func {
+	return g.subst(fc.Signature()).(*types.Func)
+}
+
+// paramType returns the type of the i-th parameter of the called function.
+func (g *Generator) paramType(fc *ast.FuncCall, i int) types.Type {
+	return g.signature(fc).Params[i]
+}
+
+func (g *Generator) genMethodCall(mc *ast.MethodCall) value.Value {
+	if g.block == nil {
+		panic("block is nil")
+	}
+
+	if mc.Variant != nil {
+		return g.genVariant(mc.Variant, mc.Args)
 	}
 
-	f, ok := g.funcs[fc.Token.Value]
+	if mc.FuncDecl != nil {
+		return g.genStaticMethodCall(mc, mc.FuncDecl)
+	}
+
+	t := g.subst(mc.Receiver.Type())
+	iface, ok := t.(*types.Interface)
 	if !ok {
-		panic(fmt.Sprintf("Cannot find func %s", fc.Token.Value))
+		fd, ok := g.decls[methodName(t, mc.Token.Value)]
+		if !ok {
+			panic(fmt.Sprintf("cannot call method %s on %s", mc.Token.Value, types.String(t)))
+		}
+		return g.genStaticMethodCall(mc, fd)
+	}
+	idx, m, ok := iface.Method(mc.Token.Value)
+	if !ok {
+		panic(fmt.Sprintf("Cannot find method %s", mc.Token.Value))
 	}
+
+	recv := g.genNode(mc.Receiver)
+	vt := g.block.NewExtractValue(recv, 1)
+	vtType := vt.Type().(*irtypes.PointerType).ElemType
+	fp := g.block.NewGetElementPtr(vtType, vt, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, int64(idx)))
+	f := g.block.NewLoad(fp.Type().(*irtypes.PointerType).ElemType, fp)
+
+	args := []value.Value{g.block.NewExtractValue(recv, 0)}
+	for i, n := range mc.Args {
+		args = append(args, g.convert(g.genNode(n), n.Type(), m.Sig.Params[i]))
+	}
+
 	return g.block.NewCall(f, args...)
 }
 
-func (g *Generator) genFuncDecl(fd *ast.FuncDecl) value.Value {
+// genStaticMethodCall calls a method of a named type, taking the address of
+// or dereferencing the receiver to match the method's receiver type.
+func (g *Generator) genStaticMethodCall(mc *ast.MethodCall, fd *ast.FuncDecl) value.Value {
+	var recv value.Value
+	_, wantPtr := fd.Receiver.Type.Type.(*types.Pointer)
+	_, isPtr := g.subst(mc.Receiver.Type()).(*types.Pointer)
+	switch {
+	case wantPtr && !isPtr:
+		v := mc.Receiver.(*ast.Var)
+		recv = g.vars[v.VarDecl]
+	case !wantPtr && isPtr:
+		p := g.genNode(mc.Receiver)
+		recv = g.block.NewLoad(p.Type().(*irtypes.PointerType).ElemType, p)
+	default:
+		recv = g.genNode(mc.Receiver)
+	}
+
+	args := []value.Value{recv}
+	for i, n := range mc.Args {
+		args = append(args, g.convert(g.genNode(n), n.Type(), fd.Params[i].Type.Type))
+	}
+
+	return g.block.NewCall(g.funcs[funcName(fd)], args...)
+}
+
+// declareFunc adds the function to the module, so that it can be called
+// before its definition is generated.
+func (g *Generator) declareFunc(fd *ast.FuncDecl, name string) {
+	if fd.Extern && fd.Receiver == nil {
+		g.declareC(fd, name)
+		return
+	}
+
 	ip := make([]*ir.Param, 0)
+	if fd.Receiver != nil {
+		ip = append(ip, ir.NewParam(fd.Receiver.Token.Value, g.irType(fd.Receiver.Type.Type)))
+	}
 	for _, p := range fd.Params {
-		ip = append(ip, ir.NewParam(p.Token.Value, irType(p.Type.Type)))
+		ip = append(ip, ir.NewParam(p.Token.Value, g.irType(p.Type.Type)))
+	}
+
+	var rt irtypes.Type = irtypes.Void
+	if fd.HasReturn {
+		rt = g.irType(fd.ReturnType.Type)
+	}
+
+	g.funcs[name] = g.module.NewFunc(name, rt, ip...)
+	g.decls[name] = fd
+
+	if fd.Export {
+		g.exportC(fd, g.funcs[name])
+	}
+}
+
+// declareGlobal adds a global variable to the module. Globals are initialized
+// with integer literals, which the checker enforces.
+func (g *Generator) declareGlobal(program *ast.Program, vd *ast.VarDecl) {
+	name := vd.Token.Value
+	if program.Package != "" && program.Package != "main" {
+		name = program.Package + "." + name
+	}
+
+	t := g.irType(vd.Type.Type).(*irtypes.IntType)
+	v := int64(vd.Value.(*ast.IntLiteral).Value)
+	g.vars[vd] = g.module.NewGlobalDef(name, constant.NewInt(t, v))
+}
+
+// instantiate returns the instance of a generic function for the type
+// arguments of a call, declaring it if it does not exist yet. Instances are
+// named after their type arguments, e.g. max[i32].
+func (g *Generator) instantiate(fc *ast.FuncCall) *ir.Func {
+	typeArgs := make(map[*types.TypeParam]types.Type)
+	names := make([]string, 0)
+	for i, tp := range fc.FuncDecl.TypeParams {
+		t := g.subst(fc.Instance[i])
+		typeArgs[tp.Type] = t
+		names = append(names, types.String(t))
+	}
+
+	name := fmt.Sprintf("%s[%s]", funcName(fc.FuncDecl), strings.Join(names, ", "))
+	if f, ok := g.funcs[name]; ok {
+		return f
+	}
+
+	outer := g.typeArgs
+	g.typeArgs = typeArgs
+	g.declareFunc(fc.FuncDecl, name)
+	g.typeArgs = outer
+
+	g.pending = append(g.pending, &instance{fd: fc.FuncDecl, name: name, typeArgs: typeArgs})
+
+	return g.funcs[name]
+}
+
+func (g *Generator) genFuncDecl(fd *ast.FuncDecl) value.Value {
+	if len(fd.TypeParams) > 0 {
+		return nil
 	}
 
-	g.function = g.module.NewFunc(fd.Token.Value, irType(fd.ReturnType.Type), ip...)
+	g.genFunc(fd, funcName(fd))
+
+	return nil
+}
+
+func (g *Generator) genFunc(fd *ast.FuncDecl, name string) {
+	g.decl = fd
+	g.function = g.funcs[name]
 
 	if !fd.Extern {
-		g.block = g.function.NewBlock("")
-		for _, n := range fd.Body {
-			g.genNode(n)
+		g.entry = g.function.NewBlock("")
+		g.block = g.entry
+		g.genParams(fd)
+		g.genDeferSlots(fd)
+
+		g.genBody(fd.Body)
+
+		if g.block.Term == nil {
+			switch {
+			case g.cleanup != nil:
+				g.block.NewBr(g.cleanup)
+			case fd.HasReturn:
+				g.block.NewUnreachable()
+			default:
+				g.block.NewRet(nil)
+			}
 		}
+
+		g.genCleanup()
+
+		g.entry = nil
 		g.block = nil
 	}
 
-	g.funcs[fd.Token.Value] = g.function
+	g.decl = nil
 	g.function = nil
+}
+
+func (g *Generator) genBody(stmts []ast.Statement) {
+	for _, n := range stmts {
+		if g.block.Term != nil {
+			g.block = g.function.NewBlock("")
+		}
+		g.genNode(n)
+	}
+}
+
+// appendBlock adds a block that was created before the blocks preceding it.
+func (g *Generator) appendBlock(b *ir.Block) {
+	b.Parent = g.function
+	g.function.Blocks = append(g.function.Blocks, b)
+}
+
+// genParams stores the parameters in stack slots, so that their address can
+// be taken like that of any other variable.
+func (g *Generator) genParams(fd *ast.FuncDecl) {
+	params := fd.Params
+	if fd.Receiver != nil {
+		params = append([]*ast.VarDecl{fd.Receiver}, params...)
+	}
+
+	// Function literals take their environment as the first parameter,
+	// which holds pointers to the variables they capture.
+	ps := g.function.Params
+	if lit, ok := g.lits[fd]; ok {
+		env := g.block.NewBitCast(ps[0], irtypes.NewPointer(g.envType(lit)))
+		for i, vd := range lit.Captures {
+			p := g.block.NewGetElementPtr(env.Type().(*irtypes.PointerType).ElemType, env, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, int64(i)))
+			g.vars[vd] = g.block.NewLoad(p.Type().(*irtypes.PointerType).ElemType, p)
+		}
+		ps = ps[1:]
+	}
+
+	for i, vd := range params {
+		g.declare(vd, ps[i])
+	}
+}
+
+// declare allocates a local variable with the initial value v. Variables
+// that are captured by function literals are allocated on the heap, since
+// closures can outlive the function that declares them.
+func (g *Generator) declare(vd *ast.VarDecl, v value.Value) {
+	if vd.Captured {
+		g.vars[vd] = g.block.NewBitCast(g.box(v), irtypes.NewPointer(v.Type()))
+		return
+	}
+
+	dst := g.block.NewAlloca(v.Type())
+	g.block.NewStore(v, dst)
+	g.vars[vd] = dst
+}
+
+// genFuncLit generates a closure, a pair of a function and its environment.
+// The body of the function is generated after the enclosing function.
+func (g *Generator) genFuncLit(lit *ast.FuncLit) value.Value {
+	if g.block == nil {
+		panic("block is nil")
+	}
+
+	fd := lit.Decl
+	sig := g.subst(fd.Signature()).(*types.Func)
+	ft := g.funcType(sig, true)
+
+	params := []*ir.Param{ir.NewParam("env", irtypes.I8Ptr)}
+	for i, vd := range fd.Params {
+		params = append(params, ir.NewParam(vd.Token.Value, ft.Params[i+1]))
+	}
+	g.closures++
+	name := fmt.Sprintf("%s.func%d", g.function.Name(), g.closures)
+	f := g.module.NewFunc(name, ft.RetType, params...)
+	g.funcs[name] = f
+	g.lits[fd] = lit
+	g.pending = append(g.pending, &instance{fd: fd, name: name, typeArgs: g.typeArgs})
+
+	var env value.Value = constant.NewNull(irtypes.I8Ptr)
+	if len(lit.Captures) > 0 {
+		var ptrs value.Value = constant.NewUndef(g.envType(lit))
+		for i, vd := range lit.Captures {
+			ptrs = g.block.NewInsertValue(ptrs, g.vars[vd], uint64(i))
+		}
+		env = g.box(ptrs)
+	}
+
+	return g.closure(f, env)
+}
+
+// envType returns the type of the environment of a function literal.
+func (g *Generator) envType(lit *ast.FuncLit) *irtypes.StructType {
+	fields := make([]irtypes.Type, 0)
+	for _, vd := range lit.Captures {
+		fields = append(fields, irtypes.NewPointer(g.irType(vd.Type.Type)))
+	}
+	return irtypes.NewStruct(fields...)
+}
+
+func (g *Generator) closure(f, env value.Value) value.Value {
+	t := irtypes.NewStruct(f.Type(), irtypes.I8Ptr)
+	c := g.block.NewInsertValue(constant.NewUndef(t), f, 0)
+	return g.block.NewInsertValue(c, env, 1)
+}
+
+// funcValue returns a closure that calls a named function, through a thunk
+// that ignores the environment.
+func (g *Generator) funcValue(fd *ast.FuncDecl) value.Value {
+	name := funcName(fd)
+	thunk, ok := g.funcs[name+".fn"]
+	if !ok {
+		f := g.funcs[name]
+		params := []*ir.Param{ir.NewParam("env", irtypes.I8Ptr)}
+		for _, p := range fd.Params {
+			params = append(params, ir.NewParam(p.Token.Value, g.irType(p.Type.Type)))
+		}
+		thunk = g.module.NewFunc(name+".fn", g.irType(fd.Result()), params...)
+		g.funcs[name+".fn"] = thunk
+
+		b := thunk.NewBlock("")
+		args := make([]value.Value, 0)
+		for _, p := range params[1:] {
+			args = append(args, p)
+		}
+		ret := g.call(b, f, args)
+		if fd.HasReturn {
+			b.NewRet(ret)
+		} else {
+			b.NewRet(nil)
+		}
+	}
+
+	return g.closure(thunk, constant.NewNull(irtypes.I8Ptr))
+}
+
+// genDeferSlots allocates the flag and argument slots of every defer in the
+// function body and, if there are any, the cleanup block that each return
+// branches through.
+func (g *Generator) genDeferSlots(fd *ast.FuncDecl) {
+	it := ast.NewIterator(fd)
+	for n, ok := it.Next(); ok; n, ok = it.Next() {
+		d, ok := n.(*ast.Defer)
+		if !ok {
+			continue
+		}
+
+		df := &deferred{node: d, flag: g.entry.NewAlloca(irtypes.I1)}
+		g.entry.NewStore(constant.False, df.flag)
+		fc := d.Call.(*ast.FuncCall)
+		sig := g.signature(fc)
+		if fc.VarDecl != nil {
+			df.fn = g.entry.NewAlloca(g.irType(sig))
+		}
+		for _, t := range g.argTypes(fc) {
+			df.args = append(df.args, g.entry.NewAlloca(t))
+		}
+		g.defers = append(g.defers, df)
+	}
+
+	if len(g.defers) == 0 {
+		return
+	}
+
+	g.cleanup = ir.NewBlock("cleanup")
+	if fd.HasReturn {
+		g.retval = g.entry.NewAlloca(g.irType(fd.ReturnType.Type))
+	}
+}
+
+// genCleanup emits the cleanup blocks, which run the deferred calls that were
+// reached in LIFO order and then return from the function.
+func (g *Generator) genCleanup() {
+	if g.cleanup == nil {
+		return
+	}
+
+	g.appendBlock(g.cleanup)
+	g.block = g.cleanup
+
+	for i := len(g.defers) - 1; i >= 0; i-- {
+		df := g.defers[i]
+
+		call := g.function.NewBlock("")
+		next := g.function.NewBlock("")
+
+		flag := g.block.NewLoad(irtypes.I1, df.flag)
+		g.block.NewCondBr(flag, call, next)
+
+		args := make([]value.Value, 0)
+		for _, slot := range df.args {
+			args = append(args, call.NewLoad(slot.ElemType, slot))
+		}
+		if df.fn != nil {
+			g.callClosure(call, call.NewLoad(df.fn.ElemType, df.fn), args)
+		} else {
+			g.call(call, g.getFunc(df.node.Call.(*ast.FuncCall)), args)
+		}
+		call.NewBr(next)
+
+		g.block = next
+	}
+
+	if g.retval != nil {
+		g.block.NewRet(g.block.NewLoad(g.retval.ElemType, g.retval))
+	} else {
+		g.block.NewRet(nil)
+	}
+
+	g.cleanup = nil
+	g.retval = nil
+	g.defers = nil
+}
+
+func (g *Generator) genDefer(d *ast.Defer) value.Value {
+	if g.block == nil {
+		panic("block is nil")
+	}
+
+	var df *deferred
+	for _, v := range g.defers {
+		if v.node == d {
+			df = v
+		}
+	}
+	if df == nil {
+		panic("defer has no slots")
+	}
+
+	// Function values are evaluated when the defer statement runs, like
+	// the arguments.
+	fc := d.Call.(*ast.FuncCall)
+	if df.fn != nil {
+		g.block.NewStore(g.callee(fc), df.fn)
+	}
+	for i, arg := range g.genArgs(fc) {
+		g.block.NewStore(arg, df.args[i])
+	}
+	g.block.NewStore(constant.True, df.flag)
 
 	return nil
 }
 
+func (g *Generator) getFunc(fc *ast.FuncCall) *ir.Func {
+	if len(fc.Instance) > 0 {
+		return g.instantiate(fc)
+	}
+
+	f, ok := g.funcs[funcName(fc.FuncDecl)]
+	if !ok {
+		panic(fmt.Sprintf("Cannot find func %s", fc.Token.Value))
+	}
+	return f
+}
+
 func (g *Generator) genInfixExpression(ie *ast.InfixExpression) value.Value {
-	l := g.genNode(ie.Left)
-	r := g.genNode(ie.Right)
+	t := ie.Type()
+	l := g.convert(g.genNode(ie.Left), ie.Left.Type(), t)
+	r := g.convert(g.genNode(ie.Right), ie.Right.Type(), t)
 
 	switch ie.Token.Type {
 	case token.PLUS:
 		return g.block.NewAdd(l, r)
+	case token.MINUS:
+		return g.block.NewSub(l, r)
 	case token.ASTERISK:
 		return g.block.NewMul(l, r)
+	case token.SLASH:
+		// Integers narrower than i32 are unsigned, like in convert.
+		if types.IntBits(g.subst(t)) < 32 {
+			return g.block.NewUDiv(l, r)
+		}
+		return g.block.NewSDiv(l, r)
 	default:
 		panic(fmt.Sprintf("cannot generate %s", ie.Token.Type))
 	}
@@ -113,59 +746,604 @@ func (g *Generator) genInfixExpression(ie *ast.InfixExpression) value.Value {
 }
 
 func (g *Generator) genIntLiteral(il *ast.IntLiteral) value.Value {
-	t := irType(il.Type()).(*irtypes.IntType)
+	t := g.irType(il.Type()).(*irtypes.IntType)
 	v := int64(il.Value)
 	return constant.NewInt(t, v)
 }
 
+// genStringLiteral adds the bytes of a string to the module and returns a
+// pointer to the first one.
+func (g *Generator) genStringLiteral(sl *ast.StringLiteral) value.Value {
+	data := constant.NewCharArrayFromString(sl.Value + "\x00")
+	str := g.module.NewGlobalDef(fmt.Sprintf("str.%d", g.strings), data)
+	str.Linkage = enum.LinkagePrivate
+	str.Immutable = true
+	g.strings++
+
+	zero := constant.NewInt(irtypes.I32, 0)
+	return constant.NewGetElementPtr(data.Typ, str, zero, zero)
+}
+
+// genIndex loads an element of a slice.
+func (g *Generator) genIndex(ix *ast.Index) value.Value {
+	slice := g.genNode(ix.X)
+	data := g.block.NewExtractValue(slice, 0)
+	i := g.convert(g.genNode(ix.Index), ix.Index.Type(), types.TypeInt32)
+
+	elemType := data.Type().(*irtypes.PointerType).ElemType
+	return g.block.NewLoad(elemType, g.block.NewGetElementPtr(elemType, data, i))
+}
+
 func (g *Generator) genReturn(r *ast.Return) value.Value {
 	if g.block == nil {
 		panic("g.block is nil")
 	}
-	v := g.genNode(r.Value)
-	g.block.NewRet(v)
+
+	var v value.Value
+	if r.HasValue {
+		v = g.convert(g.genNode(r.Value), r.Value.Type(), g.decl.ReturnType.Type)
+	}
+	g.ret(v)
 
 	return nil
 }
 
-func (g *Generator) genVar(v *ast.Var) value.Value {
-	if g.function != nil {
-		for _, p := range g.function.Params {
-			if p.LocalIdent.LocalName == v.Token.Value {
-				return p
+// ret returns v from the function, running the deferred calls first.
+func (g *Generator) ret(v value.Value) {
+	if g.cleanup != nil {
+		if v != nil {
+			g.block.NewStore(v, g.retval)
+		}
+		g.block.NewBr(g.cleanup)
+	} else {
+		g.block.NewRet(v)
+	}
+}
+
+// genTry branches on the tag of an optional or a result. If it holds no
+// value, its none or error is returned from the function, otherwise the
+// value is unwrapped.
+func (g *Generator) genTry(t *ast.Try) value.Value {
+	if g.block == nil {
+		panic("block is nil")
+	}
+
+	x := g.genNode(t.X)
+	slot := g.block.NewAlloca(x.Type())
+	g.block.NewStore(x, slot)
+
+	u, _ := types.AsUnion(g.subst(t.X.Type()))
+	success, failure := u.Variants[1], u.Variants[0]
+	if _, ok := t.X.Type().(*types.Result); ok {
+		success, failure = u.Variants[0], u.Variants[1]
+	}
+
+	fail := g.function.NewBlock("")
+	cont := ir.NewBlock("")
+	tag := g.block.NewExtractValue(x, 0)
+	g.block.NewCondBr(g.block.NewICmp(enum.IPredEQ, tag, constant.NewInt(irtypes.I32, int64(success.Tag))), cont, fail)
+
+	g.block = fail
+	switch ret := g.subst(g.decl.Result()).(type) {
+	case *types.Optional:
+		g.ret(g.wrap(ret, ret.Union.Variants[0]))
+	case *types.Result:
+		g.ret(g.wrap(ret, ret.Union.Variants[1], g.field(slot, failure, 0)))
+	}
+
+	g.appendBlock(cont)
+	g.block = cont
+
+	return g.field(slot, success, 0)
+}
+
+func (g *Generator) genSelector(s *ast.Selector) value.Value {
+	if s.Variant != nil {
+		return g.genVariant(s.Variant, nil)
+	}
+
+	t := g.irType(s.Member.Enum).(*irtypes.IntType)
+	return constant.NewInt(t, int64(s.Member.Value))
+}
+
+func (g *Generator) genVariant(v *types.Variant, args []ast.Expression) value.Value {
+	if g.block == nil {
+		panic("block is nil")
+	}
+
+	fields := make([]value.Value, 0)
+	for i, arg := range args {
+		fields = append(fields, g.convert(g.genNode(arg), arg.Type(), v.Fields[i]))
+	}
+
+	return g.wrap(v.Union, v, fields...)
+}
+
+// wrap builds a value of t, which is a union, an optional or a result, by
+// storing the tag and the fields of the variant into a stack slot.
+func (g *Generator) wrap(t types.Type, v *types.Variant, fields ...value.Value) value.Value {
+	ut := g.irType(t)
+	slot := g.block.NewAlloca(ut)
+	tag := g.block.NewGetElementPtr(ut, slot, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, 0))
+	g.block.NewStore(constant.NewInt(irtypes.I32, int64(v.Tag)), tag)
+
+	if len(fields) > 0 {
+		payload := g.payload(slot, v)
+		for i, f := range fields {
+			dst := g.block.NewGetElementPtr(payload.Type().(*irtypes.PointerType).ElemType, payload, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, int64(i)))
+			g.block.NewStore(f, dst)
+		}
+	}
+
+	return g.block.NewLoad(ut, slot)
+}
+
+// payload returns a pointer to the fields of variant v of the union at slot.
+func (g *Generator) payload(slot value.Value, v *types.Variant) value.Value {
+	t := slot.Type().(*irtypes.PointerType).ElemType
+	p := g.block.NewGetElementPtr(t, slot, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, 1))
+	return g.block.NewBitCast(p, irtypes.NewPointer(g.variantType(v)))
+}
+
+// field loads field i of variant v of the union at slot.
+func (g *Generator) field(slot value.Value, v *types.Variant, i int) value.Value {
+	payload := g.payload(slot, v)
+	pt := payload.Type().(*irtypes.PointerType).ElemType
+	src := g.block.NewGetElementPtr(pt, payload, constant.NewInt(irtypes.I32, 0), constant.NewInt(irtypes.I32, int64(i)))
+	return g.block.NewLoad(g.irType(v.Fields[i]), src)
+}
+
+// genSwitch lowers a switch to a switch instruction with a block for each
+// case. Without a default case, values that match no case continue after
+// the switch.
+func (g *Generator) genSwitch(s *ast.Switch) value.Value {
+	if g.block == nil {
+		panic("block is nil")
+	}
+
+	x := g.genNode(s.Value)
+
+	// Unions are switched on their tag. The value is kept in a stack slot
+	// so that patterns can load the fields of the matched variant.
+	var slot value.Value
+	if _, ok := types.AsUnion(g.subst(s.Value.Type())); ok {
+		slot = g.block.NewAlloca(x.Type())
+		g.block.NewStore(x, slot)
+		x = g.block.NewExtractValue(x, 0)
+	}
+
+	t := x.Type().(*irtypes.IntType)
+	from := g.block
+
+	end := ir.NewBlock("")
+	def := end
+	cases := make([]*ir.Case, 0)
+
+	for _, cs := range s.Cases {
+		b := g.function.NewBlock("")
+		if cs.Default {
+			def = b
+		}
+		for _, e := range cs.Values {
+			cases = append(cases, ir.NewCase(constant.NewInt(t, int64(caseValue(e))), b))
+		}
+
+		g.block = b
+		for _, e := range cs.Values {
+			if p, ok := e.(*ast.Pattern); ok {
+				g.genPattern(p, slot)
 			}
 		}
+		g.genBody(cs.Body)
+		if g.block.Term == nil {
+			g.block.NewBr(end)
+		}
+	}
+
+	from.NewSwitch(x, def, cases...)
 
-		vd, ok := g.vars[v.Token.Value]
+	g.appendBlock(end)
+	g.block = end
+
+	return nil
+}
+
+// genPattern copies the fields of the union at slot into the variables bound
+// by the pattern.
+func (g *Generator) genPattern(p *ast.Pattern, slot value.Value) {
+	for i, vd := range p.Bindings {
+		if vd.Token.Value == "_" {
+			continue
+		}
+
+		g.declare(vd, g.field(slot, p.Variant, i))
+	}
+}
+
+func (g *Generator) genVar(v *ast.Var) value.Value {
+	if v.FuncDecl != nil {
+		return g.funcValue(v.FuncDecl)
+	}
+
+	if g.function != nil {
+		p, ok := g.vars[v.VarDecl]
 		if ok {
-			aa := vd.(*ir.InstAlloca)
-			return g.block.NewLoad(aa.Typ.ElemType, vd)
+			return g.block.NewLoad(p.Type().(*irtypes.PointerType).ElemType, p)
 		}
 	}
 	panic(fmt.Sprintf("Could not find var %s", v.Token.Value))
 }
 
-func (g *Generator) genVarDecl(vd *ast.VarDecl) value.Value {
+func (g *Generator) genAssign(a *ast.Assign) value.Value {
 	if g.block == nil {
 		panic("block is nil")
 	}
 
-	src := g.genNode(vd.Value)
-	dst := g.block.NewAlloca(irType(vd.Type.Type))
-	g.block.NewStore(src, dst)
+	v := g.convert(g.genNode(a.Value), a.Value.Type(), a.X.VarDecl.Type.Type)
+	g.block.NewStore(v, g.vars[a.X.VarDecl])
+
+	return nil
+}
+
+func (g *Generator) genVarDecl(vd *ast.VarDecl) value.Value {
+	if g.block == nil {
+		panic("block is nil")
+	}
 
-	g.vars[vd.Token.Value] = dst
+	g.declare(vd, g.convert(g.genNode(vd.Value), vd.Value.Type(), vd.Type.Type))
 
 	return nil
 }
 
-func irType(t types.Type) irtypes.Type {
+// convert converts v from type from to type to. Values converted to an
+// interface become a pair of a data pointer and a vtable pointer. Pointers are
+// used as the data pointer directly, other values are copied to the heap.
+func (g *Generator) convert(v value.Value, from, to types.Type) value.Value {
+	from, to = g.subst(from), g.subst(to)
+
+	// Integers are unsigned if they are narrower than i32, so they are
+	// extended with zeros.
+	if fb, tb := types.IntBits(from), types.IntBits(to); fb != 0 && tb != 0 && fb != tb {
+		t := irtypes.NewInt(uint64(tb))
+		if fb < tb {
+			return g.block.NewZExt(v, t)
+		}
+		return g.block.NewTrunc(v, t)
+	}
+
+	switch t := to.(type) {
+	case *types.Optional:
+		switch from.(type) {
+		case *types.None:
+			return g.wrap(t, t.Union.Variants[0])
+		case *types.Optional:
+			return v
+		default:
+			return g.wrap(t, t.Union.Variants[1], g.convert(v, from, t.Elem))
+		}
+	case *types.Result:
+		switch f := from.(type) {
+		case *types.Error:
+			return g.wrap(t, t.Union.Variants[1], g.convert(v, f.Err, t.Err))
+		case *types.Result:
+			return v
+		default:
+			return g.wrap(t, t.Union.Variants[0], g.convert(v, from, t.Value))
+		}
+	}
+
+	iface, ok := to.(*types.Interface)
+	if !ok || from == to {
+		return v
+	}
+
+	var data value.Value
+	if _, ok := from.(*types.Pointer); ok {
+		data = g.block.NewBitCast(v, irtypes.I8Ptr)
+	} else {
+		data = g.box(v)
+	}
+
+	fat := g.block.NewInsertValue(constant.NewUndef(g.irType(iface)), data, 0)
+	return g.block.NewInsertValue(fat, g.vtable(from, iface), 1)
+}
+
+func (g *Generator) box(v value.Value) value.Value {
+	t := v.Type()
+	end := constant.NewGetElementPtr(t, constant.NewNull(irtypes.NewPointer(t)), constant.NewInt(irtypes.I32, 1))
+	size := constant.NewPtrToInt(end, irtypes.I64)
+
+	mem := g.block.NewCall(g.mallocFunc(), size)
+	g.block.NewStore(v, g.block.NewBitCast(mem, irtypes.NewPointer(t)))
+
+	return mem
+}
+
+// mallocFunc returns malloc as a function that takes an i64 and returns an
+// i8*. If the program declares malloc itself, possibly with other types,
+// that declaration is converted instead of adding a conflicting one.
+func (g *Generator) mallocFunc() value.Value {
+	if g.malloc == nil {
+		for _, f := range g.module.Funcs {
+			if f.Name() == "malloc" {
+				g.malloc = f
+			}
+		}
+	}
+	if g.malloc == nil {
+		g.malloc = g.module.NewFunc("malloc", irtypes.I8Ptr, ir.NewParam("size", irtypes.I64))
+	}
+
+	t := irtypes.NewPointer(irtypes.NewFunc(irtypes.I8Ptr, irtypes.I64))
+	if g.malloc.Type().Equal(t) {
+		return g.malloc
+	}
+	return constant.NewBitCast(g.malloc, t)
+}
+
+// vtable returns the table of methods of t that implement iface.
+func (g *Generator) vtable(t types.Type, iface *types.Interface) *ir.Global {
+	name := fmt.Sprintf("%s.%s.vtable", types.String(t), iface.Name())
+	if vt, ok := g.vtables[name]; ok {
+		return vt
+	}
+
+	vtType := g.interfaceType(iface).Fields[1].(*irtypes.PointerType).ElemType.(*irtypes.StructType)
+	methods := make([]constant.Constant, 0)
+	for i, m := range iface.Methods {
+		f, ok := g.method(t, m, vtType.Fields[i])
+		if !ok {
+			panic(fmt.Sprintf("cannot find method %s of %s", m.Name, types.String(t)))
+		}
+		methods = append(methods, f)
+	}
+
+	vt := g.module.NewGlobalDef(name, constant.NewStruct(vtType, methods...))
+	vt.Immutable = true
+	g.vtables[name] = vt
+
+	return vt
+}
+
+// method returns the function that implements m for values of type t, taking
+// the data pointer of an interface value as its receiver. Methods with a
+// pointer receiver are used directly, methods with a value receiver are
+// wrapped in a thunk that loads the receiver from the data pointer.
+func (g *Generator) method(t types.Type, m *types.Method, ft irtypes.Type) (constant.Constant, bool) {
+	name := methodName(t, m.Name)
+	fd, ok := g.decls[name]
+	if !ok || fd.Receiver == nil {
+		return nil, false
+	}
+	f := g.funcs[name]
+
+	if _, ok := fd.Receiver.Type.Type.(*types.Pointer); ok {
+		return constant.NewBitCast(f, ft), true
+	}
+
+	thunk, ok := g.funcs[name+".thunk"]
+	if !ok {
+		params := []*ir.Param{ir.NewParam("data", irtypes.I8Ptr)}
+		for _, p := range f.Params[1:] {
+			params = append(params, ir.NewParam(p.LocalName, p.Typ))
+		}
+		thunk = g.module.NewFunc(name+".thunk", f.Sig.RetType, params...)
+		g.funcs[name+".thunk"] = thunk
+
+		b := thunk.NewBlock("")
+		recvType := f.Params[0].Typ
+		args := []value.Value{b.NewLoad(recvType, b.NewBitCast(params[0], irtypes.NewPointer(recvType)))}
+		for _, p := range params[1:] {
+			args = append(args, p)
+		}
+
+		ret := b.NewCall(f, args...)
+		if fd.HasReturn {
+			b.NewRet(ret)
+		} else {
+			b.NewRet(nil)
+		}
+	}
+
+	return thunk, true
+}
+
+func (g *Generator) irType(t types.Type) irtypes.Type {
 	switch v := t.(type) {
 	case *types.Int32:
 		return irtypes.NewInt(32)
+	case *types.Uint8:
+		return irtypes.I8
+	case *types.Nil:
+		return irtypes.Void
 	case *types.Pointer:
-		return irtypes.NewPointer(irType(v.To))
+		return irtypes.NewPointer(g.irType(v.To))
+	case *types.Slice:
+		return irtypes.NewStruct(irtypes.NewPointer(g.irType(v.Elem)), irtypes.I32)
+	case *types.Named:
+		return g.irType(v.Underlying)
+	case *types.Enum:
+		return g.irType(v.Backing)
+	case *types.TypeParam:
+		t, ok := g.typeArgs[v]
+		if !ok {
+			panic(fmt.Sprintf("type parameter %s is not bound", v.Name()))
+		}
+		return g.irType(t)
+	case *types.Interface:
+		return g.interfaceType(v)
+	case *types.Union:
+		return g.unionType(v)
+	case *types.Func:
+		return irtypes.NewStruct(irtypes.NewPointer(g.funcType(v, true)), irtypes.I8Ptr)
+	case *types.Optional:
+		return g.unionType(g.subst(v).(*types.Optional).Union)
+	case *types.Result:
+		return g.unionType(g.subst(v).(*types.Result).Union)
 	default:
 		panic(fmt.Sprintf("cannot convert %T", v))
 	}
 }
+
+// funcType returns the type of functions with the signature sig. Functions
+// that are called through closures take the environment as the first
+// parameter.
+func (g *Generator) funcType(sig *types.Func, env bool) *irtypes.FuncType {
+	params := make([]irtypes.Type, 0)
+	if env {
+		params = append(params, irtypes.I8Ptr)
+	}
+	for _, p := range sig.Params {
+		params = append(params, g.irType(p))
+	}
+	return irtypes.NewFunc(g.irType(sig.Return), params...)
+}
+
+// subst replaces the type parameters of the generic function instance that is
+// being generated.
+func (g *Generator) subst(t types.Type) types.Type {
+	return types.Subst(t, g.typeArgs)
+}
+
+// interfaceType returns the type of interface values, a data pointer followed
+// by a pointer to a struct of methods. Each method takes the data pointer as
+// its first argument.
+func (g *Generator) interfaceType(iface *types.Interface) *irtypes.StructType {
+	if st, ok := g.ifaces[iface]; ok {
+		return st
+	}
+
+	vt := irtypes.NewStruct()
+	g.module.NewTypeDef(iface.Name()+".vtable", vt)
+	st := irtypes.NewStruct(irtypes.I8Ptr, irtypes.NewPointer(vt))
+	g.module.NewTypeDef(iface.Name(), st)
+	g.ifaces[iface] = st
+
+	for _, m := range iface.Methods {
+		params := []irtypes.Type{irtypes.I8Ptr}
+		for _, p := range m.Sig.Params {
+			params = append(params, g.irType(p))
+		}
+		f := irtypes.NewFunc(g.irType(m.Sig.Return), params...)
+		vt.Fields = append(vt.Fields, irtypes.NewPointer(f))
+	}
+
+	return st
+}
+
+// unionType returns the type of union values, a tag followed by enough space
+// for the fields of the largest variant. Types are cached by name, since each
+// occurrence of an optional or result type has its own union.
+func (g *Generator) unionType(u *types.Union) *irtypes.StructType {
+	if st, ok := g.unions[u.Name()]; ok {
+		return st
+	}
+
+	// The type is registered before its fields are known, since variants
+	// may hold pointers to the union itself.
+	st := irtypes.NewStruct()
+	g.module.NewTypeDef(u.Name(), st)
+	g.unions[u.Name()] = st
+
+	size := int64(0)
+	for _, v := range u.Variants {
+		if s := sizeOf(g.variantType(v)); s > size {
+			size = s
+		}
+	}
+	st.Fields = []irtypes.Type{irtypes.I32, irtypes.NewArray(uint64((size+7)/8), irtypes.I64)}
+
+	return st
+}
+
+// variantType returns the struct type of the fields of a variant.
+func (g *Generator) variantType(v *types.Variant) *irtypes.StructType {
+	fields := make([]irtypes.Type, 0)
+	for _, f := range v.Fields {
+		fields = append(fields, g.irType(f))
+	}
+	return irtypes.NewStruct(fields...)
+}
+
+// sizeOf returns the size in bytes of values of type t on x86-64.
+func sizeOf(t irtypes.Type) int64 {
+	switch v := t.(type) {
+	case *irtypes.IntType:
+		return int64(v.BitSize+7) / 8
+	case *irtypes.PointerType:
+		return 8
+	case *irtypes.ArrayType:
+		return int64(v.Len) * sizeOf(v.ElemType)
+	case *irtypes.StructType:
+		size := int64(0)
+		for _, f := range v.Fields {
+			size = align(size, alignOf(f)) + sizeOf(f)
+		}
+		return align(size, alignOf(v))
+	default:
+		panic(fmt.Sprintf("cannot compute size of %v", t))
+	}
+}
+
+// alignOf returns the alignment in bytes of values of type t on x86-64.
+func alignOf(t irtypes.Type) int64 {
+	switch v := t.(type) {
+	case *irtypes.ArrayType:
+		return alignOf(v.ElemType)
+	case *irtypes.StructType:
+		a := int64(1)
+		for _, f := range v.Fields {
+			if fa := alignOf(f); fa > a {
+				a = fa
+			}
+		}
+		return a
+	default:
+		return sizeOf(t)
+	}
+}
+
+func align(n, a int64) int64 {
+	return (n + a - 1) / a * a
+}
+
+func caseValue(e ast.Expression) int {
+	switch v := e.(type) {
+	case *ast.IntLiteral:
+		return v.Value
+	case *ast.Selector:
+		if v.Variant != nil {
+			return v.Variant.Tag
+		}
+		return v.Member.Value
+	case *ast.Pattern:
+		return v.Variant.Tag
+	case *ast.None:
+		return 0
+	default:
+		panic(fmt.Sprintf("cannot use %T as case value", v))
+	}
+}
+
+// funcName returns the symbol of a function. Methods are prefixed with the
+// name of their receiver's type, e.g. Position.Len, and functions of packages
+// other than main with the name of their package, e.g. geom.Area.
+func funcName(fd *ast.FuncDecl) string {
+	if fd.Receiver == nil {
+		if fd.Extern || fd.Export || fd.Package == "" || fd.Package == "main" {
+			return fd.Token.Value
+		}
+		return fd.Package + "." + fd.Token.Value
+	}
+
+	return methodName(fd.Receiver.Type.Type, fd.Token.Value)
+}
+
+// methodName returns the symbol of the named method of t, which is a named
+// type or a pointer to one.
+func methodName(t types.Type, name string) string {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.To
+	}
+	return t.Name() + "." + name
+}
@@ -0,0 +1,179 @@
+package llvm
+
+import (
+	"lang/checker"
+	"lang/lexer"
+	"lang/parser"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// generate checks input and returns its IR, which is verified with opt if it
+// is in PATH.
+func generate(t *testing.T, input string) string {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	prog, ok := p.ParseProgram()
+	if !ok {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+	c := checker.New(prog)
+	c.Check()
+	if len(c.Errors) > 0 {
+		t.Fatalf("check errors: %v", c.Errors)
+	}
+
+	code := NewGenerator().Generate(prog)
+	opt, err := exec.LookPath("opt")
+	if err != nil {
+		return code
+	}
+	cmd := exec.Command(opt, "-verify", "-disable-output")
+	cmd.Stdin = strings.NewReader(code)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("opt -verify: %v\n%s\n%s", err, out, code)
+	}
+	return code
+}
+
+func TestInfixConversion(t *testing.T) {
+	code := generate(t, `
+func main() i32 {
+	var b u8 = 250
+	var x i32 = 10
+	b = b + x
+	return x * b
+}
+`)
+	for _, want := range []string{"trunc i32", "zext i8", "add i32", "mul i32"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %q in\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "add i8") {
+		t.Errorf("u8 operand of i32 addition is not widened in\n%s", code)
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	code := generate(t, `
+func main() i32 {
+	var b u8 = 200
+	var x i32 = 7 - 2 * 10 / 4 + 30
+	b = b / 3 - 1
+	return x - b
+}
+`)
+	for _, want := range []string{"sdiv i32", "udiv i8", "sub i32", "sub i8"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %q in\n%s", want, code)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	code := generate(t, `
+type Meters i32
+var m Meters = 3
+var b u8 = 4
+func main() i32 {
+	return m + b
+}
+`)
+	for _, want := range []string{"@m = global i32 3", "@b = global i8 4"} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %q in\n%s", want, code)
+		}
+	}
+}
+
+func TestDefer(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+
+		// branches is the number of exits that run the deferred calls.
+		branches int
+	}{
+		{"returns", `
+func puts(s ^u8) i32
+func close(x i32)
+func main(argc i32, argv ^^u8) i32 {
+	defer puts("one")
+	switch argc {
+	case 1:
+		return 1
+	}
+	defer close(argc)
+	return 0
+}
+`, []string{"cleanup:", "call i32 @puts", "call void @close"}, 2},
+		{"void", `
+func close(x i32)
+func main() {
+	defer close(1)
+	switch 2 {
+	case 1:
+		return
+	}
+	close(2)
+}
+`, []string{"cleanup:", "call void @close"}, 2},
+		{"try", `
+func close(x i32)
+func find(x i32) ?i32
+func twice(x i32) ?i32 {
+	defer close(x)
+	var y i32 = try find(x)
+	return try find(y)
+}
+`, []string{"cleanup:", "call void @close"}, 3},
+		{"closure", `
+func close(x i32)
+func main() i32 {
+	var f func(i32) = close
+	var g func() i32 = func() i32 {
+		defer f(2)
+		return 1
+	}
+	defer f(1)
+	return g()
+}
+`, []string{"cleanup:"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := generate(t, tt.source)
+			for _, want := range tt.want {
+				if !strings.Contains(code, want) {
+					t.Errorf("missing %q in\n%s", want, code)
+				}
+			}
+			if n := strings.Count(code, "br label %cleanup"); n != tt.branches {
+				t.Errorf("got %d branches to cleanup, want %d in\n%s", n, tt.branches, code)
+			}
+		})
+	}
+}
+
+func TestClosureMalloc(t *testing.T) {
+	code := generate(t, `
+func malloc(n i32) ^u8
+func main() i32 {
+	var p ^u8 = malloc(4)
+	var x i32 = 1
+	var f func() i32 = func() i32 {
+		return x
+	}
+	return f()
+}
+`)
+	if n := strings.Count(code, "declare i8* @malloc"); n != 1 {
+		t.Errorf("got %d declarations of malloc, want 1 in\n%s", n, code)
+	}
+	if !strings.Contains(code, "bitcast (i8* (i32)* @malloc to i8* (i64)*)") {
+		t.Errorf("missing conversion of malloc in\n%s", code)
+	}
+}
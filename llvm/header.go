@@ -0,0 +1,231 @@
+package llvm
+
+import (
+	"fmt"
+	"lang/ast"
+	"lang/types"
+	"strings"
+	"unicode"
+
+	irtypes "github.com/llir/llvm/ir/types"
+)
+
+// Header returns a C header named name, which declares the exported functions
+// of the generated programs and the types of their parameters and results. It
+// must be called after Generate.
+func (g *Generator) Header(name string) string {
+	h := &header{g: g, done: make(map[string]bool)}
+	protos := make([]string, 0)
+	for _, fd := range g.exports {
+		protos = append(protos, h.prototype(fd))
+	}
+
+	guard := headerGuard(name)
+	var b strings.Builder
+	b.WriteString("// Code generated by lang. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "#ifndef %s\n#define %s\n\n", guard, guard)
+	b.WriteString("#include <stdint.h>\n\n")
+	b.WriteString("#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+	for _, s := range h.decls {
+		b.WriteString(s + "\n")
+	}
+	if len(h.decls) > 0 {
+		b.WriteString("\n")
+	}
+	for _, s := range h.defs {
+		b.WriteString(s + "\n\n")
+	}
+	for _, s := range protos {
+		b.WriteString(s + "\n")
+	}
+	if len(protos) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("#ifdef __cplusplus\n}\n#endif\n\n#endif\n")
+
+	return b.String()
+}
+
+// header collects the C declarations of the types that exported functions
+// use. Structs are declared before all definitions, so that definitions can
+// point to structs that are defined later.
+type header struct {
+	g     *Generator
+	done  map[string]bool
+	decls []string
+	defs  []string
+}
+
+// prototype returns the C prototype of the exported function fd.
+func (h *header) prototype(fd *ast.FuncDecl) string {
+	params := make([]string, 0)
+	for _, p := range fd.Params {
+		params = append(params, declarator(h.cType(p.Type.Type), p.Token.Value))
+	}
+	if len(params) == 0 {
+		params = append(params, "void")
+	}
+	return fmt.Sprintf("%s(%s);", declarator(h.cType(fd.Result()), fd.Token.Value), strings.Join(params, ", "))
+}
+
+// cType returns the C type of values of type t, defining it if needed.
+func (h *header) cType(t types.Type) string {
+	switch v := t.(type) {
+	case *types.Int32:
+		return "int32_t"
+	case *types.Uint8:
+		return "uint8_t"
+	case *types.Nil:
+		return "void"
+	case *types.Pointer:
+		return declarator(h.cType(v.To), "*")
+	case *types.Named:
+		name := cName(v.Name())
+		h.define(name, func() string {
+			return fmt.Sprintf("typedef %s;", declarator(h.cType(v.Underlying), name))
+		})
+		return name
+	case *types.Enum:
+		name := cName(v.Name())
+		h.define(name, func() string {
+			consts := make([]string, 0)
+			for _, m := range v.Members {
+				consts = append(consts, fmt.Sprintf("%s_%s = %d", name, m.Name, m.Value))
+			}
+			return fmt.Sprintf("typedef %s %s;\n", h.cType(v.Backing), name) + cEnum(consts)
+		})
+		return name
+	case *types.Slice:
+		name := mangle(v)
+		h.defineStruct(name, func() []string {
+			return []string{declarator(h.cType(v.Elem), "*data"), "int32_t len"}
+		})
+		return name
+	case *types.Interface:
+		name := cName(v.Name())
+		h.defineStruct(name, func() []string {
+			return []string{"void *data", "const void *vtable"}
+		})
+		return name
+	case *types.Union, *types.Optional, *types.Result:
+		return h.union(t)
+	case *types.Func:
+		name := mangle(v)
+		h.defineStruct(name, func() []string {
+			return []string{"void *fn", "void *env"}
+		})
+		return name
+	default:
+		panic(fmt.Sprintf("cannot declare %s in C", types.String(t)))
+	}
+}
+
+// union defines the struct of the union, optional or result t, the constants
+// of its tags and a struct for the fields of each variant that has some, which
+// are stored in data.
+func (h *header) union(t types.Type) string {
+	u, _ := types.AsUnion(t)
+	name := mangle(t)
+	h.define(name, func() string {
+		h.decls = append(h.decls, fmt.Sprintf("typedef struct %s %s;", name, name))
+
+		fields := []string{"int32_t tag", "int32_t pad"}
+		data := h.g.irType(t).(*irtypes.StructType).Fields[1].(*irtypes.ArrayType)
+		if data.Len > 0 {
+			fields[1] = fmt.Sprintf("int64_t data[%d]", data.Len)
+		}
+		def := cStruct(name, fields)
+
+		tags := make([]string, 0)
+		for _, v := range u.Variants {
+			tags = append(tags, fmt.Sprintf("%s_%s = %d", name, v.Name, v.Tag))
+		}
+		def += "\n\n" + cEnum(tags)
+
+		for _, v := range u.Variants {
+			if len(v.Fields) == 0 {
+				continue
+			}
+			payload := make([]string, 0)
+			for i, f := range v.Fields {
+				payload = append(payload, declarator(h.cType(f), fmt.Sprintf("f%d", i)))
+			}
+			vname := name + "_" + v.Name + "_fields"
+			h.decls = append(h.decls, fmt.Sprintf("typedef struct %s %s;", vname, vname))
+			def += "\n\n" + cStruct(vname, payload)
+		}
+		return def
+	})
+	return name
+}
+
+// define adds the definition that def returns, unless name is defined
+// already. The types that def refers to are defined first.
+func (h *header) define(name string, def func() string) {
+	if h.done[name] {
+		return
+	}
+	h.done[name] = true
+	h.defs = append(h.defs, def())
+}
+
+// defineStruct defines the struct name with the given fields.
+func (h *header) defineStruct(name string, fields func() []string) {
+	h.define(name, func() string {
+		h.decls = append(h.decls, fmt.Sprintf("typedef struct %s %s;", name, name))
+		return cStruct(name, fields())
+	})
+}
+
+func cStruct(name string, fields []string) string {
+	return fmt.Sprintf("struct %s {\n\t%s;\n};", name, strings.Join(fields, ";\n\t"))
+}
+
+func cEnum(consts []string) string {
+	if len(consts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("enum {\n\t%s,\n};", strings.Join(consts, ",\n\t"))
+}
+
+// declarator declares name as a value of type typ, e.g. uint8_t *p.
+func declarator(typ, name string) string {
+	if strings.HasSuffix(typ, "*") {
+		return typ + name
+	}
+	return typ + " " + name
+}
+
+// mangle returns a C identifier for the type t, e.g. slice_u8 for []u8.
+func mangle(t types.Type) string {
+	switch v := t.(type) {
+	case *types.Pointer:
+		return "ptr_" + mangle(v.To)
+	case *types.Slice:
+		return "slice_" + mangle(v.Elem)
+	case *types.Optional:
+		return "optional_" + mangle(v.Elem)
+	case *types.Result:
+		return "result_" + mangle(v.Err) + "_" + mangle(v.Value)
+	case *types.Func:
+		return "func"
+	default:
+		return cName(t.Name())
+	}
+}
+
+// cName returns the C identifier of a qualified name like geom.Point.
+func cName(name string) string {
+	return strings.ReplaceAll(name, ".", "_")
+}
+
+// headerGuard returns the include guard of the header name, e.g. GEOM_H for
+// geom.h.
+func headerGuard(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return '_'
+		}
+		return unicode.ToUpper(r)
+	}, name)
+}
@@ -0,0 +1,104 @@
+package llvm
+
+import (
+	"lang/checker"
+	"lang/lexer"
+	"lang/parser"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	input := `
+enum Color u8 {
+	Red,
+	Blue = 4
+}
+func helper() {}
+export func paint(c Color, n i32) Color {
+	return c
+}
+export func find(xs []u8) ?^u8 {
+	return none
+}
+export func reset() {}
+`
+	l := lexer.New(input)
+	p := parser.New(l)
+	prog, ok := p.ParseProgram()
+	if !ok {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+	c := checker.New(prog)
+	c.Check()
+	if len(c.Errors) > 0 {
+		t.Fatalf("check errors: %v", c.Errors)
+	}
+
+	g := NewGenerator()
+	code := g.Generate(prog)
+	for _, want := range []string{
+		"define zeroext i8 @paint(i8 zeroext %c, i32 %n)",
+		`define %"?^u8" @find.native({ i8*, i32 } %xs)`,
+		"define { i32, i64 } @find(i8* %xs.coerce0, i32 %xs.coerce1)",
+		"define void @reset()",
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %q in\n%s", want, code)
+		}
+	}
+
+	want := `// Code generated by lang. DO NOT EDIT.
+
+#ifndef PAINT_H
+#define PAINT_H
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef struct slice_u8 slice_u8;
+typedef struct optional_ptr_u8 optional_ptr_u8;
+typedef struct optional_ptr_u8_some_fields optional_ptr_u8_some_fields;
+
+typedef uint8_t Color;
+enum {
+	Color_Red = 0,
+	Color_Blue = 4,
+};
+
+struct slice_u8 {
+	uint8_t *data;
+	int32_t len;
+};
+
+struct optional_ptr_u8 {
+	int32_t tag;
+	int64_t data[1];
+};
+
+enum {
+	optional_ptr_u8_none = 0,
+	optional_ptr_u8_some = 1,
+};
+
+struct optional_ptr_u8_some_fields {
+	uint8_t *f0;
+};
+
+Color paint(Color c, int32_t n);
+optional_ptr_u8 find(slice_u8 xs);
+void reset(void);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
+`
+	if got := g.Header("paint.h"); got != want {
+		t.Errorf("got header\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,343 @@
+// Package loader reads the files of a program and of the packages it imports.
+// A package is a directory of source files, which is imported by its path
+// relative to one of the directories of the search path. Paths ending in .h
+// import a C header, which is translated into declarations.
+package loader
+
+import (
+	"fmt"
+	"lang/ast"
+	"lang/cheader"
+	"lang/diag"
+	"lang/lexer"
+	"lang/parser"
+	"lang/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Ext is the extension of source files.
+const Ext = ".lang"
+
+type Loader struct {
+	// Path lists the directories that imports are resolved in, in order.
+	// C headers are also looked up in Include.
+	Path    []string
+	Include []string
+	Errors  []*diag.Diagnostic
+
+	// Warnings describes the declarations of C headers that could not be
+	// translated.
+	Warnings []*diag.Diagnostic
+
+	// Fset holds the source files of the loaded packages.
+	Fset *token.FileSet
+
+	// Overlay maps file names to sources that are read instead of the
+	// files on disk, like the unsaved files of an editor. A file of the
+	// overlay can be loaded even if it does not exist on disk.
+	Overlay map[string][]byte
+
+	pkgs    map[string]*ast.Program
+	loading []string
+	order   []*ast.Program
+}
+
+func New(path []string) *Loader {
+	return &Loader{
+		Path:     path,
+		Errors:   make([]*diag.Diagnostic, 0),
+		Warnings: make([]*diag.Diagnostic, 0),
+		Fset:     token.NewFileSet(),
+		pkgs:     make(map[string]*ast.Program),
+	}
+}
+
+// Load loads the main package from a file or from all source files in a
+// directory, and the packages it imports. It returns the packages ordered so
+// that each package comes after the packages it imports.
+func (l *Loader) Load(path string) ([]*ast.Program, bool) {
+	return l.LoadPackage(path, "main")
+}
+
+// LoadPackage is like Load for the package name, whose files must declare
+// it, instead of the main package.
+func (l *Loader) LoadPackage(path, name string) ([]*ast.Program, bool) {
+	files, err := l.sourceFiles(path)
+	if err != nil {
+		l.error(diag.Span{}, "%s", err)
+		return nil, false
+	}
+
+	prog, ok := l.parse(files, name)
+	if !ok {
+		return nil, false
+	}
+	if !l.loadImports(prog) {
+		return nil, false
+	}
+	l.order = append(l.order, prog)
+
+	return l.order, len(l.Errors) == 0
+}
+
+// loadImports loads the packages that prog imports and links them to its
+// imports.
+func (l *Loader) loadImports(prog *ast.Program) bool {
+	for _, imp := range prog.Imports {
+		dep, ok := l.loadPackage(imp)
+		if !ok {
+			return false
+		}
+		imp.Program = dep
+	}
+	return true
+}
+
+func (l *Loader) loadPackage(imp *ast.Import) (*ast.Program, bool) {
+	if prog, ok := l.pkgs[imp.Path]; ok {
+		return prog, true
+	}
+
+	for i, path := range l.loading {
+		if path == imp.Path {
+			cycle := append(append([]string{}, l.loading[i:]...), imp.Path)
+			l.error(diag.At(imp.Token), "import cycle not allowed: %s", strings.Join(cycle, " -> "))
+			return nil, false
+		}
+	}
+
+	var prog *ast.Program
+	var ok bool
+	if strings.HasSuffix(imp.Path, ".h") {
+		prog, ok = l.loadHeader(imp)
+	} else {
+		prog, ok = l.loadDir(imp)
+	}
+	if !ok {
+		return nil, false
+	}
+	for path, other := range l.pkgs {
+		if other.Package == prog.Package {
+			l.error(diag.At(imp.Token), "packages %q and %q have the same name %s", path, imp.Path, prog.Package)
+			return nil, false
+		}
+	}
+
+	l.loading = append(l.loading, imp.Path)
+	ok = l.loadImports(prog)
+	l.loading = l.loading[:len(l.loading)-1]
+	if !ok {
+		return nil, false
+	}
+
+	l.pkgs[imp.Path] = prog
+	l.order = append(l.order, prog)
+
+	return prog, true
+}
+
+func (l *Loader) loadDir(imp *ast.Import) (*ast.Program, bool) {
+	dir, ok := l.find(imp.Path, l.Path, true)
+	if !ok {
+		l.error(diag.At(imp.Token), "cannot find package %q in any of %s", imp.Path, strings.Join(l.Path, ", "))
+		return nil, false
+	}
+	files, err := l.sourceFiles(dir)
+	if err != nil {
+		l.error(diag.At(imp.Token), "%s", err)
+		return nil, false
+	}
+
+	name := imp.Path[strings.LastIndex(imp.Path, "/")+1:]
+	return l.parse(files, name)
+}
+
+// loadHeader translates a C header into a package named after the file.
+func (l *Loader) loadHeader(imp *ast.Import) (*ast.Program, bool) {
+	dirs := append(append([]string{}, l.Path...), l.Include...)
+	file, ok := l.find(imp.Path, dirs, false)
+	if !ok {
+		l.error(diag.At(imp.Token), "cannot find header %q in any of %s", imp.Path, strings.Join(dirs, ", "))
+		return nil, false
+	}
+	b, err := l.readFile(file)
+	if err != nil {
+		l.error(diag.At(imp.Token), "%s", err)
+		return nil, false
+	}
+
+	name := headerName(imp.Path)
+	src, skipped := cheader.Translate(name, string(b))
+	for _, s := range skipped {
+		l.Warnings = append(l.Warnings, diag.Warningf(diag.InFile(file), diag.Foreign, "skipped %s", s))
+	}
+
+	lx := lexer.NewFile(l.Fset.AddFile(file, []byte(src)))
+	prog, ok := l.parseAll([]*lexer.Lexer{lx}, name)
+	prog.Foreign = true
+
+	return prog, ok
+}
+
+// headerName returns the name of the package of a header, which is the name
+// of its file with characters that cannot appear in names replaced by _.
+func headerName(path string) string {
+	base := strings.TrimSuffix(path[strings.LastIndex(path, "/")+1:], ".h")
+	return strings.Map(func(r rune) rune {
+		if r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, base)
+}
+
+// find returns the first file or directory at path in dirs.
+func (l *Loader) find(path string, dirs []string, dir bool) (string, bool) {
+	for _, root := range dirs {
+		p := filepath.Join(root, filepath.FromSlash(path))
+		if info, err := os.Stat(p); err == nil && info.IsDir() == dir {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// parse parses the files of the package name into one program.
+func (l *Loader) parse(files []string, name string) (*ast.Program, bool) {
+	lexers := make([]*lexer.Lexer, 0)
+	ok := true
+	for _, file := range files {
+		b, err := l.readFile(file)
+		if err != nil {
+			l.error(diag.Span{}, "%s", err)
+			ok = false
+			continue
+		}
+		lexers = append(lexers, lexer.NewFile(l.Fset.AddFile(file, b)))
+	}
+
+	prog, parsed := l.parseAll(lexers, name)
+	return prog, ok && parsed
+}
+
+// parseAll parses the sources of the package name into one program. The
+// sources must all declare the package, except for sources of the main
+// package, which can leave out the declaration.
+func (l *Loader) parseAll(lexers []*lexer.Lexer, name string) (*ast.Program, bool) {
+	prog := &ast.Program{Package: name, Imports: make([]*ast.Import, 0), Statements: make([]ast.Statement, 0)}
+	imports := make(map[string]*ast.Import)
+	ok := true
+
+	for _, lx := range lexers {
+		file := lx.File().Name()
+		p := parser.New(lx)
+		fp, _ := p.ParseProgram()
+		if len(p.Errors) > 0 {
+			l.Errors = append(l.Errors, p.Errors...)
+			ok = false
+			continue
+		}
+
+		switch {
+		case fp.Package == name, fp.Package == "" && name == "main":
+		case fp.Package == "":
+			l.error(diag.InFile(file), "no package declaration, want package %s", name)
+			ok = false
+			continue
+		default:
+			l.error(diag.InFile(file), "declares package %s, want package %s", fp.Package, name)
+			ok = false
+			continue
+		}
+
+		for _, imp := range fp.Imports {
+			if dup, found := imports[imp.Name]; found {
+				if dup.Path != imp.Path {
+					l.error(diag.At(imp.Token), "%s refers to both %q and %q in package %s", imp.Name, dup.Path, imp.Path, name)
+					ok = false
+				}
+				continue
+			}
+			imports[imp.Name] = imp
+			prog.Imports = append(prog.Imports, imp)
+		}
+		prog.Statements = append(prog.Statements, fp.Statements...)
+	}
+
+	return prog, ok
+}
+
+// SourceFiles returns the source files of a directory in lexical order, or
+// the file itself if path is not a directory.
+func SourceFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == Ext {
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no %s files in %s", Ext, path)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// sourceFiles is like SourceFiles, but also returns the files of the overlay
+// in a directory, or a file of the overlay itself.
+func (l *Loader) sourceFiles(path string) ([]string, error) {
+	if _, ok := l.Overlay[path]; ok {
+		return []string{path}, nil
+	}
+
+	files, err := SourceFiles(path)
+	extra := false
+	for file := range l.Overlay {
+		if filepath.Dir(file) == filepath.Clean(path) && filepath.Ext(file) == Ext && !contains(files, file) {
+			files = append(files, file)
+			extra = true
+		}
+	}
+	if !extra {
+		return files, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readFile returns the source of a file from the overlay or from disk.
+func (l *Loader) readFile(file string) ([]byte, error) {
+	if src, ok := l.Overlay[file]; ok {
+		return src, nil
+	}
+	return os.ReadFile(file)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Loader) error(span diag.Span, msg string, args ...interface{}) {
+	l.Errors = append(l.Errors, diag.Errorf(span, diag.Import, msg, args...))
+}
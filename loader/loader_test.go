@@ -0,0 +1,150 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadOrder(t *testing.T) {
+	root := write(t, map[string]string{
+		"main.lang":        "import \"geom\"\nimport \"util\"\nfunc main() {}\n",
+		"geom/shape.lang":  "package geom\nimport \"util\"\nfunc Area() {}\n",
+		"geom/square.lang": "package geom\nfunc Square() {}\n",
+		"util/util.lang":   "package util\nfunc Twice() {}\n",
+	})
+
+	l := New([]string{root})
+	progs, ok := l.Load(filepath.Join(root, "main.lang"))
+	if !ok {
+		t.Fatalf("load errors: %v", l.Errors)
+	}
+
+	want := []string{"util", "geom", "main"}
+	if len(progs) != len(want) {
+		t.Fatalf("got %d packages, want %d", len(progs), len(want))
+	}
+	for i, name := range want {
+		if progs[i].Package != name {
+			t.Fatalf("[%d] got package %s, want %s", i, progs[i].Package, name)
+		}
+	}
+	if len(progs[1].Statements) != 2 {
+		t.Fatalf("got %d statements in geom, want 2", len(progs[1].Statements))
+	}
+	if progs[2].Imports[1].Program != progs[0] || progs[1].Imports[0].Program != progs[0] {
+		t.Fatalf("imports of util are not linked to the same package")
+	}
+}
+
+func TestLoadHeader(t *testing.T) {
+	root := write(t, map[string]string{
+		"main.lang":     "import c \"include/lib.h\"\nfunc main() {}\n",
+		"include/lib.h": "#define SIZE 4\nint lib_size(void);\ndouble lib_time(void);\n",
+	})
+
+	l := New([]string{root})
+	progs, ok := l.Load(filepath.Join(root, "main.lang"))
+	if !ok {
+		t.Fatalf("load errors: %v", l.Errors)
+	}
+
+	lib := progs[0]
+	if lib.Package != "lib" || !lib.Foreign {
+		t.Fatalf("got package %s, foreign %v, want foreign package lib", lib.Package, lib.Foreign)
+	}
+	if len(lib.Statements) != 2 {
+		t.Fatalf("got %d statements, want 2", len(lib.Statements))
+	}
+	if len(l.Warnings) != 1 || !strings.Contains(l.Warnings[0].Error(), "lib_time") {
+		t.Fatalf("expected a warning for lib_time, got %v", l.Warnings)
+	}
+}
+
+func TestLoadOverlay(t *testing.T) {
+	root := write(t, map[string]string{
+		"util/util.lang": "package util\nfunc Twice() {}\n",
+	})
+
+	main := filepath.Join(root, "main.lang")
+	extra := filepath.Join(root, "util", "extra.lang")
+	l := New([]string{root})
+	l.Overlay = map[string][]byte{
+		main:  []byte("import \"util\"\nfunc main() {}\n"),
+		extra: []byte("package util\nfunc Thrice() {}\n"),
+	}
+	progs, ok := l.Load(main)
+	if !ok {
+		t.Fatalf("load errors: %v", l.Errors)
+	}
+	if len(progs) != 2 || len(progs[0].Statements) != 2 {
+		t.Fatalf("got %d packages, want util with the function of the overlay and main", len(progs))
+	}
+
+	l = New([]string{root})
+	progs, ok = l.LoadPackage(filepath.Join(root, "util"), "util")
+	if !ok || len(progs) != 1 || progs[0].Package != "util" {
+		t.Fatalf("got %d packages, errors %v, want package util", len(progs), l.Errors)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		want  string
+	}{
+		{
+			map[string]string{"main.lang": "import \"nope\"\n"},
+			"cannot find package \"nope\"",
+		},
+		{
+			map[string]string{
+				"main.lang": "import \"a\"\n",
+				"a/a.lang":  "package a\nimport \"b\"\n",
+				"b/b.lang":  "package b\nimport \"a\"\n",
+			},
+			"import cycle not allowed: a -> b -> a",
+		},
+		{
+			map[string]string{
+				"main.lang": "import \"a\"\n",
+				"a/a.lang":  "package b\n",
+			},
+			"declares package b, want package a",
+		},
+		{
+			map[string]string{
+				"main.lang": "import \"a\"\n",
+				"a/a.lang":  "func F() {}\n",
+			},
+			"no package declaration, want package a",
+		},
+	}
+
+	for i, tt := range tests {
+		root := write(t, tt.files)
+		l := New([]string{root})
+		if _, ok := l.Load(filepath.Join(root, "main.lang")); ok {
+			t.Fatalf("[%d] expected an error", i)
+		}
+		if len(l.Errors) != 1 || !strings.Contains(l.Errors[0].Error(), tt.want) {
+			t.Fatalf("[%d] expected %q, got %v", i, tt.want, l.Errors)
+		}
+	}
+}
+
+// write creates the files in a temporary directory and returns it.
+func write(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
@@ -0,0 +1,746 @@
+package lsp
+
+import (
+	"lang/ast"
+	"lang/checker"
+	"lang/diag"
+	"lang/token"
+	"lang/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// analysis holds the checked packages that a document was loaded with. The
+// package of the document is the last one.
+type analysis struct {
+	fset     *token.FileSet
+	progs    []*ast.Program
+	checkers []*checker.Checker
+
+	// file is the source file of the document
+	file *token.File
+}
+
+func (a *analysis) prog() *ast.Program {
+	return a.progs[len(a.progs)-1]
+}
+
+func (a *analysis) checker() *checker.Checker {
+	return a.checkers[len(a.checkers)-1]
+}
+
+func (a *analysis) fileNamed(name string) *token.File {
+	return fileNamed(a.fset, name)
+}
+
+func fileNamed(fset *token.FileSet, name string) *token.File {
+	for _, f := range fset.Files() {
+		if f.Name() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// location returns the location of the source from up to to.
+func (a *analysis) location(from, to token.Pos) *Location {
+	f := a.fset.File(from)
+	if f == nil {
+		return nil
+	}
+	return &Location{URI: pathURI(f.Name()), Range: nodeRange(f, from, to)}
+}
+
+// pos returns the position in file of the protocol position p, which is
+// moved to the end of its line or of the file if it is past them.
+func pos(file *token.File, p Position) token.Pos {
+	line := p.Line + 1
+	if line > file.LineCount() {
+		return file.Pos(file.Size())
+	}
+	if line < 1 {
+		line = 1
+	}
+	src := file.Source()
+	offset := file.Offset(file.LineStart(line))
+	for units := 0; units < p.Character && offset < len(src) && src[offset] != '\n'; {
+		r, size := utf8.DecodeRune(src[offset:])
+		units += utf16.RuneLen(r)
+		offset += size
+	}
+	return file.Pos(offset)
+}
+
+// position returns the protocol position of p in file.
+func position(file *token.File, p token.Pos) Position {
+	line := file.Position(p).Line
+	start := file.Offset(file.LineStart(line))
+	return Position{Line: line - 1, Character: units(file.Source()[start:file.Offset(p)])}
+}
+
+func nodeRange(file *token.File, from, to token.Pos) Range {
+	return Range{Start: position(file, from), End: position(file, to)}
+}
+
+// units returns the number of UTF-16 code units of src.
+func units(src []byte) int {
+	n := 0
+	for len(src) > 0 {
+		r, size := utf8.DecodeRune(src)
+		n += utf16.RuneLen(r)
+		src = src[size:]
+	}
+	return n
+}
+
+// spanRange returns the range of a span of a diagnostic, whose columns count
+// characters.
+func spanRange(fset *token.FileSet, span diag.Span) Range {
+	file := fileNamed(fset, span.Start.Filename)
+	if file == nil {
+		return Range{}
+	}
+	return Range{Start: diagPosition(file, span.Start), End: diagPosition(file, span.End)}
+}
+
+func diagPosition(file *token.File, p diag.Pos) Position {
+	if !p.IsValid() || p.Line > file.LineCount() {
+		return Position{}
+	}
+	src := file.Source()
+	start := file.Offset(file.LineStart(p.Line))
+	if p.Line == 1 && strings.HasPrefix(string(src), "\uFEFF") {
+		start += len("\uFEFF")
+	}
+	offset := start
+	for col := 1; col < p.Column && offset < len(src) && src[offset] != '\n'; col++ {
+		_, size := utf8.DecodeRune(src[offset:])
+		offset += size
+	}
+	return Position{Line: p.Line - 1, Character: units(src[start:offset])}
+}
+
+// path returns the nodes of the document that contain p, from the outermost
+// to the innermost.
+func path(prog *ast.Program, p token.Pos) []ast.Node {
+	path := make([]ast.Node, 0)
+	nodes := children(prog)
+	for {
+		var next ast.Node
+		for _, n := range nodes {
+			if n.Pos().IsValid() && n.Pos() <= p && p <= n.End() {
+				next = n
+				break
+			}
+		}
+		if next == nil {
+			return path
+		}
+		path = append(path, next)
+		nodes = children(next)
+	}
+}
+
+// children returns the nodes directly below n in source order. Unlike the
+// Iterator, it descends into function literals and into types.
+func children(n ast.Node) []ast.Node {
+	nodes := make([]ast.Node, 0)
+	add := func(ns ...ast.Node) {
+		for _, n := range ns {
+			if n != nil && !reflect.ValueOf(n).IsNil() {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+
+	switch v := n.(type) {
+	case *ast.Program:
+		for _, imp := range v.Imports {
+			add(imp)
+		}
+		for _, s := range v.Statements {
+			add(s)
+		}
+	case *ast.FuncDecl:
+		add(v.Receiver)
+		for _, tp := range v.TypeParams {
+			add(tp)
+		}
+		for _, vd := range v.Params {
+			add(vd)
+		}
+		if v.HasReturn {
+			add(v.ReturnType)
+		}
+		for _, s := range v.Body {
+			add(s)
+		}
+	case *ast.TypeParam:
+		add(v.Constraint)
+	case *ast.TypeDecl:
+		// The type of a declaration spans the type it is declared as,
+		// which does not refer to the declaration.
+		for _, fd := range v.Methods {
+			add(fd)
+		}
+		for _, em := range v.Members {
+			add(em)
+		}
+		for _, vr := range v.Variants {
+			add(vr)
+		}
+	case *ast.EnumMember:
+		add(v.Value)
+	case *ast.Variant:
+		for _, t := range v.Fields {
+			add(t)
+		}
+	case *ast.VarDecl:
+		add(v.Type, v.Value)
+	case *ast.FuncArg:
+		add(v.Type)
+	case *ast.FuncCall:
+		for _, t := range v.TypeArgs {
+			add(t)
+		}
+		for _, e := range v.Args {
+			add(e)
+		}
+	case *ast.MethodCall:
+		add(v.Receiver)
+		for _, e := range v.Args {
+			add(e)
+		}
+	case *ast.Index:
+		add(v.X, v.Index)
+	case *ast.Return:
+		if v.HasValue {
+			add(v.Value)
+		}
+	case *ast.FuncLit:
+		add(v.Decl)
+	case *ast.Try:
+		add(v.X)
+	case *ast.Err:
+		add(v.X)
+	case *ast.InfixExpression:
+		add(v.Left, v.Right)
+	case *ast.Selector:
+		add(v.X)
+	case *ast.Pattern:
+		add(v.X)
+		for _, vd := range v.Bindings {
+			add(vd)
+		}
+	case *ast.Switch:
+		add(v.Value)
+		for _, cs := range v.Cases {
+			add(cs)
+		}
+	case *ast.Case:
+		for _, e := range v.Values {
+			add(e)
+		}
+		for _, s := range v.Body {
+			add(s)
+		}
+	case *ast.Assign:
+		add(v.X, v.Value)
+	case *ast.Defer:
+		add(v.Call)
+	}
+	return nodes
+}
+
+// on reports whether p is on the token t or just after it.
+func on(t token.Token, p token.Pos) bool {
+	return t.Pos.IsValid() && t.Pos <= p && p <= t.End()
+}
+
+// definition returns the location of the declaration of the name at p.
+func (d *document) definition(p Position) *Location {
+	a := d.checked
+	if a == nil {
+		return nil
+	}
+	at := pos(a.file, p)
+	decl, ok := a.declaration(path(a.prog(), at), at)
+	if !ok {
+		return nil
+	}
+	return a.location(decl.Pos, decl.End())
+}
+
+// declaration returns the token that declares the name at p, which is in the
+// innermost node of path.
+func (a *analysis) declaration(path []ast.Node, p token.Pos) (token.Token, bool) {
+	if len(path) == 0 {
+		return token.Token{}, false
+	}
+
+	switch v := path[len(path)-1].(type) {
+	case *ast.Var:
+		switch {
+		case v.VarDecl != nil:
+			return v.VarDecl.Token, true
+		case v.FuncDecl != nil:
+			return v.FuncDecl.Token, true
+		case len(path) > 1:
+			// The name of a union or an enum that a variant or a
+			// member is selected from.
+			if td := a.typeDecl(typeSelectedFrom(path[len(path)-2], v)); td != nil {
+				return td.Token, true
+			}
+		}
+	case *ast.FuncCall:
+		switch {
+		case !on(v.Token, p):
+		case v.FuncDecl != nil:
+			return v.FuncDecl.Token, true
+		case v.VarDecl != nil:
+			return v.VarDecl.Token, true
+		}
+	case *ast.MethodCall:
+		switch {
+		case !on(v.Token, p):
+		case v.FuncDecl != nil:
+			return v.FuncDecl.Token, true
+		case v.Variant != nil:
+			return a.variant(v.Variant)
+		}
+	case *ast.Selector:
+		switch {
+		case v.Member != nil:
+			return a.member(v.Member)
+		case v.Variant != nil:
+			return a.variant(v.Variant)
+		}
+	case *ast.Pattern:
+		if v.Variant != nil {
+			return a.variant(v.Variant)
+		}
+	case *ast.Type:
+		if td := a.typeDecl(v.Type); td != nil {
+			return td.Token, true
+		}
+	case *ast.VarDecl:
+		return v.Token, on(v.Token, p)
+	case *ast.FuncDecl:
+		return v.Token, on(v.Token, p)
+	case *ast.TypeDecl:
+		return v.Token, on(v.Token, p)
+	case *ast.EnumMember:
+		return v.Token, on(v.Token, p)
+	case *ast.Variant:
+		return v.Token, on(v.Token, p)
+	}
+	return token.Token{}, false
+}
+
+// typeSelectedFrom returns the type that parent selects a member or a variant
+// from if x names it, or nil.
+func typeSelectedFrom(parent ast.Node, x *ast.Var) types.Type {
+	switch v := parent.(type) {
+	case *ast.Selector:
+		if v.X == x && (v.Member != nil || v.Variant != nil) {
+			return v.Type()
+		}
+	case *ast.MethodCall:
+		if v.Receiver == x && v.Variant != nil {
+			return v.Variant.Union
+		}
+	case *ast.Pattern:
+		if v.X == x && v.Variant != nil {
+			return v.Variant.Union
+		}
+	}
+	return nil
+}
+
+// typeDecl returns the declaration of the named type that t is or points to,
+// or nil if it is not declared in one of the packages.
+func (a *analysis) typeDecl(t types.Type) *ast.TypeDecl {
+	for {
+		switch v := t.(type) {
+		case *types.Pointer:
+			t = v.To
+			continue
+		case *types.Slice:
+			t = v.Elem
+			continue
+		case *types.Optional:
+			t = v.Elem
+			continue
+		case nil:
+			return nil
+		}
+		break
+	}
+
+	for _, prog := range a.progs {
+		for _, stmt := range prog.Statements {
+			if td, ok := stmt.(*ast.TypeDecl); ok && td.Type != nil && td.Type.Type == t {
+				return td
+			}
+		}
+	}
+	return nil
+}
+
+func (a *analysis) member(m *types.EnumMember) (token.Token, bool) {
+	if td := a.typeDecl(m.Enum); td != nil {
+		for _, em := range td.Members {
+			if em.Token.Value == m.Name {
+				return em.Token, true
+			}
+		}
+	}
+	return token.Token{}, false
+}
+
+func (a *analysis) variant(vr *types.Variant) (token.Token, bool) {
+	if td := a.typeDecl(vr.Union); td != nil {
+		for _, v := range td.Variants {
+			if v.Token.Value == vr.Name {
+				return v.Token, true
+			}
+		}
+	}
+	return token.Token{}, false
+}
+
+// hover returns the declaration or the type of the name or the expression at
+// p.
+func (d *document) hover(p Position) *Hover {
+	a := d.checked
+	if a == nil {
+		return nil
+	}
+	at := pos(a.file, p)
+	path := path(a.prog(), at)
+	if len(path) == 0 {
+		return nil
+	}
+
+	n := path[len(path)-1]
+	text := ""
+	switch v := n.(type) {
+	case *ast.Var:
+		switch {
+		case v.VarDecl != nil:
+			text = describeVar(v.VarDecl)
+		case v.FuncDecl != nil:
+			text = signature(v.FuncDecl)
+		case len(path) > 1:
+			if td := a.typeDecl(typeSelectedFrom(path[len(path)-2], v)); td != nil {
+				text = describeType(td)
+			}
+		}
+	case *ast.FuncCall:
+		switch {
+		case on(v.Token, at) && v.FuncDecl != nil:
+			text = signature(v.FuncDecl)
+		case on(v.Token, at) && v.VarDecl != nil:
+			text = describeVar(v.VarDecl)
+		}
+	case *ast.MethodCall:
+		switch {
+		case on(v.Token, at) && v.FuncDecl != nil:
+			text = signature(v.FuncDecl)
+		case on(v.Token, at) && v.Method != nil:
+			text = "func " + v.Method.Name + strings.TrimPrefix(types.String(v.Method.Sig), "func")
+		}
+	case *ast.VarDecl:
+		if on(v.Token, at) {
+			text = describeVar(v)
+		}
+	case *ast.FuncDecl:
+		if on(v.Token, at) {
+			text = signature(v)
+		}
+	case *ast.TypeDecl:
+		if on(v.Token, at) {
+			text = describeType(v)
+		}
+	case *ast.Type:
+		if td := a.typeDecl(v.Type); td != nil && td.Type.Type == v.Type {
+			text = describeType(td)
+		} else {
+			text = types.String(v.Type)
+		}
+	}
+	if e, ok := n.(ast.Expression); ok && text == "" {
+		if t := e.Type(); t != types.TypeNil && t != nil {
+			text = types.String(t)
+		}
+	}
+	if text == "" {
+		return nil
+	}
+
+	r := nodeRange(a.file, n.Pos(), n.End())
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: "```lang\n" + text + "\n```"}, Range: &r}
+}
+
+func describeVar(vd *ast.VarDecl) string {
+	if vd.Type == nil {
+		return "var " + vd.Token.Value
+	}
+	return "var " + vd.Token.Value + " " + types.String(vd.Type.Type)
+}
+
+// signature returns the declaration of a function without its body.
+func signature(fd *ast.FuncDecl) string {
+	var b strings.Builder
+	b.WriteString("func ")
+	if fd.Receiver != nil {
+		b.WriteString("(" + fd.Receiver.Token.Value + " " + types.String(fd.Receiver.Type.Type) + ") ")
+	}
+	b.WriteString(fd.Token.Value)
+
+	if len(fd.TypeParams) > 0 {
+		tps := make([]string, 0, len(fd.TypeParams))
+		for _, tp := range fd.TypeParams {
+			s := tp.Token.Value
+			if tp.Constraint != nil {
+				s += " " + types.String(tp.Constraint.Type)
+			}
+			tps = append(tps, s)
+		}
+		b.WriteString("[" + strings.Join(tps, ", ") + "]")
+	}
+
+	params := make([]string, 0, len(fd.Params)+1)
+	for i, vd := range fd.Params {
+		t := types.String(vd.Type.Type)
+		if s, ok := vd.Type.Type.(*types.Slice); ok && fd.Variadic && i == len(fd.Params)-1 {
+			t = "..." + types.String(s.Elem)
+		}
+		params = append(params, vd.Token.Value+" "+t)
+	}
+	if fd.VarArgs {
+		params = append(params, "...")
+	}
+	b.WriteString("(" + strings.Join(params, ", ") + ")")
+
+	if fd.HasReturn {
+		b.WriteString(" " + types.String(fd.ReturnType.Type))
+	}
+	return b.String()
+}
+
+func describeType(td *ast.TypeDecl) string {
+	switch v := td.Type.Type.(type) {
+	case *types.Named:
+		return "type " + td.Token.Value + " " + types.String(v.Underlying)
+	case *types.Enum:
+		return "enum " + td.Token.Value
+	case *types.Union:
+		return "union " + td.Token.Value
+	case *types.Interface:
+		return "type " + td.Token.Value + " interface"
+	default:
+		return "type " + td.Token.Value
+	}
+}
+
+// symbols returns the functions and the variables that the document
+// declares at the top level. They are taken from the syntax tree of the
+// document alone, so that they are found even if it has errors.
+func (d *document) symbols() []DocumentSymbol {
+	file := d.syntaxFset.Files()[0]
+	symbols := make([]DocumentSymbol, 0)
+	for _, stmt := range d.syntax.Statements {
+		var sym DocumentSymbol
+		switch v := stmt.(type) {
+		case *ast.FuncDecl:
+			sym = DocumentSymbol{Name: v.Token.Value, Detail: signature(v), Kind: SymbolFunction}
+			sym.SelectionRange = nodeRange(file, v.Token.Pos, v.Token.End())
+			if v.Receiver != nil {
+				sym.Kind = SymbolMethod
+			}
+		case *ast.VarDecl:
+			sym = DocumentSymbol{Name: v.Token.Value, Kind: SymbolVariable}
+			sym.SelectionRange = nodeRange(file, v.Token.Pos, v.Token.End())
+			if v.Type != nil {
+				sym.Detail = types.String(v.Type.Type)
+			}
+		default:
+			continue
+		}
+		sym.Range = nodeRange(file, stmt.Pos(), stmt.End())
+		symbols = append(symbols, sym)
+	}
+	return symbols
+}
+
+// completion returns the names that can be written at p. After a name and a
+// dot, these are the exported names of an imported package or the members
+// of an enum or a union. Otherwise they are the names in scope at p, the
+// innermost first.
+func (d *document) completion(p Position) *CompletionList {
+	list := &CompletionList{Items: make([]CompletionItem, 0)}
+	a := d.checked
+	if a == nil {
+		return list
+	}
+
+	// The document is usually being edited, so it is read from its current
+	// text, while the scopes come from its last check.
+	current := d.syntaxFset.Files()[0]
+	at := pos(a.file, p)
+	path := path(a.prog(), at)
+	ctx := a.checker().Scope()
+	for i := len(path) - 1; i >= 0; i-- {
+		if scope, ok := a.checker().Scopes[path[i]]; ok {
+			ctx = scope
+			break
+		}
+	}
+
+	if qual, ok := qualifier(current.Source(), current.Offset(pos(current, p))); ok {
+		list.Items = a.qualified(ctx, qual)
+		return list
+	}
+
+	seen := make(map[string]bool)
+	add := func(item CompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			list.Items = append(list.Items, item)
+		}
+	}
+	for ; ctx != nil; ctx = ctx.Outer() {
+		local := ctx.Outer() != nil
+		for _, name := range sorted(ctx.Vars()) {
+			vd := ctx.Vars()[name]
+			if local && vd.Pos() >= at {
+				continue
+			}
+			add(varItem(vd))
+		}
+		for _, name := range sorted(ctx.Funcs()) {
+			add(funcItem(ctx.Funcs()[name]))
+		}
+		for _, name := range sorted(ctx.Types()) {
+			add(typeItem(ctx.Types()[name]))
+		}
+	}
+	for _, imp := range a.prog().Imports {
+		add(CompletionItem{Label: imp.Name, Kind: CompletionModule, Detail: "import " + strconv.Quote(imp.Path)})
+	}
+	return list
+}
+
+// qualified returns the names that can follow qual and a dot in ctx.
+func (a *analysis) qualified(ctx *checker.Context, qual string) []CompletionItem {
+	items := make([]CompletionItem, 0)
+
+	for _, imp := range a.prog().Imports {
+		if imp.Name != qual {
+			continue
+		}
+		for i, prog := range a.progs {
+			if prog != imp.Program {
+				continue
+			}
+			scope := a.checkers[i].Scope()
+			exported := func(name string) bool {
+				return prog.Foreign || name != "" && unicode.IsUpper(rune(name[0]))
+			}
+			for _, name := range sorted(scope.Vars()) {
+				if exported(name) {
+					items = append(items, varItem(scope.Vars()[name]))
+				}
+			}
+			for _, name := range sorted(scope.Funcs()) {
+				if exported(name) {
+					items = append(items, funcItem(scope.Funcs()[name]))
+				}
+			}
+			for _, name := range sorted(scope.Types()) {
+				if exported(name) {
+					items = append(items, typeItem(scope.Types()[name]))
+				}
+			}
+		}
+		return items
+	}
+
+	for ; ctx != nil; ctx = ctx.Outer() {
+		td, ok := ctx.Types()[qual]
+		if !ok {
+			continue
+		}
+		for _, em := range td.Members {
+			items = append(items, CompletionItem{Label: em.Token.Value, Kind: CompletionEnumMember, Detail: qual})
+		}
+		for _, v := range td.Variants {
+			items = append(items, CompletionItem{Label: v.Token.Value, Kind: CompletionEnumMember, Detail: qual})
+		}
+		break
+	}
+	return items
+}
+
+// qualifier returns the name before the dot that precedes the name being
+// written at offset in src, if there is one.
+func qualifier(src []byte, offset int) (string, bool) {
+	i := identStart(src, offset)
+	if i == 0 || src[i-1] != '.' {
+		return "", false
+	}
+	j := identStart(src, i-1)
+	return string(src[j : i-1]), j < i-1
+}
+
+// identStart returns the offset of the first character of the identifier
+// that ends at offset in src.
+func identStart(src []byte, offset int) int {
+	for offset > 0 {
+		r, size := utf8.DecodeLastRune(src[:offset])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		offset -= size
+	}
+	return offset
+}
+
+func varItem(vd *ast.VarDecl) CompletionItem {
+	item := CompletionItem{Label: vd.Token.Value, Kind: CompletionVariable}
+	if vd.Type != nil {
+		item.Detail = types.String(vd.Type.Type)
+	}
+	return item
+}
+
+func funcItem(fd *ast.FuncDecl) CompletionItem {
+	return CompletionItem{Label: fd.Token.Value, Kind: CompletionFunction, Detail: signature(fd)}
+}
+
+func typeItem(td *ast.TypeDecl) CompletionItem {
+	item := CompletionItem{Label: td.Token.Value, Kind: CompletionClass, Detail: describeType(td)}
+	switch td.Type.Type.(type) {
+	case *types.Enum, *types.Union:
+		item.Kind = CompletionEnum
+	case *types.Interface:
+		item.Kind = CompletionInterface
+	}
+	return item
+}
+
+// sorted returns the keys of a map with string keys in order.
+func sorted(m interface{}) []string {
+	keys := make([]string, 0)
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,391 @@
+// Package lsp implements a server of the Language Server Protocol. It checks
+// the open documents on each edit and publishes their diagnostics, and finds
+// the definitions, the types, the declarations and the names in scope that
+// editors ask for.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lang/ast"
+	"lang/checker"
+	"lang/diag"
+	"lang/lexer"
+	"lang/loader"
+	"lang/parser"
+	"lang/token"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Server serves one client over a stream, like the standard input and output
+// of the process that an editor starts.
+type Server struct {
+	// Path lists the directories that imports are resolved in after the
+	// directory of a document, and Include the directories of C headers.
+	Path    []string
+	Include []string
+
+	in  *bufio.Reader
+	out io.Writer
+
+	// docs holds the open documents by URI.
+	docs        map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(r),
+		out:  w,
+		docs: make(map[string]*document),
+	}
+}
+
+// errExit is returned by the handler of the exit notification.
+var errExit = errors.New("exit")
+
+// Run serves requests until the client sends the exit notification or closes
+// the stream. It returns an error if the client exits or closes the stream
+// without asking the server to shut down first.
+func (s *Server) Run() error {
+	for {
+		body, err := s.read()
+		if err == io.EOF {
+			if s.shutdown {
+				return nil
+			}
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.reply(json.RawMessage("null"), nil, &ResponseError{ParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		result, err := s.handle(&req)
+		if err == errExit {
+			if s.shutdown {
+				return nil
+			}
+			return errors.New("exit before shutdown")
+		}
+		if req.ID == nil {
+			// Notifications have no response, not even for errors.
+			continue
+		}
+		var rerr *ResponseError
+		if err != nil && !errors.As(err, &rerr) {
+			return err
+		}
+		if err := s.reply(*req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads the body of the next message, which follows a header with its
+// length.
+func (s *Server) read() ([]byte, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *Server) write(msg interface{}) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(b)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(b)
+	return err
+}
+
+func (s *Server) reply(id json.RawMessage, result interface{}, rerr *ResponseError) error {
+	resp := &response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = b
+	}
+	return s.write(resp)
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	return s.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle handles a request and returns its result. Errors of the request are
+// *ResponseErrors, while other errors stop the server.
+func (s *Server) handle(req *request) (interface{}, error) {
+	switch {
+	case req.Method == "exit":
+		return nil, errExit
+	case req.Method == "initialize":
+		s.initialized = true
+		return &InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       SyncFull,
+				DefinitionProvider:     true,
+				HoverProvider:          true,
+				DocumentSymbolProvider: true,
+				CompletionProvider:     &CompletionOptions{TriggerCharacters: []string{"."}},
+			},
+			ServerInfo: ServerInfo{Name: "lang"},
+		}, nil
+	case !s.initialized:
+		return nil, &ResponseError{ServerNotInitialized, "server not initialized"}
+	case s.shutdown:
+		return nil, &ResponseError{InvalidRequest, "server is shut down"}
+	}
+
+	switch req.Method {
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, s.update(params.TextDocument.URI, text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+
+	case "textDocument/definition":
+		doc, pos, err := s.position(req.Params)
+		if doc == nil {
+			return nil, err
+		}
+		return doc.definition(pos), nil
+	case "textDocument/hover":
+		doc, pos, err := s.position(req.Params)
+		if doc == nil {
+			return nil, err
+		}
+		return doc.hover(pos), nil
+	case "textDocument/completion":
+		doc, pos, err := s.position(req.Params)
+		if doc == nil {
+			return nil, err
+		}
+		return doc.completion(pos), nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return nil, &ResponseError{InvalidParams, "document not open: " + params.TextDocument.URI}
+		}
+		return doc.symbols(), nil
+	}
+
+	if strings.HasPrefix(req.Method, "$/") {
+		// Optional notifications and requests can be ignored.
+		return nil, nil
+	}
+	return nil, &ResponseError{MethodNotFound, "method not found: " + req.Method}
+}
+
+func unmarshal(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ResponseError{InvalidParams, err.Error()}
+	}
+	return nil
+}
+
+// position returns the open document and the position of the parameters of a
+// request, or nil and an error.
+func (s *Server) position(params json.RawMessage) (*document, Position, error) {
+	var p TextDocumentPositionParams
+	if err := unmarshal(params, &p); err != nil {
+		return nil, Position{}, err
+	}
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, Position{}, &ResponseError{InvalidParams, "document not open: " + p.TextDocument.URI}
+	}
+	return doc, p.Position, nil
+}
+
+// update sets the text of a document, checks it and publishes its
+// diagnostics.
+func (s *Server) update(uri, text string) error {
+	path, err := uriPath(uri)
+	if err != nil {
+		return &ResponseError{InvalidParams, err.Error()}
+	}
+	doc, ok := s.docs[uri]
+	if !ok {
+		doc = &document{uri: uri, path: path}
+		s.docs[uri] = doc
+	}
+	doc.text = []byte(text)
+
+	diags := s.check(doc)
+	return s.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+// check parses and checks a document with the packages it imports, reading
+// the other open documents instead of their files. It returns the
+// diagnostics of the document, where errors in other files are reported at
+// its start.
+func (s *Server) check(doc *document) []Diagnostic {
+	fset := token.NewFileSet()
+	p := parser.New(lexer.NewFile(fset.AddFile(doc.path, doc.text)))
+	doc.syntax, _ = p.ParseProgram()
+	doc.syntaxFset = fset
+
+	name := doc.syntax.Package
+	if name == "" {
+		name = "main"
+	}
+
+	dirs := append([]string{filepath.Dir(doc.path)}, s.Path...)
+	ld := loader.New(dirs)
+	ld.Include = s.Include
+	ld.Overlay = make(map[string][]byte)
+	for _, other := range s.docs {
+		ld.Overlay[other.path] = other.text
+	}
+
+	progs, ok := ld.LoadPackage(doc.path, name)
+	diags := append(ld.Warnings, ld.Errors...)
+	if ok {
+		a := &analysis{fset: ld.Fset, progs: progs, checkers: checker.Checkers(progs)}
+		for _, c := range a.checkers {
+			diags = append(diags, c.Errors...)
+		}
+		a.file = a.fileNamed(doc.path)
+		if a.file != nil {
+			doc.checked = a
+		}
+	}
+
+	result := make([]Diagnostic, 0, len(diags))
+	for _, d := range diags {
+		result = append(result, convert(ld.Fset, doc.path, d))
+	}
+	return result
+}
+
+// convert returns the diagnostic d of the document at path. Diagnostics of
+// other files are moved to the start of the document and name their
+// position in their message.
+func convert(fset *token.FileSet, path string, d *diag.Diagnostic) Diagnostic {
+	severity := SeverityError
+	if d.Severity == diag.Warning {
+		severity = SeverityWarning
+	}
+	result := Diagnostic{
+		Severity: severity,
+		Code:     d.Code,
+		Source:   "lang",
+		Message:  d.Message,
+	}
+	if d.Span.Start.Filename == path {
+		result.Range = spanRange(fset, d.Span)
+	} else if d.Span.Start.Filename != "" {
+		result.Message = d.Span.Start.String() + ": " + d.Message
+	}
+
+	for _, n := range d.Notes {
+		if n.Span.Start.Filename == "" {
+			result.Message += "\n" + n.Message
+			continue
+		}
+		result.RelatedInformation = append(result.RelatedInformation, DiagnosticRelatedInformation{
+			Location: Location{URI: pathURI(n.Span.Start.Filename), Range: spanRange(fset, n.Span)},
+			Message:  n.Message,
+		})
+	}
+	return result
+}
+
+// uriPath returns the path of a file URI.
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported URI %s", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// pathURI returns the file URI of a path.
+func pathURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	u := &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+// document is an open file and the result of checking it.
+type document struct {
+	uri  string
+	path string
+	text []byte
+
+	// syntax is the syntax tree of the document alone in syntaxFset, which
+	// is kept even if it has errors.
+	syntax     *ast.Program
+	syntaxFset *token.FileSet
+
+	// checked holds the result of the last check of the document that had
+	// no syntax errors, which is used while the document is being edited.
+	checked *analysis
+}
@@ -0,0 +1,450 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const mainSource = `import "util"
+
+var limit i32 = 10
+
+enum Color { Red, Green }
+
+func add(a i32, b i32) i32 {
+	var sum i32 = a + b
+	return sum
+}
+
+func main() i32 {
+	var total i32 = add(1, util.Twice(2))
+	var c Color = Color.Red
+	return total
+}
+`
+
+const utilSource = `package util
+
+func Twice(x i32) i32 {
+	return x + x
+}
+`
+
+// message is a message that the server sends.
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *ResponseError  `json:"error"`
+}
+
+// client is a scripted client of a server that runs until the test ends.
+type client struct {
+	t    *testing.T
+	in   *io.PipeWriter
+	out  *bufio.Reader
+	done chan error
+	id   int
+
+	// notifications holds the notifications read while waiting for a
+	// response.
+	notifications []*message
+}
+
+func start(t *testing.T) *client {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	s := NewServer(inr, outw)
+
+	c := &client{t: t, in: inw, out: bufio.NewReader(outr), done: make(chan error, 1)}
+	go func() {
+		err := s.Run()
+		outw.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() { inw.Close() })
+	return c
+}
+
+func (c *client) send(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	b, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(b), b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() *message {
+	header, err := textproto.NewReader(c.out).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("reading header: %v", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf("invalid Content-Length: %v", err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.out, body); err != nil {
+		c.t.Fatal(err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("invalid message %s: %v", body, err)
+	}
+	return &msg
+}
+
+// call sends a request and decodes its result into result. It returns the
+// error of the response.
+func (c *client) call(method string, params, result interface{}) *ResponseError {
+	c.id++
+	c.send(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	for {
+		msg := c.read()
+		if msg.ID == nil {
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if *msg.ID != c.id {
+			c.t.Fatalf("got response %d, want %d", *msg.ID, c.id)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				c.t.Fatalf("%s: invalid result %s: %v", method, msg.Result, err)
+			}
+		}
+		return nil
+	}
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// diagnostics returns the next diagnostics that the server publishes.
+func (c *client) diagnostics() *PublishDiagnosticsParams {
+	var msg *message
+	if len(c.notifications) > 0 {
+		msg, c.notifications = c.notifications[0], c.notifications[1:]
+	} else {
+		msg = c.read()
+	}
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("got %s, want diagnostics", msg.Method)
+	}
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	return &params
+}
+
+// open sends the text of a document and returns its diagnostics.
+func (c *client) open(uri, text string) []Diagnostic {
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": TextDocumentItem{URI: uri, LanguageID: "lang", Version: 1, Text: text},
+	})
+	return c.diagnostics().Diagnostics
+}
+
+func (c *client) change(uri, text string) []Diagnostic {
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   TextDocumentIdentifier{URI: uri},
+		"contentChanges": []TextDocumentContentChangeEvent{{Text: text}},
+	})
+	return c.diagnostics().Diagnostics
+}
+
+func (c *client) exit() error {
+	if err := c.call("shutdown", nil, nil); err != nil {
+		c.t.Fatalf("shutdown: %v", err)
+	}
+	c.notify("exit", nil)
+	return <-c.done
+}
+
+// at returns the position of the n-th character of the first occurrence of
+// s in text.
+func at(t *testing.T, text, s string, n int) Position {
+	i := strings.Index(text, s)
+	if i < 0 {
+		t.Fatalf("%q not in source", s)
+	}
+	i += n
+	line := strings.Count(text[:i], "\n")
+	return Position{Line: line, Character: i - strings.LastIndex(text[:i], "\n") - 1}
+}
+
+func textDocument(uri string, p Position) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: p}
+}
+
+// setup writes the package util and returns the URI of the main package
+// next to it, which is only sent to the server.
+func setup(t *testing.T) (string, string) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "util"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	util := filepath.Join(root, "util", "util.lang")
+	if err := os.WriteFile(util, []byte(utilSource), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return pathURI(filepath.Join(root, "main.lang")), pathURI(util)
+}
+
+func initialize(t *testing.T) *client {
+	c := start(t)
+	var result InitializeResult
+	if err := c.call("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, &result); err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	if !result.Capabilities.DefinitionProvider || result.Capabilities.TextDocumentSync != SyncFull {
+		t.Fatalf("unexpected capabilities %+v", result.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func TestDiagnostics(t *testing.T) {
+	uri, _ := setup(t)
+	c := initialize(t)
+
+	if diags := c.open(uri, mainSource); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %+v", diags)
+	}
+
+	text := strings.Replace(mainSource, "return sum", "return su", 1)
+	diags := c.change(uri, text)
+	want := Range{Start: at(t, text, "su\n", 0), End: at(t, text, "su\n", 2)}
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, "su not declared") || diags[0].Range != want {
+		t.Fatalf("expected su not declared at %+v, got %+v", want, diags)
+	}
+
+	text = strings.Replace(mainSource, "var sum i32", "var sum i32 +", 1)
+	diags = c.change(uri, text)
+	if len(diags) == 0 || diags[0].Severity != SeverityError || diags[0].Range.Start.Line != 7 {
+		t.Fatalf("expected a syntax error on line 8, got %+v", diags)
+	}
+
+	text = strings.Replace(mainSource, `"util"`, `"nope"`, 1)
+	diags = c.change(uri, text)
+	if len(diags) != 1 || !strings.Contains(diags[0].Message, `cannot find package "nope"`) {
+		t.Fatalf("expected an import error, got %+v", diags)
+	}
+
+	c.notify("textDocument/didClose", map[string]interface{}{"textDocument": TextDocumentIdentifier{URI: uri}})
+	if params := c.diagnostics(); params.URI != uri || len(params.Diagnostics) != 0 {
+		t.Fatalf("expected the diagnostics to be cleared, got %+v", params)
+	}
+
+	if err := c.exit(); err != nil {
+		t.Fatalf("server exited with %v", err)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	uri, utilURI := setup(t)
+	c := initialize(t)
+	c.open(uri, mainSource)
+
+	tests := []struct {
+		at   Position
+		uri  string
+		want Position
+	}{
+		{at(t, mainSource, "add(1", 1), uri, at(t, mainSource, "add(a", 0)},
+		{at(t, mainSource, "return sum", 8), uri, at(t, mainSource, "sum i32", 0)},
+		{at(t, mainSource, "a + b", 0), uri, at(t, mainSource, "a i32", 0)},
+		{at(t, mainSource, "Twice", 2), utilURI, at(t, utilSource, "Twice", 0)},
+		{at(t, mainSource, "Color.Red", 6), uri, at(t, mainSource, "Red", 0)},
+		{at(t, mainSource, "Color.Red", 0), uri, at(t, mainSource, "Color {", 0)},
+		{at(t, mainSource, "c Color", 3), uri, at(t, mainSource, "Color {", 0)},
+	}
+	for i, tt := range tests {
+		var loc *Location
+		if err := c.call("textDocument/definition", textDocument(uri, tt.at), &loc); err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		if loc == nil || loc.URI != tt.uri || loc.Range.Start != tt.want {
+			t.Errorf("[%d] got %+v, want %s at %+v", i, loc, tt.uri, tt.want)
+		}
+	}
+
+	var loc *Location
+	if err := c.call("textDocument/definition", textDocument(uri, at(t, mainSource, "10", 0)), &loc); err != nil || loc != nil {
+		t.Errorf("expected no definition of a literal, got %+v, %v", loc, err)
+	}
+
+	// The last check without syntax errors is used while editing.
+	text := strings.Replace(mainSource, "\treturn total", "\treturn total +", 1)
+	c.change(uri, text)
+	if err := c.call("textDocument/definition", textDocument(uri, at(t, text, "add(1", 0)), &loc); err != nil || loc == nil {
+		t.Errorf("expected a definition after a syntax error, got %+v, %v", loc, err)
+	}
+
+	if err := c.exit(); err != nil {
+		t.Fatalf("server exited with %v", err)
+	}
+}
+
+func TestHover(t *testing.T) {
+	uri, _ := setup(t)
+	c := initialize(t)
+	c.open(uri, mainSource)
+
+	tests := []struct {
+		at   Position
+		want string
+	}{
+		{at(t, mainSource, "return total", 7), "var total i32"},
+		{at(t, mainSource, "add(1", 0), "func add(a i32, b i32) i32"},
+		{at(t, mainSource, "Twice", 0), "func Twice(x i32) i32"},
+		{at(t, mainSource, "a + b", 2), "i32"},
+		{at(t, mainSource, "Color.Red", 6), "Color"},
+		{at(t, mainSource, "c Color", 2), "enum Color"},
+		{at(t, mainSource, "limit", 0), "var limit i32"},
+		{at(t, mainSource, "add(a", 0), "func add(a i32, b i32) i32"},
+	}
+	for i, tt := range tests {
+		var hover *Hover
+		if err := c.call("textDocument/hover", textDocument(uri, tt.at), &hover); err != nil {
+			t.Fatalf("[%d] %v", i, err)
+		}
+		want := "```lang\n" + tt.want + "\n```"
+		if hover == nil || hover.Contents.Value != want {
+			t.Errorf("[%d] got %+v, want %q", i, hover, want)
+		}
+	}
+
+	var hover *Hover
+	want := Range{Start: at(t, mainSource, "a + b", 0), End: at(t, mainSource, "a + b", 5)}
+	if err := c.call("textDocument/hover", textDocument(uri, at(t, mainSource, "a + b", 2)), &hover); err != nil || hover.Range == nil || *hover.Range != want {
+		t.Errorf("got range %+v, want %+v", hover, want)
+	}
+
+	if err := c.exit(); err != nil {
+		t.Fatalf("server exited with %v", err)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	uri, _ := setup(t)
+	c := initialize(t)
+
+	// Symbols are found even with syntax errors.
+	text := strings.Replace(mainSource, "return total", "return total +", 1)
+	c.open(uri, text)
+
+	var symbols []DocumentSymbol
+	if err := c.call("textDocument/documentSymbol", map[string]interface{}{"textDocument": TextDocumentIdentifier{URI: uri}}, &symbols); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name string
+		kind SymbolKind
+		line int
+	}{
+		{"limit", SymbolVariable, 2},
+		{"add", SymbolFunction, 6},
+		{"main", SymbolFunction, 11},
+	}
+	if len(symbols) != len(want) {
+		t.Fatalf("got %d symbols, want %d: %+v", len(symbols), len(want), symbols)
+	}
+	for i, w := range want {
+		s := symbols[i]
+		if s.Name != w.name || s.Kind != w.kind || s.SelectionRange.Start.Line != w.line {
+			t.Errorf("[%d] got %+v, want %s of kind %d on line %d", i, s, w.name, w.kind, w.line+1)
+		}
+	}
+	if symbols[1].Detail != "func add(a i32, b i32) i32" || symbols[1].Range.End.Line != 9 {
+		t.Errorf("got %+v for add", symbols[1])
+	}
+
+	if err := c.exit(); err != nil {
+		t.Fatalf("server exited with %v", err)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	uri, _ := setup(t)
+	c := initialize(t)
+	c.open(uri, mainSource)
+
+	labels := func(p Position) []string {
+		var list CompletionList
+		if err := c.call("textDocument/completion", textDocument(uri, p), &list); err != nil {
+			t.Fatal(err)
+		}
+		labels := make([]string, 0)
+		for _, item := range list.Items {
+			labels = append(labels, item.Label)
+		}
+		return labels
+	}
+
+	// Locals come first and are only those declared before the position.
+	got := labels(at(t, mainSource, "\tvar c", 0))
+	want := []string{"total", "limit", "add", "main", "Color", "util"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got = labels(at(t, mainSource, "return sum", 0))
+	want = []string{"a", "b", "sum", "limit", "add", "main", "Color", "util"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	text := strings.Replace(mainSource, "\treturn total", "\tutil.Tw\n\treturn total", 1)
+	c.change(uri, text)
+	if got := labels(at(t, text, "util.Tw", 7)); len(got) != 1 || got[0] != "Twice" {
+		t.Errorf("got %v, want [Twice]", got)
+	}
+
+	text = strings.Replace(mainSource, "\treturn total", "\tvar d Color = Color.\n\treturn total", 1)
+	c.change(uri, text)
+	if got := labels(at(t, text, "Color.\n", 6)); strings.Join(got, " ") != "Red Green" {
+		t.Errorf("got %v, want [Red Green]", got)
+	}
+
+	if err := c.exit(); err != nil {
+		t.Fatalf("server exited with %v", err)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	c := start(t)
+	if err := c.call("textDocument/hover", nil, nil); err == nil || err.Code != ServerNotInitialized {
+		t.Fatalf("expected ServerNotInitialized, got %v", err)
+	}
+	if err := c.call("initialize", map[string]interface{}{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.call("textDocument/unknown", map[string]interface{}{}, nil); err == nil || err.Code != MethodNotFound {
+		t.Fatalf("expected MethodNotFound, got %v", err)
+	}
+	if err := c.call("textDocument/hover", textDocument("file:///nope.lang", Position{}), nil); err == nil || err.Code != InvalidParams {
+		t.Fatalf("expected InvalidParams, got %v", err)
+	}
+
+	c.notify("exit", nil)
+	if err := <-c.done; err == nil {
+		t.Fatalf("expected an error for exit without shutdown")
+	}
+}
@@ -1,12 +1,14 @@
 package parser
 
 import (
-	"fmt"
 	"lang/ast"
+	"lang/diag"
 	"lang/lexer"
 	"lang/token"
 	"lang/types"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -22,16 +24,31 @@ const (
 
 type Parser struct {
 	l        *lexer.Lexer
+	prev     token.Token
 	curr     token.Token
 	next     token.Token
 	register int
-	Errors   []string
+	Errors   []*diag.Diagnostic
+
+	// lastError is the token of the last syntax error, and lexed the number
+	// of errors of the lexer that have been added to Errors.
+	lastError token.Token
+	lexed     int
+
+	// pkg is the name of the package of the file and imports the names of
+	// the packages it imports
+	pkg     string
+	imports map[string]bool
+
+	// comments are the comments that the lexer returned
+	comments []*ast.Comment
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		Errors: make([]string, 0),
+		l:       l,
+		Errors:  make([]*diag.Diagnostic, 0),
+		imports: make(map[string]bool),
 	}
 	p.advance()
 	p.advance()
@@ -39,15 +56,110 @@ func New(l *lexer.Lexer) *Parser {
 }
 
 func (p *Parser) ParseProgram() (*ast.Program, bool) {
-	prog := &ast.Program{make([]ast.Statement, 0)}
+	prog := p.parseProgram()
+	prog.Comments = p.comments
+	p.sortErrors()
+
+	return prog, len(p.Errors) == 0
+}
+
+// sortErrors sorts the errors by position. Errors of the lexer are added when
+// it reads the token after the current one, which can be before errors about
+// the current token.
+func (p *Parser) sortErrors() {
+	sort.SliceStable(p.Errors, func(i, j int) bool {
+		a, b := p.Errors[i].Span.Start, p.Errors[j].Span.Start
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+}
+
+// ParseInput parses an input of an interactive session, which is a
+// declaration, a statement or an expression. Calls and try are parsed as
+// expressions, so that their values can be shown.
+func (p *Parser) ParseInput() (ast.Node, bool) {
+	for p.currIs(token.SEMICOLON) {
+		p.advance()
+	}
+
+	var n ast.Node
+	var ok bool
+	switch p.curr.Type {
+	case token.FUNC:
+		n, ok = p.parseFuncDecl()
+	case token.EXPORT:
+		n, ok = p.parseExportDecl()
+	case token.TYPE:
+		n, ok = p.parseTypeDecl()
+	case token.ENUM:
+		n, ok = p.parseEnumDecl()
+	case token.UNION:
+		n, ok = p.parseUnionDecl()
+	case token.VAR, token.SWITCH, token.DEFER, token.RETURN:
+		n, ok = p.parseStatement()
+	case token.IDENT:
+		if p.nextIs(token.ASSIGN) {
+			n, ok = p.parseAssign()
+			break
+		}
+		fallthrough
+	default:
+		n, ok = p.parseExpression(LOWEST)
+	}
+
+	if ok {
+		for p.currIs(token.SEMICOLON) {
+			p.advance()
+		}
+		if !p.currIs(token.EOF) {
+			p.error(p.curr, "expected end of input, got %s", describe(p.curr))
+			ok = false
+		}
+	}
+	p.sortErrors()
+
+	return n, ok && len(p.Errors) == 0
+}
+
+func (p *Parser) parseProgram() *ast.Program {
+	prog := &ast.Program{Imports: make([]*ast.Import, 0), Statements: make([]ast.Statement, 0)}
+
+	if p.currIs(token.PACKAGE) {
+		if !p.parsePackage(prog) {
+			return prog
+		}
+		p.expectSemi()
+	}
+
+	for p.currIs(token.IMPORT) {
+		imp, ok := p.parseImport()
+		if !ok {
+			return prog
+		}
+		prog.Imports = append(prog.Imports, imp)
+		p.expectSemi()
+	}
 
 	for !p.currIs(token.EOF) {
+		if p.currIs(token.SEMICOLON) {
+			p.advance()
+			continue
+		}
+
 		var stmt ast.Statement
 		var ok bool
+		start := p.curr
 
 		switch p.curr.Type {
 		case token.FUNC:
 			stmt, ok = p.parseFuncDecl()
+		case token.EXPORT:
+			stmt, ok = p.parseExportDecl()
+		case token.TYPE:
+			stmt, ok = p.parseTypeDecl()
+		case token.ENUM:
+			stmt, ok = p.parseEnumDecl()
+		case token.UNION:
+			stmt, ok = p.parseUnionDecl()
 		case token.VAR:
 			stmt, ok = p.parseVarDecl()
 		default:
@@ -56,13 +168,117 @@ func (p *Parser) ParseProgram() (*ast.Program, bool) {
 		}
 
 		if !ok {
-			return prog, false
+			p.sync(start, declStops...)
+			stmt = &ast.BadStmt{From: start, To: p.curr}
+		} else if !p.expectSemi() {
+			p.sync(start, declStops...)
 		}
 
 		prog.Statements = append(prog.Statements, stmt)
 	}
 
-	return prog, true
+	return prog
+}
+
+func (p *Parser) parsePackage(prog *ast.Program) bool {
+	if !p.assertCurrIs(token.PACKAGE) {
+		return false
+	}
+	prog.PackagePos = p.curr.Pos
+	p.advance()
+
+	if !p.assertCurrIs(token.IDENT) {
+		return false
+	}
+	prog.Package = p.curr.Value
+	p.pkg = p.curr.Value
+	p.advance()
+
+	return true
+}
+
+// parseImport parses an import like import "lib/geom", whose package is
+// referred to by the last element of its path without a .h extension, or
+// import g "lib/geom".
+func (p *Parser) parseImport() (*ast.Import, bool) {
+	if !p.assertCurrIs(token.IMPORT) {
+		return nil, false
+	}
+	kw := p.curr
+	p.advance()
+
+	var name token.Token
+	if p.currIs(token.IDENT) {
+		name = p.curr
+		p.advance()
+	}
+
+	if !p.assertCurrIs(token.STRING) {
+		return nil, false
+	}
+	path, err := strconv.Unquote(p.curr.Value)
+	if err != nil || path == "" {
+		p.error(p.curr, "invalid import path %s", p.curr.Value)
+		return nil, false
+	}
+	imp := &ast.Import{Token: p.curr, Name: name.Value, Path: path, ImportPos: kw.Pos}
+	if imp.Name == "" {
+		imp.Name = strings.TrimSuffix(path[strings.LastIndex(path, "/")+1:], ".h")
+	}
+	p.advance()
+
+	if p.imports[imp.Name] {
+		p.error(imp.Token, "%s imported twice", imp.Name)
+		return nil, false
+	}
+	p.imports[imp.Name] = true
+
+	return imp, true
+}
+
+// qualify returns the name of a type declared in the file. Types of packages
+// other than main are qualified with the name of their package, so that they
+// are distinct from the types of other packages.
+func (p *Parser) qualify(name string) string {
+	if p.pkg == "" || p.pkg == "main" {
+		return name
+	}
+	return p.pkg + "." + name
+}
+
+// isQualified reports whether the current token is the name of an imported
+// package followed by a selector.
+func (p *Parser) isQualified() bool {
+	return p.currIs(token.IDENT) && p.nextIs(token.DOT) && p.imports[p.curr.Value]
+}
+
+// parseQualified parses a reference to a function or variable of an imported
+// package, like geom.Area(s).
+func (p *Parser) parseQualified() (ast.Expression, bool) {
+	pkg := p.curr
+	p.advance()
+	p.advance()
+
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	if p.nextIs(token.LPAREN) || p.nextIs(token.LBRACKET) {
+		fc, ok := p.parseFuncCall()
+		if !ok {
+			return nil, false
+		}
+		fc.Package = pkg.Value
+		fc.PackagePos = pkg.Pos
+		return fc, true
+	}
+
+	v, ok := p.parseVar()
+	if !ok {
+		return nil, false
+	}
+	v.Package = pkg.Value
+	v.PackagePos = pkg.Pos
+	return v, true
 }
 
 func (p *Parser) parseExpression(precedence int) (ast.Expression, bool) {
@@ -72,10 +288,25 @@ func (p *Parser) parseExpression(precedence int) (ast.Expression, bool) {
 	switch p.curr.Type {
 	case token.INT:
 		left, ok = p.parseIntLiteral()
+	case token.STRING:
+		left, ok = p.parseStringLiteral()
+	case token.NONE:
+		left, ok = &ast.None{Token: p.curr}, true
+		p.advance()
+	case token.ERR:
+		left, ok = p.parseErr()
+	case token.TRY:
+		left, ok = p.parseTry()
+	case token.FUNC:
+		left, ok = p.parseFuncLit()
 	case token.IDENT:
-		switch p.next.Type {
-		case token.LPAREN:
+		switch {
+		case p.isQualified():
+			left, ok = p.parseQualified()
+		case p.nextIs(token.LPAREN):
 			left, ok = p.parseFuncCall()
+		case p.nextIs(token.LBRACKET):
+			left, ok = p.parseIndexOrCall()
 		default:
 			left, ok = p.parseVar()
 		}
@@ -98,6 +329,8 @@ func (p *Parser) parseExpression(precedence int) (ast.Expression, bool) {
 			fallthrough
 		case token.PLUS:
 			left, ok = p.parseInfixExpression(left)
+		case token.DOT:
+			left, ok = p.parseSelector(left)
 		default:
 			return left, true
 		}
@@ -127,242 +360,1122 @@ func (p *Parser) parseInfixExpression(left ast.Expression) (ast.Expression, bool
 
 func (p *Parser) parseFuncDecl() (*ast.FuncDecl, bool) {
 	fd := &ast.FuncDecl{
-		Extern: true,
-		Params: make([]*ast.VarDecl, 0),
+		Extern:  true,
+		Package: p.pkg,
+		Params:  make([]*ast.VarDecl, 0),
 	}
 	var ok bool
 
 	if !p.assertCurrIs(token.FUNC) {
 		return nil, false
 	}
+	fd.FuncPos = p.curr.Pos
 	p.advance()
 
-	fd.Token = p.curr
-	p.advance()
-
-	if !p.assertCurrIs(token.LPAREN) {
-		return nil, false
-	}
-	p.advance()
+	if p.currIs(token.LPAREN) {
+		p.advance()
 
-	for !p.currIs(token.RPAREN) {
-		vd, ok := p.parseFuncParam()
+		fd.Receiver, ok = p.parseFuncParam()
 		if !ok {
 			return nil, false
 		}
-		fd.Params = append(fd.Params, vd)
 
-		if p.currIs(token.COMMA) {
-			p.advance()
+		if !p.assertCurrIs(token.RPAREN) {
+			return nil, false
 		}
+		p.advance()
 	}
-	p.advance()
 
-	if p.currIs(token.IDENT) {
-		fd.ReturnType, ok = p.parseType()
-		if !ok {
-			return nil, false
-		}
-		fd.HasReturn = true
+	if !p.parseSignature(fd) {
+		return nil, false
 	}
 
 	if p.currIs(token.LBRACE) {
 		fd.Extern = false
 		p.advance()
 
-		fd.Body, ok = p.parseFuncBody()
-		if !ok {
-			return nil, false
-		}
-		p.advance()
+		p.parseFuncBody(fd)
 	}
 
 	return fd, true
 }
 
-func (p *Parser) parseFuncBody() ([]ast.Statement, bool) {
-	body := make([]ast.Statement, 0)
+// parseExportDecl parses a function that is exported to C, like
+// export func add(a i32, b i32) i32 {}.
+func (p *Parser) parseExportDecl() (*ast.FuncDecl, bool) {
+	if !p.assertCurrIs(token.EXPORT) {
+		return nil, false
+	}
+	kw := p.curr
+	p.advance()
 
-	for !p.currIsOrEOF(token.RBRACE) {
-		var stmt ast.Statement
-		var ok bool
+	fd, ok := p.parseFuncDecl()
+	if !ok {
+		return nil, false
+	}
+	fd.Export = true
+	fd.FuncPos = kw.Pos
 
-		switch p.curr.Type {
-		case token.IDENT:
-			switch p.next.Type {
-			case token.LPAREN:
-				stmt, ok = p.parseFuncCall()
-			default:
-				p.errorInvalidToken()
-				ok = false
-			}
-		case token.RETURN:
-			stmt, ok = p.parseReturn()
-		case token.VAR:
-			stmt, ok = p.parseVarDecl()
-		default:
-			p.errorInvalidToken()
-			ok = false
-		}
+	return fd, true
+}
+
+// parseSignature parses the name, parameters and return type of a function.
+func (p *Parser) parseSignature(fd *ast.FuncDecl) bool {
+	var ok bool
+
+	if !p.assertCurrIs(token.IDENT) {
+		return false
+	}
+	fd.Token = p.curr
+	p.advance()
 
+	if p.currIs(token.LBRACKET) {
+		fd.TypeParams, ok = p.parseTypeParams()
 		if !ok {
-			return body, false
+			return false
 		}
-
-		body = append(body, stmt)
 	}
 
-	return body, true
+	return p.parseParams(fd)
 }
 
-func (p *Parser) parseFuncCall() (*ast.FuncCall, bool) {
-	if !p.assertCurrIs(token.IDENT) {
-		return nil, false
-	}
-	fc := &ast.FuncCall{Token: p.curr, Args: make([]ast.Expression, 0)}
-	p.advance()
+// parseParams parses the parameters and the return type of a function.
+func (p *Parser) parseParams(fd *ast.FuncDecl) bool {
+	var ok bool
 
 	if !p.assertCurrIs(token.LPAREN) {
-		return nil, false
+		return false
 	}
 	p.advance()
 
 	for !p.currIsOrEOF(token.RPAREN) {
-		e, ok := p.parseExpression(LOWEST)
+		if p.currIs(token.ELLIPSIS) {
+			fd.VarArgs = true
+			p.advance()
+			if !p.assertCurrIs(token.RPAREN) {
+				return false
+			}
+			break
+		}
+
+		vd, ok := p.parseFuncParam()
 		if !ok {
-			return nil, false
+			return false
+		}
+		fd.Params = append(fd.Params, vd)
+
+		if _, ok := vd.Type.Type.(*types.Slice); ok && vd.Type.Token.Type == token.ELLIPSIS {
+			fd.Variadic = true
+			if !p.currIs(token.RPAREN) {
+				p.error(vd.Type.Token, "can only use ... with the last parameter")
+				return false
+			}
 		}
-		fc.Args = append(fc.Args, e)
 
 		if p.currIs(token.COMMA) {
 			p.advance()
 		}
 	}
+	if !p.assertCurrIs(token.RPAREN) {
+		return false
+	}
+	fd.Rparen = p.curr.Pos
 	p.advance()
 
-	return fc, true
+	if p.startsType() {
+		fd.ReturnType, ok = p.parseType()
+		if !ok {
+			return false
+		}
+		fd.HasReturn = true
+	}
+
+	return true
 }
 
-func (p *Parser) parseVarDecl() (*ast.VarDecl, bool) {
-	if !p.assertCurrIs(token.VAR) {
-		return nil, false
-	}
-	p.advance()
+func (p *Parser) parseTypeParams() ([]*ast.TypeParam, bool) {
+	tps := make([]*ast.TypeParam, 0)
 
-	if !p.assertCurrIs(token.IDENT) {
+	if !p.assertCurrIs(token.LBRACKET) {
 		return nil, false
 	}
-	vd := &ast.VarDecl{Token: p.curr}
 	p.advance()
 
-	t, ok := p.parseType()
-	if !ok {
-		return nil, false
-	}
-	vd.Type = t
+	for !p.currIsOrEOF(token.RBRACKET) {
+		if !p.assertCurrIs(token.IDENT) {
+			return nil, false
+		}
+		tp := &ast.TypeParam{Token: p.curr, Type: types.NewTypeParam(p.curr.Value)}
+		p.advance()
 
-	if !p.assertCurrIs(token.ASSIGN) {
-		return nil, false
+		if p.currIs(token.IDENT) {
+			t, ok := p.parseType()
+			if !ok {
+				return nil, false
+			}
+			tp.Constraint = t
+		}
+		tps = append(tps, tp)
+
+		if p.currIs(token.COMMA) {
+			p.advance()
+		}
 	}
-	p.advance()
 
-	e, ok := p.parseExpression(LOWEST)
-	if !ok {
+	if !p.assertCurrIs(token.RBRACKET) {
 		return nil, false
 	}
-	vd.Value = e
+	p.advance()
 
-	return vd, true
+	return tps, true
 }
 
-func (p *Parser) parseVar() (*ast.Var, bool) {
-	if !p.assertCurrIs(token.IDENT) {
+func (p *Parser) parseTypeDecl() (*ast.TypeDecl, bool) {
+	if !p.assertCurrIs(token.TYPE) {
 		return nil, false
 	}
-	v := &ast.Var{Token: p.curr}
+	kw := p.curr
 	p.advance()
 
-	return v, true
-}
-
-func (p *Parser) parseReturn() (*ast.Return, bool) {
-	if !p.assertCurrIs(token.RETURN) {
+	if !p.assertCurrIs(token.IDENT) {
 		return nil, false
 	}
-	r := &ast.Return{Token: p.curr}
+	td := &ast.TypeDecl{Token: p.curr, Methods: make([]*ast.FuncDecl, 0), TypePos: kw.Pos}
 	p.advance()
 
-	if !p.currIs("}") {
-		e, ok := p.parseExpression(LOWEST)
+	if !p.currIs(token.INTERFACE) {
+		t, ok := p.parseType()
 		if !ok {
 			return nil, false
 		}
-		r.Value = e
-		r.HasValue = true
-	}
-
-	return r, true
-}
-
-func (p *Parser) parseFuncParam() (*ast.VarDecl, bool) {
-	vd := &ast.VarDecl{Value: &ast.EmptyExpression{}}
+		td.Type = &ast.Type{Token: t.Token, Type: types.NewNamed(p.qualify(td.Token.Value), t.Type), From: t.From, To: t.To}
 
-	if !p.assertCurrIs(token.IDENT) {
-		return nil, false
+		return td, true
 	}
-	vd.Token = p.curr
+	td.Type = &ast.Type{Token: p.curr, Type: types.NewInterface(p.qualify(td.Token.Value))}
 	p.advance()
 
-	t, ok := p.parseType()
-	if !ok {
+	if !p.assertCurrIs(token.LBRACE) {
 		return nil, false
 	}
-	vd.Type = t
-
-	return vd, true
-}
-
-func (p *Parser) parseType() (*ast.Type, bool) {
-	t := &ast.Type{}
-
-	var ptr *types.Pointer
-
-	if p.currIs(token.POINTER) {
-		ptr = &types.Pointer{}
-		t.Type = ptr
-		p.advance()
-	}
+	p.advance()
 
-	for p.currIs(token.POINTER) {
-		to := &types.Pointer{}
-		ptr.To = to
-		ptr = to
-		p.advance()
+	for !p.currIsOrEOF(token.RBRACE) {
+		fd := &ast.FuncDecl{Extern: true, Params: make([]*ast.VarDecl, 0)}
+		if !p.parseSignature(fd) || !p.expectSemi() {
+			return nil, false
+		}
+		td.Methods = append(td.Methods, fd)
 	}
 
-	if !p.assertCurrIs(token.IDENT) {
+	if !p.assertCurrIs(token.RBRACE) {
 		return nil, false
 	}
-	t.Token = p.curr
-	if ptr != nil {
-		ptr.To = types.FromToken(p.curr)
-	} else {
-		t.Type = types.FromToken(p.curr)
-	}
+	td.Rbrace = p.curr.Pos
 	p.advance()
 
-	return t, true
+	return td, true
 }
 
-func (p *Parser) parseIntLiteral() (*ast.IntLiteral, bool) {
-	if !p.assertCurrIs(token.INT) {
+func (p *Parser) parseEnumDecl() (*ast.TypeDecl, bool) {
+	if !p.assertCurrIs(token.ENUM) {
 		return nil, false
 	}
-	il := &ast.IntLiteral{Token: p.curr}
+	kw := p.curr
+	p.advance()
 
-	n, err := strconv.Atoi(p.curr.Value)
-	if err != nil {
-		p.errorParse(err)
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	td := &ast.TypeDecl{Token: p.curr, Members: make([]*ast.EnumMember, 0), TypePos: kw.Pos}
+	p.advance()
+
+	var backing types.Type = types.TypeInt32
+	if !p.currIs(token.LBRACE) {
+		t, ok := p.parseType()
+		if !ok {
+			return nil, false
+		}
+		backing = t.Type
+	}
+	td.Type = &ast.Type{Token: kw, Type: types.NewEnum(p.qualify(td.Token.Value), backing)}
+
+	if !p.assertCurrIs(token.LBRACE) {
+		return nil, false
+	}
+	p.advance()
+
+	for !p.currIsOrEOF(token.RBRACE) {
+		if !p.assertCurrIs(token.IDENT) {
+			return nil, false
+		}
+		em := &ast.EnumMember{Token: p.curr}
+		p.advance()
+
+		if p.currIs(token.ASSIGN) {
+			p.advance()
+
+			il, ok := p.parseIntLiteral()
+			if !ok {
+				return nil, false
+			}
+			em.Value = il
+		}
+		td.Members = append(td.Members, em)
+
+		if p.currIs(token.COMMA) || p.currIs(token.SEMICOLON) {
+			p.advance()
+		}
+	}
+
+	if !p.assertCurrIs(token.RBRACE) {
+		return nil, false
+	}
+	td.Rbrace = p.curr.Pos
+	p.advance()
+
+	return td, true
+}
+
+func (p *Parser) parseUnionDecl() (*ast.TypeDecl, bool) {
+	if !p.assertCurrIs(token.UNION) {
+		return nil, false
+	}
+	kw := p.curr
+	p.advance()
+
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	td := &ast.TypeDecl{Token: p.curr, Variants: make([]*ast.Variant, 0), TypePos: kw.Pos}
+	td.Type = &ast.Type{Token: kw, Type: types.NewUnion(p.qualify(td.Token.Value))}
+	p.advance()
+
+	if !p.assertCurrIs(token.LBRACE) {
+		return nil, false
+	}
+	p.advance()
+
+	for !p.currIsOrEOF(token.RBRACE) {
+		if !p.assertCurrIs(token.IDENT) {
+			return nil, false
+		}
+		v := &ast.Variant{Token: p.curr, Fields: make([]*ast.Type, 0)}
+		p.advance()
+
+		if p.currIs(token.LPAREN) {
+			p.advance()
+
+			for !p.currIsOrEOF(token.RPAREN) {
+				t, ok := p.parseType()
+				if !ok {
+					return nil, false
+				}
+				v.Fields = append(v.Fields, t)
+
+				if p.currIs(token.COMMA) {
+					p.advance()
+				}
+			}
+
+			if !p.assertCurrIs(token.RPAREN) {
+				return nil, false
+			}
+			v.Rparen = p.curr.Pos
+			p.advance()
+		}
+		td.Variants = append(td.Variants, v)
+
+		if p.currIs(token.COMMA) || p.currIs(token.SEMICOLON) {
+			p.advance()
+		}
+	}
+
+	if !p.assertCurrIs(token.RBRACE) {
+		return nil, false
+	}
+	td.Rbrace = p.curr.Pos
+	p.advance()
+
+	return td, true
+}
+
+// parseFuncLit parses an anonymous function like func(x i32) i32 { ... }.
+func (p *Parser) parseFuncLit() (*ast.FuncLit, bool) {
+	if !p.assertCurrIs(token.FUNC) {
+		return nil, false
+	}
+	fd := &ast.FuncDecl{Token: p.curr, Params: make([]*ast.VarDecl, 0), FuncPos: p.curr.Pos}
+	lit := &ast.FuncLit{Token: p.curr, Decl: fd}
+	p.advance()
+
+	if !p.parseParams(fd) {
+		return nil, false
+	}
+
+	if !p.assertCurrIs(token.LBRACE) {
+		return nil, false
+	}
+	p.advance()
+
+	p.parseFuncBody(fd)
+
+	return lit, true
+}
+
+// parseFuncBody parses the statements of the body of fd and its closing
+// brace. A missing brace is reported, but the body is kept, so that the
+// function is still declared.
+func (p *Parser) parseFuncBody(fd *ast.FuncDecl) {
+	fd.Body = p.parseStatements(token.RBRACE)
+	if p.assertCurrIs(token.RBRACE) {
+		fd.Rbrace = p.curr.Pos
+		p.advance()
+	}
+}
+
+// parseStatements parses statements until one of the given tokens, or until
+// a declaration, which means that the closing brace of the block is missing.
+// Statements with syntax errors are skipped and replaced by a BadStmt, while
+// statements that are not terminated are kept. Empty statements are skipped.
+func (p *Parser) parseStatements(end ...token.TokenType) []ast.Statement {
+	body := make([]ast.Statement, 0)
+
+	for !p.currIsOrEOF(end...) && !p.currIsAny(declKeywords...) {
+		if p.currIs(token.SEMICOLON) {
+			p.advance()
+			continue
+		}
+
+		start := p.curr
+		stmt, ok := p.parseStatement()
+		if !ok {
+			p.sync(start, stmtStops...)
+			stmt = &ast.BadStmt{From: start, To: p.curr}
+		} else if !p.expectSemi() {
+			p.sync(start, stmtStops...)
+		}
+
+		body = append(body, stmt)
+	}
+
+	return body
+}
+
+func (p *Parser) parseStatement() (ast.Statement, bool) {
+	var stmt ast.Statement
+	var ok bool
+
+	switch p.curr.Type {
+	case token.IDENT:
+		switch p.next.Type {
+		case token.LPAREN:
+			stmt, ok = p.parseFuncCall()
+		case token.LBRACKET, token.DOT:
+			stmt, ok = p.parseCallStatement()
+		case token.ASSIGN:
+			stmt, ok = p.parseAssign()
+		default:
+			p.errorInvalidToken()
+			ok = false
+		}
+	case token.DEFER:
+		stmt, ok = p.parseDefer()
+	case token.RETURN:
+		stmt, ok = p.parseReturn()
+	case token.TRY:
+		stmt, ok = p.parseTry()
+	case token.SWITCH:
+		stmt, ok = p.parseSwitch()
+	case token.VAR:
+		stmt, ok = p.parseVarDecl()
+	default:
+		p.errorInvalidToken()
+		ok = false
+	}
+
+	return stmt, ok
+}
+
+func (p *Parser) parseSwitch() (*ast.Switch, bool) {
+	if !p.assertCurrIs(token.SWITCH) {
+		return nil, false
+	}
+	s := &ast.Switch{Token: p.curr, Cases: make([]*ast.Case, 0)}
+	p.advance()
+
+	e, ok := p.parseExpression(LOWEST)
+	if !ok {
+		return nil, false
+	}
+	s.Value = e
+
+	if !p.assertCurrIs(token.LBRACE) {
+		return nil, false
+	}
+	p.advance()
+
+	for !p.currIsOrEOF(token.RBRACE) && !p.currIsAny(declKeywords...) {
+		start := p.curr
+		c, ok := p.parseCase()
+		if !ok {
+			p.sync(start, token.CASE, token.DEFAULT, token.RBRACE)
+			continue
+		}
+		s.Cases = append(s.Cases, c)
+	}
+
+	if !p.assertCurrIs(token.RBRACE) {
+		return nil, false
+	}
+	s.Rbrace = p.curr.Pos
+	p.advance()
+
+	return s, true
+}
+
+func (p *Parser) parseCase() (*ast.Case, bool) {
+	c := &ast.Case{Token: p.curr, Values: make([]ast.Expression, 0)}
+
+	switch p.curr.Type {
+	case token.DEFAULT:
+		c.Default = true
+		p.advance()
+	case token.CASE:
+		p.advance()
+		for {
+			e, ok := p.parseCaseValue()
+			if !ok {
+				return nil, false
+			}
+			c.Values = append(c.Values, e)
+
+			if !p.currIs(token.COMMA) {
+				break
+			}
+			p.advance()
+		}
+	default:
+		p.errorInvalidToken()
+		return nil, false
+	}
+
+	if !p.assertCurrIs(token.COLON) {
+		return nil, false
+	}
+	c.Colon = p.curr.Pos
+	p.advance()
+
+	c.Body = p.parseStatements(token.CASE, token.DEFAULT, token.RBRACE)
+
+	return c, true
+}
+
+func (p *Parser) parseFuncCall() (*ast.FuncCall, bool) {
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	fc := &ast.FuncCall{Token: p.curr}
+	p.advance()
+
+	if p.currIs(token.LBRACKET) {
+		p.advance()
+
+		var ok bool
+		fc.TypeArgs, ok = p.parseTypeArgs()
+		if !ok {
+			return nil, false
+		}
+	}
+
+	args, ok := p.parseCallArgs()
+	if !ok {
+		return nil, false
+	}
+	fc.Args = args
+	fc.Rparen = p.prev.Pos
+
+	return fc, true
+}
+
+// parseTypeArgs parses the type arguments of a call up to and including the
+// closing bracket.
+func (p *Parser) parseTypeArgs() ([]*ast.Type, bool) {
+	typeArgs := make([]*ast.Type, 0)
+	for !p.currIsOrEOF(token.RBRACKET) {
+		t, ok := p.parseType()
+		if !ok {
+			return nil, false
+		}
+		typeArgs = append(typeArgs, t)
+
+		if p.currIs(token.COMMA) {
+			p.advance()
+		}
+	}
+
+	if !p.assertCurrIs(token.RBRACKET) {
+		return nil, false
+	}
+	p.advance()
+
+	return typeArgs, true
+}
+
+// parseIndexOrCall parses a name followed by brackets, which is either an
+// index into a slice like xs[i + 1] or a call of a generic function with
+// type arguments like max[i32](a, b). A single name in brackets can be
+// either, which is decided by whether arguments follow.
+func (p *Parser) parseIndexOrCall() (ast.Expression, bool) {
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	x := &ast.Var{Token: p.curr}
+	p.advance()
+
+	if !p.assertCurrIs(token.LBRACKET) {
+		return nil, false
+	}
+	lbrack := p.curr
+	p.advance()
+
+	if !p.startsTypeArg() {
+		ix := &ast.Index{Token: lbrack, X: x}
+
+		var ok bool
+		ix.Index, ok = p.parseExpression(LOWEST)
+		if !ok {
+			return nil, false
+		}
+		if !p.assertCurrIs(token.RBRACKET) {
+			return nil, false
+		}
+		ix.Rbrack = p.curr.Pos
+		p.advance()
+
+		return ix, true
+	}
+
+	typeArgs, ok := p.parseTypeArgs()
+	if !ok {
+		return nil, false
+	}
+
+	if !p.currIs(token.LPAREN) && len(typeArgs) == 1 {
+		if c, ok := typeArgs[0].Type.(*types.Custom); ok {
+			v := &ast.Var{Token: typeArgs[0].Token, Package: c.Package}
+			if c.Package != "" {
+				v.PackagePos = typeArgs[0].From
+			}
+			return &ast.Index{Token: lbrack, X: x, Index: v, Rbrack: p.prev.Pos}, true
+		}
+	}
+
+	fc := &ast.FuncCall{Token: x.Token, TypeArgs: typeArgs}
+	fc.Args, ok = p.parseCallArgs()
+	if !ok {
+		return nil, false
+	}
+	fc.Rparen = p.prev.Pos
+
+	return fc, true
+}
+
+// startsTypeArg reports whether the current token starts a type argument
+// rather than an index expression.
+func (p *Parser) startsTypeArg() bool {
+	if !p.startsType() {
+		return false
+	}
+	if !p.currIs(token.IDENT) {
+		return true
+	}
+	switch p.next.Type {
+	case token.RBRACKET, token.COMMA, token.BANG:
+		return true
+	case token.DOT:
+		return p.isQualified()
+	default:
+		return false
+	}
+}
+
+// parseCaseValue parses a value of a case, which is either an expression or a
+// pattern like Shape.Rect(w, h) or some(x) that binds the fields of a union
+// variant.
+func (p *Parser) parseCaseValue() (ast.Expression, bool) {
+	var x *ast.Var
+	switch {
+	case p.currIs(token.IDENT) && p.nextIs(token.DOT):
+		var pkg token.Token
+		if p.isQualified() {
+			pkg = p.curr
+			p.advance()
+			p.advance()
+		}
+
+		var ok bool
+		x, ok = p.parseVar()
+		if !ok {
+			return nil, false
+		}
+		x.Package = pkg.Value
+		x.PackagePos = pkg.Pos
+
+		if !p.assertCurrIs(token.DOT) {
+			return nil, false
+		}
+		p.advance()
+
+		if !p.assertCurrIs(token.IDENT) {
+			return nil, false
+		}
+	case (p.currIs(token.IDENT) || p.currIs(token.ERR)) && p.nextIs(token.LPAREN):
+	default:
+		return p.parseExpression(LOWEST)
+	}
+	name := p.curr
+	p.advance()
+
+	if !p.currIs(token.LPAREN) {
+		return &ast.Selector{Token: name, X: x}, true
+	}
+	p.advance()
+
+	pat := &ast.Pattern{Token: name, X: x, Bindings: make([]*ast.VarDecl, 0)}
+	for !p.currIsOrEOF(token.RPAREN) {
+		if !p.assertCurrIs(token.IDENT) {
+			return nil, false
+		}
+		pat.Bindings = append(pat.Bindings, &ast.VarDecl{Token: p.curr, Value: &ast.EmptyExpression{}})
+		p.advance()
+
+		if p.currIs(token.COMMA) {
+			p.advance()
+		}
+	}
+
+	if !p.assertCurrIs(token.RPAREN) {
+		return nil, false
+	}
+	pat.Rparen = p.curr.Pos
+	p.advance()
+
+	return pat, true
+}
+
+// parseSelector parses a method call if the selected name is followed by
+// arguments and a selector expression otherwise.
+func (p *Parser) parseSelector(x ast.Expression) (ast.Expression, bool) {
+	if !p.assertCurrIs(token.DOT) {
+		return nil, false
+	}
+	p.advance()
+
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	if !p.nextIs(token.LPAREN) {
+		s := &ast.Selector{Token: p.curr, X: x}
+		p.advance()
+		return s, true
+	}
+	mc := &ast.MethodCall{Token: p.curr, Receiver: x}
+	p.advance()
+
+	args, ok := p.parseCallArgs()
+	if !ok {
+		return nil, false
+	}
+	mc.Args = args
+	mc.Rparen = p.prev.Pos
+
+	return mc, true
+}
+
+func (p *Parser) parseCallArgs() ([]ast.Expression, bool) {
+	args := make([]ast.Expression, 0)
+
+	if !p.assertCurrIs(token.LPAREN) {
+		return nil, false
+	}
+	p.advance()
+
+	for !p.currIsOrEOF(token.RPAREN) {
+		// The arguments go on after a line break that is missing a comma.
+		if p.currIs(token.SEMICOLON) {
+			p.error(p.curr, "unexpected %s in argument list; possibly missing comma or )", describe(p.curr))
+			p.advance()
+			continue
+		}
+
+		e, ok := p.parseExpression(LOWEST)
+		if !ok {
+			return nil, false
+		}
+		args = append(args, e)
+
+		if p.currIs(token.COMMA) {
+			p.advance()
+		}
+	}
+	p.advance()
+
+	return args, true
+}
+
+// parseCallStatement parses an expression that is used as a statement, which
+// is only allowed for calls.
+func (p *Parser) parseCallStatement() (ast.Statement, bool) {
+	t := p.curr
+
+	e, ok := p.parseExpression(LOWEST)
+	if !ok {
+		return nil, false
+	}
+
+	stmt, ok := e.(ast.Statement)
+	if !ok {
+		p.error(t, "expression is not a statement")
+		return nil, false
+	}
+
+	return stmt, true
+}
+
+func (p *Parser) parseVarDecl() (*ast.VarDecl, bool) {
+	if !p.assertCurrIs(token.VAR) {
+		return nil, false
+	}
+	kw := p.curr
+	p.advance()
+
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	vd := &ast.VarDecl{Token: p.curr, VarPos: kw.Pos}
+	p.advance()
+
+	t, ok := p.parseType()
+	if !ok {
+		return nil, false
+	}
+	vd.Type = t
+
+	if !p.assertCurrIs(token.ASSIGN) {
+		return nil, false
+	}
+	p.advance()
+
+	vd.Value = p.parseValue()
+
+	return vd, true
+}
+
+func (p *Parser) parseAssign() (*ast.Assign, bool) {
+	x, ok := p.parseVar()
+	if !ok {
+		return nil, false
+	}
+
+	if !p.assertCurrIs(token.ASSIGN) {
+		return nil, false
+	}
+	a := &ast.Assign{Token: p.curr, X: x}
+	p.advance()
+
+	a.Value = p.parseValue()
+
+	return a, true
+}
+
+// parseValue parses the value of a declaration, an assignment or a return.
+// Values with syntax errors are replaced by a BadExpr, so that the statement
+// still declares its variable.
+func (p *Parser) parseValue() ast.Expression {
+	start := p.curr
+	e, ok := p.parseExpression(LOWEST)
+	if !ok {
+		p.sync(start, valueStops...)
+		return &ast.BadExpr{From: start, To: p.curr}
+	}
+	return e
+}
+
+func (p *Parser) parseVar() (*ast.Var, bool) {
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	v := &ast.Var{Token: p.curr}
+	p.advance()
+
+	return v, true
+}
+
+func (p *Parser) parseReturn() (*ast.Return, bool) {
+	if !p.assertCurrIs(token.RETURN) {
+		return nil, false
+	}
+	r := &ast.Return{Token: p.curr}
+	p.advance()
+
+	// A value on the next line is a statement of its own, since a semicolon
+	// is inserted after return.
+	if !p.currIsOrEOF(token.SEMICOLON, token.RBRACE, token.CASE, token.DEFAULT) {
+		r.Value = p.parseValue()
+		r.HasValue = true
+	}
+
+	return r, true
+}
+
+func (p *Parser) parseTry() (*ast.Try, bool) {
+	if !p.assertCurrIs(token.TRY) {
+		return nil, false
+	}
+	t := &ast.Try{Token: p.curr}
+	p.advance()
+
+	x, ok := p.parseExpression(PREFIX)
+	if !ok {
+		return nil, false
+	}
+	t.X = x
+
+	return t, true
+}
+
+func (p *Parser) parseErr() (*ast.Err, bool) {
+	if !p.assertCurrIs(token.ERR) {
+		return nil, false
+	}
+	e := &ast.Err{Token: p.curr}
+	p.advance()
+
+	if !p.assertCurrIs(token.LPAREN) {
+		return nil, false
+	}
+	p.advance()
+
+	x, ok := p.parseExpression(LOWEST)
+	if !ok {
+		return nil, false
+	}
+	e.X = x
+
+	if !p.assertCurrIs(token.RPAREN) {
+		return nil, false
+	}
+	e.Rparen = p.curr.Pos
+	p.advance()
+
+	return e, true
+}
+
+func (p *Parser) parseDefer() (*ast.Defer, bool) {
+	if !p.assertCurrIs(token.DEFER) {
+		return nil, false
+	}
+	d := &ast.Defer{Token: p.curr}
+	p.advance()
+
+	e, ok := p.parseExpression(LOWEST)
+	if !ok {
+		return nil, false
+	}
+	d.Call = e
+
+	return d, true
+}
+
+func (p *Parser) parseFuncParam() (*ast.VarDecl, bool) {
+	vd := &ast.VarDecl{Value: &ast.EmptyExpression{}}
+
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	vd.Token = p.curr
+	p.advance()
+
+	t, ok := p.parseVariadicType()
+	if !ok {
+		return nil, false
+	}
+	vd.Type = t
+
+	return vd, true
+}
+
+// parseVariadicType parses the type of a parameter, which is a slice if it
+// is written as ...T. The type keeps the ... token, so that the variadic
+// parameter can be told apart from a parameter of type []T.
+func (p *Parser) parseVariadicType() (*ast.Type, bool) {
+	if !p.currIs(token.ELLIPSIS) {
+		return p.parseType()
+	}
+	tok := p.curr
+	p.advance()
+
+	t, ok := p.parseType()
+	if !ok {
+		return nil, false
+	}
+	return &ast.Type{Token: tok, Type: &types.Slice{Elem: t.Type}, From: tok.Pos, To: t.To}, true
+}
+
+// parseType parses a type. The error type of a result binds tighter than
+// pointers and optionals, so ^E!T is a result with the error type ^E.
+func (p *Parser) parseType() (*ast.Type, bool) {
+	from := p.curr.Pos
+	t, ok := p.parseTypeOperand()
+	if !ok {
+		return nil, false
+	}
+
+	if p.currIs(token.BANG) {
+		p.advance()
+
+		value, ok := p.parseType()
+		if !ok {
+			return nil, false
+		}
+		t.Type = types.NewResult(t.Type, value.Type)
+	}
+	t.From, t.To = from, p.prev.End()
+
+	return t, true
+}
+
+func (p *Parser) parseTypeOperand() (*ast.Type, bool) {
+	switch p.curr.Type {
+	case token.POINTER:
+		p.advance()
+		t, ok := p.parseTypeOperand()
+		if !ok {
+			return nil, false
+		}
+		t.Type = &types.Pointer{To: t.Type}
+		return t, true
+	case token.QUESTION:
+		p.advance()
+		t, ok := p.parseTypeOperand()
+		if !ok {
+			return nil, false
+		}
+		t.Type = types.NewOptional(t.Type)
+		return t, true
+	case token.FUNC:
+		return p.parseFuncType()
+	case token.LBRACKET:
+		tok := p.curr
+		p.advance()
+		if !p.assertCurrIs(token.RBRACKET) {
+			return nil, false
+		}
+		p.advance()
+		t, ok := p.parseTypeOperand()
+		if !ok {
+			return nil, false
+		}
+		return &ast.Type{Token: tok, Type: &types.Slice{Elem: t.Type}}, true
+	}
+
+	if p.isQualified() {
+		pkg := p.curr.Value
+		p.advance()
+		p.advance()
+
+		if !p.assertCurrIs(token.IDENT) {
+			return nil, false
+		}
+		t := &ast.Type{Token: p.curr, Type: types.NewQualified(pkg, p.curr.Value)}
+		p.advance()
+
+		return t, true
+	}
+
+	if !p.assertCurrIs(token.IDENT) {
+		return nil, false
+	}
+	t := &ast.Type{Token: p.curr, Type: types.FromToken(p.curr)}
+	p.advance()
+
+	return t, true
+}
+
+// parseFuncType parses a function type like func(i32, ^i32) i32.
+func (p *Parser) parseFuncType() (*ast.Type, bool) {
+	if !p.assertCurrIs(token.FUNC) {
+		return nil, false
+	}
+	sig := &types.Func{Params: make([]types.Type, 0), Return: types.TypeNil}
+	t := &ast.Type{Token: p.curr, Type: sig}
+	p.advance()
+
+	if !p.assertCurrIs(token.LPAREN) {
+		return nil, false
+	}
+	p.advance()
+
+	for !p.currIsOrEOF(token.RPAREN) {
+		param, ok := p.parseVariadicType()
+		if !ok {
+			return nil, false
+		}
+		sig.Params = append(sig.Params, param.Type)
+
+		if param.Token.Type == token.ELLIPSIS {
+			sig.Variadic = true
+			if !p.currIs(token.RPAREN) {
+				p.error(param.Token, "can only use ... with the last parameter")
+				return nil, false
+			}
+		}
+
+		if p.currIs(token.COMMA) {
+			p.advance()
+		}
+	}
+
+	if !p.assertCurrIs(token.RPAREN) {
+		return nil, false
+	}
+	p.advance()
+
+	if p.startsType() {
+		ret, ok := p.parseType()
+		if !ok {
+			return nil, false
+		}
+		sig.Return = ret.Type
+	}
+
+	return t, true
+}
+
+// startsType reports whether the current token starts a type. The keyword
+// func only starts a type if it is followed by a parameter list, otherwise it
+// starts the next function declaration.
+func (p *Parser) startsType() bool {
+	switch p.curr.Type {
+	case token.IDENT, token.POINTER, token.QUESTION, token.LBRACKET:
+		return true
+	case token.FUNC:
+		return p.nextIs(token.LPAREN)
+	default:
+		return false
+	}
+}
+
+func (p *Parser) parseIntLiteral() (*ast.IntLiteral, bool) {
+	if !p.assertCurrIs(token.INT) {
+		return nil, false
+	}
+	il := &ast.IntLiteral{Token: p.curr}
+
+	n, err := strconv.Atoi(p.curr.Value)
+	if err != nil {
+		p.errorParse(err)
 		return nil, false
 	}
 	p.advance()
@@ -371,27 +1484,105 @@ func (p *Parser) parseIntLiteral() (*ast.IntLiteral, bool) {
 	return il, true
 }
 
+// parseStringLiteral parses a string literal, which can contain the escape
+// sequences of Go strings.
+func (p *Parser) parseStringLiteral() (*ast.StringLiteral, bool) {
+	if !p.assertCurrIs(token.STRING) {
+		return nil, false
+	}
+	sl := &ast.StringLiteral{Token: p.curr}
+
+	v, err := strconv.Unquote(p.curr.Value)
+	if err != nil {
+		p.errorParse(err)
+		return nil, false
+	}
+	if strings.IndexByte(v, 0) >= 0 {
+		p.error(p.curr, "string literal contains a NUL byte")
+		return nil, false
+	}
+	p.advance()
+	sl.Value = v
+
+	return sl, true
+}
+
 func (p *Parser) advance() {
+	p.prev = p.curr
 	p.curr = p.next
 	p.next = p.l.NextToken()
+	for p.next.Type == token.COMMENT {
+		p.comments = append(p.comments, &ast.Comment{Token: p.next})
+		p.next = p.l.NextToken()
+	}
+
+	// Errors of the lexer are about the token it read, so errors of the
+	// parser about the token are left out.
+	if len(p.l.Errors) > p.lexed {
+		p.Errors = append(p.Errors, p.l.Errors[p.lexed:]...)
+		p.lexed = len(p.l.Errors)
+		p.lastError = p.next
+	}
 }
 
+// assertCurrIs reports an error if the current token is not of type t.
+// Missing punctuation, whose type is the token itself, is fixed by inserting
+// it before the current token.
 func (p *Parser) assertCurrIs(t token.TokenType) bool {
 	if !p.currIs(t) {
-		p.error(p.curr, fmt.Sprintf("expected %v, got %v", t, p.curr.Type))
+		d := p.error(p.curr, "expected %v, got %v", t, describe(p.curr))
+		if strings.ToUpper(string(t)) == strings.ToLower(string(t)) {
+			at := diag.At(p.curr)
+			d.Fix(diag.Span{Start: at.Start, End: at.Start}, string(t))
+		}
 		return false
 	}
 	return true
 }
 
-func (p *Parser) currIsOrEOF(t token.TokenType) bool {
-	return t == p.curr.Type || p.curr.Type == token.EOF
+// expectSemi consumes the semicolon that ends a statement or declaration.
+// It can be left out before a closing brace and at the end of the file, so
+// that a block like { return x } fits on one line.
+func (p *Parser) expectSemi() bool {
+	switch p.curr.Type {
+	case token.SEMICOLON:
+		p.advance()
+		return true
+	case token.RBRACE, token.EOF:
+		return true
+	default:
+		p.error(p.curr, "expected ; or newline, got %s", describe(p.curr)).
+			Fix(diag.Span{Start: diag.At(p.curr).Start, End: diag.At(p.curr).Start}, ";")
+		return false
+	}
+}
+
+func (p *Parser) currIsOrEOF(ts ...token.TokenType) bool {
+	for _, t := range ts {
+		if t == p.curr.Type {
+			return true
+		}
+	}
+	return p.curr.Type == token.EOF
+}
+
+func (p *Parser) currIsAny(ts ...token.TokenType) bool {
+	for _, t := range ts {
+		if t == p.curr.Type {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Parser) currIs(t token.TokenType) bool {
 	return t == p.curr.Type
 }
 
+func (p *Parser) nextIs(t token.TokenType) bool {
+	return t == p.next.Type
+}
+
 func (p *Parser) currPrecedence() int {
 	switch p.curr.Type {
 	case token.ASTERISK:
@@ -402,21 +1593,90 @@ func (p *Parser) currPrecedence() int {
 		fallthrough
 	case token.PLUS:
 		return SUM
+	case token.DOT:
+		return FUNCCALL
 	default:
 		return LOWEST
 	}
 }
 
-func (p *Parser) error(t token.Token, msg string, args ...interface{}) {
-	msg = fmt.Sprintf(msg, args...)
-	err := fmt.Sprintf("%s ParseError: %s", t.Path(), msg)
-	p.Errors = append(p.Errors, err)
+// declKeywords start declarations, which only appear at the top level.
+var declKeywords = []token.TokenType{token.FUNC, token.EXPORT, token.TYPE, token.ENUM, token.UNION}
+
+// declStops, stmtStops and valueStops are the tokens that end the tokens
+// skipped after a syntax error in a declaration, a statement and the value of
+// a statement, which keeps the semicolon that ends the statement.
+var (
+	declStops  = append([]token.TokenType{token.VAR}, declKeywords...)
+	stmtStops  = append([]token.TokenType{token.RBRACE, token.CASE, token.DEFAULT, token.RETURN, token.DEFER, token.SWITCH, token.TRY}, declStops...)
+	valueStops = append([]token.TokenType{token.SEMICOLON}, stmtStops...)
+)
+
+// sync skips the tokens of a construct with a syntax error that starts at
+// start, up to one of the stop tokens or past a semicolon that is not a
+// stop. Blocks are skipped as a whole and end the skipped tokens. At least
+// one token is skipped if the construct did not consume any and does not
+// start with a stop, so that parsing makes progress.
+func (p *Parser) sync(start token.Token, stops ...token.TokenType) {
+	if p.curr == start && !p.currIs(token.LBRACE) && !p.currIs(token.EOF) && !p.currIsAny(stops...) {
+		p.advance()
+	}
+
+	depth := 0
+	for !p.currIs(token.EOF) {
+		switch {
+		case p.currIs(token.LBRACE):
+			depth++
+		case p.currIs(token.RBRACE) && depth > 0:
+			depth--
+			if depth == 0 {
+				p.advance()
+				return
+			}
+		case depth > 0:
+		case p.currIsAny(stops...):
+			return
+		case p.currIs(token.SEMICOLON):
+			p.advance()
+			return
+		}
+		p.advance()
+	}
+}
+
+// error records a syntax error. Errors on the line of the previous error are
+// dropped, since they mostly follow from it.
+func (p *Parser) error(t token.Token, msg string, args ...interface{}) *diag.Diagnostic {
+	d := diag.Errorf(diag.At(t), diag.Syntax, msg, args...)
+	if len(p.Errors) > 0 && p.lastError.Filename == t.Filename && p.lastError.Line == t.Line {
+		return d
+	}
+	p.lastError = t
+	p.Errors = append(p.Errors, d)
+	return d
 }
 
 func (p *Parser) errorInvalidToken() {
+	if p.currIs(token.SEMICOLON) && p.curr.Value != ";" {
+		p.error(p.curr, "unexpected %s", describe(p.curr))
+		return
+	}
 	p.error(p.curr, "invalid token: '%s'", p.curr.Value)
 }
 
+// describe returns the type of t for errors. Semicolons that the lexer
+// inserted are described as the newline or the end of file they stand for.
+func describe(t token.Token) string {
+	switch {
+	case t.Type != token.SEMICOLON || t.Value == ";":
+		return string(t.Type)
+	case t.Value == "\n":
+		return "newline"
+	default:
+		return "end of file"
+	}
+}
+
 func (p *Parser) errorParse(err error) {
 	p.error(p.curr, "%s", err)
 }
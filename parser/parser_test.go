@@ -6,6 +6,7 @@ import (
 	"lang/lexer"
 	"lang/token"
 	"lang/types"
+	"strings"
 	"testing"
 )
 
@@ -19,7 +20,7 @@ var x i32 = y
 				Type:  token.IDENT,
 				Value: "x",
 			},
-			Type: &ast.Type{Type: types.Int32},
+			Type: &ast.Type{Type: types.TypeInt32},
 			Value: &ast.Var{
 				Token: token.Token{
 					Type:  token.IDENT,
@@ -53,7 +54,7 @@ func test()
 					Type: &ast.Type{
 						Type: &types.Pointer{
 							To: &types.Pointer{
-								To: types.Int32,
+								To: types.TypeInt32,
 							},
 						},
 					},
@@ -67,7 +68,7 @@ func test()
 				},
 			},
 			HasReturn:  true,
-			ReturnType: &ast.Type{Type: types.Int32},
+			ReturnType: &ast.Type{Type: types.TypeInt32},
 		},
 		&ast.FuncDecl{
 			Token: token.Token{
@@ -92,7 +93,7 @@ var y i32 = 2
 				Type:  token.IDENT,
 				Value: "x",
 			},
-			Type:  &ast.Type{Type: types.Int32},
+			Type:  &ast.Type{Type: types.TypeInt32},
 			Value: &ast.IntLiteral{Value: 1},
 		},
 		&ast.VarDecl{
@@ -100,13 +101,1071 @@ var y i32 = 2
 				Type:  token.IDENT,
 				Value: "y",
 			},
-			Type:  &ast.Type{Type: types.Int32},
+			Type:  &ast.Type{Type: types.TypeInt32},
 			Value: &ast.IntLiteral{Value: 2},
 		},
 	}
 	test(t, input, want)
 }
 
+func TestDefer(t *testing.T) {
+	input := `
+func main() {
+	defer close(x)
+}
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "main",
+			},
+			Body: []ast.Statement{
+				&ast.Defer{
+					Call: &ast.FuncCall{
+						Token: token.Token{
+							Type:  token.IDENT,
+							Value: "close",
+						},
+						Args: []ast.Expression{
+							&ast.Var{
+								Token: token.Token{
+									Type:  token.IDENT,
+									Value: "x",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestTry(t *testing.T) {
+	input := `
+func parse(x ?^i32) Error!?i32 {
+	try check()
+	var v ^i32 = try x
+	return err(none)
+}
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "parse",
+			},
+			Params: []*ast.VarDecl{
+				&ast.VarDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "x",
+					},
+					Type: &ast.Type{
+						Type: types.NewOptional(&types.Pointer{To: types.TypeInt32}),
+					},
+					Value: &ast.EmptyExpression{},
+				},
+			},
+			Body: []ast.Statement{
+				&ast.Try{
+					X: &ast.FuncCall{
+						Token: token.Token{
+							Type:  token.IDENT,
+							Value: "check",
+						},
+					},
+				},
+				&ast.VarDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "v",
+					},
+					Type: &ast.Type{
+						Type: &types.Pointer{To: types.TypeInt32},
+					},
+					Value: &ast.Try{
+						X: &ast.Var{
+							Token: token.Token{
+								Type:  token.IDENT,
+								Value: "x",
+							},
+						},
+					},
+				},
+				&ast.Return{
+					HasValue: true,
+					Value:    &ast.Err{X: &ast.None{}},
+				},
+			},
+			HasReturn:  true,
+			ReturnType: &ast.Type{Type: types.NewResult(types.FromToken(token.Token{Value: "Error"}), types.NewOptional(types.TypeInt32))},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestFuncType(t *testing.T) {
+	input := `
+func apply(f func(i32, ^i32) i32) func() {
+	var g func(func()) = run
+	g(f)
+}
+func run(f func())
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "apply",
+			},
+			Params: []*ast.VarDecl{
+				&ast.VarDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "f",
+					},
+					Type: &ast.Type{
+						Type: &types.Func{
+							Params: []types.Type{types.TypeInt32, &types.Pointer{To: types.TypeInt32}},
+							Return: types.TypeInt32,
+						},
+					},
+					Value: &ast.EmptyExpression{},
+				},
+			},
+			Body: []ast.Statement{
+				&ast.VarDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "g",
+					},
+					Type: &ast.Type{
+						Type: &types.Func{
+							Params: []types.Type{&types.Func{Return: types.TypeNil}},
+							Return: types.TypeNil,
+						},
+					},
+					Value: &ast.Var{
+						Token: token.Token{
+							Type:  token.IDENT,
+							Value: "run",
+						},
+					},
+				},
+				&ast.FuncCall{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "g",
+					},
+					Args: []ast.Expression{
+						&ast.Var{
+							Token: token.Token{
+								Type:  token.IDENT,
+								Value: "f",
+							},
+						},
+					},
+				},
+			},
+			HasReturn:  true,
+			ReturnType: &ast.Type{Type: &types.Func{Return: types.TypeNil}},
+		},
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "run",
+			},
+			Params: []*ast.VarDecl{
+				&ast.VarDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "f",
+					},
+					Type:  &ast.Type{Type: &types.Func{Return: types.TypeNil}},
+					Value: &ast.EmptyExpression{},
+				},
+			},
+			Body:   []ast.Statement{},
+			Extern: true,
+		},
+	}
+	test(t, input, want)
+}
+
+func TestFuncLit(t *testing.T) {
+	input := `
+func main() {
+	var f func(i32) = func(x i32) {
+		y = x
+	}
+}
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "main",
+			},
+			Body: []ast.Statement{
+				&ast.VarDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "f",
+					},
+					Type: &ast.Type{
+						Type: &types.Func{
+							Params: []types.Type{types.TypeInt32},
+							Return: types.TypeNil,
+						},
+					},
+					Value: &ast.FuncLit{
+						Decl: &ast.FuncDecl{
+							Token: token.Token{
+								Type:  token.FUNC,
+								Value: "func",
+							},
+							Params: []*ast.VarDecl{
+								&ast.VarDecl{
+									Token: token.Token{
+										Type:  token.IDENT,
+										Value: "x",
+									},
+									Type:  &ast.Type{Type: types.TypeInt32},
+									Value: &ast.EmptyExpression{},
+								},
+							},
+							Body: []ast.Statement{
+								&ast.Assign{
+									X: &ast.Var{
+										Token: token.Token{
+											Type:  token.IDENT,
+											Value: "y",
+										},
+									},
+									Value: &ast.Var{
+										Token: token.Token{
+											Type:  token.IDENT,
+											Value: "x",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestTypeDecl(t *testing.T) {
+	input := `
+type Animal interface {
+	speak(times i32) i32
+	walk()
+}
+	`
+	want := []ast.Statement{
+		&ast.TypeDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "Animal",
+			},
+			Type: &ast.Type{Type: types.NewInterface("Animal")},
+			Methods: []*ast.FuncDecl{
+				&ast.FuncDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "speak",
+					},
+					Params: []*ast.VarDecl{
+						&ast.VarDecl{
+							Token: token.Token{
+								Type:  token.IDENT,
+								Value: "times",
+							},
+							Type:  &ast.Type{Type: types.TypeInt32},
+							Value: &ast.EmptyExpression{},
+						},
+					},
+					Extern:     true,
+					HasReturn:  true,
+					ReturnType: &ast.Type{Type: types.TypeInt32},
+				},
+				&ast.FuncDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "walk",
+					},
+					Extern: true,
+				},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestMethodCall(t *testing.T) {
+	input := `
+func main() {
+	a.speak(1)
+}
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "main",
+			},
+			Body: []ast.Statement{
+				&ast.MethodCall{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "speak",
+					},
+					Receiver: &ast.Var{
+						Token: token.Token{
+							Type:  token.IDENT,
+							Value: "a",
+						},
+					},
+					Args: []ast.Expression{
+						&ast.IntLiteral{Value: 1},
+					},
+				},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestMethodDecl(t *testing.T) {
+	input := `
+type Meters i32
+func (m ^Meters) Len() i32 {
+	return 1
+}
+	`
+	want := []ast.Statement{
+		&ast.TypeDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "Meters",
+			},
+			Type:    &ast.Type{Type: types.NewNamed("Meters", types.TypeInt32)},
+			Methods: []*ast.FuncDecl{},
+		},
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "Len",
+			},
+			Receiver: &ast.VarDecl{
+				Token: token.Token{
+					Type:  token.IDENT,
+					Value: "m",
+				},
+				Type: &ast.Type{
+					Type: &types.Pointer{To: types.FromToken(token.Token{Value: "Meters"})},
+				},
+				Value: &ast.EmptyExpression{},
+			},
+			Body: []ast.Statement{
+				&ast.Return{
+					HasValue: true,
+					Value:    &ast.IntLiteral{Value: 1},
+				},
+			},
+			HasReturn:  true,
+			ReturnType: &ast.Type{Type: types.TypeInt32},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestGenericFuncDecl(t *testing.T) {
+	input := `
+func max[T Ordered, U](a T) T {
+	return max[i32, ^U](a)
+}
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "max",
+			},
+			TypeParams: []*ast.TypeParam{
+				&ast.TypeParam{
+					Token:      token.Token{Type: token.IDENT, Value: "T"},
+					Constraint: &ast.Type{Type: types.FromToken(token.Token{Value: "Ordered"})},
+				},
+				&ast.TypeParam{
+					Token: token.Token{Type: token.IDENT, Value: "U"},
+				},
+			},
+			Params: []*ast.VarDecl{
+				&ast.VarDecl{
+					Token: token.Token{
+						Type:  token.IDENT,
+						Value: "a",
+					},
+					Type:  &ast.Type{Type: types.FromToken(token.Token{Value: "T"})},
+					Value: &ast.EmptyExpression{},
+				},
+			},
+			Body: []ast.Statement{
+				&ast.Return{
+					HasValue: true,
+					Value: &ast.FuncCall{
+						Token: token.Token{
+							Type:  token.IDENT,
+							Value: "max",
+						},
+						TypeArgs: []*ast.Type{
+							&ast.Type{Type: types.TypeInt32},
+							&ast.Type{Type: &types.Pointer{To: types.FromToken(token.Token{Value: "U"})}},
+						},
+						Args: []ast.Expression{
+							&ast.Var{
+								Token: token.Token{
+									Type:  token.IDENT,
+									Value: "a",
+								},
+							},
+						},
+					},
+				},
+			},
+			HasReturn:  true,
+			ReturnType: &ast.Type{Type: types.FromToken(token.Token{Value: "T"})},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestEnumDecl(t *testing.T) {
+	input := `
+enum Color i32 {
+	Red,
+	Green = 4,
+	Blue
+}
+	`
+	want := []ast.Statement{
+		&ast.TypeDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "Color",
+			},
+			Type: &ast.Type{Type: types.NewEnum("Color", types.TypeInt32)},
+			Members: []*ast.EnumMember{
+				&ast.EnumMember{Token: token.Token{Type: token.IDENT, Value: "Red"}},
+				&ast.EnumMember{
+					Token: token.Token{Type: token.IDENT, Value: "Green"},
+					Value: &ast.IntLiteral{Value: 4},
+				},
+				&ast.EnumMember{Token: token.Token{Type: token.IDENT, Value: "Blue"}},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestUnionDecl(t *testing.T) {
+	input := `
+union Shape {
+	Circle(i32),
+	Rect(i32, ^i32),
+	Empty
+}
+	`
+	want := []ast.Statement{
+		&ast.TypeDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "Shape",
+			},
+			Type: &ast.Type{Type: types.NewUnion("Shape")},
+			Variants: []*ast.Variant{
+				&ast.Variant{
+					Token:  token.Token{Type: token.IDENT, Value: "Circle"},
+					Fields: []*ast.Type{&ast.Type{Type: types.TypeInt32}},
+				},
+				&ast.Variant{
+					Token: token.Token{Type: token.IDENT, Value: "Rect"},
+					Fields: []*ast.Type{
+						&ast.Type{Type: types.TypeInt32},
+						&ast.Type{Type: &types.Pointer{To: types.TypeInt32}},
+					},
+				},
+				&ast.Variant{Token: token.Token{Type: token.IDENT, Value: "Empty"}},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestPatternCase(t *testing.T) {
+	input := `
+func main() {
+	switch s {
+	case Shape.Rect(w, _):
+		return
+	case Shape.Empty:
+	}
+}
+	`
+	shape := &ast.Var{Token: token.Token{Type: token.IDENT, Value: "Shape"}}
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "main",
+			},
+			Body: []ast.Statement{
+				&ast.Switch{
+					Value: &ast.Var{Token: token.Token{Type: token.IDENT, Value: "s"}},
+					Cases: []*ast.Case{
+						&ast.Case{
+							Values: []ast.Expression{
+								&ast.Pattern{
+									Token: token.Token{Type: token.IDENT, Value: "Rect"},
+									X:     shape,
+									Bindings: []*ast.VarDecl{
+										&ast.VarDecl{Token: token.Token{Type: token.IDENT, Value: "w"}},
+										&ast.VarDecl{Token: token.Token{Type: token.IDENT, Value: "_"}},
+									},
+								},
+							},
+							Body: []ast.Statement{
+								&ast.Return{},
+							},
+						},
+						&ast.Case{
+							Values: []ast.Expression{
+								&ast.Selector{
+									Token: token.Token{Type: token.IDENT, Value: "Empty"},
+									X:     shape,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestSwitch(t *testing.T) {
+	input := `
+func main() {
+	switch c {
+	case Color.Red, 1:
+		return
+	default:
+	}
+}
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: token.Token{
+				Type:  token.IDENT,
+				Value: "main",
+			},
+			Body: []ast.Statement{
+				&ast.Switch{
+					Value: &ast.Var{Token: token.Token{Type: token.IDENT, Value: "c"}},
+					Cases: []*ast.Case{
+						&ast.Case{
+							Values: []ast.Expression{
+								&ast.Selector{
+									Token: token.Token{Type: token.IDENT, Value: "Red"},
+									X:     &ast.Var{Token: token.Token{Type: token.IDENT, Value: "Color"}},
+								},
+								&ast.IntLiteral{Value: 1},
+							},
+							Body: []ast.Statement{
+								&ast.Return{},
+							},
+						},
+						&ast.Case{
+							Default: true,
+						},
+					},
+				},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestPackageImports(t *testing.T) {
+	input := `
+package shapes
+
+import "lib/geom"
+import m "math"
+
+type Area i32
+
+func Total(s geom.Shape) Area {
+	return m.Add(geom.Area(s), geom.Zero)
+}
+	`
+	l := lexer.New(input)
+	p := New(l)
+
+	prog, ok := p.ParseProgram()
+	if !ok {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+	if prog.Package != "shapes" {
+		t.Fatalf("got package %q, want shapes", prog.Package)
+	}
+
+	imports := []struct{ name, path string }{{"geom", "lib/geom"}, {"m", "math"}}
+	if len(prog.Imports) != len(imports) {
+		t.Fatalf("got %d imports, want %d", len(prog.Imports), len(imports))
+	}
+	for i, imp := range imports {
+		if prog.Imports[i].Name != imp.name || prog.Imports[i].Path != imp.path {
+			t.Fatalf("imports [%d]: got %s %q, want %s %q", i, prog.Imports[i].Name, prog.Imports[i].Path, imp.name, imp.path)
+		}
+	}
+
+	td := prog.Statements[0].(*ast.TypeDecl)
+	if err := checkString(td.Type.Type.Name(), "shapes.Area"); err != nil {
+		t.Fatalf("type name: %v", err)
+	}
+
+	fd := prog.Statements[1].(*ast.FuncDecl)
+	if err := checkString(fd.Package, "shapes"); err != nil {
+		t.Fatalf("FuncDecl.Package: %v", err)
+	}
+	if err := checkTypeType(fd.Params[0].Type.Type, types.NewQualified("geom", "Shape")); err != nil {
+		t.Fatalf("param type: %v", err)
+	}
+
+	want := &ast.FuncCall{
+		Token:   token.Token{Type: token.IDENT, Value: "Add"},
+		Package: "m",
+		Args: []ast.Expression{
+			&ast.FuncCall{
+				Token:   token.Token{Type: token.IDENT, Value: "Area"},
+				Package: "geom",
+				Args: []ast.Expression{
+					&ast.Var{Token: token.Token{Type: token.IDENT, Value: "s"}},
+				},
+			},
+			&ast.Var{Token: token.Token{Type: token.IDENT, Value: "Zero"}, Package: "geom"},
+		},
+	}
+	if err := checkNode(fd.Body[0].(*ast.Return).Value, want); err != nil {
+		t.Fatalf("return value: %v", err)
+	}
+}
+
+func TestVariadic(t *testing.T) {
+	input := `
+func printf(format ^u8, ...) i32
+func sum(xs ...i32) i32 {
+	printf("%d\n", xs[i + 1], id[T](xs[i]))
+	return len(xs)
+}
+	`
+	ident := func(name string) token.Token {
+		return token.Token{Type: token.IDENT, Value: name}
+	}
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token: ident("printf"),
+			Params: []*ast.VarDecl{
+				&ast.VarDecl{
+					Token: ident("format"),
+					Type:  &ast.Type{Type: &types.Pointer{To: types.TypeUint8}},
+					Value: &ast.EmptyExpression{},
+				},
+			},
+			Body:       []ast.Statement{},
+			Extern:     true,
+			HasReturn:  true,
+			ReturnType: &ast.Type{Type: types.TypeInt32},
+			VarArgs:    true,
+		},
+		&ast.FuncDecl{
+			Token: ident("sum"),
+			Params: []*ast.VarDecl{
+				&ast.VarDecl{
+					Token: ident("xs"),
+					Type:  &ast.Type{Type: &types.Slice{Elem: types.TypeInt32}},
+					Value: &ast.EmptyExpression{},
+				},
+			},
+			Body: []ast.Statement{
+				&ast.FuncCall{
+					Token: ident("printf"),
+					Args: []ast.Expression{
+						&ast.StringLiteral{Value: "%d\n"},
+						&ast.Index{
+							X: &ast.Var{Token: ident("xs")},
+							Index: &ast.InfixExpression{
+								Token: token.Token{Type: token.PLUS, Value: "+"},
+								Left:  &ast.Var{Token: ident("i")},
+								Right: &ast.IntLiteral{Value: 1},
+							},
+						},
+						&ast.FuncCall{
+							Token:    ident("id"),
+							TypeArgs: []*ast.Type{&ast.Type{Type: types.FromToken(ident("T"))}},
+							Args: []ast.Expression{
+								&ast.Index{X: &ast.Var{Token: ident("xs")}, Index: &ast.Var{Token: ident("i")}},
+							},
+						},
+					},
+				},
+				&ast.Return{
+					Token: token.Token{Type: token.RETURN, Value: "return"},
+					Value: &ast.FuncCall{
+						Token: ident("len"),
+						Args:  []ast.Expression{&ast.Var{Token: ident("xs")}},
+					},
+					HasValue: true,
+				},
+			},
+			HasReturn:  true,
+			ReturnType: &ast.Type{Type: types.TypeInt32},
+			Variadic:   true,
+		},
+	}
+	test(t, input, want)
+
+	for _, input := range []string{"func f(xs ...i32, y i32)", "func f(..., x i32)"} {
+		p := New(lexer.New(input))
+		if _, ok := p.ParseProgram(); ok {
+			t.Fatalf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	input := `
+export func add(a i32, b i32) i32 {
+	return a + b
+}
+	`
+	ident := func(name string) token.Token {
+		return token.Token{Type: token.IDENT, Value: name}
+	}
+	param := func(name string) *ast.VarDecl {
+		return &ast.VarDecl{Token: ident(name), Type: &ast.Type{Type: types.TypeInt32}, Value: &ast.EmptyExpression{}}
+	}
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token:  ident("add"),
+			Params: []*ast.VarDecl{param("a"), param("b")},
+			Body: []ast.Statement{
+				&ast.Return{
+					Token: token.Token{Type: token.RETURN, Value: "return"},
+					Value: &ast.InfixExpression{
+						Token: token.Token{Type: token.PLUS, Value: "+"},
+						Left:  &ast.Var{Token: ident("a")},
+						Right: &ast.Var{Token: ident("b")},
+					},
+					HasValue: true,
+				},
+			},
+			HasReturn:  true,
+			ReturnType: &ast.Type{Type: types.TypeInt32},
+			Export:     true,
+		},
+	}
+	test(t, input, want)
+
+	p := New(lexer.New("export var x i32 = 1"))
+	if _, ok := p.ParseProgram(); ok {
+		t.Fatalf("expected an error for an exported variable")
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `
+func f(x i32) i32 {
+	var y i32 = x +
+	return y
+}
+
+func g( i32 {
+	return 1
+}
+
+func h(z i32) i32 {
+	switch z {
+	case 1 + :
+		return 2
+	case 2:
+		z = )
+	}
+	foo bar
+	return f(z)
+}
+	`
+	p := New(lexer.New(input))
+	prog, ok := p.ParseProgram()
+	if ok {
+		t.Fatalf("expected errors")
+	}
+
+	want := []string{
+		":4:2: error: invalid token: 'return'",
+		":7:13: error: expected IDENT, got {",
+		":13:11: error: invalid token: ':'",
+		":16:7: error: invalid token: ')'",
+		":18:2: error: invalid token: 'foo'",
+	}
+	if len(p.Errors) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), p.Errors)
+	}
+	for i := range want {
+		if p.Errors[i].Error() != want[i] {
+			t.Errorf("[%d] expected %q, got %q", i, want[i], p.Errors[i].Error())
+		}
+	}
+
+	if len(prog.Statements) != 3 {
+		t.Fatalf("got %d statements, want 3", len(prog.Statements))
+	}
+	f, ok := prog.Statements[0].(*ast.FuncDecl)
+	if !ok || len(f.Body) != 2 {
+		t.Fatalf("f: got %#v", prog.Statements[0])
+	}
+	if vd, ok := f.Body[0].(*ast.VarDecl); !ok || vd.Token.Value != "y" {
+		t.Errorf("f: got %#v, want var y", f.Body[0])
+	} else if _, ok := vd.Value.(*ast.BadExpr); !ok {
+		t.Errorf("f: got value %#v, want BadExpr", vd.Value)
+	}
+	if _, ok := prog.Statements[1].(*ast.BadStmt); !ok {
+		t.Errorf("g: got %#v, want BadStmt", prog.Statements[1])
+	}
+
+	h, ok := prog.Statements[2].(*ast.FuncDecl)
+	if !ok || len(h.Body) != 3 {
+		t.Fatalf("h: got %#v", prog.Statements[2])
+	}
+	if s, ok := h.Body[0].(*ast.Switch); !ok || len(s.Cases) != 1 {
+		t.Errorf("h: got %#v, want switch with one case", h.Body[0])
+	}
+	if _, ok := h.Body[1].(*ast.BadStmt); !ok {
+		t.Errorf("h: got %#v, want BadStmt", h.Body[1])
+	}
+	if _, ok := h.Body[2].(*ast.Return); !ok {
+		t.Errorf("h: got %#v, want return", h.Body[2])
+	}
+}
+
+func TestMissingBrace(t *testing.T) {
+	input := `
+func f() i32 {
+	return 1
+
+func g() {}
+	`
+	p := New(lexer.New(input))
+	prog, ok := p.ParseProgram()
+	if ok || len(p.Errors) != 1 || !strings.Contains(p.Errors[0].Message, "expected }, got FUNC") {
+		t.Fatalf("expected a missing brace, got %v", p.Errors)
+	}
+	if len(prog.Statements) != 2 {
+		t.Fatalf("got %d statements, want 2", len(prog.Statements))
+	}
+}
+
+func TestSemicolons(t *testing.T) {
+	input := `
+func f() i32 {
+	return
+	g(1)
+}
+func h() i32 { var x i32 = 1; x = 2; return x }
+enum E { A; B }
+	`
+	want := []ast.Statement{
+		&ast.FuncDecl{
+			Token:      token.Token{Type: token.IDENT, Value: "f"},
+			ReturnType: &ast.Type{Type: types.TypeInt32},
+			HasReturn:  true,
+			Body: []ast.Statement{
+				&ast.Return{},
+				&ast.FuncCall{
+					Token: token.Token{Type: token.IDENT, Value: "g"},
+					Args:  []ast.Expression{&ast.IntLiteral{Value: 1}},
+				},
+			},
+		},
+		&ast.FuncDecl{
+			Token:      token.Token{Type: token.IDENT, Value: "h"},
+			ReturnType: &ast.Type{Type: types.TypeInt32},
+			HasReturn:  true,
+			Body: []ast.Statement{
+				&ast.VarDecl{
+					Token: token.Token{Type: token.IDENT, Value: "x"},
+					Type:  &ast.Type{Type: types.TypeInt32},
+					Value: &ast.IntLiteral{Value: 1},
+				},
+				&ast.Assign{
+					X:     &ast.Var{Token: token.Token{Type: token.IDENT, Value: "x"}},
+					Value: &ast.IntLiteral{Value: 2},
+				},
+				&ast.Return{
+					HasValue: true,
+					Value:    &ast.Var{Token: token.Token{Type: token.IDENT, Value: "x"}},
+				},
+			},
+		},
+		&ast.TypeDecl{
+			Token: token.Token{Type: token.IDENT, Value: "E"},
+			Type:  &ast.Type{Type: types.NewEnum("E", types.TypeInt32)},
+			Members: []*ast.EnumMember{
+				{Token: token.Token{Type: token.IDENT, Value: "A"}},
+				{Token: token.Token{Type: token.IDENT, Value: "B"}},
+			},
+		},
+	}
+	test(t, input, want)
+}
+
+func TestMissingSemicolon(t *testing.T) {
+	input := `func f() {
+	var x i32 = 1 x = 2
+	g(1,
+		2
+	)
+	x = 3
+}`
+	p := New(lexer.New(input))
+	prog, ok := p.ParseProgram()
+	if ok {
+		t.Fatalf("expected errors")
+	}
+
+	want := []string{
+		":2:16: error: expected ; or newline, got IDENT",
+		":4:4: error: unexpected newline in argument list; possibly missing comma or )",
+	}
+	if len(p.Errors) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), p.Errors)
+	}
+	for i := range want {
+		if p.Errors[i].Error() != want[i] {
+			t.Errorf("[%d] expected %q, got %q", i, want[i], p.Errors[i].Error())
+		}
+	}
+	if body := prog.Statements[0].(*ast.FuncDecl).Body; len(body) != 3 {
+		t.Fatalf("expected f with 3 statements, got %v", body)
+	}
+}
+
+func TestComments(t *testing.T) {
+	l := lexer.New("// f\nfunc f() { /* a */ return // b\n}\n")
+	l.ScanComments = true
+	p := New(l)
+	prog, ok := p.ParseProgram()
+	if !ok {
+		t.Fatal(p.Errors)
+	}
+
+	want := []string{"// f", "/* a */", "// b"}
+	if len(prog.Comments) != len(want) {
+		t.Fatalf("got %d comments, want %d", len(prog.Comments), len(want))
+	}
+	for i := range want {
+		if got := prog.Comments[i].Token.Value; got != want[i] {
+			t.Errorf("[%d] got %q, want %q", i, got, want[i])
+		}
+	}
+	if body := prog.Statements[0].(*ast.FuncDecl).Body; len(body) != 1 || body[0].(*ast.Return).HasValue {
+		t.Fatalf("got body %v, want a return without value", body)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	input := `package geom
+
+import m "lib/math"
+
+type Len i32
+type Shape interface {
+	Area() i32
+}
+enum Color u8 { Red, Green = 4 }
+union Tree { Leaf(i32), Node(^Tree, ^Tree), Empty }
+
+export func area(s []u8, xs ...i32) ?i32 {
+	var f func(i32) i32 = func(x i32) i32 {
+		return x * 2
+	}
+	switch s[0] {
+	case 1, 2:
+		return m.abs(f(3))
+	default:
+	}
+	return none
+}
+`
+	file := token.NewFileSet().AddFile("geom.lang", []byte(input))
+	p := New(lexer.NewFile(file))
+	prog, ok := p.ParseProgram()
+	if !ok {
+		t.Fatalf("parse errors: %v", p.Errors)
+	}
+
+	text := func(n ast.Node) string {
+		return input[file.Offset(n.Pos()):file.Offset(n.End())]
+	}
+	area := prog.Statements[4].(*ast.FuncDecl)
+	sw := area.Body[1].(*ast.Switch)
+	ret := sw.Cases[0].Body[0].(*ast.Return)
+	tests := []struct {
+		node ast.Node
+		want string
+	}{
+		{prog.Imports[0], `import m "lib/math"`},
+		{prog.Statements[0], "type Len i32"},
+		{prog.Statements[1], "type Shape interface {\n\tArea() i32\n}"},
+		{prog.Statements[1].(*ast.TypeDecl).Methods[0], "Area() i32"},
+		{prog.Statements[2], "enum Color u8 { Red, Green = 4 }"},
+		{prog.Statements[2].(*ast.TypeDecl).Members[1], "Green = 4"},
+		{prog.Statements[3].(*ast.TypeDecl).Variants[1], "Node(^Tree, ^Tree)"},
+		{area.Params[0], "s []u8"},
+		{area.Params[1].Type, "...i32"},
+		{area.ReturnType, "?i32"},
+		{area.Body[0], "var f func(i32) i32 = func(x i32) i32 {\n\t\treturn x * 2\n\t}"},
+		{area.Body[0].(*ast.VarDecl).Type, "func(i32) i32"},
+		{sw.Value, "s[0]"},
+		{sw.Cases[0], "case 1, 2:\n\t\treturn m.abs(f(3))"},
+		{sw.Cases[1], "default:"},
+		{ret.Value, "m.abs(f(3))"},
+		{ret.Value.(*ast.FuncCall).Args[0], "f(3)"},
+		{area.Body[2], "return none"},
+	}
+	for i, tt := range tests {
+		if got := text(tt.node); got != tt.want {
+			t.Errorf("[%d] got %q, want %q", i, got, tt.want)
+		}
+	}
+	if got := text(area); !strings.HasPrefix(got, "export func area(") || !strings.HasSuffix(got, "return none\n}") {
+		t.Errorf("got %q for the function", got)
+	}
+	if got := text(sw); !strings.HasPrefix(got, "switch s[0] {") || !strings.HasSuffix(got, "default:\n\t}") {
+		t.Errorf("got %q for the switch", got)
+	}
+}
+
 func test(t *testing.T, input string, want []ast.Statement) {
 	l := lexer.New(input)
 	p := New(l)
@@ -114,7 +1173,7 @@ func test(t *testing.T, input string, want []ast.Statement) {
 	prog, ok := p.ParseProgram()
 	if !ok {
 		for _, err := range p.Errors {
-			t.Fatalf(err)
+			t.Fatal(err)
 		}
 	}
 
@@ -140,6 +1199,105 @@ func checkNode(gotNode, wantNode ast.Node) error {
 		if err := checkFuncDecl(got, want); err != nil {
 			return fmt.Errorf("*ast.FuncDecl: %v", err)
 		}
+	case *ast.Defer:
+		want, ok := wantNode.(*ast.Defer)
+		if !ok {
+			return fmt.Errorf("got *ast.Defer, wanted %v", wantNode)
+		}
+		if err := checkNode(got.Call, want.Call); err != nil {
+			return fmt.Errorf("*ast.Defer: %v", err)
+		}
+	case *ast.FuncCall:
+		want, ok := wantNode.(*ast.FuncCall)
+		if !ok {
+			return fmt.Errorf("got *ast.FuncCall, wanted %v", wantNode)
+		}
+		if err := checkFuncCall(got, want); err != nil {
+			return fmt.Errorf("*ast.FuncCall: %v", err)
+		}
+	case *ast.Switch:
+		want, ok := wantNode.(*ast.Switch)
+		if !ok {
+			return fmt.Errorf("got *ast.Switch, wanted %v", wantNode)
+		}
+		if err := checkSwitch(got, want); err != nil {
+			return fmt.Errorf("*ast.Switch: %v", err)
+		}
+	case *ast.FuncLit:
+		want, ok := wantNode.(*ast.FuncLit)
+		if !ok {
+			return fmt.Errorf("got *ast.FuncLit, wanted %v", wantNode)
+		}
+		if err := checkFuncDecl(got.Decl, want.Decl); err != nil {
+			return fmt.Errorf("*ast.FuncLit: %v", err)
+		}
+	case *ast.Assign:
+		want, ok := wantNode.(*ast.Assign)
+		if !ok {
+			return fmt.Errorf("got *ast.Assign, wanted %v", wantNode)
+		}
+		if err := checkVar(got.X, want.X); err != nil {
+			return fmt.Errorf("*ast.Assign: X: %v", err)
+		}
+		if err := checkNode(got.Value, want.Value); err != nil {
+			return fmt.Errorf("*ast.Assign: Value: %v", err)
+		}
+	case *ast.Try:
+		want, ok := wantNode.(*ast.Try)
+		if !ok {
+			return fmt.Errorf("got *ast.Try, wanted %v", wantNode)
+		}
+		if err := checkNode(got.X, want.X); err != nil {
+			return fmt.Errorf("*ast.Try: %v", err)
+		}
+	case *ast.Err:
+		want, ok := wantNode.(*ast.Err)
+		if !ok {
+			return fmt.Errorf("got *ast.Err, wanted %v", wantNode)
+		}
+		if err := checkNode(got.X, want.X); err != nil {
+			return fmt.Errorf("*ast.Err: %v", err)
+		}
+	case *ast.None:
+		_, ok := wantNode.(*ast.None)
+		if !ok {
+			return fmt.Errorf("got *ast.None, wanted %v", wantNode)
+		}
+	case *ast.Selector:
+		want, ok := wantNode.(*ast.Selector)
+		if !ok {
+			return fmt.Errorf("got *ast.Selector, wanted %v", wantNode)
+		}
+		if err := checkToken(got.Token, want.Token); err != nil {
+			return fmt.Errorf("*ast.Selector: Token: %v", err)
+		}
+		if err := checkNode(got.X, want.X); err != nil {
+			return fmt.Errorf("*ast.Selector: X: %v", err)
+		}
+	case *ast.Pattern:
+		want, ok := wantNode.(*ast.Pattern)
+		if !ok {
+			return fmt.Errorf("got *ast.Pattern, wanted %v", wantNode)
+		}
+		if err := checkPattern(got, want); err != nil {
+			return fmt.Errorf("*ast.Pattern: %v", err)
+		}
+	case *ast.MethodCall:
+		want, ok := wantNode.(*ast.MethodCall)
+		if !ok {
+			return fmt.Errorf("got *ast.MethodCall, wanted %v", wantNode)
+		}
+		if err := checkMethodCall(got, want); err != nil {
+			return fmt.Errorf("*ast.MethodCall: %v", err)
+		}
+	case *ast.TypeDecl:
+		want, ok := wantNode.(*ast.TypeDecl)
+		if !ok {
+			return fmt.Errorf("got *ast.TypeDecl, wanted %v", wantNode)
+		}
+		if err := checkTypeDecl(got, want); err != nil {
+			return fmt.Errorf("*ast.TypeDecl: %v", err)
+		}
 	case *ast.Return:
 		want, ok := wantNode.(*ast.Return)
 		if !ok {
@@ -180,6 +1338,39 @@ func checkNode(gotNode, wantNode ast.Node) error {
 		if err := checkVarDecl(got, want); err != nil {
 			return fmt.Errorf("*ast.VarDecl: %v", err)
 		}
+	case *ast.InfixExpression:
+		want, ok := wantNode.(*ast.InfixExpression)
+		if !ok {
+			return fmt.Errorf("got *ast.InfixExpression, wanted %v", wantNode)
+		}
+		if err := checkToken(got.Token, want.Token); err != nil {
+			return fmt.Errorf("*ast.InfixExpression: Token: %v", err)
+		}
+		if err := checkNode(got.Left, want.Left); err != nil {
+			return fmt.Errorf("*ast.InfixExpression: Left: %v", err)
+		}
+		if err := checkNode(got.Right, want.Right); err != nil {
+			return fmt.Errorf("*ast.InfixExpression: Right: %v", err)
+		}
+	case *ast.StringLiteral:
+		want, ok := wantNode.(*ast.StringLiteral)
+		if !ok {
+			return fmt.Errorf("got *ast.StringLiteral, wanted %v", wantNode)
+		}
+		if err := checkString(got.Value, want.Value); err != nil {
+			return fmt.Errorf("*ast.StringLiteral: %v", err)
+		}
+	case *ast.Index:
+		want, ok := wantNode.(*ast.Index)
+		if !ok {
+			return fmt.Errorf("got *ast.Index, wanted %v", wantNode)
+		}
+		if err := checkNode(got.X, want.X); err != nil {
+			return fmt.Errorf("*ast.Index: X: %v", err)
+		}
+		if err := checkNode(got.Index, want.Index); err != nil {
+			return fmt.Errorf("*ast.Index: Index: %v", err)
+		}
 	case *ast.EmptyExpression:
 		_, ok := wantNode.(*ast.EmptyExpression)
 		if !ok {
@@ -196,6 +1387,10 @@ func checkVar(got, want *ast.Var) error {
 		return fmt.Errorf("VarDecl: expected nil, got %v", got.VarDecl)
 	}
 
+	if err := checkString(got.Package, want.Package); err != nil {
+		return fmt.Errorf("Package: %v", err)
+	}
+
 	if err := checkToken(got.Token, want.Token); err != nil {
 		return fmt.Errorf("Name: %v", err)
 	}
@@ -232,6 +1427,24 @@ func checkVarDecl(got, want *ast.VarDecl) error {
 }
 
 func checkFuncDecl(got, want *ast.FuncDecl) error {
+	if len(got.TypeParams) != len(want.TypeParams) {
+		return fmt.Errorf("got %d type params, want %d", len(got.TypeParams), len(want.TypeParams))
+	}
+	for i := range got.TypeParams {
+		if err := checkTypeParam(got.TypeParams[i], want.TypeParams[i]); err != nil {
+			return fmt.Errorf("type params [%d]: %v", i, err)
+		}
+	}
+
+	if (got.Receiver == nil) != (want.Receiver == nil) {
+		return fmt.Errorf("Receiver: got %v, want %v", got.Receiver, want.Receiver)
+	}
+	if got.Receiver != nil {
+		if err := checkNode(got.Receiver, want.Receiver); err != nil {
+			return fmt.Errorf("Receiver: %v", err)
+		}
+	}
+
 	if len(got.Params) != len(want.Params) {
 		return fmt.Errorf("got %d params, want %d", len(got.Params), len(want.Params))
 	}
@@ -266,6 +1479,18 @@ func checkFuncDecl(got, want *ast.FuncDecl) error {
 		return fmt.Errorf("HasReturn: %v", err)
 	}
 
+	if err := checkBool(got.Variadic, want.Variadic); err != nil {
+		return fmt.Errorf("Variadic: %v", err)
+	}
+
+	if err := checkBool(got.VarArgs, want.VarArgs); err != nil {
+		return fmt.Errorf("VarArgs: %v", err)
+	}
+
+	if err := checkBool(got.Export, want.Export); err != nil {
+		return fmt.Errorf("Export: %v", err)
+	}
+
 	if got.HasReturn {
 		if err := checkNode(got.ReturnType, want.ReturnType); err != nil {
 			return err
@@ -275,13 +1500,194 @@ func checkFuncDecl(got, want *ast.FuncDecl) error {
 	return nil
 }
 
+func checkTypeParam(got, want *ast.TypeParam) error {
+	if err := checkToken(got.Token, want.Token); err != nil {
+		return fmt.Errorf("Token: %v", err)
+	}
+
+	if (got.Constraint == nil) != (want.Constraint == nil) {
+		return fmt.Errorf("Constraint: got %v, want %v", got.Constraint, want.Constraint)
+	}
+	if got.Constraint != nil {
+		if err := checkType(got.Constraint, want.Constraint); err != nil {
+			return fmt.Errorf("Constraint: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func checkFuncCall(got, want *ast.FuncCall) error {
+	if err := checkToken(got.Token, want.Token); err != nil {
+		return fmt.Errorf("Token: %v", err)
+	}
+
+	if err := checkString(got.Package, want.Package); err != nil {
+		return fmt.Errorf("Package: %v", err)
+	}
+
+	if len(got.TypeArgs) != len(want.TypeArgs) {
+		return fmt.Errorf("got %d type args, want %d", len(got.TypeArgs), len(want.TypeArgs))
+	}
+	for i := range got.TypeArgs {
+		if err := checkType(got.TypeArgs[i], want.TypeArgs[i]); err != nil {
+			return fmt.Errorf("type args [%d]: %v", i, err)
+		}
+	}
+
+	if len(got.Args) != len(want.Args) {
+		return fmt.Errorf("got %d args, want %d", len(got.Args), len(want.Args))
+	}
+	for i := range got.Args {
+		if err := checkNode(got.Args[i], want.Args[i]); err != nil {
+			return fmt.Errorf("args [%d]: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+func checkMethodCall(got, want *ast.MethodCall) error {
+	if err := checkToken(got.Token, want.Token); err != nil {
+		return fmt.Errorf("Token: %v", err)
+	}
+
+	if err := checkNode(got.Receiver, want.Receiver); err != nil {
+		return fmt.Errorf("Receiver: %v", err)
+	}
+
+	if len(got.Args) != len(want.Args) {
+		return fmt.Errorf("got %d args, want %d", len(got.Args), len(want.Args))
+	}
+	for i := range got.Args {
+		if err := checkNode(got.Args[i], want.Args[i]); err != nil {
+			return fmt.Errorf("args [%d]: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+func checkSwitch(got, want *ast.Switch) error {
+	if err := checkNode(got.Value, want.Value); err != nil {
+		return fmt.Errorf("Value: %v", err)
+	}
+
+	if len(got.Cases) != len(want.Cases) {
+		return fmt.Errorf("got %d cases, want %d", len(got.Cases), len(want.Cases))
+	}
+	for i, gc := range got.Cases {
+		wc := want.Cases[i]
+		if err := checkBool(gc.Default, wc.Default); err != nil {
+			return fmt.Errorf("cases [%d]: Default: %v", i, err)
+		}
+		if len(gc.Values) != len(wc.Values) {
+			return fmt.Errorf("cases [%d]: got %d values, want %d", i, len(gc.Values), len(wc.Values))
+		}
+		for j := range gc.Values {
+			if err := checkNode(gc.Values[j], wc.Values[j]); err != nil {
+				return fmt.Errorf("cases [%d]: values [%d]: %v", i, j, err)
+			}
+		}
+		if len(gc.Body) != len(wc.Body) {
+			return fmt.Errorf("cases [%d]: got %d body statements, want %d", i, len(gc.Body), len(wc.Body))
+		}
+		for j := range gc.Body {
+			if err := checkNode(gc.Body[j], wc.Body[j]); err != nil {
+				return fmt.Errorf("cases [%d]: body [%d]: %v", i, j, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkPattern(got, want *ast.Pattern) error {
+	if err := checkToken(got.Token, want.Token); err != nil {
+		return fmt.Errorf("Token: %v", err)
+	}
+
+	if err := checkVar(got.X, want.X); err != nil {
+		return fmt.Errorf("X: %v", err)
+	}
+
+	if len(got.Bindings) != len(want.Bindings) {
+		return fmt.Errorf("got %d bindings, want %d", len(got.Bindings), len(want.Bindings))
+	}
+	for i := range got.Bindings {
+		if err := checkToken(got.Bindings[i].Token, want.Bindings[i].Token); err != nil {
+			return fmt.Errorf("bindings [%d]: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+func checkTypeDecl(got, want *ast.TypeDecl) error {
+	if err := checkToken(got.Token, want.Token); err != nil {
+		return fmt.Errorf("Token: %v", err)
+	}
+
+	if err := checkType(got.Type, want.Type); err != nil {
+		return fmt.Errorf("Type: %v", err)
+	}
+
+	if len(got.Members) != len(want.Members) {
+		return fmt.Errorf("got %d members, want %d", len(got.Members), len(want.Members))
+	}
+	for i := range got.Members {
+		if err := checkToken(got.Members[i].Token, want.Members[i].Token); err != nil {
+			return fmt.Errorf("members [%d]: %v", i, err)
+		}
+		if (got.Members[i].Value == nil) != (want.Members[i].Value == nil) {
+			return fmt.Errorf("members [%d]: got value %v, want %v", i, got.Members[i].Value, want.Members[i].Value)
+		}
+		if got.Members[i].Value != nil {
+			if err := checkIntLiteral(got.Members[i].Value, want.Members[i].Value); err != nil {
+				return fmt.Errorf("members [%d]: %v", i, err)
+			}
+		}
+	}
+
+	if len(got.Variants) != len(want.Variants) {
+		return fmt.Errorf("got %d variants, want %d", len(got.Variants), len(want.Variants))
+	}
+	for i := range got.Variants {
+		gv, wv := got.Variants[i], want.Variants[i]
+		if err := checkToken(gv.Token, wv.Token); err != nil {
+			return fmt.Errorf("variants [%d]: %v", i, err)
+		}
+		if len(gv.Fields) != len(wv.Fields) {
+			return fmt.Errorf("variants [%d]: got %d fields, want %d", i, len(gv.Fields), len(wv.Fields))
+		}
+		for j := range gv.Fields {
+			if err := checkType(gv.Fields[j], wv.Fields[j]); err != nil {
+				return fmt.Errorf("variants [%d]: fields [%d]: %v", i, j, err)
+			}
+		}
+	}
+
+	if len(got.Methods) != len(want.Methods) {
+		return fmt.Errorf("got %d methods, want %d", len(got.Methods), len(want.Methods))
+	}
+	for i := range got.Methods {
+		if err := checkNode(got.Methods[i], want.Methods[i]); err != nil {
+			return fmt.Errorf("methods [%d]: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
 func checkReturn(got, want *ast.Return) error {
 	if err := checkBool(got.HasValue, want.HasValue); err != nil {
 		return fmt.Errorf("HasValue: %v", err)
 	}
 
-	if err := checkNode(got.Value, want.Value); err != nil {
-		return fmt.Errorf("Value: %v", err)
+	if got.HasValue {
+		if err := checkNode(got.Value, want.Value); err != nil {
+			return fmt.Errorf("Value: %v", err)
+		}
 	}
 
 	return nil
@@ -300,6 +1706,12 @@ func checkTypeType(gotType, wantType types.Type) error {
 	case *types.Pointer:
 		want := wantType.(*types.Pointer)
 		return checkTypeType(got.To, want.To)
+	case *types.Slice:
+		want, ok := wantType.(*types.Slice)
+		if !ok {
+			return fmt.Errorf("got %s, want %s", types.String(got), types.String(wantType))
+		}
+		return checkTypeType(got.Elem, want.Elem)
 	default:
 		return checkString(gotType.Name(), wantType.Name())
 	}
@@ -329,3 +1741,94 @@ func checkBool(got, want bool) error {
 	}
 	return nil
 }
+
+func TestIllegal(t *testing.T) {
+	input := "func main() i32 {\n\tvar é i32 = 1\n\t@\n\treturn é\n}\n\xff"
+	p := New(lexer.New(input))
+	_, ok := p.ParseProgram()
+	if ok {
+		t.Fatalf("expected errors")
+	}
+
+	want := []string{
+		":3:2: error: invalid character U+0040 '@'",
+		":6:1: error: invalid UTF-8 encoding",
+	}
+	if len(p.Errors) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), p.Errors)
+	}
+	for i := range want {
+		if p.Errors[i].Error() != want[i] {
+			t.Errorf("[%d] expected %q, got %q", i, want[i], p.Errors[i].Error())
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	input := `func f() i32 {
+	var s ^u8 = "abc
+	return 1
+}
+
+/* unused
+func g() i32 {
+	return )
+}
+`
+	p := New(lexer.New(input))
+	prog, ok := p.ParseProgram()
+	if ok {
+		t.Fatalf("expected errors")
+	}
+
+	want := []string{
+		":2:14: error: string literal not terminated",
+		":6:1: error: comment not terminated",
+	}
+	if len(p.Errors) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), p.Errors)
+	}
+	for i := range want {
+		if p.Errors[i].Error() != want[i] {
+			t.Errorf("[%d] expected %q, got %q", i, want[i], p.Errors[i].Error())
+		}
+	}
+	if len(prog.Statements) != 1 || len(prog.Statements[0].(*ast.FuncDecl).Body) != 2 {
+		t.Fatalf("expected f with 2 statements, got %v", prog.Statements)
+	}
+}
+
+func TestParseInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"func f() i32 {\n\treturn 1\n}", "*ast.FuncDecl"},
+		{"enum Color { Red }", "*ast.TypeDecl"},
+		{"var x i32 = 1;", "*ast.VarDecl"},
+		{"x = 2", "*ast.Assign"},
+		{"x", "*ast.Var"},
+		{"1 + f(2) * 3\n", "*ast.InfixExpression"},
+		{"f(1)", "*ast.FuncCall"},
+		{"try f()", "*ast.Try"},
+		{"switch x {\ncase 1:\n}", "*ast.Switch"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		n, ok := p.ParseInput()
+		if !ok {
+			t.Errorf("%q: unexpected errors %v", tt.input, p.Errors)
+			continue
+		}
+		if got := fmt.Sprintf("%T", n); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.input, tt.want, got)
+		}
+	}
+
+	for _, input := range []string{"1 +", "x y", "var x i32 = 1\nx"} {
+		p := New(lexer.New(input))
+		if _, ok := p.ParseInput(); ok {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package repl implements an interactive session, which reads declarations,
+// statements and expressions one input at a time. Each input is checked
+// against the declarations of the inputs before it and run with the
+// interpreter, and the values of expressions are shown with their types.
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lang/ast"
+	"lang/checker"
+	"lang/diag"
+	"lang/interp"
+	"lang/lexer"
+	"lang/parser"
+	"lang/token"
+	"lang/types"
+	"strings"
+)
+
+// Session holds the declarations of the inputs so far and the values of their
+// variables.
+type Session struct {
+	// Out is where values and the output of the program are written, and
+	// Err where errors are.
+	Out io.Writer
+	Err io.Writer
+
+	fset    *token.FileSet
+	checker *checker.Checker
+	interp  *interp.Interp
+	inputs  int
+}
+
+// New returns a session that starts out with an empty main package.
+func New(out, errs io.Writer) *Session {
+	prog := &ast.Program{Imports: make([]*ast.Import, 0), Statements: make([]ast.Statement, 0)}
+	c := checker.New(prog)
+	c.Check()
+	in := interp.New(prog)
+	in.Stdout = out
+
+	return &Session{Out: out, Err: errs, fset: token.NewFileSet(), checker: c, interp: in}
+}
+
+const help = `Type a declaration, a statement or an expression to run it. Expressions
+are shown with their value and type. Inputs continue on the next line until
+their braces and parentheses are closed and they do not end in the middle
+of an expression.
+
+  :help   show this help
+  :quit   end the session
+`
+
+// Run reads inputs from r and evaluates them until the end of r or :quit. It
+// returns an interp.Exit error if the program exits.
+func (s *Session) Run(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	var input strings.Builder
+	for {
+		prompt := "> "
+		if input.Len() > 0 {
+			prompt = ". "
+		}
+		fmt.Fprint(s.Out, prompt)
+		if !sc.Scan() {
+			fmt.Fprintln(s.Out)
+			return sc.Err()
+		}
+
+		line := sc.Text()
+		if input.Len() == 0 {
+			switch strings.TrimSpace(line) {
+			case "":
+				continue
+			case ":quit", ":q":
+				return nil
+			case ":help", ":h":
+				fmt.Fprint(s.Out, help)
+				continue
+			}
+		}
+
+		input.WriteString(line)
+		input.WriteByte('\n')
+		if !complete(input.String()) {
+			continue
+		}
+		err := s.Eval(input.String())
+		input.Reset()
+
+		var exit interp.Exit
+		if errors.As(err, &exit) {
+			return err
+		}
+	}
+}
+
+// complete reports whether src closes all the braces, brackets and
+// parentheses that it opens, and does not end before the parser expects it
+// to, like 1 + does.
+func complete(src string) bool {
+	l := lexer.New(src)
+	depth := 0
+	t := l.NextToken()
+	for ; t.Type != token.EOF; t = l.NextToken() {
+		switch t.Type {
+		case token.LBRACE, token.LPAREN, token.LBRACKET:
+			depth++
+		case token.RBRACE, token.RPAREN, token.RBRACKET:
+			depth--
+		}
+	}
+	if depth > 0 {
+		return false
+	}
+
+	p := parser.New(lexer.New(src))
+	if _, ok := p.ParseInput(); ok {
+		return true
+	}
+	eof := diag.At(t).Start
+	for _, d := range p.Errors {
+		if d.Span.Start == eof {
+			return false
+		}
+	}
+	return true
+}
+
+// Eval checks and runs an input. Declarations are added to the session,
+// statements are run and expressions are run and shown. Errors of the input
+// are written to Err and returned, and the input is then discarded.
+func (s *Session) Eval(src string) error {
+	s.inputs++
+	file := s.fset.AddFile(fmt.Sprintf("input%d", s.inputs), []byte(src))
+	p := parser.New(lexer.NewFile(file))
+	n, ok := p.ParseInput()
+	if !ok {
+		return s.report(p.Errors)
+	}
+
+	switch v := n.(type) {
+	case *ast.FuncDecl, *ast.TypeDecl, *ast.VarDecl:
+		stmt := v.(ast.Statement)
+		if errs := s.checker.CheckDecl(stmt); len(errs) > 0 {
+			return s.report(errs)
+		}
+		if vd, ok := v.(*ast.VarDecl); ok {
+			return s.fail(s.interp.Define(vd))
+		}
+		s.interp.Declare(stmt)
+	case ast.Expression:
+		if errs := s.checker.CheckExpression(v); len(errs) > 0 {
+			return s.report(errs)
+		}
+		x, err := s.interp.Eval(v)
+		if err != nil {
+			return s.fail(err)
+		}
+		if _, ok := v.Type().(*types.Nil); !ok {
+			fmt.Fprintf(s.Out, "%s : %s\n", interp.Format(x, v.Type()), types.String(v.Type()))
+		}
+	case ast.Statement:
+		if errs := s.checker.CheckStatement(v); len(errs) > 0 {
+			return s.report(errs)
+		}
+		return s.fail(s.interp.Exec(v))
+	}
+	return nil
+}
+
+// report writes diagnostics with the lines of the inputs they point at, and
+// returns the first one.
+func (s *Session) report(diags []*diag.Diagnostic) error {
+	p := diag.NewPrinter(s.Err)
+	p.ReadFile = s.readFile
+	p.PrintAll(diags)
+	return diags[0]
+}
+
+// fail reports an error of the running program, except for interp.Exit, and
+// returns it.
+func (s *Session) fail(err error) error {
+	var rerr *interp.Error
+	switch {
+	case errors.As(err, &rerr):
+		s.report([]*diag.Diagnostic{diag.Errorf(diag.Span{Start: rerr.Pos, End: rerr.Pos}, "", "%s", rerr.Msg)})
+	case err != nil && !errors.As(err, new(interp.Exit)):
+		fmt.Fprintln(s.Err, err)
+	}
+	return err
+}
+
+// readFile returns the source of an input by its file name.
+func (s *Session) readFile(name string) ([]byte, error) {
+	for _, f := range s.fset.Files() {
+		if f.Name() == name {
+			return f.Source(), nil
+		}
+	}
+	return nil, fmt.Errorf("no input %s", name)
+}
@@ -0,0 +1,101 @@
+package repl
+
+import (
+	"bytes"
+	"errors"
+	"lang/interp"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	input := `1 + 2 *
+3
+union Shape {
+	Circle(i32),
+	Rect(i32, i32)
+}
+func area(s Shape) i32 {
+	switch s {
+	case Shape.Circle(r):
+		return 3 * r * r
+	case Shape.Rect(w, h):
+		return w * h
+	}
+}
+var total i32 = area(Shape.Rect(2, 3))
+total = total + area(Shape.Circle(1))
+total
+Shape.Circle(total)
+func half(n i32) ?i32 {
+	switch n {
+	case 0:
+		return none
+	}
+	return n / 2
+}
+half(total)
+half(0)
+func puts(s ^u8) i32
+puts("hi")
+:quit
+1
+`
+	want := "> . 7 : i32\n" +
+		"> . . . > . . . . . . . > > > 9 : i32\n" +
+		"> Shape.Circle(9) : Shape\n" +
+		"> . . . . . . > some(4) : ?i32\n" +
+		"> none : ?i32\n" +
+		"> > hi\n0 : i32\n" +
+		"> "
+
+	var out, errs bytes.Buffer
+	if err := New(&out, &errs).Run(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want || errs.Len() > 0 {
+		t.Fatalf("got output\n%s\nwant\n%s\nerrors:\n%s", out.String(), want, errs.String())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var out, errs bytes.Buffer
+	s := New(&out, &errs)
+
+	inputs := []struct {
+		input string
+		want  string
+	}{
+		{"var x i32 = 1 +\n", "input1:2:1: error[syntax]:"},
+		{"func f() i32 {\n\treturn y\n}\n", "input2:2:9: error[undeclared]: y not declared"},
+		{"f()\n", "input3:1:1: error[undeclared]: f not declared"},
+		{"var x i32 = 10 / 0\n", "input4:1:16: error: division by zero"},
+		{"var y i32 = 2\n", ""},
+		{"y * 21\n", ""},
+	}
+	for _, tt := range inputs {
+		errs.Reset()
+		err := s.Eval(tt.input)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(errs.String(), tt.want) {
+			t.Errorf("%q: got %v and\n%s\nwant %q", tt.input, err, errs.String(), tt.want)
+		}
+	}
+	if !strings.HasSuffix(out.String(), "42 : i32\n") {
+		t.Errorf("got output %q", out.String())
+	}
+}
+
+func TestExit(t *testing.T) {
+	var out, errs bytes.Buffer
+	err := New(&out, &errs).Run(strings.NewReader("func exit(status i32)\nexit(4)\n1\n"))
+	var exit interp.Exit
+	if !errors.As(err, &exit) || exit != 4 {
+		t.Fatalf("got %v, want exit status 4", err)
+	}
+}
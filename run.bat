@@ -1,4 +0,0 @@
-go run cmd\compile.go
-clang out.ll
-a.exe
-echo %ERRORLEVEL%
@@ -0,0 +1,157 @@
+package token
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Pos is a position in a FileSet, which is the byte offset of the position in
+// its file plus the base of the file. The zero Pos is NoPos.
+type Pos int
+
+// NoPos is the position of tokens and nodes that are not in a source file.
+const NoPos Pos = 0
+
+func (p Pos) IsValid() bool {
+	return p != NoPos
+}
+
+// Position is a position in a source file. Offsets count bytes from 0, while
+// lines and columns start at 1 and columns count characters.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// File is a source file in a FileSet. It keeps the source, so that positions
+// can be mapped to lines and columns.
+type File struct {
+	name string
+	base int
+	src  []byte
+
+	// lines holds the offsets of the first byte of each line
+	lines []int
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) Base() int {
+	return f.base
+}
+
+func (f *File) Size() int {
+	return len(f.src)
+}
+
+func (f *File) Source() []byte {
+	return f.src
+}
+
+func (f *File) LineCount() int {
+	return len(f.lines)
+}
+
+// Pos returns the position of the byte at offset, which can be the size of
+// the file for the end of the file.
+func (f *File) Pos(offset int) Pos {
+	if offset < 0 || offset > len(f.src) {
+		panic(fmt.Sprintf("offset %d out of range [0, %d] in %s", offset, len(f.src), f.name))
+	}
+	return Pos(f.base + offset)
+}
+
+// Offset returns the byte offset of p, which must be in the file.
+func (f *File) Offset(p Pos) int {
+	offset := int(p) - f.base
+	if offset < 0 || offset > len(f.src) {
+		panic(fmt.Sprintf("position %d out of range in %s", p, f.name))
+	}
+	return offset
+}
+
+// LineStart returns the position of the first byte of line, which starts
+// at 1.
+func (f *File) LineStart(line int) Pos {
+	if line < 1 || line > len(f.lines) {
+		panic(fmt.Sprintf("line %d out of range [1, %d] in %s", line, len(f.lines), f.name))
+	}
+	return Pos(f.base + f.lines[line-1])
+}
+
+// Position returns the line and column of p, which must be in the file. A
+// byte order mark at the start of the file is not counted as a column.
+func (f *File) Position(p Pos) Position {
+	offset := f.Offset(p)
+	line := sort.Search(len(f.lines), func(i int) bool { return f.lines[i] > offset })
+	start := f.lines[line-1]
+	if line == 1 && bytes.HasPrefix(f.src, bom) && offset >= len(bom) {
+		start = len(bom)
+	}
+	col := utf8.RuneCount(f.src[start:offset]) + 1
+	return Position{Filename: f.name, Offset: offset, Line: line, Column: col}
+}
+
+var bom = []byte("\uFEFF")
+
+// FileSet holds the source files of a program. Each file is given a range of
+// positions, so that a Pos identifies both a file and an offset in it.
+type FileSet struct {
+	base  int
+	files []*File
+}
+
+func NewFileSet() *FileSet {
+	return &FileSet{base: 1}
+}
+
+// AddFile adds a file with the given name and source to the set.
+func (s *FileSet) AddFile(filename string, src []byte) *File {
+	f := &File{name: filename, base: s.base, src: src, lines: []int{0}}
+	for i, b := range src {
+		if b == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+	// The end of the file is a position too, so the next file starts after it.
+	s.base += len(src) + 1
+	s.files = append(s.files, f)
+	return f
+}
+
+// File returns the file that contains p, or nil if there is none.
+func (s *FileSet) File(p Pos) *File {
+	i := sort.Search(len(s.files), func(i int) bool { return s.files[i].base > int(p) }) - 1
+	if i < 0 || int(p) > s.files[i].base+len(s.files[i].src) {
+		return nil
+	}
+	return s.files[i]
+}
+
+// Position returns the position of p, or the zero Position if p is not in
+// one of the files.
+func (s *FileSet) Position(p Pos) Position {
+	if f := s.File(p); f != nil {
+		return f.Position(p)
+	}
+	return Position{}
+}
+
+// Files returns the files in the order they were added.
+func (s *FileSet) Files() []*File {
+	return s.files
+}
@@ -0,0 +1,39 @@
+package token
+
+import "testing"
+
+func TestFileSet(t *testing.T) {
+	fset := NewFileSet()
+	a := fset.AddFile("a.lang", []byte("ab\ncd"))
+	b := fset.AddFile("b.lang", []byte("π = 1\n\nx"))
+
+	tests := []struct {
+		pos  Pos
+		want string
+	}{
+		{a.Pos(0), "a.lang:1:1"},
+		{a.Pos(4), "a.lang:2:2"},
+		{a.Pos(5), "a.lang:2:3"},
+		{b.Pos(0), "b.lang:1:1"},
+		{b.Pos(2), "b.lang:1:2"},
+		{b.Pos(3), "b.lang:1:3"},
+		{b.Pos(7), "b.lang:2:1"},
+		{b.Pos(8), "b.lang:3:1"},
+		{b.Pos(9), "b.lang:3:2"},
+	}
+	for _, tt := range tests {
+		if got := fset.Position(tt.pos).String(); got != tt.want {
+			t.Errorf("Position(%d) = %s, want %s", tt.pos, got, tt.want)
+		}
+	}
+
+	if fset.File(a.Pos(5)) != a || fset.File(b.Pos(0)) != b {
+		t.Errorf("File returned the wrong file")
+	}
+	if fset.File(NoPos) != nil || fset.Position(b.Pos(9)+1).IsValid() {
+		t.Errorf("expected no file outside of the files")
+	}
+	if b.LineCount() != 3 || b.LineStart(3) != b.Pos(8) {
+		t.Errorf("got %d lines starting line 3 at %d", b.LineCount(), b.LineStart(3))
+	}
+}
@@ -7,37 +7,78 @@ type TokenType string
 const (
 	ASSIGN    = "="
 	ASTERISK  = "*"
+	BANG      = "!"
+	CASE      = "CASE"
+	COLON     = ":"
 	COMMA     = ","
+	COMMENT   = "COMMENT"
+	DEFAULT   = "DEFAULT"
+	DEFER     = "DEFER"
+	DOT       = "."
+	ELLIPSIS  = "..."
+	ENUM      = "ENUM"
 	EOF       = "EOF"
+	ERR       = "ERR"
+	EXPORT    = "EXPORT"
 	EXTERN    = "EXTERN"
 	FUNC      = "FUNC"
 	IDENT     = "IDENT"
+	ILLEGAL   = "ILLEGAL"
+	IMPORT    = "IMPORT"
 	INT       = "INT"
+	INTERFACE = "INTERFACE"
 	LBRACE    = "{"
+	LBRACKET  = "["
 	LPAREN    = "("
 	MINUS     = "-"
+	NONE      = "NONE"
+	PACKAGE   = "PACKAGE"
 	PLUS      = "+"
 	POINTER   = "^"
+	QUESTION  = "?"
 	RBRACE    = "}"
+	RBRACKET  = "]"
 	RETURN    = "RETURN"
 	RPAREN    = ")"
 	SEMICOLON = ";"
 	SLASH     = "/"
 	STRING    = "STRING"
+	SWITCH    = "SWITCH"
+	TRY       = "TRY"
+	TYPE      = "TYPE"
+	UNION     = "UNION"
 	VAR       = "VAR"
 )
 
 var KeywordsMap = map[string]TokenType{
-	"extern": EXTERN,
-	"func":   FUNC,
-	"return": RETURN,
-	"var":    VAR,
+	"case":      CASE,
+	"default":   DEFAULT,
+	"defer":     DEFER,
+	"enum":      ENUM,
+	"err":       ERR,
+	"export":    EXPORT,
+	"extern":    EXTERN,
+	"func":      FUNC,
+	"import":    IMPORT,
+	"interface": INTERFACE,
+	"none":      NONE,
+	"package":   PACKAGE,
+	"return":    RETURN,
+	"switch":    SWITCH,
+	"try":       TRY,
+	"type":      TYPE,
+	"union":     UNION,
+	"var":       VAR,
 }
 
+// Token is a token of a source file. Value is the source of the token, Pos its
+// position in the FileSet of the file, and Line and Column its position in the
+// file.
 type Token struct {
 	Column   int
 	Filename string
 	Line     int
+	Pos      Pos
 	Type     TokenType
 	Value    string
 }
@@ -52,6 +93,14 @@ func New(t TokenType, v string, l, c int, f string) Token {
 	}
 }
 
+// End returns the position just after the token.
+func (t Token) End() Pos {
+	if !t.Pos.IsValid() {
+		return NoPos
+	}
+	return t.Pos + Pos(len(t.Value))
+}
+
 func (t Token) Path() string {
 	return fmt.Sprintf("%s:%d:%d", t.Filename, t.Line, t.Column)
 }
@@ -1,6 +1,9 @@
 package types
 
-import "lang/token"
+import (
+	"lang/token"
+	"strings"
+)
 
 type Type interface {
 	IsNumeric() bool
@@ -9,7 +12,9 @@ type Type interface {
 
 var (
 	TypeInt32 = &Int32{}
+	TypeUint8 = &Uint8{}
 	TypeNil   = &Nil{}
+	TypeNone  = &None{}
 )
 
 type Pointer struct {
@@ -24,25 +29,399 @@ type Int32 struct{}
 func (i *Int32) IsNumeric() bool { return true }
 func (i *Int32) Name() string    { return "i32" }
 
+// Uint8 is the type of bytes, which strings are made of.
+type Uint8 struct{}
+
+func (u *Uint8) IsNumeric() bool { return true }
+func (u *Uint8) Name() string    { return "u8" }
+
 type Nil struct{}
 
 func (n *Nil) IsNumeric() bool { return false }
 func (n *Nil) Name() string    { return "" }
 
+// None is the type of none, which converts to any optional type.
+type None struct{}
+
+func (n *None) IsNumeric() bool { return false }
+func (n *None) Name() string    { return "none" }
+
+// Custom is a type referred to by name, which the checker resolves. Package
+// is the imported package the name is qualified with, if any.
 type Custom struct {
-	name string
+	Package string
+	name    string
+}
+
+// NewQualified returns the type named name in the imported package pkg.
+func NewQualified(pkg, name string) *Custom {
+	return &Custom{Package: pkg, name: name}
 }
 
 func (c *Custom) IsNumeric() bool { return false }
-func (c *Custom) Name() string    { return c.name }
+
+func (c *Custom) Name() string {
+	if c.Package != "" {
+		return c.Package + "." + c.name
+	}
+	return c.name
+}
+
+// Named is a type declared with a name and an underlying type, which can have
+// methods.
+type Named struct {
+	name       string
+	Underlying Type
+}
+
+func NewNamed(name string, underlying Type) *Named {
+	return &Named{name: name, Underlying: underlying}
+}
+
+func (n *Named) IsNumeric() bool { return n.Underlying.IsNumeric() }
+func (n *Named) Name() string    { return n.name }
+
+// TypeParam is a type parameter of a generic function. It is replaced by a
+// type argument, which implements Constraint if it is not nil, when the
+// function is instantiated.
+type TypeParam struct {
+	name       string
+	Constraint *Interface
+}
+
+func NewTypeParam(name string) *TypeParam {
+	return &TypeParam{name: name}
+}
+
+func (tp *TypeParam) IsNumeric() bool { return false }
+func (tp *TypeParam) Name() string    { return tp.name }
+
+// Enum is an integer type with a set of named values.
+type Enum struct {
+	name    string
+	Backing Type
+	Members []*EnumMember
+}
+
+type EnumMember struct {
+	Name  string
+	Value int
+	Enum  *Enum
+}
+
+func NewEnum(name string, backing Type) *Enum {
+	return &Enum{name: name, Backing: backing}
+}
+
+func (e *Enum) IsNumeric() bool { return false }
+func (e *Enum) Name() string    { return e.name }
+
+func (e *Enum) Member(name string) (*EnumMember, bool) {
+	for _, m := range e.Members {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Union is a tagged union, whose values are one of its variants. Each variant
+// carries a payload of zero or more fields.
+type Union struct {
+	name     string
+	Variants []*Variant
+}
+
+type Variant struct {
+	Name   string
+	Tag    int
+	Fields []Type
+	Union  *Union
+}
+
+func NewUnion(name string) *Union {
+	return &Union{name: name}
+}
+
+func (u *Union) IsNumeric() bool { return false }
+func (u *Union) Name() string    { return u.name }
+
+func (u *Union) Variant(name string) (*Variant, bool) {
+	for _, v := range u.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Optional is the type ?T of values that either hold a T or are none. Its
+// values are matched like a union with the variants none and some(T).
+type Optional struct {
+	Elem  Type
+	Union *Union
+}
+
+func NewOptional(elem Type) *Optional {
+	o := &Optional{Elem: elem}
+	o.Union = NewUnion(String(o))
+	o.Union.Variants = []*Variant{
+		{Name: "none", Tag: 0, Union: o.Union},
+		{Name: "some", Tag: 1, Fields: []Type{elem}, Union: o.Union},
+	}
+	return o
+}
+
+func (o *Optional) IsNumeric() bool { return false }
+func (o *Optional) Name() string    { return String(o) }
+
+// Result is the type E!T of values that either hold a T or an error E. Its
+// values are matched like a union with the variants ok(T) and err(E).
+type Result struct {
+	Err   Type
+	Value Type
+	Union *Union
+}
+
+func NewResult(err, value Type) *Result {
+	r := &Result{Err: err, Value: value}
+	r.Union = NewUnion(String(r))
+	r.Union.Variants = []*Variant{
+		{Name: "ok", Tag: 0, Fields: []Type{value}, Union: r.Union},
+		{Name: "err", Tag: 1, Fields: []Type{err}, Union: r.Union},
+	}
+	return r
+}
+
+func (r *Result) IsNumeric() bool { return false }
+func (r *Result) Name() string    { return String(r) }
+
+// Error is the type of err(e), which converts to the results with the error
+// type Err.
+type Error struct {
+	Err Type
+}
+
+func (e *Error) IsNumeric() bool { return false }
+func (e *Error) Name() string    { return String(e) }
+
+// AsUnion returns the union that describes the values of t if t is a union,
+// an optional or a result.
+func AsUnion(t Type) (*Union, bool) {
+	switch v := t.(type) {
+	case *Union:
+		return v, true
+	case *Optional:
+		return v.Union, true
+	case *Result:
+		return v.Union, true
+	default:
+		return nil, false
+	}
+}
+
+// Slice is the type []T of a pointer to a sequence of T and its length.
+type Slice struct {
+	Elem Type
+}
+
+func (s *Slice) IsNumeric() bool { return false }
+func (s *Slice) Name() string    { return String(s) }
+
+// Func is the type of a function. Variadic functions receive the arguments
+// after their fixed parameters as a slice in their last parameter.
+type Func struct {
+	Params   []Type
+	Return   Type
+	Variadic bool
+}
+
+// Rest returns the element type of the variadic parameter, or nil if f is
+// not variadic.
+func (f *Func) Rest() Type {
+	if !f.Variadic || len(f.Params) == 0 {
+		return nil
+	}
+	if s, ok := f.Params[len(f.Params)-1].(*Slice); ok {
+		return s.Elem
+	}
+	return nil
+}
+
+func (f *Func) IsNumeric() bool { return false }
+func (f *Func) Name() string    { return String(f) }
+
+type Method struct {
+	Name string
+	Sig  *Func
+}
+
+type Interface struct {
+	name    string
+	Methods []*Method
+}
+
+func NewInterface(name string) *Interface {
+	return &Interface{name: name}
+}
+
+func (i *Interface) IsNumeric() bool { return false }
+func (i *Interface) Name() string    { return i.name }
+
+// Method returns the index and signature of the named method.
+func (i *Interface) Method(name string) (int, *Method, bool) {
+	for idx, m := range i.Methods {
+		if m.Name == name {
+			return idx, m, true
+		}
+	}
+	return 0, nil, false
+}
 
 func FromToken(t token.Token) Type {
 	switch t.Value {
 	case "i32":
 		return TypeInt32
+	case "u8":
+		return TypeUint8
 	case "nil":
 		return TypeNil
 	default:
 		return &Custom{name: t.Value}
 	}
 }
+
+// Promote returns the type that a value of type t is passed as to a C
+// variadic function, which is int for integers smaller than an int. It
+// returns nil for types that cannot be passed to C.
+func Promote(t Type) Type {
+	switch v := t.(type) {
+	case *Int32, *Uint8:
+		return TypeInt32
+	case *Named:
+		if _, ok := v.Underlying.(*Pointer); ok {
+			return v
+		}
+		return Promote(v.Underlying)
+	case *Enum:
+		return Promote(v.Backing)
+	case *Pointer:
+		return v
+	default:
+		return nil
+	}
+}
+
+// IntBits returns the width of the integer type t, which is the width of the
+// backing type for enums, or 0 if t is not an integer type.
+func IntBits(t Type) int {
+	switch v := t.(type) {
+	case *Int32:
+		return 32
+	case *Uint8:
+		return 8
+	case *Named:
+		return IntBits(v.Underlying)
+	case *Enum:
+		return IntBits(v.Backing)
+	default:
+		return 0
+	}
+}
+
+// Subst replaces the type parameters in t by the types they are bound to.
+func Subst(t Type, bindings map[*TypeParam]Type) Type {
+	if len(bindings) == 0 {
+		return t
+	}
+
+	switch v := t.(type) {
+	case *TypeParam:
+		if b, ok := bindings[v]; ok {
+			return b
+		}
+	case *Pointer:
+		return &Pointer{To: Subst(v.To, bindings)}
+	case *Slice:
+		return &Slice{Elem: Subst(v.Elem, bindings)}
+	case *Optional:
+		return NewOptional(Subst(v.Elem, bindings))
+	case *Result:
+		return NewResult(Subst(v.Err, bindings), Subst(v.Value, bindings))
+	case *Func:
+		f := &Func{Params: make([]Type, 0), Return: Subst(v.Return, bindings), Variadic: v.Variadic}
+		for _, p := range v.Params {
+			f.Params = append(f.Params, Subst(p, bindings))
+		}
+		return f
+	}
+	return t
+}
+
+// Identical reports whether a and b denote the same type.
+func Identical(a, b Type) bool {
+	switch x := a.(type) {
+	case *Pointer:
+		y, ok := b.(*Pointer)
+		return ok && Identical(x.To, y.To)
+	case *Slice:
+		y, ok := b.(*Slice)
+		return ok && Identical(x.Elem, y.Elem)
+	case *Optional:
+		y, ok := b.(*Optional)
+		return ok && Identical(x.Elem, y.Elem)
+	case *Result:
+		y, ok := b.(*Result)
+		return ok && Identical(x.Err, y.Err) && Identical(x.Value, y.Value)
+	case *Custom:
+		y, ok := b.(*Custom)
+		return ok && x.Name() == y.Name()
+	case *Func:
+		y, ok := b.(*Func)
+		if !ok || len(x.Params) != len(y.Params) || x.Variadic != y.Variadic || !Identical(x.Return, y.Return) {
+			return false
+		}
+		for i := range x.Params {
+			if !Identical(x.Params[i], y.Params[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// String returns the type as it is written in source.
+func String(t Type) string {
+	switch v := t.(type) {
+	case *Pointer:
+		return "^" + String(v.To)
+	case *Slice:
+		return "[]" + String(v.Elem)
+	case *Optional:
+		return "?" + String(v.Elem)
+	case *Result:
+		return String(v.Err) + "!" + String(v.Value)
+	case *Error:
+		return "err(" + String(v.Err) + ")"
+	case *Func:
+		params := make([]string, 0)
+		for i, p := range v.Params {
+			if sl, ok := p.(*Slice); ok && v.Variadic && i == len(v.Params)-1 {
+				params = append(params, "..."+String(sl.Elem))
+				continue
+			}
+			params = append(params, String(p))
+		}
+		s := "func(" + strings.Join(params, ", ") + ")"
+		if _, ok := v.Return.(*Nil); !ok {
+			s += " " + String(v.Return)
+		}
+		return s
+	case *Nil:
+		return "nil"
+	default:
+		return t.Name()
+	}
+}